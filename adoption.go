@@ -0,0 +1,138 @@
+package gotoproduction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// ErrIllegalTransition represents moving a dog to an adoption status that can't follow the one it is in
+var ErrIllegalTransition = errors.New("illegal adoption status transition")
+
+// DogStatus is where a dog is in the adoption workflow
+type DogStatus string
+
+// Adoption statuses, a new dog is available
+const (
+	DogStatusAvailable       DogStatus = "available"
+	DogStatusOnHold          DogStatus = "on-hold"
+	DogStatusPendingAdoption DogStatus = "pending-adoption"
+	DogStatusAdopted         DogStatus = "adopted"
+)
+
+const (
+	maxReasonLength = 500
+	// maxTransitionAttempts is how often a transition is tried when it loses a race without the caller asking for a
+	// precondition, so the loser is told the move is no longer legal rather than to retry
+	maxTransitionAttempts = 3
+)
+
+// dogTransitions lists the statuses each status can move to. Holds and pending adoptions can fall through back to
+// available, and an adopted dog can be returned
+var dogTransitions = map[DogStatus][]DogStatus{
+	DogStatusAvailable:       {DogStatusOnHold, DogStatusPendingAdoption},
+	DogStatusOnHold:          {DogStatusAvailable, DogStatusPendingAdoption},
+	DogStatusPendingAdoption: {DogStatusAvailable, DogStatusAdopted},
+	DogStatusAdopted:         {DogStatusAvailable},
+}
+
+// CanTransition says whether a dog in status from may move to status to
+func CanTransition(from, to DogStatus) bool {
+	for _, next := range dogTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// statusOf is the status of a dog, dogs written before the adoption workflow existed are available
+func statusOf(dog *Dog) DogStatus {
+	if dog.Status == "" {
+		return DogStatusAvailable
+	}
+	return dog.Status
+}
+
+// TransitionDogRequest moves a dog to another adoption status, Reason says why and is kept in the dog's history.
+// LastUpdateTime works like it does for UpdateDogRequest
+type TransitionDogRequest struct {
+	Status         DogStatus
+	Reason         string
+	LastUpdateTime time.Time
+}
+
+// Validate checks a transition request, the status has to be a known one and a reason has to be given
+func (r *TransitionDogRequest) Validate() error {
+	v := &validator{}
+	v.str("status", string(r.Status), required, knownStatus)
+	v.str("reason", r.Reason, required, maxLength(maxReasonLength))
+	return v.err()
+}
+
+func knownStatus(value string) *FieldError {
+	if _, ok := dogTransitions[DogStatus(value)]; !ok {
+		return &FieldError{Code: "unknown_status", Message: fmt.Sprintf("%q is not an adoption status", value)}
+	}
+	return nil
+}
+
+// TransitionDog moves a live dog to another adoption status, failing with ErrIllegalTransition when the workflow doesn't
+// allow the move. The transition is audited with the principal on ctx and the reason, and announced as a status change.
+// A transition that loses a race to another one is tried again against the dog as it is now, unless the request
+// carries a LastUpdateTime, so of two people placing a hold at once one gets it and the other an illegal transition
+func (ds *DogService) TransitionDog(ctx context.Context, id string, request *TransitionDogRequest) (_ *Dog, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.TransitionDog")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "TransitionDog", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionUpdateDogs); err != nil {
+		return nil, err
+	}
+	logger := ds.appLogger.WrapTraceContext(ctx)
+
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		current, err := ds.store.GetDog(ctx, id)
+		if errors.Is(err, ErrDogNotFound) {
+			return nil, ErrDogNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ds.store.GetDog(%q): %w", id, err)
+		}
+		if current.DeletedAt != nil {
+			return nil, ErrDogNotFound
+		}
+		if !request.LastUpdateTime.IsZero() && !request.LastUpdateTime.Equal(current.UpdateTime) {
+			return nil, ErrDogConflict
+		}
+		from := statusOf(current)
+		if !CanTransition(from, request.Status) {
+			return nil, fmt.Errorf("%w: %s to %s", ErrIllegalTransition, from, request.Status)
+		}
+
+		before := copyDog(current)
+		before.Status = from
+		current.Status = request.Status
+		audit := newAuditEntry(ctx, AuditActionTransition, before, current, time.Now().UTC())
+		audit.Reason = request.Reason
+		updated, err := ds.store.TransitionDog(ctx, current, from, current.UpdateTime, audit)
+		switch {
+		case errors.Is(err, ErrDogConflict) && request.LastUpdateTime.IsZero() && attempt < maxTransitionAttempts:
+			logger.Debugw("transition lost a race, retrying", "id", id, "attempt", attempt)
+			continue
+		case errors.Is(err, ErrDogNotFound):
+			return nil, ErrDogNotFound
+		case errors.Is(err, ErrDogConflict):
+			return nil, ErrDogConflict
+		case err != nil:
+			return nil, fmt.Errorf("ds.store.TransitionDog(%q): %w", id, err)
+		}
+		ds.indexDog(updated)
+		logger.Debugw("transitioned dog", "id", id, "from", from, "to", request.Status)
+		return updated, nil
+	}
+}
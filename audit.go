@@ -0,0 +1,148 @@
+package gotoproduction
+
+import (
+	"context"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Audit actions, one per kind of dog mutation
+const (
+	AuditActionCreate  = "create"
+	AuditActionUpdate  = "update"
+	AuditActionDelete  = "delete"
+	AuditActionRestore = "restore"
+	AuditActionPurge   = "purge"
+	// AuditActionTransfer gives a dog to another owner
+	AuditActionTransfer = "transfer"
+	// AuditActionTransition moves a dog to another adoption status
+	AuditActionTransition = "transition"
+)
+
+// AuditEntry records one mutation of a dog, stores write it together with the change and never modify it afterwards
+type AuditEntry struct {
+	ID    string `json:"id" firestore:"id"`
+	DogID string `json:"dog_id" firestore:"dog_id"`
+	// Action is one of the AuditAction constants
+	Action string `json:"action" firestore:"action"`
+	// Actor is the subject of the principal that made the change, anonymous when authentication is disabled
+	Actor       string        `json:"actor" firestore:"actor"`
+	ActorMethod string        `json:"actor_method,omitempty" firestore:"actor_method"`
+	Changes     []AuditChange `json:"changes" firestore:"changes"`
+	// Reason is why the change was made, given with adoption status transitions
+	Reason    string    `json:"reason,omitempty" firestore:"reason"`
+	TraceID   string    `json:"trace_id,omitempty" firestore:"trace_id"`
+	Timestamp time.Time `json:"timestamp" firestore:"timestamp"`
+}
+
+// AuditChange is one field that changed, Before is nil for a created dog and After is nil for a purged one
+type AuditChange struct {
+	Field  string      `json:"field" firestore:"field"`
+	Before interface{} `json:"before" firestore:"before"`
+	After  interface{} `json:"after" firestore:"after"`
+}
+
+// AuditCursor marks the last entry of a page of history
+type AuditCursor struct {
+	ID        string
+	Timestamp time.Time
+}
+
+// AuditQuery is what the store needs to produce one page of a dog's history, oldest first
+type AuditQuery struct {
+	DogID      string
+	Limit      int
+	StartAfter *AuditCursor
+}
+
+// ListDogHistoryRequest asks for one page of a dog's audit trail, PageToken is the NextPageToken of the previous page
+type ListDogHistoryRequest struct {
+	PageSize  int
+	PageToken string
+}
+
+// ListDogHistoryResponse is one page of audit entries, NextPageToken is empty on the last page
+type ListDogHistoryResponse struct {
+	Entries       []*AuditEntry
+	NextPageToken string
+}
+
+// newAuditEntry describes the change from before to after, made by whoever is on ctx. before is nil for a create and
+// after is nil for a purge
+func newAuditEntry(ctx context.Context, action string, before, after *Dog, now time.Time) *AuditEntry {
+	entry := &AuditEntry{
+		Action:    action,
+		Actor:     "anonymous",
+		Changes:   diffDogs(before, after),
+		Timestamp: now,
+	}
+	if principal, ok := authx.FromContext(ctx); ok {
+		entry.Actor = principal.Subject
+		entry.ActorMethod = principal.Method
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		entry.TraceID = sc.TraceID().String()
+	}
+	switch {
+	case after != nil:
+		entry.DogID = after.ID
+	case before != nil:
+		entry.DogID = before.ID
+	}
+	return entry
+}
+
+// diffDogs lists the user visible fields that differ, a nil dog has none of them set
+func diffDogs(before, after *Dog) []AuditChange {
+	fields := func(dog *Dog) map[string]interface{} {
+		if dog == nil {
+			return map[string]interface{}{}
+		}
+		values := map[string]interface{}{"name": dog.Name, "age": dog.Age, "type": dog.Type}
+		if dog.DeletedAt != nil {
+			values["deleted_at"] = *dog.DeletedAt
+		}
+		if dog.OwnerID != "" {
+			values["owner_id"] = dog.OwnerID
+		}
+		if dog.Status != "" {
+			values["status"] = string(dog.Status)
+		}
+		return values
+	}
+	b, a := fields(before), fields(after)
+	var changes []AuditChange
+	for _, field := range []string{"name", "age", "type", "deleted_at", "owner_id", "status"} {
+		if !auditValueEqual(b[field], a[field]) {
+			changes = append(changes, AuditChange{Field: field, Before: b[field], After: a[field]})
+		}
+	}
+	return changes
+}
+
+func auditValueEqual(a, b interface{}) bool {
+	at, aok := a.(time.Time)
+	bt, bok := b.(time.Time)
+	if aok && bok {
+		return at.Equal(bt)
+	}
+	return a == b
+}
+
+func copyAuditEntry(entry *AuditEntry) *AuditEntry {
+	c := *entry
+	c.Changes = append([]AuditChange(nil), entry.Changes...)
+	return &c
+}
+
+func auditCursorOf(entry *AuditEntry) *AuditCursor {
+	return &AuditCursor{ID: entry.ID, Timestamp: entry.Timestamp}
+}
+
+func auditCursorLess(a, b *AuditCursor) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	return a.ID < b.ID
+}
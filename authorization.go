@@ -0,0 +1,65 @@
+package gotoproduction
+
+import (
+	"context"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/logx"
+)
+
+// Permissions the services check against the policy, they double as the jwt scopes the routes require
+const (
+	PermissionReadDogs   = "dogs.read"
+	PermissionCreateDogs = "dogs.create"
+	PermissionUpdateDogs = "dogs.update"
+	PermissionDeleteDogs = "dogs.delete"
+	// PermissionReadDogHistory reads the audit trail, it says who did what so only admins get it by default
+	PermissionReadDogHistory = "dogs.history.read"
+	// PermissionManageWebhooks covers every webhook subscription endpoint, subscriptions hold secrets and send our data
+	// to other people's servers so only admins get it by default
+	PermissionManageWebhooks = "webhooks.manage"
+	PermissionReadOwners     = "owners.read"
+	PermissionWriteOwners    = "owners.write"
+	PermissionDeleteOwners   = "owners.delete"
+)
+
+// Built in roles of DefaultPolicy
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// DefaultPolicy lets viewers read, editors also create and update, and admins do anything
+func DefaultPolicy() *authx.Policy {
+	return authx.NewPolicy(map[string][]string{
+		RoleViewer: {PermissionReadDogs, PermissionReadOwners},
+		RoleEditor: {PermissionReadDogs, PermissionCreateDogs, PermissionUpdateDogs, PermissionReadOwners, PermissionWriteOwners},
+		RoleAdmin:  {authx.AnyPermission},
+	})
+}
+
+// WithPolicy makes every DogService method check the principal on the context against the policy, without it the
+// service trusts its callers
+func WithPolicy(policy *authx.Policy) DogServiceOption {
+	return func(ds *DogService) {
+		ds.policy = policy
+	}
+}
+
+// authorize checks permission before a method touches the store, denials are logged as audit events with the principal
+// WrapTraceContext picks up from ctx
+func (ds *DogService) authorize(ctx context.Context, permission string) error {
+	return authorize(ctx, ds.policy, ds.appLogger, permission)
+}
+
+// authorize checks the principal on ctx against policy, a nil policy allows everything
+func authorize(ctx context.Context, policy *authx.Policy, logger *logx.AppLogger, permission string) error {
+	if policy == nil {
+		return nil
+	}
+	err := policy.Authorize(ctx, permission)
+	if err != nil {
+		logger.WrapTraceContext(ctx).Warnw("authorization denied", "audit", "authz", "permission", permission, "decision", "deny", "err", err)
+	}
+	return err
+}
@@ -0,0 +1,29 @@
+package gotoproduction
+
+import (
+	"context"
+	"errors"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"testing"
+	"time"
+)
+
+func TestDogService_policy(t *testing.T) {
+	is := is.New(t)
+	store := NewMemoryStore()
+	ds := NewDogService(store, logx.NewTesterLogger(t), WithPolicy(DefaultPolicy()))
+	editor := authx.NewContext(context.Background(), authx.Principal{Subject: "user-1", Roles: []string{RoleEditor}})
+
+	_, err := ds.CreateDog(context.Background(), &CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+	is.True(errors.Is(err, authx.ErrUnauthenticated)) // the service itself refuses callers without a principal
+
+	id, err := ds.CreateDog(editor, &CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+	is.NoErr(err) // editors can create
+
+	err = ds.DeleteDog(editor, id, time.Time{})
+	is.True(errors.Is(err, authx.ErrPermissionDenied)) // but not delete
+	_, err = store.GetDog(context.Background(), id)
+	is.NoErr(err) // the denied delete never reached the store
+}
@@ -0,0 +1,90 @@
+package gotoproduction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// MigrationPrincipal is the actor of the audit entries written by data migrations
+var MigrationPrincipal = authx.Principal{Subject: "migration", Method: "system"}
+
+// BreedMigrationResult says what a breed migration did, dogs of an unknown type are left as they are and listed so
+// someone can fix them by hand
+type BreedMigrationResult struct {
+	Scanned   int
+	Rewritten int
+	// Conflicts are dogs that changed while they were being rewritten, running the migration again picks them up
+	Conflicts int
+	// Unknown maps the ids of dogs whose type is not in the catalog to that type
+	Unknown map[string]string
+}
+
+// BreedMigrator rewrites the type of dogs written before the breed catalog existed to the canonical breed id. Like the
+// Purger it works on the store directly, deleted dogs included
+type BreedMigrator struct {
+	store     DogStore
+	catalog   *BreedCatalog
+	appLogger *logx.AppLogger
+	batchSize int
+}
+
+// NewBreedMigrator creates a migrator that reads batchSize dogs at a time and resolves their types through catalog
+func NewBreedMigrator(store DogStore, catalog *BreedCatalog, logger *logx.AppLogger, batchSize int) *BreedMigrator {
+	return &BreedMigrator{store: store, catalog: catalog, appLogger: logger, batchSize: batchSize}
+}
+
+// Migrate walks every dog in created order and rewrites those whose type is a known breed under anything but its id.
+// Every rewrite is an audited update conditional on the dog not having changed since it was read, so running it again
+// or next to live traffic is safe
+func (m *BreedMigrator) Migrate(ctx context.Context) (*BreedMigrationResult, error) {
+	ctx, span := otel.Tracer("gotoproduction").Start(ctx, "BreedMigrator.Migrate")
+	defer span.End()
+	ctx = authx.NewContext(ctx, MigrationPrincipal)
+	logger := m.appLogger.WrapTraceContext(ctx)
+
+	result := &BreedMigrationResult{Unknown: map[string]string{}}
+	// the created timestamp never changes, so rewriting dogs doesn't move them around under the cursor
+	query := DogQuery{OrderBy: DogOrderCreated, Limit: m.batchSize, IncludeDeleted: true}
+	for {
+		dogs, err := m.store.ListDogs(ctx, query)
+		if err != nil {
+			return result, fmt.Errorf("m.store.ListDogs(): %w", err)
+		}
+		for _, dog := range dogs {
+			result.Scanned++
+			breed, ok := m.catalog.Lookup(dog.Type)
+			if !ok {
+				result.Unknown[dog.ID] = dog.Type
+				continue
+			}
+			if dog.Type == breed.ID {
+				continue
+			}
+			migrated := copyDog(dog)
+			migrated.Type = breed.ID
+			audit := newAuditEntry(ctx, AuditActionUpdate, dog, migrated, time.Now().UTC())
+			_, err := m.store.UpdateDog(ctx, migrated, dog.UpdateTime, audit)
+			switch {
+			case errors.Is(err, ErrDogConflict), errors.Is(err, ErrDogNotFound):
+				result.Conflicts++
+				logger.Debugw("dog changed while migrating its breed", "id", dog.ID)
+			case err != nil:
+				return result, fmt.Errorf("m.store.UpdateDog(%q): %w", dog.ID, err)
+			default:
+				result.Rewritten++
+			}
+		}
+		if m.batchSize <= 0 || len(dogs) < m.batchSize {
+			span.SetAttributes(attribute.Int("scanned", result.Scanned), attribute.Int("rewritten", result.Rewritten))
+			return result, nil
+		}
+		logger.Debugw("migrated batch", "scanned", result.Scanned, "rewritten", result.Rewritten)
+		query.StartAfter = cursorOf(dogs[len(dogs)-1])
+	}
+}
@@ -0,0 +1,209 @@
+package gotoproduction
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrBreedNotFound represents a breed that is not in the catalog under any of its names
+var ErrBreedNotFound = errors.New("breed not found")
+
+// DefaultBreedSearchLimit is how many breeds an autocomplete returns when the caller doesn't say
+const DefaultBreedSearchLimit = 10
+
+//go:embed breeds.json
+var breedsJSON []byte
+
+// defaultBreedCatalog is built from breeds.json when the package loads, a broken data file fails every test
+var defaultBreedCatalog = mustLoadBreedCatalog(breedsJSON)
+
+// Breed is one entry of the breed catalog, dogs store the ID as their type
+type Breed struct {
+	// ID is the canonical id, lower case words joined by hyphens
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Aliases are other names people use for the breed, they resolve to the same ID
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// BreedCatalog resolves the many ways people write a breed to one canonical id. Lookups ignore case, spaces and
+// punctuation, so "Golden Doodle", "golden-doodle" and "Goldendoodle" are the same breed
+type BreedCatalog struct {
+	// breeds is sorted by name
+	breeds []*Breed
+	byKey  map[string]*Breed
+}
+
+// NewBreedCatalog builds a catalog, failing when an id is malformed or two breeds share a name once normalized
+func NewBreedCatalog(breeds []*Breed) (*BreedCatalog, error) {
+	c := &BreedCatalog{byKey: map[string]*Breed{}}
+	for _, breed := range breeds {
+		if breed.ID == "" || breed.ID != breedID(breed.ID) {
+			return nil, fmt.Errorf("breed id %q must be lower case words joined by hyphens", breed.ID)
+		}
+		if strings.TrimSpace(breed.Name) == "" {
+			return nil, fmt.Errorf("breed %q has no name", breed.ID)
+		}
+		breed = copyBreed(breed)
+		for _, name := range breed.names() {
+			key := breedKey(name)
+			if other, ok := c.byKey[key]; ok && other != breed {
+				return nil, fmt.Errorf("%q of breed %q is already a name of breed %q", name, breed.ID, other.ID)
+			}
+			c.byKey[key] = breed
+		}
+		c.breeds = append(c.breeds, breed)
+	}
+	sort.Slice(c.breeds, func(i, j int) bool {
+		return c.breeds[i].Name < c.breeds[j].Name
+	})
+	return c, nil
+}
+
+// DefaultBreedCatalog is the catalog shipped with the service, it is what dog types are validated and normalized against
+func DefaultBreedCatalog() *BreedCatalog {
+	return defaultBreedCatalog
+}
+
+func mustLoadBreedCatalog(data []byte) *BreedCatalog {
+	var breeds []*Breed
+	if err := json.Unmarshal(data, &breeds); err != nil {
+		panic(fmt.Sprintf("json.Unmarshal(breeds.json): %v", err))
+	}
+	catalog, err := NewBreedCatalog(breeds)
+	if err != nil {
+		panic(fmt.Sprintf("NewBreedCatalog(breeds.json): %v", err))
+	}
+	return catalog
+}
+
+// Lookup finds the breed value is the id, name or an alias of
+func (c *BreedCatalog) Lookup(value string) (*Breed, bool) {
+	breed, ok := c.byKey[breedKey(value)]
+	if !ok {
+		return nil, false
+	}
+	return copyBreed(breed), true
+}
+
+// Canonical is the id of the breed value names, or value itself when it isn't a known breed so lookups of types
+// written before the catalog existed still work
+func (c *BreedCatalog) Canonical(value string) string {
+	if breed, ok := c.byKey[breedKey(value)]; ok {
+		return breed.ID
+	}
+	return value
+}
+
+// Search autocompletes query against the id, name and aliases of every breed, also matching the start of any of their
+// words. Breeds whose name matches come before those matching only by alias, then they are ordered by name. An empty
+// query returns every breed. limit <= 0 means no limit
+func (c *BreedCatalog) Search(query string, limit int) []*Breed {
+	prefix := breedKey(query)
+	type match struct {
+		breed  *Breed
+		byName bool
+	}
+	var matches []match
+	for _, breed := range c.breeds {
+		switch {
+		case prefix == "" || nameMatches(breed.Name, prefix) || nameMatches(breed.ID, prefix):
+			matches = append(matches, match{breed: breed, byName: true})
+		default:
+			for _, alias := range breed.Aliases {
+				if nameMatches(alias, prefix) {
+					matches = append(matches, match{breed: breed})
+					break
+				}
+			}
+		}
+	}
+	// c.breeds is sorted by name already, a stable sort keeps that within each group
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].byName && !matches[j].byName
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	breeds := make([]*Breed, 0, len(matches))
+	for _, m := range matches {
+		breeds = append(breeds, copyBreed(m.breed))
+	}
+	return breeds
+}
+
+// nameMatches says whether name, or one of its words, starts with the normalized prefix
+func nameMatches(name, prefix string) bool {
+	if strings.HasPrefix(breedKey(name), prefix) {
+		return true
+	}
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == ' ' || r == '-' }) {
+		if strings.HasPrefix(breedKey(word), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Breed) names() []string {
+	return append([]string{b.ID, b.Name}, b.Aliases...)
+}
+
+// breedKey is what names are compared by, lower case letters and digits only
+func breedKey(value string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(value) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+// breedID is the canonical form of an id, lower case words joined by single hyphens
+func breedID(value string) string {
+	words := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "-")
+}
+
+func copyBreed(breed *Breed) *Breed {
+	c := *breed
+	c.Aliases = append([]string(nil), breed.Aliases...)
+	return &c
+}
+
+// SearchBreeds autocompletes query against the breed catalog, see BreedCatalog.Search
+func (ds *DogService) SearchBreeds(ctx context.Context, query string, limit int) (_ []*Breed, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.SearchBreeds")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "SearchBreeds", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionReadDogs); err != nil {
+		return nil, err
+	}
+	return defaultBreedCatalog.Search(query, limit), nil
+}
+
+// GetBreed retrieves 1 breed by its id or any of its names
+func (ds *DogService) GetBreed(ctx context.Context, name string) (_ *Breed, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.GetBreed")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "GetBreed", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionReadDogs); err != nil {
+		return nil, err
+	}
+	breed, ok := defaultBreedCatalog.Lookup(name)
+	if !ok {
+		return nil, ErrBreedNotFound
+	}
+	return breed, nil
+}
@@ -0,0 +1,81 @@
+package gotoproduction_test
+
+import (
+	"context"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"testing"
+)
+
+func TestBreedCatalog(t *testing.T) {
+	is := is.New(t)
+	catalog := gotoproduction.DefaultBreedCatalog()
+
+	for _, name := range []string{"Golden Doodle", "golden doodle", "Goldendoodle", "golden-doodle", "GROODLE"} {
+		breed, ok := catalog.Lookup(name)
+		is.True(ok)                           // every spelling is known
+		is.Equal(breed.ID, "golden-doodle")   // and resolves to the same breed
+		is.Equal(breed.Name, "Golden Doodle") // with its display name
+	}
+	is.Equal(catalog.Canonical("Alsatian"), "german-shepherd") // aliases resolve too
+	is.Equal(catalog.Canonical("Unicorn"), "Unicorn")          // unknown types are left alone
+
+	names := func(breeds []*gotoproduction.Breed) []string {
+		var names []string
+		for _, breed := range breeds {
+			names = append(names, breed.Name)
+		}
+		return names
+	}
+	is.Equal(names(catalog.Search("gold", 0)), []string{"Golden Doodle", "Golden Retriever"})
+	is.Equal(names(catalog.Search("retr", 0)), []string{"Golden Retriever", "Labrador Retriever"}) // any word of the name
+	is.Equal(names(catalog.Search("lab", 0)), []string{"Labradoodle", "Labrador Retriever"})
+	is.Equal(names(catalog.Search("yorkie", 0)), []string{"Yorkshire Terrier"})                                                 // by alias
+	is.Equal(names(catalog.Search("do", 0)), []string{"Bernese Mountain Dog", "Golden Doodle", "Dachshund", "German Shepherd"}) // names before aliases
+	is.Equal(names(catalog.Search("do", 1)), []string{"Bernese Mountain Dog"})                                                  // limited
+	is.Equal(catalog.Search("", 0)[0].Name, "Beagle")                                                                           // everything without a query
+	is.Equal(len(catalog.Search("zzz", 0)), 0)                                                                                  // nothing matches
+
+	_, err := gotoproduction.NewBreedCatalog([]*gotoproduction.Breed{
+		{ID: "golden-doodle", Name: "Golden Doodle"},
+		{ID: "groodle", Name: "Groodle", Aliases: []string{"Goldendoodle"}},
+	})
+	is.True(err != nil) // two breeds can't share a name
+	_, err = gotoproduction.NewBreedCatalog([]*gotoproduction.Breed{{ID: "Golden Doodle", Name: "Golden Doodle"}})
+	is.True(err != nil) // ids are canonical
+}
+
+func TestBreedMigrator_Migrate(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+	ds := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+
+	// dogs written before the catalog existed, straight to the store like the old service did
+	ids := map[string]string{}
+	for _, dogType := range []string{"Golden Doodle", "goldendoodle", "golden-doodle", "Alsatian", "Unicorn"} {
+		id, err := store.CreateDog(ctx, &gotoproduction.Dog{Name: "Oscar", Age: 1, Type: dogType}, &gotoproduction.AuditEntry{Action: gotoproduction.AuditActionCreate})
+		is.NoErr(err) // store.CreateDog error
+		ids[dogType] = id
+	}
+
+	result, err := gotoproduction.NewBreedMigrator(store, gotoproduction.DefaultBreedCatalog(), logx.NewTesterLogger(t), 2).Migrate(ctx)
+	is.NoErr(err)                                                          // Migrate error
+	is.Equal(result.Scanned, 5)                                            // every dog, over three batches
+	is.Equal(result.Rewritten, 3)                                          // the canonical one is left alone
+	is.Equal(result.Unknown, map[string]string{ids["Unicorn"]: "Unicorn"}) // and so is the one that isn't a breed
+
+	dogs, err := ds.FindDogByType(ctx, "Groodle")
+	is.NoErr(err)          // ds.FindDogByType error
+	is.Equal(len(dogs), 3) // all the spellings are one breed now
+	history, err := ds.ListDogHistory(ctx, ids["goldendoodle"], &gotoproduction.ListDogHistoryRequest{})
+	is.NoErr(err) // ds.ListDogHistory error
+	migration := history.Entries[len(history.Entries)-1]
+	is.Equal(migration.Actor, gotoproduction.MigrationPrincipal.Subject) // the rewrite is audited as the migration
+	is.Equal(migration.Changes, []gotoproduction.AuditChange{{Field: "type", Before: "goldendoodle", After: "golden-doodle"}})
+
+	result, err = gotoproduction.NewBreedMigrator(store, gotoproduction.DefaultBreedCatalog(), logx.NewTesterLogger(t), 2).Migrate(ctx)
+	is.NoErr(err)                 // Migrate error
+	is.Equal(result.Rewritten, 0) // running it again changes nothing
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"cloud.google.com/go/compute/metadata"
+	"cloud.google.com/go/firestore"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/config"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/amammay/gotoproduction/internal/tracex"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// traceShutdownTimeout bounds how long we wait on the trace exporter to flush on the way out
+	traceShutdownTimeout = 5 * time.Second
+	// backfillBatchSize is how many dogs are read per query
+	backfillBatchSize = 200
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "run(): %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run backfills the fields live dog queries filter on into every dog written before they existed and exits. It has to
+// run before a server filtering on them takes traffic, running it again only touches dogs it missed the first time
+func run() error {
+	// stop between dogs on ctrl + c or sig term, whatever was backfilled so far stays backfilled
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg := config.Default()
+	cfg.ServiceName = "gotoproduction-backfilldogs"
+	if err := cfg.Load(os.Args[1:], os.Getenv); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return fmt.Errorf("cfg.Load(): %w", err)
+	}
+	if err := cfg.ResolvePlatform(config.Platform{
+		OnGCE:      metadata.OnGCE(),
+		ProjectID:  metadata.ProjectID,
+		InstanceID: metadata.InstanceID,
+	}); err != nil {
+		return fmt.Errorf("cfg.ResolvePlatform(): %w", err)
+	}
+	if cfg.PrintConfig {
+		return cfg.Print(os.Stdout)
+	}
+
+	logger, err := logx.NewLogger(cfg.ProjectID, cfg.LogFormat == config.LogFormatJSON, cfg.LogLevel)
+	if err != nil {
+		return fmt.Errorf("logx.NewLogger(): %v", err)
+	}
+	defer logger.Sync()
+
+	tracing, err := tracex.InitTracing(ctx, tracex.Options{
+		Exporter:       cfg.TraceExporter,
+		ProjectID:      cfg.ProjectID,
+		Endpoint:       cfg.TraceEndpoint,
+		SampleRatio:    cfg.TraceSampleRatio,
+		ServiceName:    cfg.ServiceName,
+		ServiceVersion: cfg.ServiceVersion,
+		InstanceID:     cfg.InstanceID,
+	})
+	if err != nil {
+		return fmt.Errorf("tracex.InitTracing(): %v", err)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+		defer cancel()
+		if err := tracing.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("tracing.Shutdown(): %v", err)
+		}
+	}()
+
+	fsClient, err := firestore.NewClient(ctx, cfg.ProjectID)
+	if err != nil {
+		return fmt.Errorf("firestore.NewClient(): %w", err)
+	}
+	defer fsClient.Close()
+
+	result, err := gotoproduction.NewFirestoreStore(fsClient).BackfillDogs(ctx, backfillBatchSize)
+	if err != nil {
+		return fmt.Errorf("BackfillDogs(): backfilled %d dogs before failing: %w", result.Backfilled, err)
+	}
+	logger.Infof("scanned %d dogs, backfilled %d and %d changed while backfilling", result.Scanned, result.Backfilled, result.Conflicts)
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"cloud.google.com/go/compute/metadata"
+	"cloud.google.com/go/firestore"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/config"
+	"github.com/amammay/gotoproduction/internal/dogrpc"
+	"github.com/amammay/gotoproduction/internal/limitx"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/amammay/gotoproduction/internal/tracex"
+	"golang.org/x/sync/errgroup"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// traceShutdownTimeout bounds how long we wait on the trace exporter to flush on the way out
+const traceShutdownTimeout = 5 * time.Second
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "run(): %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run mirrors cmd/http, it sets up our deps and serves the grpc api until we get a shutdown signal
+func run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// same config as cmd/http, we just default to the port next to it
+	cfg := config.Default()
+	cfg.Port = "8081"
+	cfg.ServiceName = "gotoproduction-grpc"
+	if err := cfg.Load(os.Args[1:], os.Getenv); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return fmt.Errorf("cfg.Load(): %w", err)
+	}
+	if err := cfg.ResolvePlatform(config.Platform{
+		OnGCE:      metadata.OnGCE(),
+		ProjectID:  metadata.ProjectID,
+		InstanceID: metadata.InstanceID,
+	}); err != nil {
+		return fmt.Errorf("cfg.ResolvePlatform(): %w", err)
+	}
+	if cfg.PrintConfig {
+		return cfg.Print(os.Stdout)
+	}
+
+	logger, err := logx.NewLogger(cfg.ProjectID, cfg.LogFormat == config.LogFormatJSON, cfg.LogLevel)
+	if err != nil {
+		return fmt.Errorf("logx.NewLogger(): %v", err)
+	}
+	defer logger.Sync()
+
+	// init open telemetry, the logger comes first so we can report a failed span flush on the way out
+	tracing, err := tracex.InitTracing(ctx, tracex.Options{
+		Exporter:       cfg.TraceExporter,
+		ProjectID:      cfg.ProjectID,
+		Endpoint:       cfg.TraceEndpoint,
+		SampleRatio:    cfg.TraceSampleRatio,
+		ServiceName:    cfg.ServiceName,
+		ServiceVersion: cfg.ServiceVersion,
+		InstanceID:     cfg.InstanceID,
+	})
+	if err != nil {
+		return fmt.Errorf("tracex.InitTracing(): %v", err)
+	}
+	defer func() {
+		// our own ctx is already cancelled by the time we get here, give the exporter a fresh deadline to flush
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+		defer cancel()
+		if err := tracing.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("tracing.Shutdown(): %v", err)
+		}
+	}()
+
+	fsClient, err := firestore.NewClient(ctx, cfg.ProjectID)
+	if err != nil {
+		return fmt.Errorf("firestore.NewClient(): %w", err)
+	}
+
+	var dogOpts []gotoproduction.DogServiceOption
+	if cfg.PageTokenSecret != "" {
+		dogOpts = append(dogOpts, gotoproduction.WithPageTokenKey([]byte(cfg.PageTokenSecret)))
+	}
+
+	// the same load shedding and rate limits as the rest api
+	guards := dogrpc.Guards{TrustedProxies: cfg.TrustedProxies}
+	if cfg.MaxInFlight > 0 {
+		guards.InFlight = limitx.NewGate(cfg.MaxInFlight)
+	}
+	if cfg.RateLimitRPS > 0 {
+		guards.Requests = limitx.NewLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+		guards.AuthFailures = limitx.NewAuthFailureLimiter()
+	}
+
+	// every dog api request has to authenticate unless we are explicitly told not to
+	if cfg.AuthDisabled {
+		logger.Info("authentication is disabled, anyone who can reach this server can change dogs")
+	} else {
+		authenticator, err := authx.New(authx.Options{
+			JWKSFile:    cfg.AuthJWKSFile,
+			JWTSecret:   cfg.AuthJWTSecret,
+			Issuer:      cfg.AuthJWTIssuer,
+			Audience:    cfg.AuthJWTAudience,
+			APIKeysFile: cfg.AuthAPIKeysFile,
+		})
+		if err != nil {
+			return fmt.Errorf("authx.New(): %w", err)
+		}
+		policy := gotoproduction.DefaultPolicy()
+		if cfg.AuthPolicyFile != "" {
+			if policy, err = authx.LoadPolicy(cfg.AuthPolicyFile); err != nil {
+				return fmt.Errorf("authx.LoadPolicy(): %w", err)
+			}
+		}
+		dogOpts = append(dogOpts, gotoproduction.WithPolicy(policy))
+		guards.Authenticator = authenticator
+	}
+	dogService := gotoproduction.NewDogService(gotoproduction.NewFirestoreStore(fsClient), logger, dogOpts...)
+	grpcServer := dogrpc.NewServer(dogService, logger, dogrpc.WithGuards(guards)...)
+
+	addr := cfg.Addr()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("net.Listen(%q): %w", addr, err)
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		select {
+		case o := <-shutdown:
+			logger.Infof("sig: %s - starting shutting down sequence...", o)
+		case <-ctx.Done():
+		}
+		// health checks go NOT_SERVING right away, then in flight rpcs get whatever is left of the shutdown grace
+		deadline := time.After(cfg.ShutdownGrace)
+		grpcServer.Drain()
+		time.Sleep(cfg.DrainDelay)
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			logger.Info("server has shutdown gracefully")
+		case <-deadline:
+			grpcServer.Stop()
+			logger.Info("shutdown grace expired, stopped in flight rpcs")
+		}
+		return nil
+	})
+	g.Go(func() error {
+		logger.Infof("starting grpc server on %q", addr)
+		if err := grpcServer.Serve(listener); err != nil {
+			return fmt.Errorf("grpcServer.Serve(): %w", err)
+		}
+		return nil
+	})
+	return g.Wait()
+}
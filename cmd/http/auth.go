@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// apiKeyHeader is where clients without a bearer token send their api key
+const apiKeyHeader = "X-API-Key"
+
+// withAuthenticator requires every dog api request to authenticate, health checks and metrics stay open for probes and
+// scrapers
+func withAuthenticator(a *authx.Authenticator) serverOption {
+	return func(s *server) {
+		s.authenticator = a
+	}
+}
+
+// authenticate puts the principal on the request context and the span, or answers 401 without calling next. Failures
+// count against the ip, one that failed too often is answered 429 before its credentials are even looked at
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var ip string
+		if s.authFailures != nil {
+			ip = clientIP(request, s.trustedProxies)
+			if result := s.authFailures.Peek(ip); !result.Allowed {
+				s.respondRateLimited(writer, request, result)
+				return
+			}
+		}
+		principal, err := s.authenticator.Authenticate(request.Header.Get("Authorization"), request.Header.Get(apiKeyHeader))
+		if err != nil {
+			if s.authFailures != nil {
+				s.authFailures.Take(ip)
+			}
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="gotoproduction"`)
+			s.respondErr(writer, request, err)
+			return
+		}
+		ctx := request.Context()
+		trace.SpanFromContext(ctx).SetAttributes(principal.Attributes()...)
+		next.ServeHTTP(writer, request.WithContext(authx.NewContext(ctx, principal)))
+	})
+}
+
+// requireScope rejects bearer tokens whose scope claim doesn't cover the route, what the principal's roles allow is up
+// to the DogService policy
+func (s *server) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		if principal, ok := authx.FromContext(ctx); ok && !principal.HasScope(scope) {
+			err := fmt.Errorf("%w: token scope does not include %s", authx.ErrPermissionDenied, scope)
+			s.appLogger.WrapTraceContext(ctx).Warnw("authorization denied", "audit", "authz", "permission", scope, "decision", "deny", "err", err)
+			s.respondErr(writer, request, err)
+			return
+		}
+		next(writer, request)
+	}
+}
+
+// errUnauthenticated keeps the reason a credential was rejected out of the response, it only goes to our logs
+func errUnauthenticated(cause error) *httpError {
+	return &httpError{status: http.StatusUnauthorized, kind: "unauthenticated", title: "Unauthenticated", detail: "a valid bearer token or api key is required", cause: cause}
+}
+
+// errForbidden is for principals the policy or their token scope does not allow the request, the reason only goes to
+// our logs
+func errForbidden(cause error) *httpError {
+	return &httpError{status: http.StatusForbidden, kind: "forbidden", title: "Forbidden", detail: "you are not allowed to do this", cause: cause}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/amammay/gotoproduction/internal/tracex"
+	"github.com/matryer/is"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testJWTSecret signs the bearer tokens newTestAuthenticator accepts
+const testJWTSecret = "0123456789abcdef0123456789abcdef"
+
+// newTestAuthenticator accepts bearer tokens signed with testJWTSecret and the api key "<name>-key" for every name
+// given, with the name as its only role
+func newTestAuthenticator(t *testing.T, names ...string) *authx.Authenticator {
+	t.Helper()
+	var keys []authx.APIKey
+	for _, name := range names {
+		keys = append(keys, authx.APIKey{Name: name, SHA256: authx.HashAPIKey(name + "-key"), Roles: []string{name}})
+	}
+	raw, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatalf("json.Marshal() err = %v; want nil", err)
+	}
+	path := filepath.Join(t.TempDir(), "api-keys.json")
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatalf("os.WriteFile() err = %v; want nil", err)
+	}
+	a, err := authx.New(authx.Options{APIKeysFile: path, JWTSecret: testJWTSecret})
+	if err != nil {
+		t.Fatalf("authx.New() err = %v; want nil", err)
+	}
+	return a
+}
+
+// signTestToken signs a token for subject that newTestAuthenticator accepts, scope is left off the token when nil
+func signTestToken(t *testing.T, subject string, roles []string, scope *string) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte(testJWTSecret)}, nil)
+	if err != nil {
+		t.Fatalf("jose.NewSigner() err = %v; want nil", err)
+	}
+	claims := map[string]interface{}{
+		"sub":   subject,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": roles,
+	}
+	if scope != nil {
+		claims["scope"] = *scope
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("CompactSerialize() err = %v; want nil", err)
+	}
+	return token
+}
+
+func Test_server_auth(t *testing.T) {
+	tracing, err := tracex.InitTracing(context.Background(), tracex.Options{Exporter: tracex.ExporterMemory, SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("tracex.InitTracing() err = %v; want nil", err)
+	}
+	defer tracing.Shutdown(context.Background())
+	s := newServer(gotoproduction.NewMemoryStore(), logx.NewTesterLogger(t), withAuthenticator(newTestAuthenticator(t, "ci")))
+
+	t.Run("no credentials", func(t *testing.T) {
+		is := is.New(t)
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs", strings.NewReader(`{"name":"Oscar","age":1,"type":"Golden Doodle"}`)))
+		result := recorder.Result()
+		is.Equal(result.StatusCode, http.StatusUnauthorized)            // dog api requires credentials
+		is.Equal(result.Header.Get("content-type"), problemContentType) // rendered as a problem
+		is.True(result.Header.Get("WWW-Authenticate") != "")            // tells the client how to authenticate
+		var p problem
+		is.NoErr(json.NewDecoder(result.Body).Decode(&p)) // json decode error
+		is.Equal(p.Type, problemTypePrefix+"unauthenticated")
+	})
+
+	t.Run("wrong api key", func(t *testing.T) {
+		is := is.New(t)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/dogs", nil)
+		request.Header.Set(apiKeyHeader, "guess")
+		s.ServeHTTP(recorder, request)
+		is.Equal(recorder.Code, http.StatusUnauthorized) // unknown keys are rejected
+	})
+
+	t.Run("api key", func(t *testing.T) {
+		is := is.New(t)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/dogs", strings.NewReader(`{"name":"Oscar","age":1,"type":"Golden Doodle"}`))
+		request.Header.Set(apiKeyHeader, "ci-key")
+		s.ServeHTTP(recorder, request)
+		is.Equal(recorder.Code, http.StatusOK) // authenticated requests go through
+
+		var recorded bool
+		for _, span := range tracing.Spans() {
+			for _, kv := range span.Attributes {
+				if kv.Key == "enduser.id" && kv.Value.AsString() == "ci" {
+					recorded = true
+				}
+			}
+		}
+		is.True(recorded) // principal recorded on the request span
+	})
+
+	t.Run("probes stay open", func(t *testing.T) {
+		is := is.New(t)
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		is.Equal(recorder.Code, http.StatusOK) // health checks don't authenticate
+	})
+}
+
+func Test_server_authorization(t *testing.T) {
+	logger := logx.NewTesterLogger(t)
+	const dogBody = `{"name":"Oscar","age":1,"type":"Golden Doodle"}`
+	readOnly, createOnly := gotoproduction.PermissionReadDogs, gotoproduction.PermissionCreateDogs
+
+	tests := []struct {
+		name   string
+		role   string
+		token  *string // scope of a bearer token, the role's api key is used when nil
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "viewer lists", role: gotoproduction.RoleViewer, method: http.MethodGet, path: "/dogs", want: http.StatusOK},
+		{name: "viewer gets", role: gotoproduction.RoleViewer, method: http.MethodGet, path: "/dogs/{id}", want: http.StatusOK},
+		{name: "viewer finds", role: gotoproduction.RoleViewer, method: http.MethodGet, path: "/dogs/find?type=Golden%20Doodle", want: http.StatusOK},
+		{name: "viewer creates", role: gotoproduction.RoleViewer, method: http.MethodPost, path: "/dogs", body: dogBody, want: http.StatusForbidden},
+		{name: "viewer patches", role: gotoproduction.RoleViewer, method: http.MethodPatch, path: "/dogs/{id}", body: `{"age":2}`, want: http.StatusForbidden},
+		{name: "viewer deletes", role: gotoproduction.RoleViewer, method: http.MethodDelete, path: "/dogs/{id}", want: http.StatusForbidden},
+		{name: "editor creates", role: gotoproduction.RoleEditor, method: http.MethodPost, path: "/dogs", body: dogBody, want: http.StatusOK},
+		{name: "editor updates", role: gotoproduction.RoleEditor, method: http.MethodPut, path: "/dogs/{id}", body: dogBody, want: http.StatusOK},
+		{name: "editor patches", role: gotoproduction.RoleEditor, method: http.MethodPatch, path: "/dogs/{id}", body: `{"age":2}`, want: http.StatusOK},
+		{name: "editor deletes", role: gotoproduction.RoleEditor, method: http.MethodDelete, path: "/dogs/{id}", want: http.StatusForbidden},
+		{name: "admin deletes", role: gotoproduction.RoleAdmin, method: http.MethodDelete, path: "/dogs/{id}", want: http.StatusNoContent},
+		{name: "editor reads history", role: gotoproduction.RoleEditor, method: http.MethodGet, path: "/dogs/{id}/history", want: http.StatusForbidden},
+		{name: "admin reads history", role: gotoproduction.RoleAdmin, method: http.MethodGet, path: "/dogs/{id}/history", want: http.StatusOK},
+		{name: "no role reads", role: "nobody", method: http.MethodGet, path: "/dogs", want: http.StatusForbidden},
+		{name: "scoped token reads", role: gotoproduction.RoleAdmin, token: &readOnly, method: http.MethodGet, path: "/dogs", want: http.StatusOK},
+		{name: "scoped token deletes", role: gotoproduction.RoleAdmin, token: &readOnly, method: http.MethodDelete, path: "/dogs/{id}", want: http.StatusForbidden},
+		{name: "scoped token beyond role", role: gotoproduction.RoleViewer, token: &createOnly, method: http.MethodPost, path: "/dogs", body: dogBody, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			store := gotoproduction.NewMemoryStore()
+			id, err := store.CreateDog(context.Background(), &gotoproduction.Dog{Name: "Bella", Age: 3, Type: "Golden Doodle"}, &gotoproduction.AuditEntry{})
+			is.NoErr(err) // store.CreateDog error
+			s := newServer(store, logger,
+				withAuthenticator(newTestAuthenticator(t, gotoproduction.RoleViewer, gotoproduction.RoleEditor, gotoproduction.RoleAdmin, "nobody")),
+				withDogServiceOptions(gotoproduction.WithPolicy(gotoproduction.DefaultPolicy())),
+			)
+
+			request := httptest.NewRequest(tt.method, strings.Replace(tt.path, "{id}", id, 1), strings.NewReader(tt.body))
+			if tt.token != nil {
+				request.Header.Set("Authorization", "Bearer "+signTestToken(t, "user-1", []string{tt.role}, tt.token))
+			} else {
+				request.Header.Set(apiKeyHeader, tt.role+"-key")
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, request)
+			is.Equal(recorder.Code, tt.want) // status for the role
+			if tt.want == http.StatusForbidden {
+				var p problem
+				is.NoErr(json.NewDecoder(recorder.Body).Decode(&p)) // json decode error
+				is.Equal(p.Type, problemTypePrefix+"forbidden")     // rendered as a forbidden problem
+			}
+		})
+	}
+}
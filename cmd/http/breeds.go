@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/amammay/gotoproduction"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// handleSearchBreeds lists the breed catalog, with q it autocompletes and returns up to limit breeds
+func (s *server) handleSearchBreeds(dogService *gotoproduction.DogService) http.HandlerFunc {
+	type searchBreedsResponse struct {
+		Breeds []*gotoproduction.Breed `json:"breeds"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit, err := parsePageSize(query.Get("limit"))
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("limit", err))
+			return
+		}
+		q := query.Get("q")
+		if limit == 0 && q != "" {
+			limit = gotoproduction.DefaultBreedSearchLimit
+		}
+		breeds, err := dogService.SearchBreeds(r.Context(), q, limit)
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		s.respond(w, &searchBreedsResponse{Breeds: breeds}, http.StatusOK)
+	}
+}
+
+// handleGetBreed resolves any name of a breed to its catalog entry
+func (s *server) handleGetBreed(dogService *gotoproduction.DogService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		breed, err := dogService.GetBreed(r.Context(), mux.Vars(r)["breedID"])
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		s.respond(w, breed, http.StatusOK)
+	}
+}
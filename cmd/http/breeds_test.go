@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_server_breeds(t *testing.T) {
+	is := is.New(t)
+	store := gotoproduction.NewMemoryStore()
+	s := newServer(store, logx.NewTesterLogger(t))
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/breeds?q=gold", nil))
+	is.Equal(recorder.Code, http.StatusOK)
+	var search struct {
+		Breeds []*gotoproduction.Breed `json:"breeds"`
+	}
+	is.NoErr(json.NewDecoder(recorder.Body).Decode(&search)) // json decode error
+	is.Equal(len(search.Breeds), 2)                          // golden doodle and golden retriever
+	is.Equal(search.Breeds[0].ID, "golden-doodle")
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/breeds?limit=-1", nil))
+	is.Equal(recorder.Code, http.StatusBadRequest)
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/breeds/Groodle", nil))
+	is.Equal(recorder.Code, http.StatusOK)
+	is.True(strings.Contains(recorder.Body.String(), `"id":"golden-doodle"`)) // aliases resolve to the breed
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/breeds/unicorn", nil))
+	is.Equal(recorder.Code, http.StatusNotFound)
+	is.Equal(decodeProblem(t, recorder.Result()).Type, problemTypePrefix+"breed-not-found")
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs", strings.NewReader(`{"name":"Oscar","age":1,"type":"golden doodle"}`)))
+	is.Equal(recorder.Code, http.StatusOK)
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/find?type=Goldendoodle", nil))
+	is.Equal(recorder.Code, http.StatusOK)
+	is.True(strings.Contains(recorder.Body.String(), `"type":"golden-doodle"`)) // stored canonical, found by alias
+}
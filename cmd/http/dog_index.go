@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"sync/atomic"
+	"time"
+)
+
+// indexClockSkew is how far before a rebuild started the index follows changes from, so a write firestore timestamped a
+// little earlier than our clock says can't slip between the rebuild and the watch
+const indexClockSkew = time.Minute
+
+// minRebuildBackoff and maxRebuildBackoff bound how long a failed rebuild waits before it is tried again
+const (
+	minRebuildBackoff = time.Second
+	maxRebuildBackoff = time.Minute
+)
+
+var errIndexRebuilding = errors.New("dog index is still being rebuilt")
+
+// indexStatus says whether the dog index has been rebuilt since startup, fuzzy searches would miss dogs until then
+type indexStatus struct {
+	rebuilt int32
+}
+
+func (st *indexStatus) markRebuilt() {
+	atomic.StoreInt32(&st.rebuilt, 1)
+}
+
+// check is a readiness check failing until the first rebuild finished
+func (st *indexStatus) check(ctx context.Context) error {
+	if atomic.LoadInt32(&st.rebuilt) == 0 {
+		return errIndexRebuilding
+	}
+	return nil
+}
+
+// syncDogIndex rebuilds the dog index, retrying until it succeeds, and then follows the changes every instance writes
+// into it until ctx is done. It runs next to the server so a large collection doesn't hold up startup, readiness fails
+// with status until the rebuild is done
+func syncDogIndex(ctx context.Context, dogService *gotoproduction.DogService, logger *logx.AppLogger, status *indexStatus) {
+	backoff := minRebuildBackoff
+	for {
+		started := time.Now()
+		indexed, err := dogService.RebuildDogIndex(ctx)
+		if err == nil {
+			logger.Infof("indexed %d dogs for fuzzy search", indexed)
+			status.markRebuilt()
+			if err := dogService.FollowDogIndex(ctx, started.Add(-indexClockSkew)); err != nil && ctx.Err() == nil {
+				logger.Errorf("dogService.FollowDogIndex(): %v", err)
+			}
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Errorf("dogService.RebuildDogIndex(): %v, retrying in %s", err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRebuildBackoff {
+			backoff = maxRebuildBackoff
+		}
+	}
+}
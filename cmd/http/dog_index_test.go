@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_syncDogIndex(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logx.NewTesterLogger(t)
+	store := gotoproduction.NewMemoryStore()
+	// another instance writing to the same store
+	writer := gotoproduction.NewDogService(store, logger)
+	_, err := writer.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Charlie", Age: 1, Type: "Beagle"})
+	is.NoErr(err) // writer.CreateDog error
+
+	index := &indexStatus{}
+	s := newServer(store, logger, withReadinessChecks(healthCheck{name: "dog_index", check: index.check}))
+	code, response := getHealth(t, s, "/readyz")
+	is.Equal(code, http.StatusServiceUnavailable)                // not ready before the rebuild
+	is.Equal(response.Checks["dog_index"].Status, statusFailing) // says why
+
+	go syncDogIndex(ctx, s.dogService, logger, index)
+	eventually := func(what string, ok func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !ok() {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s within 5s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	eventually("ready", func() bool {
+		code, _ := getHealth(t, s, "/readyz")
+		return code == http.StatusOK
+	})
+	matches := func(q string) int {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/search?q="+q, nil))
+		is.Equal(recorder.Code, http.StatusOK)
+		var response struct {
+			Matches []*gotoproduction.DogMatch `json:"matches"`
+		}
+		is.NoErr(json.NewDecoder(recorder.Body).Decode(&response)) // json decode error
+		return len(response.Matches)
+	}
+	is.Equal(matches("charlie"), 1) // rebuilt from the store
+
+	// written by the other instance after the rebuild, the index follows the store's changes
+	_, err = writer.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Boxer"})
+	is.NoErr(err) // writer.CreateDog error
+	eventually("oscar indexed", func() bool { return matches("oscar") == 1 })
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"github.com/amammay/gotoproduction"
 	"github.com/gorilla/mux"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (s *server) handleGetDog(dogService *gotoproduction.DogService) http.HandlerFunc {
@@ -14,26 +18,29 @@ func (s *server) handleGetDog(dogService *gotoproduction.DogService) http.Handle
 		dogID, ok := vars["dogID"]
 		logger.Infof("searching for dog %s", dogID)
 		if !ok {
-			s.respond(w, nil, http.StatusBadRequest)
+			s.respondErr(w, r, errMissingParam("dogID"))
 			return
 		}
-		dog, err := dogService.GetDogByID(ctx, dogID)
-		if err == gotoproduction.ErrDogNotFound {
-			s.respond(w, nil, http.StatusNotFound)
+		includeDeleted, err := parseBoolParam(r.URL.Query().Get("include_deleted"))
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("include_deleted", err))
 			return
 		}
+		dog, err := dogService.GetDogByID(ctx, dogID, gotoproduction.IncludeDeleted(includeDeleted))
 		if err != nil {
-			s.respond(w, nil, http.StatusInternalServerError)
+			s.respondErr(w, r, err)
 			return
 		}
 		logger.Infof("search found dog: %s", dog.ID)
+		w.Header().Set("ETag", dogETag(dog))
 		s.respond(w, dog, http.StatusOK)
 	}
 }
 
 func (s *server) handleFindDog(dogService *gotoproduction.DogService) http.HandlerFunc {
 	type DogTypesResponse struct {
-		Dogs []*gotoproduction.Dog `json:"dogs"`
+		Dogs          []*gotoproduction.Dog `json:"dogs"`
+		NextPageToken string                `json:"next_page_token"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -45,16 +52,78 @@ func (s *server) handleFindDog(dogService *gotoproduction.DogService) http.Handl
 		logger.Infof("searching for dog type %s", dogType)
 
 		if dogType == "" {
-			s.respond(w, nil, http.StatusNotFound)
+			s.respondErr(w, r, errMissingParam("type"))
+			return
+		}
+		pageSize, err := parsePageSize(query.Get("page_size"))
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("page_size", err))
+			return
+		}
+		includeDeleted, err := parseBoolParam(query.Get("include_deleted"))
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("include_deleted", err))
 			return
 		}
-		byType, err := dogService.FindDogByType(ctx, dogType)
+		page, err := dogService.ListDogs(ctx, &gotoproduction.ListDogsRequest{
+			Type:           dogType,
+			PageSize:       pageSize,
+			PageToken:      query.Get("page_token"),
+			IncludeDeleted: includeDeleted,
+		})
 		if err != nil {
-			s.respond(w, nil, http.StatusInternalServerError)
+			s.respondErr(w, r, err)
 			return
 		}
-		logger.Infof("found %d dogs for %s", len(byType), dogType)
-		response := &DogTypesResponse{Dogs: byType}
+		logger.Infof("found %d dogs for %s", len(page.Dogs), dogType)
+		response := &DogTypesResponse{Dogs: page.Dogs, NextPageToken: page.NextPageToken}
+		s.respond(w, response, http.StatusOK)
+	}
+}
+
+func (s *server) handleListDogs(dogService *gotoproduction.DogService) http.HandlerFunc {
+	type listDogsResponse struct {
+		Dogs          []*gotoproduction.Dog `json:"dogs"`
+		NextPageToken string                `json:"next_page_token"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+
+		query := r.URL.Query()
+		pageSize, err := parsePageSize(query.Get("page_size"))
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("page_size", err))
+			return
+		}
+		includeDeleted, err := parseBoolParam(query.Get("include_deleted"))
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("include_deleted", err))
+			return
+		}
+		// /owners/{ownerID}/dogs lists the dogs of one owner with this same handler
+		ownerID := mux.Vars(r)["ownerID"]
+		if ownerID == "" {
+			ownerID = query.Get("owner_id")
+		}
+		page, err := dogService.ListDogs(ctx, &gotoproduction.ListDogsRequest{
+			Type:           query.Get("type"),
+			OwnerID:        ownerID,
+			OrderBy:        gotoproduction.DogOrder(query.Get("order_by")),
+			PageSize:       pageSize,
+			PageToken:      query.Get("page_token"),
+			IncludeDeleted: includeDeleted,
+		})
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		logger.Infof("listed %d dogs", len(page.Dogs))
+		response := &listDogsResponse{Dogs: page.Dogs, NextPageToken: page.NextPageToken}
+		if response.Dogs == nil {
+			response.Dogs = []*gotoproduction.Dog{}
+		}
 		s.respond(w, response, http.StatusOK)
 	}
 }
@@ -65,9 +134,10 @@ func (s *server) handleCreateDog(dogService *gotoproduction.DogService) http.Han
 	}
 
 	type createDogRequest struct {
-		Name string `json:"name"`
-		Age  int    `json:"age"`
-		Type string `json:"type"`
+		Name    string `json:"name"`
+		Age     int    `json:"age"`
+		Type    string `json:"type"`
+		OwnerID string `json:"owner_id"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -76,22 +146,20 @@ func (s *server) handleCreateDog(dogService *gotoproduction.DogService) http.Han
 		request := &createDogRequest{}
 		err := s.decode(r, request)
 		if err != nil {
-			s.respond(w, nil, http.StatusBadRequest)
-		}
-
-		if request.Name == "" || request.Type == "" {
-			s.respond(w, nil, http.StatusBadRequest)
+			s.respondErr(w, r, errInvalidBody(err))
 			return
 		}
+
 		logger.Infow("incoming dog request", "name", request.Name, "type", request.Type, "age", request.Age)
 
 		dogID, err := dogService.CreateDog(ctx, &gotoproduction.CreateDogRequest{
-			Name: request.Name,
-			Age:  request.Age,
-			Type: request.Type,
+			Name:    request.Name,
+			Age:     request.Age,
+			Type:    request.Type,
+			OwnerID: request.OwnerID,
 		})
 		if err != nil {
-			s.respond(w, nil, http.StatusInternalServerError)
+			s.respondErr(w, r, err)
 			return
 		}
 		logger.Infof("created dog: %s ", dogID)
@@ -100,3 +168,273 @@ func (s *server) handleCreateDog(dogService *gotoproduction.DogService) http.Han
 		s.respond(w, response, http.StatusOK)
 	}
 }
+
+func (s *server) handleUpdateDog(dogService *gotoproduction.DogService) http.HandlerFunc {
+	type updateDogRequest struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+		Type string `json:"type"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+		dogID := mux.Vars(r)["dogID"]
+
+		lastUpdateTime, err := parseIfMatch(r)
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("If-Match", err))
+			return
+		}
+		request := &updateDogRequest{}
+		err = s.decode(r, request)
+		if err != nil {
+			s.respondErr(w, r, errInvalidBody(err))
+			return
+		}
+		logger.Infow("incoming dog update", "id", dogID, "name", request.Name, "type", request.Type, "age", request.Age)
+
+		dog, err := dogService.UpdateDog(ctx, dogID, &gotoproduction.UpdateDogRequest{
+			Name:           request.Name,
+			Age:            request.Age,
+			Type:           request.Type,
+			LastUpdateTime: lastUpdateTime,
+		})
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		logger.Infof("updated dog: %s", dog.ID)
+		w.Header().Set("ETag", dogETag(dog))
+		s.respond(w, dog, http.StatusOK)
+	}
+}
+
+func (s *server) handlePatchDog(dogService *gotoproduction.DogService) http.HandlerFunc {
+	type patchDogRequest struct {
+		Name *string `json:"name"`
+		Age  *int    `json:"age"`
+		Type *string `json:"type"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+		dogID := mux.Vars(r)["dogID"]
+
+		lastUpdateTime, err := parseIfMatch(r)
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("If-Match", err))
+			return
+		}
+		request := &patchDogRequest{}
+		err = s.decode(r, request)
+		if err != nil {
+			s.respondErr(w, r, errInvalidBody(err))
+			return
+		}
+		logger.Infow("incoming dog patch", "id", dogID)
+
+		dog, err := dogService.PatchDog(ctx, dogID, &gotoproduction.PatchDogRequest{
+			Name:           request.Name,
+			Age:            request.Age,
+			Type:           request.Type,
+			LastUpdateTime: lastUpdateTime,
+		})
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		logger.Infof("patched dog: %s", dog.ID)
+		w.Header().Set("ETag", dogETag(dog))
+		s.respond(w, dog, http.StatusOK)
+	}
+}
+
+func (s *server) handleTransferDog(dogService *gotoproduction.DogService) http.HandlerFunc {
+	type transferDogRequest struct {
+		OwnerID string `json:"owner_id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+		dogID := mux.Vars(r)["dogID"]
+
+		lastUpdateTime, err := parseIfMatch(r)
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("If-Match", err))
+			return
+		}
+		request := &transferDogRequest{}
+		if err := s.decode(r, request); err != nil {
+			s.respondErr(w, r, errInvalidBody(err))
+			return
+		}
+		dog, err := dogService.TransferDog(ctx, dogID, &gotoproduction.TransferDogRequest{
+			OwnerID:        request.OwnerID,
+			LastUpdateTime: lastUpdateTime,
+		})
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		logger.Infof("transferred dog %s to owner %s", dog.ID, dog.OwnerID)
+		w.Header().Set("ETag", dogETag(dog))
+		s.respond(w, dog, http.StatusOK)
+	}
+}
+
+func (s *server) handleTransitionDog(dogService *gotoproduction.DogService) http.HandlerFunc {
+	type transitionDogRequest struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+		dogID := mux.Vars(r)["dogID"]
+
+		lastUpdateTime, err := parseIfMatch(r)
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("If-Match", err))
+			return
+		}
+		request := &transitionDogRequest{}
+		if err := s.decode(r, request); err != nil {
+			s.respondErr(w, r, errInvalidBody(err))
+			return
+		}
+		dog, err := dogService.TransitionDog(ctx, dogID, &gotoproduction.TransitionDogRequest{
+			Status:         gotoproduction.DogStatus(request.Status),
+			Reason:         request.Reason,
+			LastUpdateTime: lastUpdateTime,
+		})
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		logger.Infof("moved dog %s to %s", dog.ID, dog.Status)
+		w.Header().Set("ETag", dogETag(dog))
+		s.respond(w, dog, http.StatusOK)
+	}
+}
+
+func (s *server) handleDeleteDog(dogService *gotoproduction.DogService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+		dogID := mux.Vars(r)["dogID"]
+
+		lastUpdateTime, err := parseIfMatch(r)
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("If-Match", err))
+			return
+		}
+		err = dogService.DeleteDog(ctx, dogID, lastUpdateTime)
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		logger.Infof("deleted dog: %s", dogID)
+		s.respond(w, nil, http.StatusNoContent)
+	}
+}
+
+func (s *server) handleRestoreDog(dogService *gotoproduction.DogService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+		dogID := mux.Vars(r)["dogID"]
+
+		lastUpdateTime, err := parseIfMatch(r)
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("If-Match", err))
+			return
+		}
+		dog, err := dogService.RestoreDog(ctx, dogID, lastUpdateTime)
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		logger.Infof("restored dog: %s", dog.ID)
+		w.Header().Set("ETag", dogETag(dog))
+		s.respond(w, dog, http.StatusOK)
+	}
+}
+
+func (s *server) handleDogHistory(dogService *gotoproduction.DogService) http.HandlerFunc {
+	type dogHistoryResponse struct {
+		Entries       []*gotoproduction.AuditEntry `json:"entries"`
+		NextPageToken string                       `json:"next_page_token"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+		dogID := mux.Vars(r)["dogID"]
+
+		query := r.URL.Query()
+		pageSize, err := parsePageSize(query.Get("page_size"))
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("page_size", err))
+			return
+		}
+		page, err := dogService.ListDogHistory(ctx, dogID, &gotoproduction.ListDogHistoryRequest{
+			PageSize:  pageSize,
+			PageToken: query.Get("page_token"),
+		})
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		logger.Infof("listed %d history entries for dog %s", len(page.Entries), dogID)
+		response := &dogHistoryResponse{Entries: page.Entries, NextPageToken: page.NextPageToken}
+		if response.Entries == nil {
+			response.Entries = []*gotoproduction.AuditEntry{}
+		}
+		s.respond(w, response, http.StatusOK)
+	}
+}
+
+// dogETag renders the update time of a dog as a strong entity tag, clients send it back with If-Match on writes
+func dogETag(dog *gotoproduction.Dog) string {
+	return fmt.Sprintf(`"%d"`, dog.UpdateTime.UnixNano())
+}
+
+// parseIfMatch turns an If-Match header produced by dogETag back into an update time, a missing header or * means no precondition
+func parseIfMatch(r *http.Request) (time.Time, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return time.Time{}, nil
+	}
+	nanos, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("strconv.ParseInt(%q): %w", ifMatch, err)
+	}
+	return time.Unix(0, nanos).UTC(), nil
+}
+
+// parsePageSize reads the page_size query param, leaving it empty lets the service pick its default
+func parsePageSize(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	pageSize, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("strconv.Atoi(%q): %w", raw, err)
+	}
+	if pageSize < 0 {
+		return 0, fmt.Errorf("page size %d must not be negative", pageSize)
+	}
+	return pageSize, nil
+}
+
+// parseBoolParam reads a boolean query param like include_deleted, an empty one is false
+func parseBoolParam(raw string) (bool, error) {
+	if raw == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("strconv.ParseBool(%q): %w", raw, err)
+	}
+	return include, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/amammay/gotoproduction"
 	"github.com/amammay/gotoproduction/internal/logx"
 	"github.com/amammay/gotoproduction/internal/testx"
@@ -45,21 +46,48 @@ func Test_server_dogs(t *testing.T) {
 	// home made firestore testing client that has a util method for clearing all data
 	fsClient := testx.NewFirestoreTestingClient(ctx, t, endpoint)
 
-	s := newServer(fsClient.Client, logx.NewTesterLogger(t))
+	newStore := func(t *testing.T) gotoproduction.DogStore {
+		fsClient.ClearData(t)
+		return gotoproduction.NewFirestoreStore(fsClient.Client)
+	}
+	testServerDogs(t, newStore)
+}
 
-	t.Run("create dog handler", test_handleCreateDog(s, fsClient))
-	t.Run("get dog handler", test_handleGetDog(s, fsClient))
-	t.Run("find dog handler", test_handleFindDog(s, fsClient))
-	t.Run("find dog handler, no dogs found", test_handleFindDog_nonFound(s, fsClient))
+// the same functional tests backed by the in memory store, these run without any containers
+func Test_server_dogs_memoryStore(t *testing.T) {
+	newStore := func(t *testing.T) gotoproduction.DogStore {
+		return gotoproduction.NewMemoryStore()
+	}
+	testServerDogs(t, newStore)
 }
 
-func test_handleCreateDog(s *server, fsClient *testx.FsTestingClient) func(t *testing.T) {
+// newStoreFunc hands each sub test a fresh, empty dog store
+type newStoreFunc func(t *testing.T) gotoproduction.DogStore
+
+func testServerDogs(t *testing.T, newStore newStoreFunc) {
+	t.Run("create dog handler", test_handleCreateDog(newStore))
+	t.Run("get dog handler", test_handleGetDog(newStore))
+	t.Run("find dog handler", test_handleFindDog(newStore))
+	t.Run("find dog handler, no dogs found", test_handleFindDog_nonFound(newStore))
+	t.Run("update dog handler", test_handleUpdateDog(newStore))
+	t.Run("patch dog handler", test_handlePatchDog(newStore))
+	t.Run("delete dog handler", test_handleDeleteDog(newStore))
+	t.Run("restore dog handler", test_handleRestoreDog(newStore))
+	t.Run("transition dog handler", test_handleTransitionDog(newStore))
+	t.Run("dog history handler", test_handleDogHistory(newStore))
+	t.Run("list dogs handler", test_handleListDogs(newStore))
+	t.Run("search dogs handler", test_handleSearchDogs(newStore))
+	t.Run("fuzzy search dogs handler", test_handleSearchDogs_fuzzy(newStore))
+}
+
+func test_handleCreateDog(newStore newStoreFunc) func(t *testing.T) {
 	type dogCreateReq struct {
 		Name string `json:"name"`
 		Type string `json:"type"`
 	}
 	return func(t *testing.T) {
-		fsClient.ClearData(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
 		is := is.New(t)
 
 		dogReq := &dogCreateReq{
@@ -84,12 +112,13 @@ func test_handleCreateDog(s *server, fsClient *testx.FsTestingClient) func(t *te
 	}
 }
 
-func test_handleGetDog(s *server, fsClient *testx.FsTestingClient) func(t *testing.T) {
+func test_handleGetDog(newStore newStoreFunc) func(t *testing.T) {
 	return func(t *testing.T) {
 		is := is.New(t)
-		fsClient.ClearData(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
 
-		dogService := gotoproduction.NewDogService(fsClient.Client, logx.NewTesterLogger(t))
+		dogService := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
 		dog, err := dogService.CreateDog(context.Background(), &gotoproduction.CreateDogRequest{
 			Name: "Oscar",
 			Age:  1,
@@ -111,12 +140,13 @@ func test_handleGetDog(s *server, fsClient *testx.FsTestingClient) func(t *testi
 
 }
 
-func test_handleFindDog(s *server, fsClient *testx.FsTestingClient) func(t *testing.T) {
+func test_handleFindDog(newStore newStoreFunc) func(t *testing.T) {
 	return func(t *testing.T) {
 		is := is.New(t)
-		fsClient.ClearData(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
 
-		dogService := gotoproduction.NewDogService(fsClient.Client, logx.NewTesterLogger(t))
+		dogService := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
 		dogType := "Golden Doodle"
 		_, err := dogService.CreateDog(context.Background(), &gotoproduction.CreateDogRequest{
 			Name: "Oscar",
@@ -142,12 +172,13 @@ func test_handleFindDog(s *server, fsClient *testx.FsTestingClient) func(t *test
 
 }
 
-func test_handleFindDog_nonFound(s *server, fsClient *testx.FsTestingClient) func(t *testing.T) {
+func test_handleFindDog_nonFound(newStore newStoreFunc) func(t *testing.T) {
 	return func(t *testing.T) {
 		is := is.New(t)
-		fsClient.ClearData(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
 
-		dogService := gotoproduction.NewDogService(fsClient.Client, logx.NewTesterLogger(t))
+		dogService := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
 		dogType := "Golden Doodle"
 		_, err := dogService.CreateDog(context.Background(), &gotoproduction.CreateDogRequest{
 			Name: "Oscar",
@@ -172,3 +203,379 @@ func test_handleFindDog_nonFound(s *server, fsClient *testx.FsTestingClient) fun
 	}
 
 }
+
+func test_handleUpdateDog(newStore newStoreFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		is := is.New(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
+
+		dogService := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+		dog, err := dogService.CreateDog(context.Background(), &gotoproduction.CreateDogRequest{
+			Name: "Oscar",
+			Age:  1,
+			Type: "Golden Doodle",
+		})
+		if err != nil {
+			t.Fatalf("dogService.CreateDog() err = %v; want nil", err)
+		}
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/"+dog, nil))
+		etag := recorder.Result().Header.Get("ETag")
+		is.True(etag != "") // get must hand out an etag
+
+		body := `{"name":"Oscar","age":2,"type":"Golden Doodle"}`
+		request := httptest.NewRequest(http.MethodPut, "/dogs/"+dog, strings.NewReader(body))
+		request.Header.Set("If-Match", etag)
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, request)
+		result := recorder.Result()
+		is.Equal(result.StatusCode, http.StatusOK) // correct status code set
+		is.True(result.Header.Get("ETag") != etag) // etag must change after an update
+		respBody, err := io.ReadAll(result.Body)
+		is.NoErr(err)                                                           // io.ReadAll error
+		is.True(strings.Contains(string(respBody), `{"name":"Oscar","age":2,`)) // verify the age was updated
+
+		// reusing the old etag means somebody else got there first
+		request = httptest.NewRequest(http.MethodPut, "/dogs/"+dog, strings.NewReader(body))
+		request.Header.Set("If-Match", etag)
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, request)
+		is.Equal(recorder.Result().StatusCode, http.StatusConflict)                  // stale etag must conflict
+		is.Equal(decodeProblem(t, recorder.Result()).Type, "/problems/dog-conflict") // conflict problem
+
+		request = httptest.NewRequest(http.MethodPut, "/dogs/999", strings.NewReader(body))
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, request)
+		is.Equal(recorder.Result().StatusCode, http.StatusNotFound)                   // unknown dog
+		is.Equal(decodeProblem(t, recorder.Result()).Type, "/problems/dog-not-found") // not found problem
+	}
+}
+
+func test_handlePatchDog(newStore newStoreFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		is := is.New(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
+
+		dogService := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+		dog, err := dogService.CreateDog(context.Background(), &gotoproduction.CreateDogRequest{
+			Name: "Oscr",
+			Age:  1,
+			Type: "Golden Doodle",
+		})
+		if err != nil {
+			t.Fatalf("dogService.CreateDog() err = %v; want nil", err)
+		}
+
+		request := httptest.NewRequest(http.MethodPatch, "/dogs/"+dog, strings.NewReader(`{"name":"Oscar"}`))
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, request)
+		result := recorder.Result()
+		is.Equal(result.StatusCode, http.StatusOK) // correct status code set
+		body, err := io.ReadAll(result.Body)
+		is.NoErr(err)                                                       // io.ReadAll error
+		is.True(strings.Contains(string(body), `{"name":"Oscar","age":1,`)) // only the name changes
+	}
+}
+
+func test_handleDeleteDog(newStore newStoreFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		is := is.New(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
+
+		dogService := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+		dog, err := dogService.CreateDog(context.Background(), &gotoproduction.CreateDogRequest{
+			Name: "Oscar",
+			Age:  1,
+			Type: "Golden Doodle",
+		})
+		if err != nil {
+			t.Fatalf("dogService.CreateDog() err = %v; want nil", err)
+		}
+
+		request := httptest.NewRequest(http.MethodDelete, "/dogs/"+dog, nil)
+		request.Header.Set("If-Match", `"1"`)
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, request)
+		is.Equal(recorder.Result().StatusCode, http.StatusConflict) // stale etag must conflict
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/dogs/"+dog, nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusNoContent) // correct status code set
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/"+dog, nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusNotFound)                   // dog must be gone
+		is.Equal(decodeProblem(t, recorder.Result()).Type, "/problems/dog-not-found") // not found problem
+	}
+}
+
+func test_handleTransitionDog(newStore newStoreFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		is := is.New(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
+
+		dogService := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+		dog, err := dogService.CreateDog(context.Background(), &gotoproduction.CreateDogRequest{
+			Name: "Oscar",
+			Age:  1,
+			Type: "Golden Doodle",
+		})
+		if err != nil {
+			t.Fatalf("dogService.CreateDog() err = %v; want nil", err)
+		}
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs/"+dog+"/transitions", strings.NewReader(`{"status":"on-hold","reason":"meet and greet"}`)))
+		is.Equal(recorder.Result().StatusCode, http.StatusOK) // held
+		is.True(recorder.Header().Get("ETag") != "")          // with the new etag
+		is.True(strings.Contains(recorder.Body.String(), `"status":"on-hold"`))
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs/"+dog+"/transitions", strings.NewReader(`{"status":"on-hold","reason":"meet and greet"}`)))
+		is.Equal(recorder.Result().StatusCode, http.StatusConflict)                                // already on hold
+		is.Equal(decodeProblem(t, recorder.Result()).Type, problemTypePrefix+"illegal-transition") // reported as an illegal move
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs/"+dog+"/transitions", strings.NewReader(`{"status":"lost","reason":"?"}`)))
+		is.Equal(recorder.Result().StatusCode, http.StatusUnprocessableEntity) // not a status
+	}
+}
+
+func test_handleRestoreDog(newStore newStoreFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		is := is.New(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
+
+		dogService := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+		dog, err := dogService.CreateDog(context.Background(), &gotoproduction.CreateDogRequest{
+			Name: "Oscar",
+			Age:  1,
+			Type: "Golden Doodle",
+		})
+		if err != nil {
+			t.Fatalf("dogService.CreateDog() err = %v; want nil", err)
+		}
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs/"+dog+"/restore", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusNotFound) // nothing to restore yet
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/dogs/"+dog, nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusNoContent) // soft deleted
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/"+dog+"?include_deleted=true", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusOK) // still there when asked for
+		deleted := &gotoproduction.Dog{}
+		is.NoErr(json.NewDecoder(recorder.Body).Decode(deleted)) // decoding the deleted dog
+		is.True(deleted.DeletedAt != nil)                        // marked deleted
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/find?type=Golden+Doodle&include_deleted=true", nil))
+		is.True(strings.Contains(recorder.Body.String(), dog)) // found by type when asked for
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/"+dog+"?include_deleted=maybe", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusBadRequest) // include_deleted must be a bool
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs/"+dog+"/restore", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusOK) // restored
+		is.True(recorder.Header().Get("ETag") != "")          // with the new etag
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/"+dog, nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusOK)              // back in plain reads
+		is.True(!strings.Contains(recorder.Body.String(), `"deleted_at"`)) // no longer marked deleted
+	}
+}
+
+func test_handleDogHistory(newStore newStoreFunc) func(t *testing.T) {
+	type historyResponse struct {
+		Entries       []*gotoproduction.AuditEntry `json:"entries"`
+		NextPageToken string                       `json:"next_page_token"`
+	}
+	return func(t *testing.T) {
+		is := is.New(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs", strings.NewReader(`{"name":"Oscar","age":1,"type":"Golden Doodle"}`)))
+		created := &struct {
+			DogID string `json:"dog_id"`
+		}{}
+		is.NoErr(json.NewDecoder(recorder.Body).Decode(created)) // decoding the created dog id
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPatch, "/dogs/"+created.DogID, strings.NewReader(`{"name":"Oscar II"}`)))
+		is.Equal(recorder.Result().StatusCode, http.StatusOK) // patched
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/"+created.DogID+"/history?page_size=1", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusOK) // correct status code set
+		page := &historyResponse{}
+		is.NoErr(json.NewDecoder(recorder.Body).Decode(page))              // decoding the first page
+		is.Equal(len(page.Entries), 1)                                     // one entry per page
+		is.Equal(page.Entries[0].Action, gotoproduction.AuditActionCreate) // the create comes first
+		is.Equal(page.Entries[0].Actor, "anonymous")                       // nobody authenticated
+		is.True(page.NextPageToken != "")                                  // the patch is on the next page
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/"+created.DogID+"/history?page_size=1&page_token="+page.NextPageToken, nil))
+		page = &historyResponse{}
+		is.NoErr(json.NewDecoder(recorder.Body).Decode(page))              // decoding the second page
+		is.Equal(page.Entries[0].Action, gotoproduction.AuditActionUpdate) // then the patch
+		is.Equal(page.Entries[0].Changes[0].After, "Oscar II")             // with the new name
+	}
+}
+
+func test_handleListDogs(newStore newStoreFunc) func(t *testing.T) {
+	type listResponse struct {
+		Dogs          []*gotoproduction.Dog `json:"dogs"`
+		NextPageToken string                `json:"next_page_token"`
+	}
+	return func(t *testing.T) {
+		is := is.New(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
+
+		dogService := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+		for _, name := range []string{"Oscar", "Bella", "Archie"} {
+			_, err := dogService.CreateDog(context.Background(), &gotoproduction.CreateDogRequest{Name: name, Age: 1, Type: "Golden Doodle"})
+			if err != nil {
+				t.Fatalf("dogService.CreateDog() err = %v; want nil", err)
+			}
+		}
+
+		var names []string
+		pageToken := ""
+		for {
+			request := httptest.NewRequest(http.MethodGet, "/dogs?order_by=name&page_size=2&page_token="+pageToken, nil)
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, request)
+			result := recorder.Result()
+			is.Equal(result.StatusCode, http.StatusOK) // correct status code set
+
+			page := &listResponse{}
+			err := json.NewDecoder(result.Body).Decode(page)
+			is.NoErr(err) // json decode error
+			for _, dog := range page.Dogs {
+				names = append(names, dog.Name)
+			}
+			if page.NextPageToken == "" {
+				break
+			}
+			pageToken = page.NextPageToken
+		}
+		is.Equal(names, []string{"Archie", "Bella", "Oscar"}) // every dog exactly once, in name order
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs?page_token=garbage", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusBadRequest)                                 // bad token
+		is.Equal(decodeProblem(t, recorder.Result()).Detail, `the "page_token" parameter is invalid`) // points at the token
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/find?type=Golden+Doodle&page_size=1", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusOK) // find honours page size
+		body, err := io.ReadAll(recorder.Result().Body)
+		is.NoErr(err)                                                    // io.ReadAll error
+		is.True(!strings.Contains(string(body), `"next_page_token":""`)) // more than one page of doodles
+	}
+}
+
+func test_handleSearchDogs(newStore newStoreFunc) func(t *testing.T) {
+	type searchResponse struct {
+		Dogs          []*gotoproduction.Dog `json:"dogs"`
+		NextPageToken string                `json:"next_page_token"`
+	}
+	return func(t *testing.T) {
+		is := is.New(t)
+		store := newStore(t)
+		s := newServer(store, logx.NewTesterLogger(t))
+
+		dogService := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+		for i, dogType := range []string{"Beagle", "Boxer", "Groodle", "Beagle"} {
+			_, err := dogService.CreateDog(context.Background(), &gotoproduction.CreateDogRequest{Name: fmt.Sprintf("Dog %d", i), Age: i + 1, Type: dogType})
+			if err != nil {
+				t.Fatalf("dogService.CreateDog() err = %v; want nil", err)
+			}
+		}
+
+		var names []string
+		path := "/dogs/search?type=beagle,golden-doodle&min_age=2&order_by=-age&page_size=2"
+		for {
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+			result := recorder.Result()
+			is.Equal(result.StatusCode, http.StatusOK) // correct status code set
+
+			page := &searchResponse{}
+			err := json.NewDecoder(result.Body).Decode(page)
+			is.NoErr(err) // json decode error
+			for _, dog := range page.Dogs {
+				names = append(names, dog.Name)
+			}
+			if page.NextPageToken == "" {
+				break
+			}
+			path = "/dogs/search?type=beagle&type=golden-doodle&min_age=2&order_by=-age&page_size=2&page_token=" + page.NextPageToken
+		}
+		is.Equal(names, []string{"Dog 3", "Dog 2"}) // filtered, oldest first, the same search across pages
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/search?name_prefix=Dog&order_by=age", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusBadRequest) // needs an index we don't ship
+		problem := decodeProblem(t, recorder.Result())
+		is.True(strings.HasSuffix(problem.Type, "unsupported-search")) // problem type
+		is.True(strings.Contains(problem.Detail, "sorted by name"))    // says what would work
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/search?created_after=yesterday", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusBadRequest)                                    // bad time
+		is.Equal(decodeProblem(t, recorder.Result()).Detail, `the "created_after" parameter is invalid`) // points at the parameter
+	}
+}
+
+func test_handleSearchDogs_fuzzy(newStore newStoreFunc) func(t *testing.T) {
+	type fuzzyResponse struct {
+		Matches []*gotoproduction.DogMatch `json:"matches"`
+	}
+	return func(t *testing.T) {
+		is := is.New(t)
+		s := newServer(newStore(t), logx.NewTesterLogger(t))
+
+		for _, name := range []string{"Charlie", "Charlotte", "Archie"} {
+			recorder := httptest.NewRecorder()
+			body := strings.NewReader(fmt.Sprintf(`{"name":%q,"age":1,"type":"beagle"}`, name))
+			s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs", body))
+			is.Equal(recorder.Result().StatusCode, http.StatusOK) // dog created through the api, so it is indexed
+		}
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/search?q=charlie&page_size=2", nil))
+		result := recorder.Result()
+		is.Equal(result.StatusCode, http.StatusOK) // correct status code set
+		response := &fuzzyResponse{}
+		is.NoErr(json.NewDecoder(result.Body).Decode(response))                                  // json decode error
+		is.Equal(len(response.Matches), 2)                                                       // limited by page_size
+		is.Equal(response.Matches[0].Dog.Name, "Charlie")                                        // best match first
+		is.Equal(response.Matches[0].Highlights, []gotoproduction.Highlight{{Start: 0, End: 7}}) // what matched
+		is.Equal(response.Matches[1].Dog.Name, "Charlotte")                                      // then the next best
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/search?q=charlie&type=beagle", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusBadRequest)                              // fuzzy searches can't be filtered
+		is.True(strings.HasSuffix(decodeProblem(t, recorder.Result()).Type, "unsupported-search")) // problem type
+
+		recorder = httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/search?q=", nil))
+		is.Equal(recorder.Result().StatusCode, http.StatusUnprocessableEntity) // empty q
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultCheckTimeout bounds a readiness check that didn't pick its own timeout, a probe that hangs is as bad as one that fails
+const defaultCheckTimeout = 2 * time.Second
+
+var errDraining = errors.New("server is shutting down")
+
+// healthCheck is one dependency readiness depends on
+type healthCheck struct {
+	name    string
+	timeout time.Duration
+	check   func(ctx context.Context) error
+}
+
+// withReadinessChecks adds dependency checks to /readyz on top of the dog store
+func withReadinessChecks(checks ...healthCheck) serverOption {
+	return func(s *server) {
+		s.readiness.checks = append(s.readiness.checks, checks...)
+	}
+}
+
+// readiness runs every check concurrently and fails as soon as draining is set
+type readiness struct {
+	draining int32
+	checks   []healthCheck
+}
+
+// drain flips readiness to failing so load balancers stop sending us new traffic
+func (rd *readiness) drain() {
+	atomic.StoreInt32(&rd.draining, 1)
+}
+
+func (rd *readiness) isDraining() bool {
+	return atomic.LoadInt32(&rd.draining) == 1
+}
+
+type checkResult struct {
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+const (
+	statusOK      = "ok"
+	statusFailing = "failing"
+)
+
+// run checks every dependency with its own timeout, the response lists each of them so a failing probe says why
+func (rd *readiness) run(ctx context.Context) (healthResponse, bool) {
+	response := healthResponse{Status: statusOK, Checks: map[string]checkResult{}}
+	if rd.isDraining() {
+		response.Status = statusFailing
+		response.Checks["shutdown"] = checkResult{Status: statusFailing, Error: errDraining.Error()}
+		return response, false
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, hc := range rd.checks {
+		hc := hc
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			timeout := hc.timeout
+			if timeout <= 0 {
+				timeout = defaultCheckTimeout
+			}
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := hc.check(checkCtx)
+			result := checkResult{Status: statusOK, DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = statusFailing
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			response.Checks[hc.name] = result
+			if err != nil {
+				response.Status = statusFailing
+			}
+		}()
+	}
+	wg.Wait()
+	return response, response.Status == statusOK
+}
+
+// handleHealthz only says the process is up and serving http, it never looks at dependencies so a database outage
+// doesn't get every instance restarted
+func (s *server) handleHealthz() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		s.respond(writer, healthResponse{Status: statusOK}, http.StatusOK)
+	}
+}
+
+// handleReadyz reports whether we should be getting traffic, 503 with the failing checks otherwise
+func (s *server) handleReadyz() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		response, ready := s.readiness.run(request.Context())
+		if !ready {
+			s.appLogger.WrapTraceContext(request.Context()).Infow("readiness check failing", "checks", response.Checks)
+			s.respond(writer, response, http.StatusServiceUnavailable)
+			return
+		}
+		s.respond(writer, response, http.StatusOK)
+	}
+}
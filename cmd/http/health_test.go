@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getHealth(t *testing.T, s *server, path string) (int, healthResponse) {
+	t.Helper()
+	is := is.New(t)
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	result := recorder.Result()
+	defer result.Body.Close()
+	var response healthResponse
+	is.NoErr(json.NewDecoder(result.Body).Decode(&response)) // json decode error
+	return result.StatusCode, response
+}
+
+func Test_server_health(t *testing.T) {
+	logger := logx.NewTesterLogger(t)
+
+	t.Run("healthz", func(t *testing.T) {
+		is := is.New(t)
+		s := newServer(gotoproduction.NewMemoryStore(), logger, withReadinessChecks(healthCheck{
+			name:  "broken",
+			check: func(ctx context.Context) error { return errors.New("boom") },
+		}))
+		code, response := getHealth(t, s, "/healthz")
+		is.Equal(code, http.StatusOK)       // liveness ignores dependencies
+		is.Equal(response.Status, statusOK) // process is alive
+		is.Equal(len(response.Checks), 0)   // no dependency breakdown on liveness
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		is := is.New(t)
+		s := newServer(gotoproduction.NewMemoryStore(), logger)
+		code, response := getHealth(t, s, "/readyz")
+		is.Equal(code, http.StatusOK)                           // memory store is always reachable
+		is.Equal(response.Checks["dog_store"].Status, statusOK) // store is checked by default
+	})
+
+	t.Run("failing check", func(t *testing.T) {
+		is := is.New(t)
+		s := newServer(gotoproduction.NewMemoryStore(), logger, withReadinessChecks(healthCheck{
+			name:  "tracer",
+			check: func(ctx context.Context) error { return errors.New("exporter down") },
+		}))
+		code, response := getHealth(t, s, "/readyz")
+		is.Equal(code, http.StatusServiceUnavailable)              // a failing dependency fails readiness
+		is.Equal(response.Status, statusFailing)                   // overall status
+		is.Equal(response.Checks["tracer"].Error, "exporter down") // says which check failed and why
+		is.Equal(response.Checks["dog_store"].Status, statusOK)    // healthy checks are still reported
+	})
+
+	t.Run("check timeout", func(t *testing.T) {
+		is := is.New(t)
+		s := newServer(gotoproduction.NewMemoryStore(), logger, withReadinessChecks(healthCheck{
+			name:    "slow",
+			timeout: 10 * time.Millisecond,
+			check: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}))
+		start := time.Now()
+		code, response := getHealth(t, s, "/readyz")
+		is.True(time.Since(start) < time.Second)                // hanging check is cut off
+		is.Equal(code, http.StatusServiceUnavailable)           // timed out check fails readiness
+		is.Equal(response.Checks["slow"].Status, statusFailing) // and is reported
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		is := is.New(t)
+		s := newServer(gotoproduction.NewMemoryStore(), logger)
+		s.readiness.drain()
+		code, response := getHealth(t, s, "/readyz")
+		is.Equal(code, http.StatusServiceUnavailable)                    // readiness fails as soon as we start shutting down
+		is.Equal(response.Checks["shutdown"].Error, errDraining.Error()) // and says why
+		code, _ = getHealth(t, s, "/healthz")
+		is.Equal(code, http.StatusOK) // still alive while draining
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayHeader marks responses that were replayed rather than produced by this request
+	idempotentReplayHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize bounds what we read into memory to fingerprint a request
+	maxIdempotentBodySize = 1 << 20
+)
+
+// withIdempotency remembers the response to every request sent with an Idempotency-Key header for ttl, retries with the
+// same key get the remembered response instead of doing the work again
+func withIdempotency(store gotoproduction.IdempotencyStore, ttl time.Duration) serverOption {
+	return func(s *server) {
+		s.idempotencyStore = store
+		s.idempotencyTTL = ttl
+	}
+}
+
+// idempotent reserves the key before calling next and stores what next responded with, server errors release the key
+// since nothing was created and the retry deserves another go
+func (s *server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if s.idempotencyStore == nil || key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			s.respondErr(w, r, errInvalidParam(idempotencyKeyHeader, fmt.Errorf("must be at most %d characters", maxIdempotencyKeyLength)))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+		if err != nil {
+			s.respondErr(w, r, errInvalidBody(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+		record := &gotoproduction.IdempotencyRecord{
+			Key:         scopedIdempotencyKey(ctx, key),
+			Fingerprint: fingerprint(r, body),
+			ExpiresAt:   time.Now().Add(s.idempotencyTTL),
+		}
+		existing, err := s.idempotencyStore.ReserveIdempotencyKey(ctx, record)
+		if err != nil {
+			s.respondErr(w, r, fmt.Errorf("s.idempotencyStore.ReserveIdempotencyKey(): %w", err))
+			return
+		}
+		if existing != nil {
+			s.replay(w, r, existing, record.Fingerprint)
+			return
+		}
+
+		capture := &responseCapture{ResponseWriter: w, status: http.StatusOK}
+		next(capture, r)
+		if capture.status >= http.StatusInternalServerError {
+			if err := s.idempotencyStore.ReleaseIdempotencyKey(ctx, record.Key); err != nil {
+				logger.Errorw("releasing idempotency key", "err", err)
+			}
+			return
+		}
+		record.Completed = true
+		record.Status = capture.status
+		record.ContentType = capture.Header().Get("content-type")
+		record.Body = capture.body.Bytes()
+		if err := s.idempotencyStore.CompleteIdempotencyKey(ctx, record); err != nil {
+			// the client already has its response, a retry will be told the request is still in progress until the key expires
+			logger.Errorw("completing idempotency key", "err", err)
+		}
+	}
+}
+
+// replay answers a retry with the stored response, as long as it really is a retry of the same request
+func (s *server) replay(w http.ResponseWriter, r *http.Request, record *gotoproduction.IdempotencyRecord, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		s.respondErr(w, r, &httpError{status: http.StatusUnprocessableEntity, kind: "idempotency-key-reused", title: "Idempotency key reused", detail: "the idempotency key was already used for a different request", cause: errors.New("fingerprint mismatch")})
+	case !record.Completed:
+		w.Header().Set("Retry-After", "1")
+		s.respondErr(w, r, &httpError{status: http.StatusConflict, kind: "idempotency-key-in-progress", title: "Request in progress", detail: "a request with this idempotency key is still being processed, retry shortly"})
+	default:
+		if record.ContentType != "" {
+			w.Header().Set("content-type", record.ContentType)
+		}
+		w.Header().Set(idempotentReplayHeader, "true")
+		w.WriteHeader(record.Status)
+		if _, err := w.Write(record.Body); err != nil {
+			s.appLogger.WrapTraceContext(r.Context()).Errorw("writing replayed response", "err", err)
+		}
+	}
+}
+
+// scopedIdempotencyKey hashes the key together with who sent it, so clients can't replay each other's responses and the
+// key is safe to use as a document id whatever it contains
+func scopedIdempotencyKey(ctx context.Context, key string) string {
+	scope := "anonymous"
+	if principal, ok := authx.FromContext(ctx); ok {
+		scope = principal.Method + ":" + principal.Subject
+	}
+	sum := sha256.Sum256([]byte(scope + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\x00", r.Method, r.URL.Path)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseCapture passes the response through while keeping a copy to store
+type responseCapture struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rc *responseCapture) WriteHeader(status int) {
+	if !rc.wroteHeader {
+		rc.status = status
+		rc.wroteHeader = true
+	}
+	rc.ResponseWriter.WriteHeader(status)
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	rc.wroteHeader = true
+	rc.body.Write(b)
+	return rc.ResponseWriter.Write(b)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createStore lets a test hold or fail CreateDog calls
+type createStore struct {
+	*gotoproduction.MemoryStore
+	entered chan struct{}
+	release chan struct{}
+	fail    error
+}
+
+func (cs *createStore) CreateDog(ctx context.Context, dog *gotoproduction.Dog, audit *gotoproduction.AuditEntry) (string, error) {
+	if cs.entered != nil {
+		cs.entered <- struct{}{}
+		<-cs.release
+	}
+	if cs.fail != nil {
+		err := cs.fail
+		cs.fail = nil
+		return "", err
+	}
+	return cs.MemoryStore.CreateDog(ctx, dog, audit)
+}
+
+func Test_server_idempotency(t *testing.T) {
+	const oscar = `{"name":"Oscar","age":1,"type":"Golden Doodle"}`
+	post := func(s *server, key, body string, headers ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/dogs", strings.NewReader(body))
+		if key != "" {
+			request.Header.Set(idempotencyKeyHeader, key)
+		}
+		for i := 0; i+1 < len(headers); i += 2 {
+			request.Header.Set(headers[i], headers[i+1])
+		}
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, request)
+		return recorder
+	}
+	countDogs := func(is *is.I, store gotoproduction.DogStore) int {
+		dogs, err := store.ListDogs(context.Background(), gotoproduction.DogQuery{})
+		is.NoErr(err) // listing dogs
+		return len(dogs)
+	}
+
+	t.Run("replays the first response", func(t *testing.T) {
+		is := is.New(t)
+		store := gotoproduction.NewMemoryStore()
+		s := newServer(store, logx.NewTesterLogger(t), withIdempotency(store, time.Hour))
+
+		first := post(s, "abc", oscar)
+		is.Equal(first.Code, http.StatusOK)                      // created
+		is.Equal(first.Header().Get(idempotentReplayHeader), "") // not a replay
+		second := post(s, "abc", oscar)
+		is.Equal(second.Code, http.StatusOK)                                              // same status
+		is.Equal(second.Body.String(), first.Body.String())                               // same body, same dog id
+		is.Equal(second.Header().Get("content-type"), first.Header().Get("content-type")) // same content type
+		is.Equal(second.Header().Get(idempotentReplayHeader), "true")                     // marked as a replay
+		is.Equal(countDogs(is, store), 1)                                                 // only one dog was created
+	})
+
+	t.Run("no key creates every time", func(t *testing.T) {
+		is := is.New(t)
+		store := gotoproduction.NewMemoryStore()
+		s := newServer(store, logx.NewTesterLogger(t), withIdempotency(store, time.Hour))
+
+		is.Equal(post(s, "", oscar).Code, http.StatusOK) // created
+		is.Equal(post(s, "", oscar).Code, http.StatusOK) // created again
+		is.Equal(countDogs(is, store), 2)                // two dogs
+	})
+
+	t.Run("different body", func(t *testing.T) {
+		is := is.New(t)
+		store := gotoproduction.NewMemoryStore()
+		s := newServer(store, logx.NewTesterLogger(t), withIdempotency(store, time.Hour))
+
+		is.Equal(post(s, "abc", oscar).Code, http.StatusOK) // created
+		reused := post(s, "abc", `{"name":"Ollie","age":2,"type":"Beagle"}`)
+		is.Equal(reused.Code, http.StatusUnprocessableEntity)                     // the key belongs to another request
+		is.Equal(reused.Header().Get("content-type"), problemContentType)         // rendered as a problem
+		is.True(strings.Contains(reused.Body.String(), "idempotency-key-reused")) // with its own kind
+		is.Equal(countDogs(is, store), 1)                                         // nothing else was created
+	})
+
+	t.Run("concurrent requests", func(t *testing.T) {
+		is := is.New(t)
+		store := &createStore{MemoryStore: gotoproduction.NewMemoryStore(), entered: make(chan struct{}), release: make(chan struct{})}
+		s := newServer(store, logx.NewTesterLogger(t), withIdempotency(store, time.Hour))
+
+		done := make(chan int)
+		go func() { done <- post(s, "abc", oscar).Code }()
+		<-store.entered
+
+		inProgress := post(s, "abc", oscar)
+		is.Equal(inProgress.Code, http.StatusConflict)                                                            // the first one hasn't finished
+		is.Equal(inProgress.Header().Get("Retry-After"), "1")                                                     // come back shortly
+		is.Equal(post(s, "abc", `{"name":"Ollie","age":2,"type":"Beagle"}`).Code, http.StatusUnprocessableEntity) // a different body is still a misuse
+
+		close(store.release)
+		is.Equal(<-done, http.StatusOK)                                              // the first request finishes
+		is.Equal(post(s, "abc", oscar).Header().Get(idempotentReplayHeader), "true") // and is replayed from then on
+	})
+
+	t.Run("server errors release the key", func(t *testing.T) {
+		is := is.New(t)
+		store := &createStore{MemoryStore: gotoproduction.NewMemoryStore(), fail: errors.New("firestore is down")}
+		s := newServer(store, logx.NewTesterLogger(t), withIdempotency(store, time.Hour))
+
+		is.Equal(post(s, "abc", oscar).Code, http.StatusInternalServerError) // the store failed
+		retry := post(s, "abc", oscar)
+		is.Equal(retry.Code, http.StatusOK)                      // the retry gets to try again
+		is.Equal(retry.Header().Get(idempotentReplayHeader), "") // for real
+	})
+
+	t.Run("client errors are replayed", func(t *testing.T) {
+		is := is.New(t)
+		store := gotoproduction.NewMemoryStore()
+		s := newServer(store, logx.NewTesterLogger(t), withIdempotency(store, time.Hour))
+
+		is.Equal(post(s, "abc", `{"name":""}`).Code, http.StatusUnprocessableEntity) // invalid dog
+		replay := post(s, "abc", `{"name":""}`)
+		is.Equal(replay.Code, http.StatusUnprocessableEntity)         // the same request fails the same way
+		is.Equal(replay.Header().Get(idempotentReplayHeader), "true") // without doing the work again
+	})
+
+	t.Run("expired keys are forgotten", func(t *testing.T) {
+		is := is.New(t)
+		store := gotoproduction.NewMemoryStore()
+		s := newServer(store, logx.NewTesterLogger(t), withIdempotency(store, time.Nanosecond))
+
+		is.Equal(post(s, "abc", oscar).Code, http.StatusOK) // created
+		time.Sleep(time.Millisecond)
+		is.Equal(post(s, "abc", oscar).Header().Get(idempotentReplayHeader), "") // expired, so created again
+		is.Equal(countDogs(is, store), 2)                                        // two dogs
+	})
+
+	t.Run("keys are per principal", func(t *testing.T) {
+		is := is.New(t)
+		store := gotoproduction.NewMemoryStore()
+		s := newServer(store, logx.NewTesterLogger(t), withIdempotency(store, time.Hour), withAuthenticator(newTestAuthenticator(t, gotoproduction.RoleEditor, gotoproduction.RoleAdmin)))
+
+		is.Equal(post(s, "abc", oscar, apiKeyHeader, gotoproduction.RoleEditor+"-key").Code, http.StatusOK) // editor creates
+		other := post(s, "abc", oscar, apiKeyHeader, gotoproduction.RoleAdmin+"-key")
+		is.Equal(other.Code, http.StatusOK)                      // admin creates with the same key
+		is.Equal(other.Header().Get(idempotentReplayHeader), "") // and doesn't get the editor's response
+		is.Equal(countDogs(is, store), 2)                        // two dogs
+	})
+
+	t.Run("key too long", func(t *testing.T) {
+		is := is.New(t)
+		store := gotoproduction.NewMemoryStore()
+		s := newServer(store, logx.NewTesterLogger(t), withIdempotency(store, time.Hour))
+
+		is.Equal(post(s, strings.Repeat("a", maxIdempotencyKeyLength+1), oscar).Code, http.StatusBadRequest) // rejected
+	})
+}
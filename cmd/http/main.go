@@ -4,34 +4,87 @@ import (
 	"cloud.google.com/go/compute/metadata"
 	"cloud.google.com/go/firestore"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/config"
+	"github.com/amammay/gotoproduction/internal/dogrpc"
+	"github.com/amammay/gotoproduction/internal/eventx"
+	"github.com/amammay/gotoproduction/internal/limitx"
 	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/amammay/gotoproduction/internal/metricx"
+	"github.com/amammay/gotoproduction/internal/tracex"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
 	"golang.org/x/sync/errgroup"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
 
-//define our ENV variable keys up here so its easy for somebody to see what they can set
-const (
-	portEnv = "PORT"
+type server struct {
+	router         *mux.Router
+	dogStore       gotoproduction.DogStore
+	dogServiceOpts []gotoproduction.DogServiceOption
+	dogService     *gotoproduction.DogService
+	readiness      readiness
+	meterProvider  metric.MeterProvider
+	metricsHandler http.Handler
+	authenticator  *authx.Authenticator
+	rateLimiter    *limitx.Limiter
+	// authFailures counts failed authentications by ip, nil without rate limiting
+	authFailures   *limitx.Limiter
+	trustedProxies []*net.IPNet
+	inFlight       *limitx.Gate
+	// idempotencyStore is nil when Idempotency-Key headers are ignored
+	idempotencyStore gotoproduction.IdempotencyStore
+	idempotencyTTL   time.Duration
+	// webhookService is nil when webhooks are turned off, the /webhooks routes are left out then
+	webhookService *gotoproduction.WebhookService
+	// ownerService is nil when the /owners routes are left out
+	ownerService *gotoproduction.OwnerService
+	// watchHeartbeat and watchMaxDuration shape /dogs/watch streams, streamsStopped is closed to end all of them
+	watchHeartbeat   time.Duration
+	watchMaxDuration time.Duration
+	streamsStopped   chan struct{}
+	stopStreamsOnce  sync.Once
+	appLogger        *logx.AppLogger
+}
 
-	defaultPortValue = "8080"
-	defaultHostValue = "127.0.0.1"
-)
+// serverOption configures optional server dependencies before the routes are built
+type serverOption func(s *server)
 
-type server struct {
-	router    *mux.Router
-	firestore *firestore.Client
-	appLogger *logx.AppLogger
+// withDogServiceOptions passes options through to the DogService the routes are built with
+func withDogServiceOptions(opts ...gotoproduction.DogServiceOption) serverOption {
+	return func(s *server) {
+		s.dogServiceOpts = append(s.dogServiceOpts, opts...)
+	}
 }
 
-func newServer(client *firestore.Client, logger *logx.AppLogger) *server {
-	s := &server{router: mux.NewRouter(), firestore: client, appLogger: logger}
+func newServer(store gotoproduction.DogStore, logger *logx.AppLogger, opts ...serverOption) *server {
+	s := &server{
+		router:           mux.NewRouter(),
+		dogStore:         store,
+		meterProvider:    global.GetMeterProvider(),
+		watchHeartbeat:   15 * time.Second,
+		watchMaxDuration: 25 * time.Second,
+		streamsStopped:   make(chan struct{}),
+		appLogger:        logger,
+	}
+	s.readiness.checks = []healthCheck{{name: "dog_store", check: store.Ping}}
+	// fuzzy search is only served over http, so only this binary keeps the dogs in memory
+	s.dogServiceOpts = []gotoproduction.DogServiceOption{gotoproduction.WithDogIndex(gotoproduction.NewTrigramIndex())}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.dogService = gotoproduction.NewDogService(s.dogStore, s.appLogger, s.dogServiceOpts...)
 	s.routes()
 	return s
 }
@@ -40,6 +93,9 @@ func (s *server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	s.router.ServeHTTP(writer, request)
 }
 
+// traceShutdownTimeout bounds how long we wait on the trace exporter to flush on the way out
+const traceShutdownTimeout = 5 * time.Second
+
 func main() {
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "run(): %v\n", err)
@@ -53,61 +109,159 @@ func run() error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// init open telemetry
-
-	onGCE := metadata.OnGCE()
-	projectID := "a-mammay-website"
-	if onGCE {
-		id, err := metadata.ProjectID()
-		if err != nil {
-			return fmt.Errorf("metadata.ProjectID(): %v", err)
+	// load our config from flags, env and an optional config file, then fill in what depends on where we are running
+	cfg := config.Default()
+	cfg.ServiceName = "gotoproduction-http"
+	if err := cfg.Load(os.Args[1:], os.Getenv); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
 		}
-		projectID = id
+		return fmt.Errorf("cfg.Load(): %w", err)
 	}
-	shutdownTracer, err := initTracing(ctx, projectID)
+	if err := cfg.ResolvePlatform(config.Platform{
+		OnGCE:      metadata.OnGCE(),
+		ProjectID:  metadata.ProjectID,
+		InstanceID: metadata.InstanceID,
+	}); err != nil {
+		return fmt.Errorf("cfg.ResolvePlatform(): %w", err)
+	}
+	if cfg.PrintConfig {
+		return cfg.Print(os.Stdout)
+	}
+
+	metrics, err := metricx.InitMetrics()
 	if err != nil {
-		return fmt.Errorf("initTracing(): %v", err)
+		return fmt.Errorf("metricx.InitMetrics(): %v", err)
 	}
-	defer shutdownTracer()
 
-	logger, err := logx.NewProdLogger(projectID)
+	logger, err := logx.NewLogger(cfg.ProjectID, cfg.LogFormat == config.LogFormatJSON, cfg.LogLevel)
 	if err != nil {
-		return fmt.Errorf("logx.NewProdLogger(): %v", err)
+		return fmt.Errorf("logx.NewLogger(): %v", err)
 	}
 	defer logger.Sync()
 
-	fsClient, err := firestore.NewClient(ctx, projectID)
+	// init open telemetry, the logger comes first so we can report a failed span flush on the way out
+	tracing, err := tracex.InitTracing(ctx, tracex.Options{
+		Exporter:       cfg.TraceExporter,
+		ProjectID:      cfg.ProjectID,
+		Endpoint:       cfg.TraceEndpoint,
+		SampleRatio:    cfg.TraceSampleRatio,
+		ServiceName:    cfg.ServiceName,
+		ServiceVersion: cfg.ServiceVersion,
+		InstanceID:     cfg.InstanceID,
+	})
+	if err != nil {
+		return fmt.Errorf("tracex.InitTracing(): %v", err)
+	}
+	defer func() {
+		// our own ctx is already cancelled by the time we get here, give the exporter a fresh deadline to flush
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+		defer cancel()
+		if err := tracing.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("tracing.Shutdown(): %v", err)
+		}
+	}()
+
+	fsClient, err := firestore.NewClient(ctx, cfg.ProjectID)
 	if err != nil {
 		return fmt.Errorf("firestore.NewClient(): %w", err)
 	}
 
-	port := os.Getenv(portEnv)
-	if port == "" {
-		port = defaultPortValue
+	serverOpts := []serverOption{
+		withReadinessChecks(healthCheck{name: "tracer", check: tracing.Check}),
+		withMetrics(metrics.MeterProvider(), metrics),
+		withWatch(cfg.WatchHeartbeat, cfg.WatchMaxDuration),
+	}
+	var ownerOpts []gotoproduction.OwnerServiceOption
+	// rest and grpc share the port, so they share the limits too. Built once here, both draw from the same buckets
+	guards := dogrpc.Guards{TrustedProxies: cfg.TrustedProxies}
+	if cfg.MaxInFlight > 0 {
+		guards.InFlight = limitx.NewGate(cfg.MaxInFlight)
+		serverOpts = append(serverOpts, withLoadShedding(guards.InFlight))
 	}
-	host := ""
-	if !onGCE {
-		var err error
-		logger, err = logx.NewDevLogger(projectID)
+	if cfg.RateLimitRPS > 0 {
+		guards.Requests = limitx.NewLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
+		guards.AuthFailures = limitx.NewAuthFailureLimiter()
+		serverOpts = append(serverOpts, withRateLimit(guards.Requests, guards.AuthFailures, cfg.TrustedProxies))
+	}
+	if cfg.PageTokenSecret != "" {
+		serverOpts = append(serverOpts, withDogServiceOptions(gotoproduction.WithPageTokenKey([]byte(cfg.PageTokenSecret))))
+		ownerOpts = append(ownerOpts, gotoproduction.WithOwnerPageTokenKey([]byte(cfg.PageTokenSecret)))
+	} else {
+		logger.Info("no page token secret set, page tokens will only be valid for this instance")
+	}
+
+	// every dog api request has to authenticate unless we are explicitly told not to
+	var webhookOpts []gotoproduction.WebhookServiceOption
+	if cfg.AuthDisabled {
+		logger.Info("authentication is disabled, anyone who can reach this server can change dogs")
+	} else {
+		authenticator, err := authx.New(authx.Options{
+			JWKSFile:    cfg.AuthJWKSFile,
+			JWTSecret:   cfg.AuthJWTSecret,
+			Issuer:      cfg.AuthJWTIssuer,
+			Audience:    cfg.AuthJWTAudience,
+			APIKeysFile: cfg.AuthAPIKeysFile,
+		})
 		if err != nil {
-			return fmt.Errorf("logx.NewProdLogger(): %v", err)
+			return fmt.Errorf("authx.New(): %w", err)
 		}
-		host = defaultHostValue
+		policy := gotoproduction.DefaultPolicy()
+		if cfg.AuthPolicyFile != "" {
+			if policy, err = authx.LoadPolicy(cfg.AuthPolicyFile); err != nil {
+				return fmt.Errorf("authx.LoadPolicy(): %w", err)
+			}
+		}
+		serverOpts = append(serverOpts, withAuthenticator(authenticator), withDogServiceOptions(gotoproduction.WithPolicy(policy)))
+		webhookOpts = append(webhookOpts, gotoproduction.WithWebhookPolicy(policy))
+		ownerOpts = append(ownerOpts, gotoproduction.WithOwnerPolicy(policy))
+		guards.Authenticator = authenticator
 	}
+	grpcOpts := dogrpc.WithGuards(guards)
 
-	s := newServer(fsClient, logger)
+	store := gotoproduction.NewFirestoreStore(fsClient)
+	serverOpts = append(serverOpts, withOwners(store, ownerOpts...))
+	if cfg.IdempotencyTTL > 0 {
+		serverOpts = append(serverOpts, withIdempotency(store, cfg.IdempotencyTTL))
+	}
+	// webhooks only go to public addresses unless we are explicitly told otherwise
+	webhookClient := gotoproduction.NewWebhookClient(cfg.WebhookTimeout)
+	if cfg.WebhookAllowPrivateURLs {
+		logger.Info("webhooks may be sent to private addresses, anyone who can register one can reach our network")
+		webhookOpts = append(webhookOpts, gotoproduction.WithPrivateWebhookURLs())
+		webhookClient = &http.Client{Timeout: cfg.WebhookTimeout}
+	}
+	if cfg.WebhookInterval > 0 {
+		serverOpts = append(serverOpts, withWebhooks(store, webhookOpts...))
+	}
+	// fuzzy searches are served from memory, we aren't ready for traffic until every dog is in the index
+	index := &indexStatus{}
+	serverOpts = append(serverOpts, withReadinessChecks(healthCheck{name: "dog_index", check: index.check}))
+	s := newServer(store, logger, serverOpts...)
+	go syncDogIndex(ctx, s.dogService, logger, index)
 
 	httpServer := http.Server{
-		Addr:         fmt.Sprintf("%s:%s", host, port),
+		Addr:         cfg.Addr(),
 		Handler:      s,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
 		BaseContext: func(listener net.Listener) context.Context {
 			return ctx
 		},
 	}
 	httpServer.RegisterOnShutdown(cancel)
 
+	var grpcServer *dogrpc.Server
+	if cfg.GRPCEnabled {
+		grpcServer = dogrpc.NewServer(s.dogService, logger, grpcOpts...)
+	}
+	multi := newMultiServer(&httpServer, grpcServer, logger)
+
+	listener, err := net.Listen("tcp", httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("net.Listen(%q): %w", httpServer.Addr, err)
+	}
+
 	// setup our shutdown signal
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(
@@ -116,24 +270,72 @@ func run() error {
 		syscall.SIGTERM, // Capture actual sig term event (kill command).
 	)
 
-	// setup our errgroup is listen for shutdown signal, from there attempt to shutdown our http server and capture any errors during shutdown
+	// setup our errgroup is listen for shutdown signal, from there attempt to shutdown our http and grpc servers and capture any errors during shutdown
 	g, ctx := errgroup.WithContext(ctx)
 	g.Go(func() error {
 		o := <-shutdown
 		logger.Infof("sig: %s - starting shutting down sequence...", o)
 		// we need to use a fresh context.Background() because the parent ctx we have in our current scope will be cancelled during the Shutdown method call
-		graceFull, cancel := context.WithTimeout(context.Background(), 9*time.Second)
+		graceFull, cancel := context.WithTimeout(context.Background(), cfg.ShutdownGrace)
 		defer cancel()
-		// Shutdown the server with a timeout
-		if err := httpServer.Shutdown(graceFull); err != nil {
-			return fmt.Errorf("httpServer.Shutdown(): %w", err)
+		// fail readiness first and keep serving for a bit, so load balancers stop routing to us before we stop accepting
+		s.readiness.drain()
+		multi.Drain()
+		// watch streams never finish on their own, end them now so their clients reconnect somewhere else
+		s.stopStreams()
+		select {
+		case <-time.After(cfg.DrainDelay):
+		case <-graceFull.Done():
+		}
+		// Shutdown both protocols with a timeout, in flight requests and rpcs get to finish
+		if err := multi.Shutdown(graceFull); err != nil {
+			return fmt.Errorf("multi.Shutdown(): %w", err)
 		}
 		logger.Info("server has shutdown gracefully")
 		return nil
 	})
-	logger.Infof("starting server on %q", httpServer.Addr)
-	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
-		return fmt.Errorf("httpServer.ListenAndServe(): %v", err)
+	// every instance purging on its own schedule is harmless, a batch that races another one fails and is retried later
+	if cfg.PurgeInterval > 0 {
+		purger := gotoproduction.NewPurger(store, logger, cfg.PurgeRetention, cfg.PurgeBatchSize)
+		go purger.Run(ctx, cfg.PurgeInterval)
+	}
+	// relays on every instance can run side by side, claiming an event hides it from the others while it is published
+	if cfg.RelayInterval > 0 {
+		publisher, closePublisher, err := eventx.New(ctx, eventx.Options{
+			Publisher:  cfg.EventPublisher,
+			ProjectID:  cfg.ProjectID,
+			Topic:      cfg.PubSubTopic,
+			WebhookURL: cfg.EventWebhookURL,
+		}, logger)
+		if err != nil {
+			return fmt.Errorf("eventx.New(): %w", err)
+		}
+		defer func() {
+			if err := closePublisher(); err != nil {
+				logger.Errorf("closePublisher(): %v", err)
+			}
+		}()
+		var publishers eventx.MultiPublisher
+		if publisher != nil {
+			publishers = append(publishers, publisher)
+		}
+		if cfg.WebhookInterval > 0 {
+			publishers = append(publishers, gotoproduction.NewWebhookFanout(store))
+			deliverer := gotoproduction.NewWebhookDeliverer(store, webhookClient, logger,
+				gotoproduction.WithWebhookMaxAttempts(cfg.WebhookMaxAttempts),
+				gotoproduction.WithWebhookDisableAfter(cfg.WebhookDisableAfter),
+			)
+			go deliverer.Run(ctx, cfg.WebhookInterval)
+		}
+		// with nowhere to publish to, events stay in the outbox
+		if len(publishers) > 0 {
+			relay := gotoproduction.NewRelay(store, publishers, logger, gotoproduction.WithRelayBatchSize(cfg.RelayBatchSize))
+			go relay.Run(ctx, cfg.RelayInterval)
+		}
+	}
+	logger.Infof("starting server on %q, grpc enabled: %t", httpServer.Addr, cfg.GRPCEnabled)
+	if err := multi.Serve(listener); err != nil {
+		return fmt.Errorf("multi.Serve(): %v", err)
 	}
 	return g.Wait()
 }
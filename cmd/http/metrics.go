@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/amammay/gotoproduction"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/unit"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// unmatchedRoute labels requests that didn't match any route, so probing random paths can't blow up our label cardinality
+const unmatchedRoute = "unmatched"
+
+// withMetrics records http metrics to the meter provider and serves handler on /metrics, the DogService records its
+// operation metrics to the same provider
+func withMetrics(mp metric.MeterProvider, handler http.Handler) serverOption {
+	return func(s *server) {
+		s.meterProvider = mp
+		s.metricsHandler = handler
+		s.dogServiceOpts = append(s.dogServiceOpts, gotoproduction.WithMeterProvider(mp))
+	}
+}
+
+type httpMetrics struct {
+	requests     metric.Int64Counter
+	latency      metric.Float64ValueRecorder
+	responseSize metric.Int64ValueRecorder
+	inFlight     metric.Int64UpDownCounter
+}
+
+func newHTTPMetrics(mp metric.MeterProvider) httpMetrics {
+	meter := metric.Must(mp.Meter("github.com/amammay/gotoproduction/cmd/http"))
+	return httpMetrics{
+		requests: meter.NewInt64Counter("http.server.requests",
+			metric.WithDescription("http requests by method, route and status code"),
+		),
+		latency: meter.NewFloat64ValueRecorder("http.server.duration",
+			metric.WithUnit(unit.Milliseconds),
+			metric.WithDescription("http request latency by method, route and status code"),
+		),
+		responseSize: meter.NewInt64ValueRecorder("http.server.response_size",
+			metric.WithUnit(unit.Bytes),
+			metric.WithDescription("http response body size by method, route and status code"),
+		),
+		inFlight: meter.NewInt64UpDownCounter("http.server.in_flight",
+			metric.WithDescription("http requests currently being served"),
+		),
+	}
+}
+
+// middleware labels by the route template rather than the path, so every dog id shares one series
+func (hm httpMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := request.Context()
+		route := unmatchedRoute
+		if current := mux.CurrentRoute(request); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		hm.inFlight.Add(ctx, 1)
+		defer hm.inFlight.Add(ctx, -1)
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+		next.ServeHTTP(recorder, request)
+
+		labels := []attribute.KeyValue{
+			attribute.String("method", request.Method),
+			attribute.String("route", route),
+			attribute.String("code", strconv.Itoa(recorder.status)),
+		}
+		hm.requests.Add(ctx, 1, labels...)
+		hm.latency.Record(ctx, float64(time.Since(start))/float64(time.Millisecond), labels...)
+		hm.responseSize.Record(ctx, recorder.size, labels...)
+	})
+}
+
+// statusRecorder remembers the status code and counts the bytes written
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if !sr.wroteHeader {
+		sr.status = status
+		sr.wroteHeader = true
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	sr.wroteHeader = true
+	n, err := sr.ResponseWriter.Write(b)
+	sr.size += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working through the recorder
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
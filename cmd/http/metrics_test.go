@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/amammay/gotoproduction/internal/metricx"
+	"github.com/matryer/is"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_server_metrics(t *testing.T) {
+	is := is.New(t)
+	exporter, err := metricx.NewExporter()
+	is.NoErr(err) // metricx.NewExporter error
+	s := newServer(gotoproduction.NewMemoryStore(), logx.NewTesterLogger(t), withMetrics(exporter.MeterProvider(), exporter))
+
+	for _, r := range []struct{ method, path, body string }{
+		{http.MethodGet, "/dogs/missing", ""},
+		{http.MethodGet, "/dogs/other-missing", ""},
+		{http.MethodPost, "/dogs", `{"name":"Oscar","age":1,"type":"Golden Doodle"}`},
+		{http.MethodPut, "/dogs", ""},
+		{http.MethodGet, "/not-a-route", ""},
+	} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(r.method, r.path, strings.NewReader(r.body)))
+	}
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	result := recorder.Result()
+	is.Equal(result.StatusCode, http.StatusOK) // metrics endpoint
+	raw, err := io.ReadAll(result.Body)
+	is.NoErr(err) // io.ReadAll error
+	body := string(raw)
+
+	for _, want := range []string{
+		`http_server_requests{code="404",method="GET",route="/dogs/{dogID}"`, // labelled by route template, not path
+		`http_server_requests{code="200",method="POST",route="/dogs"`,
+		`http_server_requests{code="405",method="PUT",route="unmatched"`, // wrong method still counted
+		`http_server_requests{code="404",method="GET",route="unmatched"`, // unknown paths share one series
+		`http_server_duration_count{code="200",method="POST",route="/dogs"`,
+		`http_server_response_size_sum{code="200",method="POST",route="/dogs"`,
+		`http_server_in_flight`,
+		`dogservice_operation_duration_count{code="OK",method="CreateDog"`,
+		`dogservice_operation_errors{code="NotFound",method="GetDogByID"`,
+		`go_goroutines`, // runtime metrics from the prometheus registry
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	is.True(!strings.Contains(body, "other-missing")) // raw paths never become labels
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/dogrpc"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/soheilhy/cmux"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/sync/errgroup"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// multiServer serves the rest api over http/1.1 and h2c, and optionally grpc, all from a single listener. Cloud run only
+// hands us one port, so grpc connections are picked out by their content type and everything else goes to the http server
+type multiServer struct {
+	httpServer *http.Server
+	grpcServer *dogrpc.Server
+	appLogger  *logx.AppLogger
+
+	mu       sync.Mutex
+	root     net.Listener
+	closing  bool
+	cmuxRoot cmux.CMux
+}
+
+// newMultiServer wraps the http handler so it also speaks cleartext http/2, grpcServer may be nil to only serve rest
+func newMultiServer(httpServer *http.Server, grpcServer *dogrpc.Server, logger *logx.AppLogger) *multiServer {
+	httpServer.Handler = h2c.NewHandler(httpServer.Handler, &http2.Server{})
+	return &multiServer{httpServer: httpServer, grpcServer: grpcServer, appLogger: logger}
+}
+
+// Serve blocks until the listener is closed by Shutdown or fails
+func (ms *multiServer) Serve(listener net.Listener) error {
+	ms.mu.Lock()
+	ms.root = listener
+	ms.mu.Unlock()
+
+	if ms.grpcServer == nil {
+		if err := ms.httpServer.Serve(listener); err != http.ErrServerClosed {
+			return fmt.Errorf("httpServer.Serve(): %w", err)
+		}
+		return nil
+	}
+
+	m := cmux.New(listener)
+	ms.mu.Lock()
+	ms.cmuxRoot = m
+	ms.mu.Unlock()
+	// grpc-go clients wait for the server settings frame before sending headers, so the matcher has to send them for us
+	grpcListener := m.MatchWithWriters(cmux.HTTP2MatchHeaderFieldPrefixSendSettings("content-type", "application/grpc"))
+	httpListener := m.Match(cmux.Any())
+
+	var g errgroup.Group
+	g.Go(func() error {
+		if err := ms.grpcServer.Serve(grpcListener); err != nil && !ms.closedErr(err) {
+			return fmt.Errorf("grpcServer.Serve(): %w", err)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		if err := ms.httpServer.Serve(httpListener); err != http.ErrServerClosed && !ms.closedErr(err) {
+			return fmt.Errorf("httpServer.Serve(): %w", err)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		if err := m.Serve(); err != nil && !ms.closedErr(err) {
+			return fmt.Errorf("cmux.Serve(): %w", err)
+		}
+		return nil
+	})
+	return g.Wait()
+}
+
+// Drain flips grpc health checks to NOT_SERVING, the http side drains through /readyz
+func (ms *multiServer) Drain() {
+	if ms.grpcServer != nil {
+		ms.grpcServer.Drain()
+	}
+}
+
+// Shutdown stops accepting new connections and drains in flight http requests and grpc calls, if ctx expires first
+// the remaining grpc calls are cut off
+func (ms *multiServer) Shutdown(ctx context.Context) error {
+	ms.mu.Lock()
+	ms.closing = true
+	m := ms.cmuxRoot
+	ms.mu.Unlock()
+
+	var g errgroup.Group
+	g.Go(func() error {
+		if err := ms.httpServer.Shutdown(ctx); err != nil {
+			return fmt.Errorf("httpServer.Shutdown(): %w", err)
+		}
+		return nil
+	})
+	if ms.grpcServer != nil {
+		g.Go(func() error {
+			stopped := make(chan struct{})
+			go func() {
+				ms.grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				ms.grpcServer.Stop()
+				return fmt.Errorf("grpcServer.GracefulStop(): %w", ctx.Err())
+			}
+		})
+	}
+	err := g.Wait()
+	if m != nil {
+		m.Close()
+	}
+	ms.mu.Lock()
+	if ms.root != nil {
+		_ = ms.root.Close()
+	}
+	ms.mu.Unlock()
+	ms.appLogger.Info("http and grpc servers drained")
+	return err
+}
+
+// closedErr reports whether err is just a listener going away because we are shutting down
+func (ms *multiServer) closedErr(err error) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if !ms.closing {
+		return false
+	}
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, cmux.ErrListenerClosed) || errors.Is(err, cmux.ErrServerClosed)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/dogpb"
+	"github.com/amammay/gotoproduction/internal/dogrpc"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// rest over http/1.1, rest over h2c and grpc all hit the same port, then everything drains on shutdown
+func Test_multiServer(t *testing.T) {
+	is := is.New(t)
+	logger := logx.NewTesterLogger(t)
+	s := newServer(gotoproduction.NewMemoryStore(), logger)
+	multi := newMultiServer(&http.Server{Handler: s}, dogrpc.NewServer(s.dogService, logger), logger)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err) // net.Listen error
+	served := make(chan error, 1)
+	go func() {
+		served <- multi.Serve(listener)
+	}()
+	addr := listener.Addr().String()
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure(), grpc.WithBlock())
+	is.NoErr(err) // grpc.Dial error
+	defer conn.Close()
+	created, err := dogpb.NewDogServiceClient(conn).CreateDog(context.Background(), &dogpb.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+	is.NoErr(err) // grpc CreateDog error
+
+	resp, err := http.Get("http://" + addr + "/dogs/" + created.GetId())
+	is.NoErr(err)                            // http/1.1 get error
+	is.Equal(resp.StatusCode, http.StatusOK) // dog created over grpc is visible over rest
+	is.Equal(resp.ProtoMajor, 1)             // served as http/1.1
+	resp.Body.Close()
+
+	h2cClient := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	resp, err = h2cClient.Get("http://" + addr + "/dogs/" + created.GetId())
+	is.NoErr(err)                            // h2c get error
+	is.Equal(resp.StatusCode, http.StatusOK) // rest over cleartext http/2
+	is.Equal(resp.ProtoMajor, 2)             // served as http/2
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	is.NoErr(multi.Shutdown(ctx)) // multi.Shutdown error
+	select {
+	case err := <-served:
+		is.NoErr(err) // Serve must return cleanly after shutdown
+	case <-time.After(5 * time.Second):
+		t.Fatal("multi.Serve() did not return after shutdown")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"github.com/amammay/gotoproduction"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// withOwners serves the /owners routes backed by store
+func withOwners(store gotoproduction.OwnerStore, opts ...gotoproduction.OwnerServiceOption) serverOption {
+	return func(s *server) {
+		s.ownerService = gotoproduction.NewOwnerService(store, s.appLogger, opts...)
+	}
+}
+
+type ownerRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (s *server) handleCreateOwner(ownerService *gotoproduction.OwnerService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request := &ownerRequest{}
+		if err := s.decode(r, request); err != nil {
+			s.respondErr(w, r, errInvalidBody(err))
+			return
+		}
+		owner, err := ownerService.CreateOwner(ctx, &gotoproduction.CreateOwnerRequest{Name: request.Name, Email: request.Email})
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		s.appLogger.WrapTraceContext(ctx).Infof("created owner: %s", owner.ID)
+		s.respond(w, owner, http.StatusCreated)
+	}
+}
+
+func (s *server) handleListOwners(ownerService *gotoproduction.OwnerService) http.HandlerFunc {
+	type listOwnersResponse struct {
+		Owners        []*gotoproduction.Owner `json:"owners"`
+		NextPageToken string                  `json:"next_page_token"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		pageSize, err := parsePageSize(query.Get("page_size"))
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("page_size", err))
+			return
+		}
+		page, err := ownerService.ListOwners(r.Context(), &gotoproduction.ListOwnersRequest{
+			PageSize:  pageSize,
+			PageToken: query.Get("page_token"),
+		})
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		response := &listOwnersResponse{Owners: page.Owners, NextPageToken: page.NextPageToken}
+		if response.Owners == nil {
+			response.Owners = []*gotoproduction.Owner{}
+		}
+		s.respond(w, response, http.StatusOK)
+	}
+}
+
+func (s *server) handleGetOwner(ownerService *gotoproduction.OwnerService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner, err := ownerService.GetOwner(r.Context(), mux.Vars(r)["ownerID"])
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		s.respond(w, owner, http.StatusOK)
+	}
+}
+
+func (s *server) handleUpdateOwner(ownerService *gotoproduction.OwnerService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		request := &ownerRequest{}
+		if err := s.decode(r, request); err != nil {
+			s.respondErr(w, r, errInvalidBody(err))
+			return
+		}
+		owner, err := ownerService.UpdateOwner(ctx, mux.Vars(r)["ownerID"], &gotoproduction.UpdateOwnerRequest{Name: request.Name, Email: request.Email})
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		s.appLogger.WrapTraceContext(ctx).Infof("updated owner: %s", owner.ID)
+		s.respond(w, owner, http.StatusOK)
+	}
+}
+
+func (s *server) handleDeleteOwner(ownerService *gotoproduction.OwnerService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ownerID := mux.Vars(r)["ownerID"]
+		cascade, err := parseBoolParam(r.URL.Query().Get("cascade"))
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("cascade", err))
+			return
+		}
+		if err := ownerService.DeleteOwner(ctx, ownerID, cascade); err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		s.appLogger.WrapTraceContext(ctx).Infof("deleted owner: %s, cascade: %t", ownerID, cascade)
+		s.respond(w, nil, http.StatusNoContent)
+	}
+}
+
+// handleOwnerDogs is the dog listing filtered to one owner, an owner that doesn't exist is not found rather than having
+// no dogs
+func (s *server) handleOwnerDogs(ownerService *gotoproduction.OwnerService, dogService *gotoproduction.DogService) http.HandlerFunc {
+	listDogs := s.handleListDogs(dogService)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ownerService.GetOwner(r.Context(), mux.Vars(r)["ownerID"]); err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		listDogs(w, r)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_server_owners(t *testing.T) {
+	is := is.New(t)
+	store := gotoproduction.NewMemoryStore()
+	s := newServer(store, logx.NewTesterLogger(t), withOwners(store))
+
+	createOwner := func(body string) *gotoproduction.Owner {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/owners", strings.NewReader(body)))
+		is.Equal(recorder.Code, http.StatusCreated)
+		owner := &gotoproduction.Owner{}
+		is.NoErr(json.NewDecoder(recorder.Body).Decode(owner)) // json decode error
+		return owner
+	}
+	alice := createOwner(`{"name":"Alice","email":"alice@example.com"}`)
+	bob := createOwner(`{"name":"Bob","email":"bob@example.com"}`)
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs", strings.NewReader(`{"name":"Oscar","age":2,"type":"Golden Doodle","owner_id":"`+alice.ID+`"}`)))
+	is.Equal(recorder.Code, http.StatusOK)
+	var created struct {
+		DogID string `json:"dog_id"`
+	}
+	is.NoErr(json.NewDecoder(recorder.Body).Decode(&created)) // json decode error
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs/"+created.DogID+"/transfer", strings.NewReader(`{"owner_id":"`+bob.ID+`"}`)))
+	is.Equal(recorder.Code, http.StatusOK)
+	is.True(recorder.Header().Get("ETag") != "") // the transfer is a new version of the dog
+	is.True(strings.Contains(recorder.Body.String(), `"owner_id":"`+bob.ID+`"`))
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/owners/"+bob.ID+"/dogs", nil))
+	is.Equal(recorder.Code, http.StatusOK)
+	var dogs struct {
+		Dogs []*gotoproduction.Dog `json:"dogs"`
+	}
+	is.NoErr(json.NewDecoder(recorder.Body).Decode(&dogs)) // json decode error
+	is.Equal(len(dogs.Dogs), 1)
+	is.Equal(dogs.Dogs[0].ID, created.DogID)
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/owners/nobody/dogs", nil))
+	is.Equal(recorder.Code, http.StatusNotFound)
+	is.Equal(decodeProblem(t, recorder.Result()).Type, problemTypePrefix+"owner-not-found")
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/owners/"+bob.ID, nil))
+	is.Equal(recorder.Code, http.StatusConflict)
+	is.Equal(decodeProblem(t, recorder.Result()).Type, problemTypePrefix+"owner-has-dogs")
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/owners/"+bob.ID+"?cascade=true", nil))
+	is.Equal(recorder.Code, http.StatusNoContent)
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/"+created.DogID, nil))
+	is.Equal(recorder.Code, http.StatusNotFound) // deleted along with bob
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/owners/"+alice.ID, strings.NewReader(`{"name":"Alice","email":"not an email"}`)))
+	is.Equal(recorder.Code, http.StatusUnprocessableEntity)
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/owners", nil))
+	is.Equal(recorder.Code, http.StatusOK)
+	var owners struct {
+		Owners []*gotoproduction.Owner `json:"owners"`
+	}
+	is.NoErr(json.NewDecoder(recorder.Body).Decode(&owners)) // json decode error
+	is.Equal(len(owners.Owners), 1)
+	is.Equal(owners.Owners[0].ID, alice.ID)
+}
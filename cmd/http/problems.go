@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	problemTypePrefix  = "/problems/"
+)
+
+// problem is an RFC 7807 problem details body, with the trace id added so a client can hand us something to search the logs for
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	TraceID  string `json:"trace_id,omitempty"`
+	// Errors lists every failing field when a request does not pass validation
+	Errors []gotoproduction.FieldError `json:"errors,omitempty"`
+}
+
+// httpError is an error that already knows how it should be rendered as a problem
+type httpError struct {
+	status int
+	kind   string
+	title  string
+	detail string
+	fields []gotoproduction.FieldError
+	cause  error
+}
+
+func (e *httpError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.title, e.detail, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.title, e.detail)
+}
+
+func (e *httpError) Unwrap() error {
+	return e.cause
+}
+
+// errMissingParam is for required query or path parameters that were not sent
+func errMissingParam(name string) *httpError {
+	return &httpError{status: http.StatusBadRequest, kind: "missing-parameter", title: "Missing parameter", detail: fmt.Sprintf("the %q parameter is required", name)}
+}
+
+// errInvalidParam is for query or path parameters that could not be parsed
+func errInvalidParam(name string, cause error) *httpError {
+	return &httpError{status: http.StatusBadRequest, kind: "invalid-parameter", title: "Invalid parameter", detail: fmt.Sprintf("the %q parameter is invalid", name), cause: cause}
+}
+
+// errInvalidBody is for request bodies that are not the json we expect
+func errInvalidBody(cause error) *httpError {
+	return &httpError{status: http.StatusBadRequest, kind: "invalid-body", title: "Invalid request body", detail: cause.Error(), cause: cause}
+}
+
+// toHTTPError maps service and storage errors onto the problem they should be reported as
+func toHTTPError(err error) *httpError {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+	var validationErr *gotoproduction.ValidationError
+	if errors.As(err, &validationErr) {
+		return &httpError{status: http.StatusUnprocessableEntity, kind: "validation", title: "Validation failed", detail: "one or more fields are invalid", fields: validationErr.Fields, cause: err}
+	}
+	switch {
+	case errors.Is(err, authx.ErrUnauthenticated):
+		return errUnauthenticated(err)
+	case errors.Is(err, authx.ErrPermissionDenied):
+		return errForbidden(err)
+	case errors.Is(err, gotoproduction.ErrDogNotFound):
+		return &httpError{status: http.StatusNotFound, kind: "dog-not-found", title: "Dog not found", detail: "no dog exists with the given id", cause: err}
+	case errors.Is(err, gotoproduction.ErrWebhookNotFound):
+		return &httpError{status: http.StatusNotFound, kind: "webhook-not-found", title: "Webhook not found", detail: "no webhook subscription exists with the given id", cause: err}
+	case errors.Is(err, gotoproduction.ErrBreedNotFound):
+		return &httpError{status: http.StatusNotFound, kind: "breed-not-found", title: "Breed not found", detail: "no breed in the catalog goes by the given name", cause: err}
+	case errors.Is(err, gotoproduction.ErrOwnerNotFound):
+		return &httpError{status: http.StatusNotFound, kind: "owner-not-found", title: "Owner not found", detail: "no owner exists with the given id", cause: err}
+	case errors.Is(err, gotoproduction.ErrOwnerHasDogs):
+		return &httpError{status: http.StatusConflict, kind: "owner-has-dogs", title: "Owner still has dogs", detail: "transfer or delete the owner's dogs first, or delete with cascade=true", cause: err}
+	case errors.Is(err, gotoproduction.ErrOwnerConflict):
+		return &httpError{status: http.StatusConflict, kind: "owner-conflict", title: "Owner was modified", detail: "the owner's dogs changed while it was being deleted, retry", cause: err}
+	case errors.Is(err, gotoproduction.ErrIllegalTransition):
+		return &httpError{status: http.StatusConflict, kind: "illegal-transition", title: "Illegal status transition", detail: err.Error(), cause: err}
+	case errors.Is(err, gotoproduction.ErrDogConflict):
+		return &httpError{status: http.StatusConflict, kind: "dog-conflict", title: "Dog was modified", detail: "the dog changed since it was last read, fetch it again and retry", cause: err}
+	case errors.Is(err, gotoproduction.ErrWatchNotSupported):
+		return &httpError{status: http.StatusNotImplemented, kind: "watch-not-supported", title: "Watch not supported", detail: "the dog database can't stream changes", cause: err}
+	case errors.Is(err, gotoproduction.ErrNoDogIndex):
+		return &httpError{status: http.StatusNotImplemented, kind: "fuzzy-search-not-supported", title: "Fuzzy search not supported", detail: "this server keeps no dog index", cause: err}
+	case errors.Is(err, gotoproduction.ErrUnsupportedSearch):
+		return &httpError{status: http.StatusBadRequest, kind: "unsupported-search", title: "Unsupported search", detail: err.Error(), cause: err}
+	case errors.Is(err, gotoproduction.ErrInvalidPageToken):
+		return errInvalidParam("page_token", err)
+	case errors.Is(err, gotoproduction.ErrInvalidOrderBy):
+		return errInvalidParam("order_by", err)
+	}
+
+	switch grpcCode(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return &httpError{status: http.StatusServiceUnavailable, kind: "storage-unavailable", title: "Storage unavailable", detail: "the dog database is temporarily unavailable, retry later", cause: err}
+	case codes.DeadlineExceeded:
+		return &httpError{status: http.StatusGatewayTimeout, kind: "storage-timeout", title: "Storage timeout", detail: "the dog database did not answer in time", cause: err}
+	}
+	return &httpError{status: http.StatusInternalServerError, kind: "internal", title: "Internal server error", cause: err}
+}
+
+// grpcCode digs the grpc status code out of a wrapped firestore error, status.Code only looks at the outer most error
+func grpcCode(err error) codes.Code {
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		return grpcErr.GRPCStatus().Code()
+	}
+	return status.Code(err)
+}
+
+// respondErr writes err as an application/problem+json response, server side failures are logged with the trace context
+func (s *server) respondErr(w http.ResponseWriter, r *http.Request, err error) {
+	httpErr := toHTTPError(err)
+	ctx := r.Context()
+	logger := s.appLogger.WrapTraceContext(ctx)
+	if httpErr.status >= http.StatusInternalServerError {
+		logger.Errorw("request failed", "status", httpErr.status, "err", err)
+	} else {
+		logger.Infow("request rejected", "status", httpErr.status, "err", err)
+	}
+
+	p := &problem{
+		Type:     problemTypePrefix + httpErr.kind,
+		Title:    httpErr.title,
+		Status:   httpErr.status,
+		Detail:   httpErr.detail,
+		Instance: r.URL.Path,
+		Errors:   httpErr.fields,
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.TraceID().IsValid() {
+		p.TraceID = sc.TraceID().String()
+	}
+	w.Header().Set("content-type", problemContentType)
+	w.WriteHeader(httpErr.status)
+	err = json.NewEncoder(w).Encode(p)
+	if err != nil {
+		logger.Errorw("writing problem response", "err", err)
+	}
+}
+
+// handleNotFound renders unknown routes as problems too
+func (s *server) handleNotFound() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.respondErr(w, r, &httpError{status: http.StatusNotFound, kind: "route-not-found", title: "Not found", detail: fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path)})
+	}
+}
+
+// handleMethodNotAllowed renders known routes hit with the wrong method as problems
+func (s *server) handleMethodNotAllowed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.respondErr(w, r, &httpError{status: http.StatusMethodNotAllowed, kind: "method-not-allowed", title: "Method not allowed", detail: fmt.Sprintf("%s is not supported on %s", r.Method, r.URL.Path)})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decodeProblem asserts the response is a problem+json body and hands it back for further assertions
+func decodeProblem(t *testing.T, result *http.Response) *problem {
+	t.Helper()
+	is := is.New(t)
+	is.Equal(result.Header.Get("content-type"), problemContentType) // problem content type
+	p := &problem{}
+	err := json.NewDecoder(result.Body).Decode(p)
+	is.NoErr(err)                         // json decode error
+	is.Equal(p.Status, result.StatusCode) // body status matches the response status
+	return p
+}
+
+func Test_server_problems(t *testing.T) {
+	// a real tracer provider, so otelmux hands out valid trace ids we can look for in the problem bodies
+	tp := sdktrace.NewTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+
+	store := gotoproduction.NewMemoryStore()
+	s := newServer(store, logx.NewTesterLogger(t))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantType   string
+		wantDetail string
+	}{
+		{name: "missing type", method: http.MethodGet, target: "/dogs/find", wantStatus: http.StatusBadRequest, wantType: "/problems/missing-parameter", wantDetail: `"type"`},
+		{name: "bad page size", method: http.MethodGet, target: "/dogs?page_size=ten", wantStatus: http.StatusBadRequest, wantType: "/problems/invalid-parameter", wantDetail: `"page_size"`},
+		{name: "bad page token", method: http.MethodGet, target: "/dogs?page_token=nope", wantStatus: http.StatusBadRequest, wantType: "/problems/invalid-parameter", wantDetail: `"page_token"`},
+		{name: "bad json", method: http.MethodPost, target: "/dogs", body: `{"name":`, wantStatus: http.StatusBadRequest, wantType: "/problems/invalid-body", wantDetail: "unexpected EOF"},
+		{name: "missing fields", method: http.MethodPost, target: "/dogs", body: `{}`, wantStatus: http.StatusUnprocessableEntity, wantType: "/problems/validation", wantDetail: "invalid"},
+		{name: "unknown field", method: http.MethodPost, target: "/dogs", body: `{"name":"Oscar","type":"Boxer","colour":"brown"}`, wantStatus: http.StatusBadRequest, wantType: "/problems/invalid-body", wantDetail: `unknown field "colour"`},
+		{name: "trailing data", method: http.MethodPost, target: "/dogs", body: `{"name":"Oscar","type":"Boxer"}{}`, wantStatus: http.StatusBadRequest, wantType: "/problems/invalid-body", wantDetail: "single json object"},
+		{name: "unknown dog", method: http.MethodGet, target: "/dogs/999", wantStatus: http.StatusNotFound, wantType: "/problems/dog-not-found"},
+		{name: "unknown route", method: http.MethodGet, target: "/cats", wantStatus: http.StatusNotFound, wantType: "/problems/route-not-found"},
+		{name: "wrong method", method: http.MethodPost, target: "/dogs/find", wantStatus: http.StatusMethodNotAllowed, wantType: "/problems/method-not-allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			request := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, request)
+			result := recorder.Result()
+			is.Equal(result.StatusCode, tt.wantStatus) // correct status code set
+
+			p := decodeProblem(t, result)
+			is.Equal(p.Type, tt.wantType)                      // problem type
+			is.True(p.Title != "")                             // problem title
+			is.True(strings.Contains(p.Detail, tt.wantDetail)) // problem detail
+			is.Equal(p.Instance, request.URL.Path)             // instance is the request path
+		})
+	}
+
+	t.Run("every failing field is reported", func(t *testing.T) {
+		is := is.New(t)
+		body := `{"name":"Oscar<script>","age":-1,"type":"Dragon"}`
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/dogs", strings.NewReader(body)))
+		is.Equal(recorder.Result().StatusCode, http.StatusUnprocessableEntity) // correct status code set
+		p := decodeProblem(t, recorder.Result())
+		is.Equal(p.Errors, []gotoproduction.FieldError{
+			{Field: "name", Code: "invalid_characters", Message: `must not contain '<'`},
+			{Field: "age", Code: "out_of_range", Message: "must be between 0 and 30"},
+			{Field: "type", Code: "unknown_breed", Message: `"Dragon" is not a known breed`},
+		}) // one error per failing field
+	})
+
+	t.Run("trace id", func(t *testing.T) {
+		is := is.New(t)
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs/999", nil))
+		p := decodeProblem(t, recorder.Result())
+		is.Equal(len(p.TraceID), 32) // routed requests carry the trace id
+	})
+}
+
+func Test_toHTTPError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantKind   string
+	}{
+		{err: gotoproduction.ErrDogNotFound, wantStatus: http.StatusNotFound, wantKind: "dog-not-found"},
+		{err: fmt.Errorf("wrapped: %w", gotoproduction.ErrDogConflict), wantStatus: http.StatusConflict, wantKind: "dog-conflict"},
+		{err: fmt.Errorf("ds.store.ListDogs(): %w", status.Error(codes.Unavailable, "down")), wantStatus: http.StatusServiceUnavailable, wantKind: "storage-unavailable"},
+		{err: fmt.Errorf("ds.store.GetDog(): %w", status.Error(codes.DeadlineExceeded, "slow")), wantStatus: http.StatusGatewayTimeout, wantKind: "storage-timeout"},
+		{err: status.Error(codes.PermissionDenied, "nope"), wantStatus: http.StatusInternalServerError, wantKind: "internal"},
+		{err: errMissingParam("type"), wantStatus: http.StatusBadRequest, wantKind: "missing-parameter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			is := is.New(t)
+			got := toHTTPError(tt.err)
+			is.Equal(got.status, tt.wantStatus) // status for error
+			is.Equal(got.kind, tt.wantKind)     // problem type for error
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/limitx"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// withRateLimit gives every client a token bucket in requests, clients are told apart by principal or else by ip.
+// Failed authentications are counted against the ip in authFailures, since they never get as far as a principal. The
+// grpc server is handed the same limiters so a client can't double its budget by switching transports
+func withRateLimit(requests, authFailures *limitx.Limiter, trustedProxies []*net.IPNet) serverOption {
+	return func(s *server) {
+		s.rateLimiter = requests
+		s.authFailures = authFailures
+		s.trustedProxies = trustedProxies
+	}
+}
+
+// withLoadShedding answers 503 once inFlight is full of dog api requests, rather than queueing work the store can't
+// keep up with. The grpc server shares the gate
+func withLoadShedding(inFlight *limitx.Gate) serverOption {
+	return func(s *server) {
+		s.inFlight = inFlight
+	}
+}
+
+// rateLimit runs after authentication so authenticated clients get a bucket of their own however many ips they use
+func (s *server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		result := s.rateLimiter.Take(s.clientKey(request))
+		header := writer.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(s.rateLimiter.Burst()))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(limitx.CeilSeconds(result.Reset)))
+		if !result.Allowed {
+			s.respondRateLimited(writer, request, result)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func (s *server) respondRateLimited(writer http.ResponseWriter, request *http.Request, result limitx.Result) {
+	writer.Header().Set("Retry-After", strconv.Itoa(limitx.CeilSeconds(result.RetryAfter)))
+	s.respondErr(writer, request, &httpError{status: http.StatusTooManyRequests, kind: "rate-limited", title: "Too many requests", detail: "slow down and retry after the Retry-After header"})
+}
+
+// shedLoad turns requests away while we are already serving as many as we allow, it runs before authentication so an
+// overloaded instance does as little as possible per rejected request
+func (s *server) shedLoad(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !s.inFlight.Enter() {
+			writer.Header().Set("Retry-After", "1")
+			s.respondErr(writer, request, &httpError{status: http.StatusServiceUnavailable, kind: "overloaded", title: "Overloaded", detail: "the server is handling too many requests, retry shortly"})
+			return
+		}
+		defer s.inFlight.Leave()
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// clientKey identifies who a request counts against. Without authentication there is no principal, and an unverified
+// credential would let a client pick a fresh bucket per request, so the ip is all we go by
+func (s *server) clientKey(request *http.Request) string {
+	if principal, ok := authx.FromContext(request.Context()); ok {
+		return fmt.Sprintf("principal:%s:%s", principal.Method, principal.Subject)
+	}
+	return "ip:" + clientIP(request, s.trustedProxies)
+}
+
+// clientIP is the ip of the client behind request, X-Forwarded-For is only believed from trustedProxies
+func clientIP(request *http.Request, trustedProxies []*net.IPNet) string {
+	return limitx.ClientIP(request.RemoteAddr, request.Header.Values("X-Forwarded-For"), trustedProxies)
+}
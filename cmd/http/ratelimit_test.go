@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/limitx"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_server_rateLimit(t *testing.T) {
+	is := is.New(t)
+	s := newServer(gotoproduction.NewMemoryStore(), logx.NewTesterLogger(t), withRateLimit(limitx.NewLimiter(0.001, 2), limitx.NewAuthFailureLimiter(), nil))
+	get := func(remoteAddr string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, "/dogs", nil)
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+
+	is.Equal(get("203.0.113.7:1").StatusCode, http.StatusOK) // within the burst
+	result := get("203.0.113.7:2")
+	is.Equal(result.StatusCode, http.StatusOK)              // within the burst
+	is.Equal(result.Header.Get("RateLimit-Limit"), "2")     // burst size
+	is.Equal(result.Header.Get("RateLimit-Remaining"), "0") // burst used up
+
+	result = get("203.0.113.7:3")
+	is.Equal(result.StatusCode, http.StatusTooManyRequests)         // same ip, different port, same bucket
+	is.Equal(result.Header.Get("content-type"), problemContentType) // rendered as a problem
+	is.True(result.Header.Get("Retry-After") != "")                 // tells the client when to come back
+
+	is.Equal(get("198.51.100.1:1").StatusCode, http.StatusOK) // other clients are unaffected
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	is.Equal(recorder.Code, http.StatusOK) // probes are never limited
+}
+
+// blockingStore holds every ListDogs call until release is closed
+type blockingStore struct {
+	*gotoproduction.MemoryStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (bs *blockingStore) ListDogs(ctx context.Context, query gotoproduction.DogQuery) ([]*gotoproduction.Dog, error) {
+	bs.entered <- struct{}{}
+	<-bs.release
+	return bs.MemoryStore.ListDogs(ctx, query)
+}
+
+func Test_server_loadShedding(t *testing.T) {
+	is := is.New(t)
+	store := &blockingStore{MemoryStore: gotoproduction.NewMemoryStore(), entered: make(chan struct{}), release: make(chan struct{})}
+	s := newServer(store, logx.NewTesterLogger(t), withLoadShedding(limitx.NewGate(1)))
+
+	done := make(chan int)
+	go func() {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs", nil))
+		done <- recorder.Code
+	}()
+	<-store.entered
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs", nil))
+	is.Equal(recorder.Code, http.StatusServiceUnavailable) // shed while the first request is in flight
+	is.Equal(recorder.Header().Get("Retry-After"), "1")    // tells the client to come back shortly
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	is.Equal(recorder.Code, http.StatusOK) // probes are never shed
+
+	close(store.release)
+	is.Equal(<-done, http.StatusOK) // the first request finishes normally
+
+	go func() { <-store.entered }()
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dogs", nil))
+	is.Equal(recorder.Code, http.StatusOK) // capacity is back once it finished
+}
+
+func Test_server_authFailures(t *testing.T) {
+	is := is.New(t)
+	s := newServer(gotoproduction.NewMemoryStore(), logx.NewTesterLogger(t),
+		withAuthenticator(newTestAuthenticator(t, "ci")), withRateLimit(limitx.NewLimiter(100, 100), limitx.NewAuthFailureLimiter(), nil))
+	get := func(remoteAddr, apiKey string) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, "/dogs", nil)
+		request.RemoteAddr = remoteAddr
+		request.Header.Set(apiKeyHeader, apiKey)
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, request)
+		return recorder.Result()
+	}
+
+	for i := 0; i < limitx.AuthFailureBurst; i++ {
+		is.Equal(get("203.0.113.7:1", fmt.Sprintf("guess-%d", i)).StatusCode, http.StatusUnauthorized) // wrong key
+	}
+	result := get("203.0.113.7:1", "guess-next")
+	is.Equal(result.StatusCode, http.StatusTooManyRequests)                         // too many failures from this ip
+	is.True(result.Header.Get("Retry-After") != "")                                 // tells the client when to come back
+	is.Equal(get("203.0.113.7:1", "ci-key").StatusCode, http.StatusTooManyRequests) // even with a good key, the ip is turned away first
+
+	is.Equal(get("198.51.100.1:1", "ci-key").StatusCode, http.StatusOK) // other ips are unaffected
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/dogs/watch", nil)
+	request.RemoteAddr = "203.0.113.7:1"
+	s.ServeHTTP(recorder, request)
+	is.Equal(recorder.Code, http.StatusTooManyRequests) // watch streams count the same failures
+}
@@ -2,24 +2,101 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/amammay/gotoproduction"
 	"github.com/gorilla/mux"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"io"
 	"net/http"
 )
 
 func (s *server) routes() {
 
-	dogService := gotoproduction.NewDogService(s.firestore, s.appLogger)
+	dogService := s.dogService
 
-	s.router.Use(otelmux.Middleware("gotoproduction"))
+	httpMetrics := newHTTPMetrics(s.meterProvider)
+	s.router.Use(otelmux.Middleware("gotoproduction"), httpMetrics.middleware)
+	s.router.NotFoundHandler = httpMetrics.middleware(s.handleNotFound())
+	s.router.MethodNotAllowedHandler = httpMetrics.middleware(s.handleMethodNotAllowed())
 
+	s.router.HandleFunc("/healthz", s.handleHealthz()).Methods(http.MethodGet)
+	s.router.HandleFunc("/readyz", s.handleReadyz()).Methods(http.MethodGet)
+	if s.metricsHandler != nil {
+		s.router.Handle("/metrics", s.metricsHandler).Methods(http.MethodGet)
+	}
+
+	// watch streams are long lived, they are left out of load shedding so they don't hold on to in flight slots. The route
+	// has to come before /dogs/{dogID} would match it
 	func(r *mux.Router) {
-		r.HandleFunc("/find", s.handleFindDog(dogService)).Methods(http.MethodGet)
-		r.HandleFunc("/{dogID}", s.handleGetDog(dogService)).Methods(http.MethodGet)
-		r.HandleFunc("", s.handleCreateDog(dogService)).Methods(http.MethodPost)
+		if s.authenticator != nil {
+			r.Use(s.authenticate)
+		}
+		if s.rateLimiter != nil {
+			r.Use(s.rateLimit)
+		}
+		r.HandleFunc("", s.requireScope(gotoproduction.PermissionReadDogs, s.handleWatchDogs(dogService))).Methods(http.MethodGet)
+	}(s.router.Path("/dogs/watch").Subrouter())
+
+	func(r *mux.Router) {
+		s.useAPIMiddleware(r)
+		r.HandleFunc("/find", s.requireScope(gotoproduction.PermissionReadDogs, s.handleFindDog(dogService))).Methods(http.MethodGet)
+		r.HandleFunc("/search", s.requireScope(gotoproduction.PermissionReadDogs, s.handleSearchDogs(dogService))).Methods(http.MethodGet)
+		r.HandleFunc("/{dogID}", s.requireScope(gotoproduction.PermissionReadDogs, s.handleGetDog(dogService))).Methods(http.MethodGet)
+		r.HandleFunc("/{dogID}", s.requireScope(gotoproduction.PermissionUpdateDogs, s.handleUpdateDog(dogService))).Methods(http.MethodPut)
+		r.HandleFunc("/{dogID}", s.requireScope(gotoproduction.PermissionUpdateDogs, s.handlePatchDog(dogService))).Methods(http.MethodPatch)
+		r.HandleFunc("/{dogID}", s.requireScope(gotoproduction.PermissionDeleteDogs, s.handleDeleteDog(dogService))).Methods(http.MethodDelete)
+		r.HandleFunc("/{dogID}/history", s.requireScope(gotoproduction.PermissionReadDogHistory, s.handleDogHistory(dogService))).Methods(http.MethodGet)
+		r.HandleFunc("/{dogID}/restore", s.requireScope(gotoproduction.PermissionDeleteDogs, s.handleRestoreDog(dogService))).Methods(http.MethodPost)
+		r.HandleFunc("/{dogID}/transfer", s.requireScope(gotoproduction.PermissionUpdateDogs, s.handleTransferDog(dogService))).Methods(http.MethodPost)
+		r.HandleFunc("/{dogID}/transitions", s.requireScope(gotoproduction.PermissionUpdateDogs, s.handleTransitionDog(dogService))).Methods(http.MethodPost)
+		r.HandleFunc("", s.requireScope(gotoproduction.PermissionReadDogs, s.handleListDogs(dogService))).Methods(http.MethodGet)
+		r.HandleFunc("", s.requireScope(gotoproduction.PermissionCreateDogs, s.idempotent(s.handleCreateDog(dogService)))).Methods(http.MethodPost)
 	}(s.router.PathPrefix("/dogs").Subrouter())
 
+	func(r *mux.Router) {
+		s.useAPIMiddleware(r)
+		r.HandleFunc("/{breedID}", s.requireScope(gotoproduction.PermissionReadDogs, s.handleGetBreed(dogService))).Methods(http.MethodGet)
+		r.HandleFunc("", s.requireScope(gotoproduction.PermissionReadDogs, s.handleSearchBreeds(dogService))).Methods(http.MethodGet)
+	}(s.router.PathPrefix("/breeds").Subrouter())
+
+	if ownerService := s.ownerService; ownerService != nil {
+		func(r *mux.Router) {
+			s.useAPIMiddleware(r)
+			r.HandleFunc("/{ownerID}", s.requireScope(gotoproduction.PermissionReadOwners, s.handleGetOwner(ownerService))).Methods(http.MethodGet)
+			r.HandleFunc("/{ownerID}", s.requireScope(gotoproduction.PermissionWriteOwners, s.handleUpdateOwner(ownerService))).Methods(http.MethodPut)
+			r.HandleFunc("/{ownerID}", s.requireScope(gotoproduction.PermissionDeleteOwners, s.handleDeleteOwner(ownerService))).Methods(http.MethodDelete)
+			r.HandleFunc("/{ownerID}/dogs", s.requireScope(gotoproduction.PermissionReadDogs, s.handleOwnerDogs(ownerService, dogService))).Methods(http.MethodGet)
+			r.HandleFunc("", s.requireScope(gotoproduction.PermissionReadOwners, s.handleListOwners(ownerService))).Methods(http.MethodGet)
+			r.HandleFunc("", s.requireScope(gotoproduction.PermissionWriteOwners, s.handleCreateOwner(ownerService))).Methods(http.MethodPost)
+		}(s.router.PathPrefix("/owners").Subrouter())
+	}
+
+	if webhookService := s.webhookService; webhookService != nil {
+		func(r *mux.Router) {
+			s.useAPIMiddleware(r)
+			r.HandleFunc("/{webhookID}", s.requireScope(gotoproduction.PermissionManageWebhooks, s.handleGetWebhook(webhookService))).Methods(http.MethodGet)
+			r.HandleFunc("/{webhookID}", s.requireScope(gotoproduction.PermissionManageWebhooks, s.handleDeleteWebhook(webhookService))).Methods(http.MethodDelete)
+			r.HandleFunc("/{webhookID}/enable", s.requireScope(gotoproduction.PermissionManageWebhooks, s.handleEnableWebhook(webhookService))).Methods(http.MethodPost)
+			r.HandleFunc("/{webhookID}/deliveries", s.requireScope(gotoproduction.PermissionManageWebhooks, s.handleWebhookDeliveries(webhookService))).Methods(http.MethodGet)
+			r.HandleFunc("", s.requireScope(gotoproduction.PermissionManageWebhooks, s.handleListWebhooks(webhookService))).Methods(http.MethodGet)
+			r.HandleFunc("", s.requireScope(gotoproduction.PermissionManageWebhooks, s.handleCreateWebhook(webhookService))).Methods(http.MethodPost)
+		}(s.router.PathPrefix("/webhooks").Subrouter())
+	}
+}
+
+// useAPIMiddleware puts the api routes of r behind load shedding, authentication and rate limiting. Load is shed before
+// doing any work, then we authenticate so the rate limit can tell clients apart by principal. Requests that fail to
+// authenticate never reach the rate limit, authenticate limits those by ip itself
+func (s *server) useAPIMiddleware(r *mux.Router) {
+	if s.inFlight != nil {
+		r.Use(s.shedLoad)
+	}
+	if s.authenticator != nil {
+		r.Use(s.authenticate)
+	}
+	if s.rateLimiter != nil {
+		r.Use(s.rateLimit)
+	}
 }
 
 func (s *server) respond(w http.ResponseWriter, data interface{}, status int) {
@@ -38,6 +115,15 @@ func (s *server) respond(w http.ResponseWriter, data interface{}, status int) {
 
 }
 
+// decode reads exactly one json value into v, unknown fields and anything trailing the value are rejected
 func (s *server) decode(r *http.Request, v interface{}) error {
-	return json.NewDecoder(r.Body).Decode(v)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return errors.New("request body must only contain a single json object")
+	}
+	return nil
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleSearchDogs serves GET /dogs/search, every filter is optional and they are combined with and. With q it is a
+// fuzzy search by name instead, answering ranked matches that can't be filtered or paged
+func (s *server) handleSearchDogs(dogService *gotoproduction.DogService) http.HandlerFunc {
+	type searchDogsResponse struct {
+		Dogs          []*gotoproduction.Dog `json:"dogs"`
+		NextPageToken string                `json:"next_page_token"`
+	}
+	type fuzzySearchResponse struct {
+		Matches []*gotoproduction.DogMatch `json:"matches"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+
+		query := r.URL.Query()
+		if _, ok := query["q"]; ok {
+			for param := range query {
+				if param != "q" && param != "page_size" {
+					s.respondErr(w, r, fmt.Errorf("%w: q can only be combined with page_size, %s was given", gotoproduction.ErrUnsupportedSearch, param))
+					return
+				}
+			}
+			limit, err := parsePageSize(query.Get("page_size"))
+			if err != nil {
+				s.respondErr(w, r, errInvalidParam("page_size", err))
+				return
+			}
+			matches, err := dogService.FuzzySearchDogs(ctx, query.Get("q"), limit)
+			if err != nil {
+				s.respondErr(w, r, err)
+				return
+			}
+			logger.Infof("matched %d dogs", len(matches))
+			s.respond(w, &fuzzySearchResponse{Matches: matches}, http.StatusOK)
+			return
+		}
+
+		request, err := parseSearchDogsRequest(query)
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		page, err := dogService.SearchDogs(ctx, request)
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		logger.Infof("found %d dogs", len(page.Dogs))
+		response := &searchDogsResponse{Dogs: page.Dogs, NextPageToken: page.NextPageToken}
+		if response.Dogs == nil {
+			response.Dogs = []*gotoproduction.Dog{}
+		}
+		s.respond(w, response, http.StatusOK)
+	}
+}
+
+// parseSearchDogsRequest reads the search filters from the query string. type can be repeated or comma separated,
+// times are RFC 3339 and a - in front of order_by sorts descending
+func parseSearchDogsRequest(query url.Values) (*gotoproduction.SearchDogsRequest, error) {
+	request := &gotoproduction.SearchDogsRequest{
+		NamePrefix: query.Get("name_prefix"),
+		PageToken:  query.Get("page_token"),
+	}
+	var err error
+	if request.PageSize, err = parsePageSize(query.Get("page_size")); err != nil {
+		return nil, errInvalidParam("page_size", err)
+	}
+	if request.MinAge, err = parseOptionalInt(query.Get("min_age")); err != nil {
+		return nil, errInvalidParam("min_age", err)
+	}
+	if request.MaxAge, err = parseOptionalInt(query.Get("max_age")); err != nil {
+		return nil, errInvalidParam("max_age", err)
+	}
+	if request.CreatedAfter, err = parseOptionalTime(query.Get("created_after")); err != nil {
+		return nil, errInvalidParam("created_after", err)
+	}
+	if request.CreatedBefore, err = parseOptionalTime(query.Get("created_before")); err != nil {
+		return nil, errInvalidParam("created_before", err)
+	}
+	for _, value := range query["type"] {
+		for _, dogType := range strings.Split(value, ",") {
+			if dogType = strings.TrimSpace(dogType); dogType != "" {
+				request.Types = append(request.Types, dogType)
+			}
+		}
+	}
+	orderBy := query.Get("order_by")
+	if strings.HasPrefix(orderBy, "-") {
+		request.Descending = true
+		orderBy = orderBy[1:]
+	}
+	request.OrderBy = gotoproduction.DogOrder(orderBy)
+	return request, nil
+}
+
+func parseOptionalInt(raw string) (*int, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, fmt.Errorf("strconv.Atoi(%q): %w", raw, err)
+	}
+	return &value, nil
+}
+
+func parseOptionalTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	value, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time.Parse(%q): %w", raw, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// watchBufferSize is how many changes a watch stream holds for a client that is slow to read them, once it is full the
+	// stream ends and the client resumes from the last event it got
+	watchBufferSize = 64
+	// watchRetry is how long EventSource clients wait before reconnecting after a stream ends
+	watchRetry = time.Second
+)
+
+var errSlowConsumer = errors.New("the client is not reading changes fast enough")
+
+// withWatch sets how often idle /dogs/watch streams get a heartbeat and how long a stream lasts before the client has
+// to reconnect, which has to be less than the http server's write timeout
+func withWatch(heartbeat, maxDuration time.Duration) serverOption {
+	return func(s *server) {
+		s.watchHeartbeat = heartbeat
+		s.watchMaxDuration = maxDuration
+	}
+}
+
+// stopStreams ends every open /dogs/watch stream and any started later, clients reconnect to an instance that isn't
+// shutting down
+func (s *server) stopStreams() {
+	s.stopStreamsOnce.Do(func() {
+		close(s.streamsStopped)
+	})
+}
+
+func (s *server) handleWatchDogs(dogService *gotoproduction.DogService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		logger := s.appLogger.WrapTraceContext(ctx)
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			s.respondErr(w, r, errors.New("the response writer can't flush"))
+			return
+		}
+		since, err := parseLastEventID(r)
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("Last-Event-ID", err))
+			return
+		}
+
+		// the watch never blocks on us, a client that can't keep up is dropped rather than holding up the listener
+		changes := make(chan gotoproduction.DogChange, watchBufferSize)
+		ready := make(chan struct{})
+		watchErr := make(chan error, 1)
+		request := &gotoproduction.WatchDogsRequest{
+			Type:  r.URL.Query().Get("type"),
+			Since: since,
+			Ready: func() { close(ready) },
+		}
+		go func() {
+			watchErr <- dogService.WatchDogs(ctx, request, func(change gotoproduction.DogChange) error {
+				select {
+				case changes <- change:
+					return nil
+				default:
+					return errSlowConsumer
+				}
+			})
+		}()
+
+		// the response only starts once the watch is listening, anything that fails before that is still a problem
+		select {
+		case <-ready:
+		case err := <-watchErr:
+			if err == nil {
+				// the client went away before the watch was listening
+				return
+			}
+			s.respondErr(w, r, err)
+			return
+		}
+		w.Header().Set("content-type", "text/event-stream")
+		w.Header().Set("cache-control", "no-cache")
+		// proxies like nginx buffer responses unless told not to
+		w.Header().Set("x-accel-buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: %d\n\n", watchRetry.Milliseconds())
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(s.watchHeartbeat)
+		defer heartbeat.Stop()
+		streamEnd := time.NewTimer(s.watchMaxDuration)
+		defer streamEnd.Stop()
+		for {
+			select {
+			case change := <-changes:
+				data, err := json.Marshal(change.Dog)
+				if err != nil {
+					logger.Errorf("json.Marshal(): %v", err)
+					return
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Time.UnixNano(), change.Type, data)
+				flusher.Flush()
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				flusher.Flush()
+			case err := <-watchErr:
+				if errors.Is(err, errSlowConsumer) {
+					logger.Warnw("dropped a slow dog watch", "type", request.Type)
+				} else if err != nil {
+					logger.Errorf("dogService.WatchDogs(): %v", err)
+				}
+				return
+			case <-streamEnd.C:
+				return
+			case <-s.streamsStopped:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// parseLastEventID turns the Last-Event-ID header an EventSource sends when it reconnects back into the time of the
+// last change it got, no header means start from now
+func parseLastEventID(r *http.Request) (time.Time, error) {
+	lastEventID := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if lastEventID == "" {
+		return time.Time{}, nil
+	}
+	nanos, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("strconv.ParseInt(%q): %w", lastEventID, err)
+	}
+	return time.Unix(0, nanos).UTC(), nil
+}
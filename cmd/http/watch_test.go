@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is one event read off a watch stream, heartbeats are counted rather than returned
+type sseEvent struct {
+	id    string
+	event string
+	dog   *gotoproduction.Dog
+}
+
+// readEvent reads up to the next event, io.EOF means the server ended the stream
+func readEvent(t *testing.T, reader *bufio.Reader, heartbeats *int) (*sseEvent, error) {
+	t.Helper()
+	event := &sseEvent{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event.event != "":
+			return event, nil
+		case strings.HasPrefix(line, ": heartbeat"):
+			*heartbeats++
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.dog = &gotoproduction.Dog{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), event.dog); err != nil {
+				t.Fatalf("json.Unmarshal() err = %v; want nil", err)
+			}
+		}
+	}
+}
+
+func Test_server_watchDogs(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := newServer(gotoproduction.NewMemoryStore(), logx.NewTesterLogger(t), withWatch(10*time.Millisecond, time.Minute))
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	watch := func(lastEventID string) *http.Response {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/dogs/watch?type="+url.QueryEscape("Golden Doodle"), nil)
+		is.NoErr(err) // http.NewRequest error
+		if lastEventID != "" {
+			request.Header.Set("Last-Event-ID", lastEventID)
+		}
+		response, err := server.Client().Do(request)
+		is.NoErr(err) // watch request error
+		return response
+	}
+
+	response := watch("")
+	is.Equal(response.StatusCode, http.StatusOK)
+	is.Equal(response.Header.Get("content-type"), "text/event-stream")
+	// the stream only starts once the watch is listening, so these are all seen
+	id, err := s.dogService.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 2, Type: "Golden Doodle"})
+	is.NoErr(err) // CreateDog error
+	_, err = s.dogService.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Rex", Age: 3, Type: "Boxer"})
+	is.NoErr(err) // CreateDog error
+
+	var heartbeats int
+	reader := bufio.NewReader(response.Body)
+	created, err := readEvent(t, reader, &heartbeats)
+	is.NoErr(err) // readEvent error
+	is.Equal(created.event, gotoproduction.EventDogCreated)
+	is.Equal(created.dog.ID, id)
+	time.Sleep(50 * time.Millisecond)
+	age := 3
+	_, err = s.dogService.PatchDog(ctx, id, &gotoproduction.PatchDogRequest{Age: &age})
+	is.NoErr(err) // PatchDog error
+	updated, err := readEvent(t, reader, &heartbeats)
+	is.NoErr(err) // readEvent error
+	is.Equal(updated.event, gotoproduction.EventDogUpdated)
+	is.True(heartbeats > 0) // idle streams get heartbeats
+	response.Body.Close()
+
+	// reconnecting after the create picks up the update that happened since
+	response = watch(created.id)
+	is.Equal(response.StatusCode, http.StatusOK)
+	reader = bufio.NewReader(response.Body)
+	resumed, err := readEvent(t, reader, &heartbeats)
+	is.NoErr(err) // readEvent error
+	is.Equal(resumed.event, gotoproduction.EventDogUpdated)
+	is.Equal(resumed.id, updated.id)
+	is.Equal(resumed.dog.Age, 3)
+
+	// shutting down ends the open streams
+	s.stopStreams()
+	_, err = readEvent(t, reader, &heartbeats)
+	is.Equal(err, io.EOF) // the stream ended
+	response.Body.Close()
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/dogs/watch", nil)
+	request.Header.Set("Last-Event-ID", "yesterday")
+	s.ServeHTTP(recorder, request)
+	is.Equal(recorder.Code, http.StatusBadRequest)
+	is.Equal(decodeProblem(t, recorder.Result()).Type, problemTypePrefix+"invalid-parameter")
+}
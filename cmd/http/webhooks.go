@@ -0,0 +1,126 @@
+package main
+
+import (
+	"github.com/amammay/gotoproduction"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// withWebhooks serves the /webhooks subscription management routes backed by store
+func withWebhooks(store gotoproduction.WebhookStore, opts ...gotoproduction.WebhookServiceOption) serverOption {
+	return func(s *server) {
+		s.webhookService = gotoproduction.NewWebhookService(store, s.appLogger, opts...)
+	}
+}
+
+func (s *server) handleCreateWebhook(webhookService *gotoproduction.WebhookService) http.HandlerFunc {
+	type createWebhookRequest struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+		Secret     string   `json:"secret"`
+	}
+	// the secret is only ever shown here, the subscriber needs it to check signatures
+	type createWebhookResponse struct {
+		*gotoproduction.WebhookSubscription
+		Secret string `json:"secret"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := s.appLogger.WrapTraceContext(ctx)
+
+		request := &createWebhookRequest{}
+		if err := s.decode(r, request); err != nil {
+			s.respondErr(w, r, errInvalidBody(err))
+			return
+		}
+		subscription, err := webhookService.CreateWebhook(ctx, &gotoproduction.CreateWebhookRequest{
+			URL:        request.URL,
+			EventTypes: request.EventTypes,
+			Secret:     request.Secret,
+		})
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		logger.Infof("created webhook: %s", subscription.ID)
+		s.respond(w, &createWebhookResponse{WebhookSubscription: subscription, Secret: subscription.Secret}, http.StatusCreated)
+	}
+}
+
+func (s *server) handleListWebhooks(webhookService *gotoproduction.WebhookService) http.HandlerFunc {
+	type listWebhooksResponse struct {
+		Webhooks []*gotoproduction.WebhookSubscription `json:"webhooks"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscriptions, err := webhookService.ListWebhooks(r.Context())
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		response := &listWebhooksResponse{Webhooks: subscriptions}
+		if response.Webhooks == nil {
+			response.Webhooks = []*gotoproduction.WebhookSubscription{}
+		}
+		s.respond(w, response, http.StatusOK)
+	}
+}
+
+func (s *server) handleGetWebhook(webhookService *gotoproduction.WebhookService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscription, err := webhookService.GetWebhook(r.Context(), mux.Vars(r)["webhookID"])
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		s.respond(w, subscription, http.StatusOK)
+	}
+}
+
+func (s *server) handleDeleteWebhook(webhookService *gotoproduction.WebhookService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		webhookID := mux.Vars(r)["webhookID"]
+		if err := webhookService.DeleteWebhook(ctx, webhookID); err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		s.appLogger.WrapTraceContext(ctx).Infof("deleted webhook: %s", webhookID)
+		s.respond(w, nil, http.StatusNoContent)
+	}
+}
+
+func (s *server) handleEnableWebhook(webhookService *gotoproduction.WebhookService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		subscription, err := webhookService.EnableWebhook(ctx, mux.Vars(r)["webhookID"])
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		s.appLogger.WrapTraceContext(ctx).Infof("enabled webhook: %s", subscription.ID)
+		s.respond(w, subscription, http.StatusOK)
+	}
+}
+
+func (s *server) handleWebhookDeliveries(webhookService *gotoproduction.WebhookService) http.HandlerFunc {
+	type webhookDeliveriesResponse struct {
+		Deliveries []*gotoproduction.WebhookDelivery `json:"deliveries"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		pageSize, err := parsePageSize(r.URL.Query().Get("page_size"))
+		if err != nil {
+			s.respondErr(w, r, errInvalidParam("page_size", err))
+			return
+		}
+		deliveries, err := webhookService.ListWebhookDeliveries(r.Context(), mux.Vars(r)["webhookID"], pageSize)
+		if err != nil {
+			s.respondErr(w, r, err)
+			return
+		}
+		response := &webhookDeliveriesResponse{Deliveries: deliveries}
+		if response.Deliveries == nil {
+			response.Deliveries = []*gotoproduction.WebhookDelivery{}
+		}
+		s.respond(w, response, http.StatusOK)
+	}
+}
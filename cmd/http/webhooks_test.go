@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_server_webhooks(t *testing.T) {
+	is := is.New(t)
+	store := gotoproduction.NewMemoryStore()
+	s := newServer(store, logx.NewTesterLogger(t), withWebhooks(store))
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"https://partner.example.com/hooks","event_types":["DogCreated"]}`)))
+	is.Equal(recorder.Code, http.StatusCreated)
+	var created struct {
+		ID         string   `json:"id"`
+		EventTypes []string `json:"event_types"`
+		Secret     string   `json:"secret"`
+	}
+	is.NoErr(json.NewDecoder(recorder.Body).Decode(&created)) // json decode error
+	is.True(created.ID != "")
+	is.True(len(created.Secret) >= 32) // a secret is generated when none is given
+	is.Equal(created.EventTypes, []string{gotoproduction.EventDogCreated})
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/webhooks/"+created.ID, nil))
+	is.Equal(recorder.Code, http.StatusOK)
+	is.True(!strings.Contains(recorder.Body.String(), created.Secret)) // the secret is never shown again
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+	is.Equal(recorder.Code, http.StatusOK)
+	var list struct {
+		Webhooks []*gotoproduction.WebhookSubscription `json:"webhooks"`
+	}
+	is.NoErr(json.NewDecoder(recorder.Body).Decode(&list)) // json decode error
+	is.Equal(len(list.Webhooks), 1)
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/webhooks/"+created.ID+"/deliveries", nil))
+	is.Equal(recorder.Code, http.StatusOK)
+	is.True(strings.Contains(recorder.Body.String(), `"deliveries":[]`)) // nothing happened yet
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"ftp://nope","event_types":["DogAdopted"],"secret":"short"}`)))
+	is.Equal(recorder.Code, http.StatusUnprocessableEntity)
+	p := decodeProblem(t, recorder.Result())
+	is.Equal(len(p.Errors), 3) // url, event type and secret
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"http://169.254.169.254/computeMetadata/v1/"}`)))
+	is.Equal(recorder.Code, http.StatusUnprocessableEntity)
+	p = decodeProblem(t, recorder.Result())
+	is.Equal(p.Errors[0].Code, "private_url") // the metadata server is off limits
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/webhooks/"+created.ID, nil))
+	is.Equal(recorder.Code, http.StatusNoContent)
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/webhooks/"+created.ID, nil))
+	is.Equal(recorder.Code, http.StatusNotFound)
+	is.Equal(decodeProblem(t, recorder.Result()).Type, problemTypePrefix+"webhook-not-found")
+}
+
+func Test_server_webhooks_disabled(t *testing.T) {
+	is := is.New(t)
+	s := newServer(gotoproduction.NewMemoryStore(), logx.NewTesterLogger(t))
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+	is.Equal(recorder.Code, http.StatusNotFound) // no routes without withWebhooks
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"cloud.google.com/go/compute/metadata"
+	"cloud.google.com/go/firestore"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/config"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/amammay/gotoproduction/internal/tracex"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// traceShutdownTimeout bounds how long we wait on the trace exporter to flush on the way out
+const traceShutdownTimeout = 5 * time.Second
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "run(): %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run purges dogs that have been soft deleted for longer than purge_retention once and exits, it is meant to be run on
+// a schedule, e.g. as a cloud run job, for deployments that don't set purge_interval on the servers
+func run() error {
+	// stop between batches on ctrl + c or sig term, whatever was purged so far stays purged
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	cfg := config.Default()
+	cfg.ServiceName = "gotoproduction-purge"
+	if err := cfg.Load(os.Args[1:], os.Getenv); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return fmt.Errorf("cfg.Load(): %w", err)
+	}
+	if err := cfg.ResolvePlatform(config.Platform{
+		OnGCE:      metadata.OnGCE(),
+		ProjectID:  metadata.ProjectID,
+		InstanceID: metadata.InstanceID,
+	}); err != nil {
+		return fmt.Errorf("cfg.ResolvePlatform(): %w", err)
+	}
+	if cfg.PrintConfig {
+		return cfg.Print(os.Stdout)
+	}
+
+	logger, err := logx.NewLogger(cfg.ProjectID, cfg.LogFormat == config.LogFormatJSON, cfg.LogLevel)
+	if err != nil {
+		return fmt.Errorf("logx.NewLogger(): %v", err)
+	}
+	defer logger.Sync()
+
+	tracing, err := tracex.InitTracing(ctx, tracex.Options{
+		Exporter:       cfg.TraceExporter,
+		ProjectID:      cfg.ProjectID,
+		Endpoint:       cfg.TraceEndpoint,
+		SampleRatio:    cfg.TraceSampleRatio,
+		ServiceName:    cfg.ServiceName,
+		ServiceVersion: cfg.ServiceVersion,
+		InstanceID:     cfg.InstanceID,
+	})
+	if err != nil {
+		return fmt.Errorf("tracex.InitTracing(): %v", err)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+		defer cancel()
+		if err := tracing.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("tracing.Shutdown(): %v", err)
+		}
+	}()
+
+	fsClient, err := firestore.NewClient(ctx, cfg.ProjectID)
+	if err != nil {
+		return fmt.Errorf("firestore.NewClient(): %w", err)
+	}
+	defer fsClient.Close()
+
+	purger := gotoproduction.NewPurger(gotoproduction.NewFirestoreStore(fsClient), logger, cfg.PurgeRetention, cfg.PurgeBatchSize)
+	purged, err := purger.Purge(ctx)
+	if err != nil {
+		return fmt.Errorf("purger.Purge(): purged %d dogs before failing: %w", purged, err)
+	}
+	logger.Infof("purged %d dogs deleted more than %s ago", purged, cfg.PurgeRetention)
+	return nil
+}
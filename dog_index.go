@@ -0,0 +1,450 @@
+package gotoproduction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+	"math"
+	"sort"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// minMatchScore is the score a name needs to be a fuzzy match, low enough for a typo or two in a short name
+const minMatchScore = 0.3
+
+// rebuildBatchSize is how many dogs a rebuild reads from the store at a time
+const rebuildBatchSize = 500
+
+// minFollowBackoff and maxFollowBackoff bound how long FollowDogIndex waits before watching again after the watch broke
+const (
+	minFollowBackoff = time.Second
+	maxFollowBackoff = time.Minute
+)
+
+// ErrNoDogIndex is returned by FuzzySearchDogs when the service was built without WithDogIndex
+var ErrNoDogIndex = errors.New("fuzzy search needs a dog index")
+
+// DogIndex finds live dogs by partial or misspelled names, something firestore can't do. It is filled from the store
+// with RebuildDogIndex and kept up to date by the DogService writes and, for writes of other instances,
+// FollowDogIndex. Implementations must be safe for concurrent use and
+// only ever keep the newest version of a dog by UpdateTime, so writes indexed out of order and a rebuild running next
+// to live writes all end up in the same state
+type DogIndex interface {
+	// Index adds or replaces a dog unless a newer version of it is indexed already, soft deleted dogs are removed
+	Index(dog *Dog)
+	// Remove drops a dog that no longer exists at all
+	Remove(id string)
+	// Search returns up to limit dogs whose name matches query, best match first, every match when limit is 0
+	Search(query string, limit int) []DogIndexHit
+	// Len is how many live dogs are indexed
+	Len() int
+}
+
+// DogIndexHit is a dog DogIndex.Search matched, with the name as it was indexed
+type DogIndexHit struct {
+	ID         string
+	Name       string
+	Score      float64
+	Highlights []Highlight
+}
+
+// Highlight marks the part of a name from Start up to End that matched, counted in characters rather than bytes
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// DogMatch is one result of FuzzySearchDogs. Score goes from 0 to 1, an exact match of the name scores 1
+type DogMatch struct {
+	Dog        *Dog        `json:"dog"`
+	Score      float64     `json:"score"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+// WithDogIndex sets the index fuzzy searches are served from, without one the service keeps no index and can't fuzzy
+// search. Only a service answering fuzzy searches should have one since a TrigramIndex holds every dog in memory
+func WithDogIndex(index DogIndex) DogServiceOption {
+	return func(ds *DogService) {
+		ds.index = index
+	}
+}
+
+// TrigramIndex is an in memory DogIndex. Names are broken into words and every word into the runs of three characters
+// it is made of, padded so the start and the end of a word count too. A name matches a query when enough of their
+// trigrams are the same, which tolerates typos and partial names
+type TrigramIndex struct {
+	mu sync.RWMutex
+	// dogs has every dog ever indexed by id, soft deleted ones are kept without trigrams so an older version indexed
+	// late can't bring them back
+	dogs map[string]*indexedDog
+	// postings maps every trigram to the ids of the live dogs having it
+	postings map[string]map[string]struct{}
+}
+
+type indexedDog struct {
+	name     string
+	version  time.Time
+	trigrams []string
+}
+
+// NewTrigramIndex creates an empty index
+func NewTrigramIndex() *TrigramIndex {
+	return &TrigramIndex{dogs: map[string]*indexedDog{}, postings: map[string]map[string]struct{}{}}
+}
+
+// Index adds or replaces a dog unless a newer version of it is indexed already, soft deleted dogs are removed
+func (ti *TrigramIndex) Index(dog *Dog) {
+	entry := &indexedDog{name: dog.Name, version: dog.UpdateTime}
+	if dog.DeletedAt == nil {
+		entry.trigrams = trigramSet(dog.Name)
+	}
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	previous, ok := ti.dogs[dog.ID]
+	if ok && dog.UpdateTime.Before(previous.version) {
+		return
+	}
+	if ok {
+		ti.unpost(dog.ID, previous)
+	}
+	ti.dogs[dog.ID] = entry
+	for _, trigram := range entry.trigrams {
+		ids, ok := ti.postings[trigram]
+		if !ok {
+			ids = map[string]struct{}{}
+			ti.postings[trigram] = ids
+		}
+		ids[dog.ID] = struct{}{}
+	}
+}
+
+// Remove drops a dog that no longer exists at all
+func (ti *TrigramIndex) Remove(id string) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	if entry, ok := ti.dogs[id]; ok {
+		ti.unpost(id, entry)
+		delete(ti.dogs, id)
+	}
+}
+
+// unpost takes a dog out of the postings, ti.mu has to be held
+func (ti *TrigramIndex) unpost(id string, entry *indexedDog) {
+	for _, trigram := range entry.trigrams {
+		delete(ti.postings[trigram], id)
+		if len(ti.postings[trigram]) == 0 {
+			delete(ti.postings, trigram)
+		}
+	}
+}
+
+// Search returns up to limit dogs whose name matches query, best match first. A name scores the average of how much of
+// the query it has and how similar the two are as a whole, so "char" finds Charlie and "Chralie" does too, but Charlie
+// still comes before Charlotte for "charlie"
+func (ti *TrigramIndex) Search(query string, limit int) []DogIndexHit {
+	queryTrigrams := trigramSet(query)
+	if len(queryTrigrams) == 0 {
+		return nil
+	}
+
+	ti.mu.RLock()
+	shared := map[string]int{}
+	for _, trigram := range queryTrigrams {
+		for id := range ti.postings[trigram] {
+			shared[id]++
+		}
+	}
+	var hits []DogIndexHit
+	for id, count := range shared {
+		entry := ti.dogs[id]
+		coverage := float64(count) / float64(len(queryTrigrams))
+		similarity := float64(count) / float64(len(queryTrigrams)+len(entry.trigrams)-count)
+		if score := (coverage + similarity) / 2; score >= minMatchScore {
+			hits = append(hits, DogIndexHit{ID: id, Name: entry.name, Score: math.Round(score*1000) / 1000})
+		}
+	}
+	ti.mu.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].Name != hits[j].Name {
+			return hits[i].Name < hits[j].Name
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	wanted := map[string]bool{}
+	for _, trigram := range queryTrigrams {
+		wanted[trigram] = true
+	}
+	for i := range hits {
+		hits[i].Highlights = highlight(hits[i].Name, wanted)
+	}
+	return hits
+}
+
+// Len is how many live dogs are indexed
+func (ti *TrigramIndex) Len() int {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	n := 0
+	for _, entry := range ti.dogs {
+		if entry.trigrams != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// nameWord is one word of a name in lower case, with the position in the name of each of its characters
+type nameWord struct {
+	runes     []rune
+	positions []int
+}
+
+// nameWords splits a name into words of letters and digits, anything else separates them
+func nameWords(name string) []nameWord {
+	var words []nameWord
+	var word nameWord
+	for i, r := range []rune(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word.runes = append(word.runes, unicode.ToLower(r))
+			word.positions = append(word.positions, i)
+			continue
+		}
+		if len(word.runes) > 0 {
+			words = append(words, word)
+			word = nameWord{}
+		}
+	}
+	if len(word.runes) > 0 {
+		words = append(words, word)
+	}
+	return words
+}
+
+// trigrams of a word padded with two spaces in front and one behind, so short words have some and word starts weigh more
+func (w nameWord) trigrams() []string {
+	padded := append(append([]rune("  "), w.runes...), ' ')
+	trigrams := make([]string, 0, len(padded)-2)
+	for i := 0; i+3 <= len(padded); i++ {
+		trigrams = append(trigrams, string(padded[i:i+3]))
+	}
+	return trigrams
+}
+
+// trigramSet is every distinct trigram of every word of value
+func trigramSet(value string) []string {
+	seen := map[string]bool{}
+	var set []string
+	for _, word := range nameWords(value) {
+		for _, trigram := range word.trigrams() {
+			if !seen[trigram] {
+				seen[trigram] = true
+				set = append(set, trigram)
+			}
+		}
+	}
+	return set
+}
+
+// highlight marks the characters of name covered by one of the wanted trigrams, joining neighbours into one range
+func highlight(name string, wanted map[string]bool) []Highlight {
+	marked := map[int]bool{}
+	for _, word := range nameWords(name) {
+		for i, trigram := range word.trigrams() {
+			if !wanted[trigram] {
+				continue
+			}
+			// trigram i covers padded positions i to i+2, the first two padded positions are spaces
+			for p := i; p < i+3; p++ {
+				if j := p - 2; j >= 0 && j < len(word.positions) {
+					marked[word.positions[j]] = true
+				}
+			}
+		}
+	}
+	positions := make([]int, 0, len(marked))
+	for p := range marked {
+		positions = append(positions, p)
+	}
+	sort.Ints(positions)
+	highlights := []Highlight{}
+	for _, p := range positions {
+		if n := len(highlights); n > 0 && highlights[n-1].End == p {
+			highlights[n-1].End = p + 1
+			continue
+		}
+		highlights = append(highlights, Highlight{Start: p, End: p + 1})
+	}
+	return highlights
+}
+
+// FuzzySearchDogs finds live dogs by a partial or misspelled name, best match first. The matches come from the index
+// and are read back from the store limit at a time, a dog that changed under the index is fixed up in it and left out
+// and the next best hits make up for it
+func (ds *DogService) FuzzySearchDogs(ctx context.Context, query string, limit int) (_ []*DogMatch, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.FuzzySearchDogs")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "FuzzySearchDogs", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionReadDogs); err != nil {
+		return nil, err
+	}
+	logger := ds.appLogger.WrapTraceContext(ctx)
+	if ds.index == nil {
+		return nil, ErrNoDogIndex
+	}
+
+	v := &validator{}
+	v.str("q", query, required, maxLength(maxNameLength))
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	hits := ds.index.Search(query, 0)
+	logger.Debugw("searched dog index", "hits", len(hits))
+	matches := []*DogMatch{}
+	for len(hits) > 0 && len(matches) < limit {
+		window := hits
+		if len(window) > limit-len(matches) {
+			window = window[:limit-len(matches)]
+		}
+		hits = hits[len(window):]
+		found, err := ds.readHits(ctx, window)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, found...)
+	}
+	return matches, nil
+}
+
+// readHits reads the dogs of hits back from the store concurrently, in the order of hits. Hits the index was wrong
+// about are fixed up in it and left out
+func (ds *DogService) readHits(ctx context.Context, hits []DogIndexHit) ([]*DogMatch, error) {
+	logger := ds.appLogger.WrapTraceContext(ctx)
+	dogs := make([]*Dog, len(hits))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, hit := range hits {
+		i, hit := i, hit
+		g.Go(func() error {
+			dog, err := ds.store.GetDog(gctx, hit.ID)
+			if errors.Is(err, ErrDogNotFound) {
+				ds.index.Remove(hit.ID)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("ds.store.GetDog(%q): %w", hit.ID, err)
+			}
+			dogs[i] = dog
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var matches []*DogMatch
+	for i, hit := range hits {
+		dog := dogs[i]
+		if dog == nil {
+			continue
+		}
+		if dog.DeletedAt != nil || dog.Name != hit.Name {
+			logger.Debugw("index was behind the store", "id", dog.ID)
+			ds.index.Index(dog)
+			continue
+		}
+		matches = append(matches, &DogMatch{Dog: dog, Score: hit.Score, Highlights: hit.Highlights})
+	}
+	return matches, nil
+}
+
+// RebuildDogIndex fills the index with every live dog in the store and says how many it read. It is meant to run once
+// on startup, writes served while it runs are safe since the index keeps the newest version of each dog
+func (ds *DogService) RebuildDogIndex(ctx context.Context) (_ int, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.RebuildDogIndex")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "RebuildDogIndex", time.Now(), &err)
+	logger := ds.appLogger.WrapTraceContext(ctx)
+	if ds.index == nil {
+		return 0, ErrNoDogIndex
+	}
+
+	read := 0
+	query := DogQuery{OrderBy: DogOrderCreated, Limit: rebuildBatchSize}
+	for {
+		dogs, err := ds.store.ListDogs(ctx, query)
+		if err != nil {
+			return read, fmt.Errorf("ds.store.ListDogs(): %w", err)
+		}
+		for _, dog := range dogs {
+			ds.index.Index(dog)
+		}
+		read += len(dogs)
+		if len(dogs) < rebuildBatchSize {
+			logger.Debugw("rebuilt dog index", "read", read, "indexed", ds.index.Len())
+			return read, nil
+		}
+		query.StartAfter = cursorOf(dogs[len(dogs)-1])
+	}
+}
+
+// FollowDogIndex indexes every dog written after since by any instance until ctx is done, so the index doesn't only
+// know about the writes this instance served. Pass the time a RebuildDogIndex started, a little earlier to allow for
+// clock skew, and nothing written while it ran is missed. A broken watch is resumed after a backoff from the last change
+// it saw. It returns ctx's error, or ErrWatchNotSupported when the store can't watch
+func (ds *DogService) FollowDogIndex(ctx context.Context, since time.Time) error {
+	if ds.index == nil {
+		return ErrNoDogIndex
+	}
+	watcher, ok := ds.store.(DogWatcher)
+	if !ok {
+		return ErrWatchNotSupported
+	}
+	logger := ds.appLogger.WrapTraceContext(ctx)
+	backoff := minFollowBackoff
+	for {
+		// every dog type, so a dog changing its type is just an update
+		err := watcher.WatchDogs(ctx, WatchDogsRequest{Since: since}, func(change DogChange) error {
+			ds.index.Index(change.Dog)
+			since = change.Time
+			backoff = minFollowBackoff
+			return nil
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.Warnw("following dog changes into the index failed", "since", since, "retry_in", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxFollowBackoff {
+			backoff = maxFollowBackoff
+		}
+	}
+}
+
+// indexDog keeps the index in step with a write that succeeded, services without an index have nothing to keep
+func (ds *DogService) indexDog(dog *Dog) {
+	if dog != nil && ds.index != nil {
+		ds.index.Index(dog)
+	}
+}
@@ -0,0 +1,180 @@
+package gotoproduction_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrigramIndex(t *testing.T) {
+	is := is.New(t)
+	index := gotoproduction.NewTrigramIndex()
+	version := time.Now()
+	for i, name := range []string{"Charlie", "Charlotte", "Archie", "Bella", "Mr. Biscuit"} {
+		index.Index(&gotoproduction.Dog{ID: fmt.Sprint(i), Name: name, UpdateTime: version})
+	}
+
+	names := func(hits []gotoproduction.DogIndexHit) []string {
+		var names []string
+		for _, hit := range hits {
+			names = append(names, hit.Name)
+		}
+		return names
+	}
+	hits := index.Search("charlie", 10)
+	is.Equal(names(hits), []string{"Charlie", "Charlotte"})                      // closest name first
+	is.Equal(hits[0].Score, 1.0)                                                 // exact match
+	is.Equal(hits[0].Highlights, []gotoproduction.Highlight{{Start: 0, End: 7}}) // the whole name
+
+	is.Equal(names(index.Search("Chralie", 10)), []string{"Charlie"})                                // typo
+	is.Equal(names(index.Search("char", 1)), []string{"Charlie"})                                    // prefix, limited
+	is.Equal(names(index.Search("bisquit", 10)), []string{"Mr. Biscuit"})                            // any word of the name
+	is.Equal(len(index.Search("zzz", 10)), 0)                                                        // nothing alike
+	is.Equal(len(index.Search("!!", 10)), 0)                                                         // nothing to search for
+	is.Equal(index.Search("bisc", 10)[0].Highlights, []gotoproduction.Highlight{{Start: 4, End: 8}}) // highlight in characters of the name
+
+	index.Index(&gotoproduction.Dog{ID: "0", Name: "Charles", UpdateTime: version.Add(-time.Second)})
+	is.Equal(names(index.Search("charlie", 10)), []string{"Charlie", "Charlotte"}) // older versions are ignored
+
+	deletedAt := version
+	index.Index(&gotoproduction.Dog{ID: "0", Name: "Charlie", UpdateTime: version.Add(time.Second), DeletedAt: &deletedAt})
+	index.Index(&gotoproduction.Dog{ID: "0", Name: "Charlie", UpdateTime: version})
+	is.Equal(names(index.Search("charlie", 10)), []string{"Charlotte"}) // deleted, and an older live version can't bring it back
+	is.Equal(index.Len(), 4)                                            // live dogs
+
+	index.Remove("1")
+	is.Equal(len(index.Search("charlie", 10)), 0) // removed for good
+}
+
+// every version of a dog indexed at once in any order has to leave the newest one behind, run with -race
+func TestTrigramIndex_concurrent(t *testing.T) {
+	is := is.New(t)
+	index := gotoproduction.NewTrigramIndex()
+	version := time.Now()
+
+	var wg sync.WaitGroup
+	for d := 0; d < 20; d++ {
+		for _, v := range rand.Perm(20) {
+			wg.Add(1)
+			go func(d, v int) {
+				defer wg.Done()
+				index.Index(&gotoproduction.Dog{ID: fmt.Sprint(d), Name: fmt.Sprintf("Rex%d", v), UpdateTime: version.Add(time.Duration(v))})
+				index.Search("rex", 5)
+			}(d, v)
+		}
+	}
+	wg.Wait()
+
+	is.Equal(index.Len(), 20) // one entry per dog
+	hits := index.Search("rex19", 100)
+	is.Equal(len(hits), 20) // every dog ended on its newest name
+	for _, hit := range hits {
+		is.Equal(hit.Name, "Rex19") // newest name
+	}
+}
+
+func TestDogService_RebuildDogIndex(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+	writer := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+	var ids []string
+	for _, name := range []string{"Charlie", "Oscar", "Archie"} {
+		id, err := writer.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: name, Age: 1, Type: "Beagle"})
+		is.NoErr(err) // writer.CreateDog error
+		ids = append(ids, id)
+	}
+	is.NoErr(writer.DeleteDog(ctx, ids[1], time.Time{})) // writer.DeleteDog error
+
+	// a second instance only knows what it read on startup
+	reader := gotoproduction.NewDogService(store, logx.NewTesterLogger(t), gotoproduction.WithDogIndex(gotoproduction.NewTrigramIndex()))
+	read, err := reader.RebuildDogIndex(ctx)
+	is.NoErr(err)     // reader.RebuildDogIndex error
+	is.Equal(read, 2) // live dogs only
+
+	matches, err := reader.FuzzySearchDogs(ctx, "charly", 0)
+	is.NoErr(err)                           // reader.FuzzySearchDogs error
+	is.Equal(len(matches), 1)               // found after the rebuild
+	is.Equal(matches[0].Dog.ID, ids[0])     // the right dog
+	is.Equal(matches[0].Dog.Type, "beagle") // read back from the store
+
+	// changed behind the reader's back, the reader drops the stale hit rather than answering with it
+	is.NoErr(writer.DeleteDog(ctx, ids[0], time.Time{})) // writer.DeleteDog error
+	matches, err = reader.FuzzySearchDogs(ctx, "charly", 0)
+	is.NoErr(err)             // reader.FuzzySearchDogs error
+	is.Equal(len(matches), 0) // deleted dog left out
+}
+
+func TestDogService_FuzzySearchDogs_staleHits(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+	writer := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+	var ids []string
+	for _, name := range []string{"Charlie", "Charley", "Charles"} {
+		id, err := writer.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: name, Age: 1, Type: "Beagle"})
+		is.NoErr(err) // writer.CreateDog error
+		ids = append(ids, id)
+	}
+	reader := gotoproduction.NewDogService(store, logx.NewTesterLogger(t), gotoproduction.WithDogIndex(gotoproduction.NewTrigramIndex()))
+	_, err := reader.RebuildDogIndex(ctx)
+	is.NoErr(err) // reader.RebuildDogIndex error
+
+	// the two best hits are gone, the limit is filled with the next best instead of coming back short
+	is.NoErr(writer.DeleteDog(ctx, ids[0], time.Time{})) // writer.DeleteDog error
+	is.NoErr(writer.DeleteDog(ctx, ids[1], time.Time{})) // writer.DeleteDog error
+	matches, err := reader.FuzzySearchDogs(ctx, "charlie", 1)
+	is.NoErr(err)                       // reader.FuzzySearchDogs error
+	is.Equal(len(matches), 1)           // filled up
+	is.Equal(matches[0].Dog.ID, ids[2]) // with the live dog
+
+	_, err = writer.FuzzySearchDogs(ctx, "charlie", 1)
+	is.True(errors.Is(err, gotoproduction.ErrNoDogIndex)) // services keep no index unless asked to
+}
+
+func TestDogService_FollowDogIndex(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := gotoproduction.NewMemoryStore()
+	writer := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+	reader := gotoproduction.NewDogService(store, logx.NewTesterLogger(t), gotoproduction.WithDogIndex(gotoproduction.NewTrigramIndex()))
+
+	// written between the rebuild starting and the watch, the resume point catches it
+	since := time.Now()
+	_, err := writer.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Charlie", Age: 1, Type: "Beagle"})
+	is.NoErr(err) // writer.CreateDog error
+	done := make(chan error, 1)
+	go func() {
+		done <- reader.FollowDogIndex(ctx, since)
+	}()
+
+	// written by another instance after the rebuild
+	_, err = writer.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Boxer"})
+	is.NoErr(err) // writer.CreateDog error
+
+	for _, name := range []string{"charlie", "oscar"} {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			matches, err := reader.FuzzySearchDogs(ctx, name, 1)
+			is.NoErr(err) // reader.FuzzySearchDogs error
+			if len(matches) == 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s was not indexed within 5s", name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	cancel()
+	is.True(errors.Is(<-done, context.Canceled)) // follows until ctx is done
+}
@@ -0,0 +1,4 @@
+// Package dogpb holds the protobuf definition of the dog api and the code generated from it
+package dogpb
+
+//go:generate sh -c "cd .. && buf generate --path dogpb"
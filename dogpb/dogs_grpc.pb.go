@@ -0,0 +1,245 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package dogpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// DogServiceClient is the client API for DogService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DogServiceClient interface {
+	// GetDog retrieves 1 dog by its id, NOT_FOUND when it does not exist
+	GetDog(ctx context.Context, in *GetDogRequest, opts ...grpc.CallOption) (*Dog, error)
+	// FindDogs returns one page of dogs of a given type
+	FindDogs(ctx context.Context, in *FindDogsRequest, opts ...grpc.CallOption) (*FindDogsResponse, error)
+	// CreateDog creates a new dog, INVALID_ARGUMENT with field violations when the request does not validate
+	CreateDog(ctx context.Context, in *CreateDogRequest, opts ...grpc.CallOption) (*CreateDogResponse, error)
+	// ListDogs streams every dog, optionally filtered by type, paging through the database behind the scenes
+	ListDogs(ctx context.Context, in *ListDogsRequest, opts ...grpc.CallOption) (DogService_ListDogsClient, error)
+}
+
+type dogServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDogServiceClient(cc grpc.ClientConnInterface) DogServiceClient {
+	return &dogServiceClient{cc}
+}
+
+func (c *dogServiceClient) GetDog(ctx context.Context, in *GetDogRequest, opts ...grpc.CallOption) (*Dog, error) {
+	out := new(Dog)
+	err := c.cc.Invoke(ctx, "/gotoproduction.dogs.v1.DogService/GetDog", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dogServiceClient) FindDogs(ctx context.Context, in *FindDogsRequest, opts ...grpc.CallOption) (*FindDogsResponse, error) {
+	out := new(FindDogsResponse)
+	err := c.cc.Invoke(ctx, "/gotoproduction.dogs.v1.DogService/FindDogs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dogServiceClient) CreateDog(ctx context.Context, in *CreateDogRequest, opts ...grpc.CallOption) (*CreateDogResponse, error) {
+	out := new(CreateDogResponse)
+	err := c.cc.Invoke(ctx, "/gotoproduction.dogs.v1.DogService/CreateDog", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dogServiceClient) ListDogs(ctx context.Context, in *ListDogsRequest, opts ...grpc.CallOption) (DogService_ListDogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DogService_ServiceDesc.Streams[0], "/gotoproduction.dogs.v1.DogService/ListDogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &dogServiceListDogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DogService_ListDogsClient interface {
+	Recv() (*Dog, error)
+	grpc.ClientStream
+}
+
+type dogServiceListDogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *dogServiceListDogsClient) Recv() (*Dog, error) {
+	m := new(Dog)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DogServiceServer is the server API for DogService service.
+// All implementations must embed UnimplementedDogServiceServer
+// for forward compatibility
+type DogServiceServer interface {
+	// GetDog retrieves 1 dog by its id, NOT_FOUND when it does not exist
+	GetDog(context.Context, *GetDogRequest) (*Dog, error)
+	// FindDogs returns one page of dogs of a given type
+	FindDogs(context.Context, *FindDogsRequest) (*FindDogsResponse, error)
+	// CreateDog creates a new dog, INVALID_ARGUMENT with field violations when the request does not validate
+	CreateDog(context.Context, *CreateDogRequest) (*CreateDogResponse, error)
+	// ListDogs streams every dog, optionally filtered by type, paging through the database behind the scenes
+	ListDogs(*ListDogsRequest, DogService_ListDogsServer) error
+	mustEmbedUnimplementedDogServiceServer()
+}
+
+// UnimplementedDogServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedDogServiceServer struct {
+}
+
+func (UnimplementedDogServiceServer) GetDog(context.Context, *GetDogRequest) (*Dog, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDog not implemented")
+}
+func (UnimplementedDogServiceServer) FindDogs(context.Context, *FindDogsRequest) (*FindDogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindDogs not implemented")
+}
+func (UnimplementedDogServiceServer) CreateDog(context.Context, *CreateDogRequest) (*CreateDogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateDog not implemented")
+}
+func (UnimplementedDogServiceServer) ListDogs(*ListDogsRequest, DogService_ListDogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListDogs not implemented")
+}
+func (UnimplementedDogServiceServer) mustEmbedUnimplementedDogServiceServer() {}
+
+// UnsafeDogServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DogServiceServer will
+// result in compilation errors.
+type UnsafeDogServiceServer interface {
+	mustEmbedUnimplementedDogServiceServer()
+}
+
+func RegisterDogServiceServer(s grpc.ServiceRegistrar, srv DogServiceServer) {
+	s.RegisterService(&DogService_ServiceDesc, srv)
+}
+
+func _DogService_GetDog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DogServiceServer).GetDog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gotoproduction.dogs.v1.DogService/GetDog",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DogServiceServer).GetDog(ctx, req.(*GetDogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DogService_FindDogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindDogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DogServiceServer).FindDogs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gotoproduction.dogs.v1.DogService/FindDogs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DogServiceServer).FindDogs(ctx, req.(*FindDogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DogService_CreateDog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateDogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DogServiceServer).CreateDog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/gotoproduction.dogs.v1.DogService/CreateDog",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DogServiceServer).CreateDog(ctx, req.(*CreateDogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DogService_ListDogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListDogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DogServiceServer).ListDogs(m, &dogServiceListDogsServer{stream})
+}
+
+type DogService_ListDogsServer interface {
+	Send(*Dog) error
+	grpc.ServerStream
+}
+
+type dogServiceListDogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *dogServiceListDogsServer) Send(m *Dog) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// DogService_ServiceDesc is the grpc.ServiceDesc for DogService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DogService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gotoproduction.dogs.v1.DogService",
+	HandlerType: (*DogServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetDog",
+			Handler:    _DogService_GetDog_Handler,
+		},
+		{
+			MethodName: "FindDogs",
+			Handler:    _DogService_FindDogs_Handler,
+		},
+		{
+			MethodName: "CreateDog",
+			Handler:    _DogService_CreateDog_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListDogs",
+			Handler:       _DogService_ListDogs_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "dogpb/dogs.proto",
+}
@@ -1,107 +1,485 @@
 package gotoproduction
 
 import (
-	"cloud.google.com/go/firestore"
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"github.com/amammay/gotoproduction/internal/authx"
 	"github.com/amammay/gotoproduction/internal/logx"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/global"
 	"go.opentelemetry.io/otel/trace"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 	"time"
 )
 
-const dogCollectionName = "dogs"
-
 // ErrDogNotFound represents when a dog cannot be found
 var ErrDogNotFound = errors.New("dog not found")
 
+// ErrDogConflict represents when a dog was modified by somebody else since it was last read
+var ErrDogConflict = errors.New("dog was modified concurrently")
+
 type Dog struct {
 	Name             string    `json:"name" firestore:"name"`
 	Age              int       `json:"age" firestore:"age"`
 	Type             string    `json:"type" firestore:"type"`
 	ID               string    `json:"id" firestore:"id"`
 	CreatedTimestamp time.Time `json:"created_timestamp" firestore:"created_timestamp,serverTimestamp"`
+	// UpdateTime is the last time the dog was written, it is maintained by the store and used for optimistic concurrency
+	UpdateTime time.Time `json:"update_time" firestore:"-"`
+	// DeletedAt is set while the dog is soft deleted, it can be restored until the purge job removes it for good
+	DeletedAt *time.Time `json:"deleted_at,omitempty" firestore:"deleted_at"`
+	// OwnerID is the owner the dog belongs to, empty for a dog without one. It only changes with TransferDog
+	OwnerID string `json:"owner_id,omitempty" firestore:"owner_id"`
+	// Status is where the dog is in the adoption workflow, it only changes with TransitionDog
+	Status DogStatus `json:"status" firestore:"status"`
 }
 
 type CreateDogRequest struct {
 	Name string `json:"name" firestore:"name"`
 	Age  int    `json:"age" firestore:"age"`
 	Type string `json:"type" firestore:"type"`
+	// OwnerID optionally gives the new dog to an existing owner
+	OwnerID string `json:"owner_id" firestore:"owner_id"`
+}
+
+// UpdateDogRequest replaces all the mutable fields of a dog, LastUpdateTime is optional and when set the update only
+// succeeds if the dog has not changed since then
+type UpdateDogRequest struct {
+	Name           string
+	Age            int
+	Type           string
+	LastUpdateTime time.Time
+}
+
+// TransferDogRequest gives a dog to another owner, LastUpdateTime works like it does for UpdateDogRequest
+type TransferDogRequest struct {
+	OwnerID        string
+	LastUpdateTime time.Time
+}
+
+// PatchDogRequest only changes the fields that are set
+type PatchDogRequest struct {
+	Name           *string
+	Age            *int
+	Type           *string
+	LastUpdateTime time.Time
 }
 
 type DogService struct {
-	db        *firestore.Client
-	appLogger *logx.AppLogger
+	store         DogStore
+	appLogger     *logx.AppLogger
+	pageTokenKey  []byte
+	meterProvider metric.MeterProvider
+	metrics       dogServiceMetrics
+	policy        *authx.Policy
+	// index serves fuzzy name searches, every successful write is indexed
+	index DogIndex
+}
+
+// WithMeterProvider sets where operation metrics are recorded, the global meter provider is used otherwise
+func WithMeterProvider(mp metric.MeterProvider) DogServiceOption {
+	return func(ds *DogService) {
+		ds.meterProvider = mp
+	}
+}
+
+// DogServiceOption tweaks how a DogService is built
+type DogServiceOption func(ds *DogService)
+
+// WithPageTokenKey sets the secret page tokens are signed with, every instance serving the same clients must share it.
+// Without it a random key is generated and tokens do not survive a restart
+func WithPageTokenKey(key []byte) DogServiceOption {
+	return func(ds *DogService) {
+		ds.pageTokenKey = key
+	}
+}
+
+// ReadOption tweaks which dogs GetDogByID and FindDogByType return
+type ReadOption func(o *readOptions)
+
+type readOptions struct {
+	includeDeleted bool
 }
 
-func NewDogService(db *firestore.Client, logger *logx.AppLogger) *DogService {
-	return &DogService{db: db, appLogger: logger}
+// IncludeDeleted makes soft deleted dogs visible, they are hidden by default
+func IncludeDeleted(include bool) ReadOption {
+	return func(o *readOptions) {
+		o.includeDeleted = include
+	}
+}
+
+func newReadOptions(opts []ReadOption) readOptions {
+	var o readOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
 }
 
-// GetDogByID retrieves 1 dog by its id
-func (ds *DogService) GetDogByID(ctx context.Context, id string) (*Dog, error) {
+func NewDogService(store DogStore, logger *logx.AppLogger, opts ...DogServiceOption) *DogService {
+	ds := &DogService{store: store, appLogger: logger, meterProvider: global.GetMeterProvider()}
+	for _, opt := range opts {
+		opt(ds)
+	}
+	ds.metrics = newDogServiceMetrics(ds.meterProvider)
+	if len(ds.pageTokenKey) == 0 {
+		ds.pageTokenKey = make([]byte, 32)
+		if _, err := rand.Read(ds.pageTokenKey); err != nil {
+			panic(fmt.Sprintf("rand.Read(): %v", err))
+		}
+	}
+	return ds
+}
+
+// GetDogByID retrieves 1 dog by its id, soft deleted dogs are not found unless asked for
+func (ds *DogService) GetDogByID(ctx context.Context, id string, opts ...ReadOption) (_ *Dog, err error) {
 	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.GetDogByID")
 	defer span.End()
+	defer ds.metrics.observe(ctx, "GetDogByID", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionReadDogs); err != nil {
+		return nil, err
+	}
 	logger := ds.appLogger.WrapTraceContext(ctx)
-	dogPath := fmt.Sprintf("%s/%s", dogCollectionName, id)
-	logger.Debugw("searching firestore", "path", dogPath)
-	docRefSnap, err := ds.db.Doc(dogPath).Get(ctx)
-	if status.Code(err) == codes.NotFound {
+	logger.Debugw("searching store", "id", id)
+	dog, err := ds.store.GetDog(ctx, id)
+	if err == ErrDogNotFound {
 		return nil, ErrDogNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("ds.db.Doc(%q): %w", dogPath, err)
+		return nil, fmt.Errorf("ds.store.GetDog(%q): %w", id, err)
 	}
-	dog := &Dog{}
-	err = docRefSnap.DataTo(dog)
-	if err != nil {
-		return nil, fmt.Errorf("docRefSnap.DataTo(): %w", err)
+	if dog.DeletedAt != nil && !newReadOptions(opts).includeDeleted {
+		return nil, ErrDogNotFound
 	}
 	return dog, nil
 }
 
-// FindDogByType will return all the dogs by a given type
-func (ds *DogService) FindDogByType(ctx context.Context, dogType string) ([]*Dog, error) {
+// FindDogByType will return all the dogs by a given type, which can be any name of the breed. It walks every page so
+// prefer ListDogs for anything unbounded
+func (ds *DogService) FindDogByType(ctx context.Context, dogType string, opts ...ReadOption) (_ []*Dog, err error) {
 	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.FindDogByType")
 	defer span.End()
+	defer ds.metrics.observe(ctx, "FindDogByType", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionReadDogs); err != nil {
+		return nil, err
+	}
+
+	var dogs []*Dog
+	request := &ListDogsRequest{Type: dogType, PageSize: MaxPageSize, IncludeDeleted: newReadOptions(opts).includeDeleted}
+	for {
+		page, err := ds.ListDogs(ctx, request)
+		if err != nil {
+			return nil, fmt.Errorf("ds.ListDogs(%q): %w", dogType, err)
+		}
+		dogs = append(dogs, page.Dogs...)
+		if page.NextPageToken == "" {
+			return dogs, nil
+		}
+		request.PageToken = page.NextPageToken
+	}
+}
+
+// ListDogs returns one page of dogs, optionally filtered by type, ordered by created timestamp or name. The type filter
+// is resolved through the breed catalog, so an alias finds the dogs stored under the canonical id
+func (ds *DogService) ListDogs(ctx context.Context, request *ListDogsRequest) (_ *ListDogsResponse, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.ListDogs")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "ListDogs", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionReadDogs); err != nil {
+		return nil, err
+	}
 	logger := ds.appLogger.WrapTraceContext(ctx)
-	logger.Debugw("searching firestore", "collection", dogCollectionName, "type", dogType)
 
-	all, err := ds.db.Collection(dogCollectionName).Where("type", "==", dogType).Documents(ctx).GetAll()
+	orderBy := request.OrderBy
+	if orderBy == "" {
+		orderBy = DogOrderCreated
+	}
+	if orderBy != DogOrderCreated && orderBy != DogOrderName {
+		return nil, ErrInvalidOrderBy
+	}
+	pageSize := request.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+
+	// ask for one extra dog so we know if there is another page without handing out a token to an empty one
+	query := DogQuery{Type: defaultBreedCatalog.Canonical(request.Type), OwnerID: request.OwnerID, OrderBy: orderBy, Limit: pageSize + 1, IncludeDeleted: request.IncludeDeleted}
+	if request.PageToken != "" {
+		token, err := decodePageToken(ds.pageTokenKey, request.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		if token.OrderBy != orderBy || token.Type != request.Type || token.OwnerID != request.OwnerID || token.IncludeDeleted != request.IncludeDeleted || token.Search != "" {
+			return nil, ErrInvalidPageToken
+		}
+		query.StartAfter = token.cursor()
+	}
+	logger.Debugw("listing store", "type", request.Type, "owner_id", request.OwnerID, "order_by", orderBy, "page_size", pageSize)
+
+	dogs, err := ds.store.ListDogs(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("ds.db.Collection(): %w", err)
+		return nil, fmt.Errorf("ds.store.ListDogs(): %w", err)
 	}
-	var dogs []*Dog
-	for _, snapshot := range all {
-		dog := &Dog{}
-		err := snapshot.DataTo(dog)
+	response := &ListDogsResponse{Dogs: dogs}
+	if len(dogs) > pageSize {
+		response.Dogs = dogs[:pageSize]
+		next, err := encodePageToken(ds.pageTokenKey, newPageToken(orderBy, request, response.Dogs[pageSize-1]))
+		if err != nil {
+			return nil, fmt.Errorf("encodePageToken(): %w", err)
+		}
+		response.NextPageToken = next
+	}
+	return response, nil
+}
+
+// ListDogHistory returns one page of a dog's audit trail, oldest first. History outlives the dog, so a purged dog still
+// has one and a dog that never existed has an empty one
+func (ds *DogService) ListDogHistory(ctx context.Context, id string, request *ListDogHistoryRequest) (_ *ListDogHistoryResponse, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.ListDogHistory")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "ListDogHistory", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionReadDogHistory); err != nil {
+		return nil, err
+	}
+
+	pageSize := request.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	query := AuditQuery{DogID: id, Limit: pageSize + 1}
+	if request.PageToken != "" {
+		token, err := decodePageToken(ds.pageTokenKey, request.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		if token.OrderBy != historyOrder || token.DogID != id {
+			return nil, ErrInvalidPageToken
+		}
+		query.StartAfter = token.auditCursor()
+	}
+
+	entries, err := ds.store.ListAuditEntries(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("ds.store.ListAuditEntries(%q): %w", id, err)
+	}
+	response := &ListDogHistoryResponse{Entries: entries}
+	if len(entries) > pageSize {
+		response.Entries = entries[:pageSize]
+		next, err := encodePageToken(ds.pageTokenKey, newHistoryPageToken(id, response.Entries[pageSize-1]))
 		if err != nil {
-			return nil, fmt.Errorf("snapshot.DataTo(): %w", err)
+			return nil, fmt.Errorf("encodePageToken(): %w", err)
 		}
-		dogs = append(dogs, dog)
+		response.NextPageToken = next
 	}
-	return dogs, nil
+	return response, nil
 }
 
-// CreateDog will create a new dog entry
-func (ds *DogService) CreateDog(ctx context.Context, request *CreateDogRequest) (string, error) {
+// CreateDog will create a new dog entry, its type is stored as the canonical id of the breed
+func (ds *DogService) CreateDog(ctx context.Context, request *CreateDogRequest) (_ string, err error) {
 	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.CreateDog")
 	defer span.End()
+	defer ds.metrics.observe(ctx, "CreateDog", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionCreateDogs); err != nil {
+		return "", err
+	}
 	logger := ds.appLogger.WrapTraceContext(ctx)
 
-	doc := ds.db.Collection(dogCollectionName).NewDoc()
-	logger.Debugw("creating firestore doc", "collection", dogCollectionName, "id", doc.ID)
+	if err := request.Validate(); err != nil {
+		return "", err
+	}
 	dog := &Dog{
-		Name: request.Name,
-		Age:  request.Age,
-		Type: request.Type,
-		ID:   doc.ID,
+		Name:    request.Name,
+		Age:     request.Age,
+		Type:    defaultBreedCatalog.Canonical(request.Type),
+		OwnerID: request.OwnerID,
+		Status:  DogStatusAvailable,
+	}
+	id, err := ds.store.CreateDog(ctx, dog, newAuditEntry(ctx, AuditActionCreate, nil, dog, time.Now().UTC()))
+	if errors.Is(err, ErrOwnerNotFound) {
+		return "", unknownOwnerErr()
+	}
+	if err != nil {
+		return "", fmt.Errorf("ds.store.CreateDog(): %w", err)
+	}
+	ds.indexDog(dog)
+	logger.Debugw("created dog", "id", id)
+	return id, nil
+}
+
+// UpdateDog replaces the name, age and type of an existing dog
+func (ds *DogService) UpdateDog(ctx context.Context, id string, request *UpdateDogRequest) (_ *Dog, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.UpdateDog")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "UpdateDog", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionUpdateDogs); err != nil {
+		return nil, err
+	}
+
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	return ds.modifyDog(ctx, id, request.LastUpdateTime, false, AuditActionUpdate, func(dog *Dog) {
+		dog.Name = request.Name
+		dog.Age = request.Age
+		dog.Type = defaultBreedCatalog.Canonical(request.Type)
+	})
+}
+
+// PatchDog changes only the fields set on the request
+func (ds *DogService) PatchDog(ctx context.Context, id string, request *PatchDogRequest) (_ *Dog, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.PatchDog")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "PatchDog", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionUpdateDogs); err != nil {
+		return nil, err
+	}
+
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	return ds.modifyDog(ctx, id, request.LastUpdateTime, false, AuditActionUpdate, func(dog *Dog) {
+		if request.Name != nil {
+			dog.Name = *request.Name
+		}
+		if request.Age != nil {
+			dog.Age = *request.Age
+		}
+		if request.Type != nil {
+			dog.Type = defaultBreedCatalog.Canonical(*request.Type)
+		}
+	})
+}
+
+// DeleteDog soft deletes a dog, when lastUpdateTime is set the delete only succeeds if the dog has not changed since then.
+// The dog disappears from reads but can be restored until it is purged
+func (ds *DogService) DeleteDog(ctx context.Context, id string, lastUpdateTime time.Time) (err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.DeleteDog")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "DeleteDog", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionDeleteDogs); err != nil {
+		return err
+	}
+
+	_, err = ds.modifyDog(ctx, id, lastUpdateTime, false, AuditActionDelete, func(dog *Dog) {
+		deletedAt := time.Now().UTC()
+		dog.DeletedAt = &deletedAt
+	})
+	return err
+}
+
+// RestoreDog brings a soft deleted dog back, restoring is undoing a delete so it takes the same permission. A dog that
+// is not deleted is not found
+func (ds *DogService) RestoreDog(ctx context.Context, id string, lastUpdateTime time.Time) (_ *Dog, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.RestoreDog")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "RestoreDog", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionDeleteDogs); err != nil {
+		return nil, err
+	}
+
+	return ds.modifyDog(ctx, id, lastUpdateTime, true, AuditActionRestore, func(dog *Dog) {
+		dog.DeletedAt = nil
+	})
+}
+
+// TransferDog gives a live dog to another owner. The dog and the dog counts of both owners change atomically, and the
+// transfer is audited and announced like any other change. Transferring a dog to the owner it already has changes nothing
+func (ds *DogService) TransferDog(ctx context.Context, id string, request *TransferDogRequest) (_ *Dog, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.TransferDog")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "TransferDog", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionUpdateDogs); err != nil {
+		return nil, err
+	}
+	logger := ds.appLogger.WrapTraceContext(ctx)
+
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	current, err := ds.store.GetDog(ctx, id)
+	if errors.Is(err, ErrDogNotFound) {
+		return nil, ErrDogNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ds.store.GetDog(%q): %w", id, err)
+	}
+	if current.DeletedAt != nil {
+		return nil, ErrDogNotFound
+	}
+	if !request.LastUpdateTime.IsZero() && !request.LastUpdateTime.Equal(current.UpdateTime) {
+		return nil, ErrDogConflict
+	}
+	if current.OwnerID == request.OwnerID {
+		return current, nil
+	}
+
+	transferred := copyDog(current)
+	transferred.OwnerID = request.OwnerID
+	audit := newAuditEntry(ctx, AuditActionTransfer, current, transferred, time.Now().UTC())
+	updated, err := ds.store.TransferDog(ctx, transferred, current.OwnerID, current.UpdateTime, audit)
+	switch {
+	case errors.Is(err, ErrOwnerNotFound):
+		return nil, unknownOwnerErr()
+	case errors.Is(err, ErrDogNotFound):
+		return nil, ErrDogNotFound
+	case errors.Is(err, ErrDogConflict):
+		return nil, ErrDogConflict
+	case err != nil:
+		return nil, fmt.Errorf("ds.store.TransferDog(%q): %w", id, err)
+	}
+	ds.indexDog(updated)
+	logger.Debugw("transferred dog", "id", id, "from", current.OwnerID, "to", request.OwnerID)
+	return updated, nil
+}
+
+// unknownOwnerErr reports an owner_id that doesn't point to an owner as a bad field, rather than as the dog not being found
+func unknownOwnerErr() error {
+	return &ValidationError{Fields: []FieldError{{Field: "owner_id", Code: "unknown_owner", Message: "no owner exists with this id"}}}
+}
+
+// modifyDog reads the current dog, applies the change and writes it back with the read update time as a precondition,
+// so a write that raced us in between the read and the write turns into a conflict rather than being overwritten.
+// deleted says whether the change applies to a soft deleted dog, a dog in the other state is not found. The change is
+// audited as action
+func (ds *DogService) modifyDog(ctx context.Context, id string, lastUpdateTime time.Time, deleted bool, action string, apply func(dog *Dog)) (*Dog, error) {
+	logger := ds.appLogger.WrapTraceContext(ctx)
+
+	current, err := ds.store.GetDog(ctx, id)
+	if errors.Is(err, ErrDogNotFound) {
+		return nil, ErrDogNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ds.store.GetDog(%q): %w", id, err)
+	}
+	if (current.DeletedAt != nil) != deleted {
+		return nil, ErrDogNotFound
+	}
+	if !lastUpdateTime.IsZero() && !lastUpdateTime.Equal(current.UpdateTime) {
+		logger.Debugw("stale update rejected", "id", id, "last_update_time", lastUpdateTime, "current_update_time", current.UpdateTime)
+		return nil, ErrDogConflict
+	}
+
+	before := copyDog(current)
+	apply(current)
+	audit := newAuditEntry(ctx, action, before, current, time.Now().UTC())
+	updated, err := ds.store.UpdateDog(ctx, current, current.UpdateTime, audit)
+	if errors.Is(err, ErrDogNotFound) {
+		return nil, ErrDogNotFound
+	}
+	if errors.Is(err, ErrDogConflict) {
+		return nil, ErrDogConflict
 	}
-	_, err := doc.Create(ctx, dog)
 	if err != nil {
-		return "", fmt.Errorf("doc.Create(): %w", err)
+		return nil, fmt.Errorf("ds.store.UpdateDog(%q): %w", id, err)
 	}
-	return doc.ID, nil
+	ds.indexDog(updated)
+	logger.Debugw("updated dog", "id", id, "deleted", updated.DeletedAt != nil)
+	return updated, nil
 }
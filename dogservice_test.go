@@ -2,14 +2,21 @@ package gotoproduction_test
 
 import (
 	"context"
+	"errors"
 	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/authx"
 	"github.com/amammay/gotoproduction/internal/logx"
 	"github.com/amammay/gotoproduction/internal/testx"
 	"github.com/matryer/is"
 	"github.com/testcontainers/testcontainers-go"
+	"sync"
 	"testing"
+	"time"
 )
 
+// newServiceFunc hands each sub test a dog service backed by a fresh, empty store
+type newServiceFunc func(t *testing.T) *gotoproduction.DogService
+
 // integration testing a service with a db interaction
 func TestDogService(t *testing.T) {
 
@@ -40,16 +47,43 @@ func TestDogService(t *testing.T) {
 	// home made firestore testing client that has a util method for clearing all data
 	fsClient := testx.NewFirestoreTestingClient(ctx, t, endpoint)
 
-	service := gotoproduction.NewDogService(fsClient.Client, logx.NewTesterLogger(t))
-	t.Run("Create", testDogService_CreateDog(service, fsClient))
-	t.Run("Find By Type", testDogService_FindDogByType(service, fsClient))
-	t.Run("GetDog By ID", testDogService_GetDogByID(service, fsClient))
+	newService := func(t *testing.T) *gotoproduction.DogService {
+		fsClient.ClearData(t)
+		return gotoproduction.NewDogService(gotoproduction.NewFirestoreStore(fsClient.Client), logx.NewTesterLogger(t),
+			gotoproduction.WithDogIndex(gotoproduction.NewTrigramIndex()))
+	}
+	testDogService(t, newService)
+}
+
+// same suite as TestDogService but against the in memory store, so it runs everywhere without docker
+func TestDogService_memoryStore(t *testing.T) {
+	newService := func(t *testing.T) *gotoproduction.DogService {
+		return gotoproduction.NewDogService(gotoproduction.NewMemoryStore(), logx.NewTesterLogger(t),
+			gotoproduction.WithDogIndex(gotoproduction.NewTrigramIndex()))
+	}
+	testDogService(t, newService)
+}
+
+func testDogService(t *testing.T, newService newServiceFunc) {
+	t.Run("Create", testDogService_CreateDog(newService))
+	t.Run("Find By Type", testDogService_FindDogByType(newService))
+	t.Run("GetDog By ID", testDogService_GetDogByID(newService))
+	t.Run("Update", testDogService_UpdateDog(newService))
+	t.Run("Patch", testDogService_PatchDog(newService))
+	t.Run("Delete", testDogService_DeleteDog(newService))
+	t.Run("Restore", testDogService_RestoreDog(newService))
+	t.Run("Transition", testDogService_TransitionDog(newService))
+	t.Run("History", testDogService_ListDogHistory(newService))
+	t.Run("List", testDogService_ListDogs(newService))
+	t.Run("Search", testDogService_SearchDogs(newService))
+	t.Run("Fuzzy search", testDogService_FuzzySearchDogs(newService))
+	t.Run("Watch", testDogService_WatchDogs(newService))
 }
 
 // simple test case, just creates a dog
-func testDogService_CreateDog(ds *gotoproduction.DogService, fsClient *testx.FsTestingClient) func(t *testing.T) {
+func testDogService_CreateDog(newService newServiceFunc) func(t *testing.T) {
 	return func(t *testing.T) {
-		fsClient.ClearData(t)
+		ds := newService(t)
 		ctx := context.Background()
 		is := is.New(t)
 
@@ -68,9 +102,9 @@ func testDogService_CreateDog(ds *gotoproduction.DogService, fsClient *testx.FsT
 }
 
 // a bit more complex test case, creates a dog and then attempts to find that dog we created
-func testDogService_FindDogByType(ds *gotoproduction.DogService, fsClient *testx.FsTestingClient) func(t *testing.T) {
+func testDogService_FindDogByType(newService newServiceFunc) func(t *testing.T) {
 	return func(t *testing.T) {
-		fsClient.ClearData(t)
+		ds := newService(t)
 
 		ctx := context.Background()
 		is := is.New(t)
@@ -93,10 +127,10 @@ func testDogService_FindDogByType(ds *gotoproduction.DogService, fsClient *testx
 }
 
 // table driven test example, create a dog then attempt to find it by its ID, also test to see if our custom error is thrown when it cant find the dog by id
-func testDogService_GetDogByID(ds *gotoproduction.DogService, fsClient *testx.FsTestingClient) func(t *testing.T) {
+func testDogService_GetDogByID(newService newServiceFunc) func(t *testing.T) {
 
 	return func(t *testing.T) {
-		fsClient.ClearData(t)
+		ds := newService(t)
 		ctx := context.Background()
 		is := is.New(t)
 
@@ -132,3 +166,485 @@ func testDogService_GetDogByID(ds *gotoproduction.DogService, fsClient *testx.Fs
 
 	}
 }
+
+// update a dog, then make sure a second update carrying the stale update time is rejected
+func testDogService_UpdateDog(newService newServiceFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		ds := newService(t)
+		ctx := context.Background()
+		is := is.New(t)
+
+		dogID, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+		original, err := ds.GetDogByID(ctx, dogID)
+		is.NoErr(err) // ds.GetDogByID error
+
+		updated, err := ds.UpdateDog(ctx, dogID, &gotoproduction.UpdateDogRequest{
+			Name:           "Oscar II",
+			Age:            2,
+			Type:           "Golden Doodle",
+			LastUpdateTime: original.UpdateTime,
+		})
+		is.NoErr(err)                                                      // ds.UpdateDog error
+		is.Equal(updated.Name, "Oscar II")                                 // name must be updated
+		is.Equal(updated.Age, 2)                                           // age must be updated
+		is.True(updated.UpdateTime.After(original.UpdateTime))             // update time must move forward
+		is.True(updated.CreatedTimestamp.Equal(original.CreatedTimestamp)) // created timestamp must be untouched
+
+		_, err = ds.UpdateDog(ctx, dogID, &gotoproduction.UpdateDogRequest{
+			Name:           "Oscar III",
+			Type:           "Golden Doodle",
+			LastUpdateTime: original.UpdateTime,
+		})
+		is.Equal(err, gotoproduction.ErrDogConflict) // stale update must conflict
+
+		_, err = ds.UpdateDog(ctx, "999", &gotoproduction.UpdateDogRequest{Name: "Ghost", Type: "Golden Doodle"})
+		is.Equal(err, gotoproduction.ErrDogNotFound) // missing dog
+	}
+}
+
+// patching a single field must leave the rest of the dog alone
+func testDogService_PatchDog(newService newServiceFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		ds := newService(t)
+		ctx := context.Background()
+		is := is.New(t)
+
+		dogID, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscr", Age: 1, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+
+		name := "Oscar"
+		patched, err := ds.PatchDog(ctx, dogID, &gotoproduction.PatchDogRequest{Name: &name})
+		is.NoErr(err)                           // ds.PatchDog error
+		is.Equal(patched.Name, "Oscar")         // name must be fixed
+		is.Equal(patched.Age, 1)                // age must be untouched
+		is.Equal(patched.Type, "golden-doodle") // type must be untouched, as the canonical breed id
+
+		_, err = ds.PatchDog(ctx, dogID, &gotoproduction.PatchDogRequest{Name: &name, LastUpdateTime: time.Unix(1, 0)})
+		is.Equal(err, gotoproduction.ErrDogConflict) // stale patch must conflict
+	}
+}
+
+// delete with a stale precondition is rejected, a good one removes the dog
+func testDogService_DeleteDog(newService newServiceFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		ds := newService(t)
+		ctx := context.Background()
+		is := is.New(t)
+
+		dogID, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+		dog, err := ds.GetDogByID(ctx, dogID)
+		is.NoErr(err) // ds.GetDogByID error
+
+		err = ds.DeleteDog(ctx, dogID, time.Unix(1, 0))
+		is.Equal(err, gotoproduction.ErrDogConflict) // stale delete must conflict
+
+		err = ds.DeleteDog(ctx, dogID, dog.UpdateTime)
+		is.NoErr(err) // ds.DeleteDog error
+
+		_, err = ds.GetDogByID(ctx, dogID)
+		is.Equal(err, gotoproduction.ErrDogNotFound) // dog must be gone
+
+		err = ds.DeleteDog(ctx, dogID, time.Time{})
+		is.Equal(err, gotoproduction.ErrDogNotFound) // deleting twice
+	}
+}
+
+// a soft deleted dog is hidden from reads unless asked for, and restoring it brings it back
+func testDogService_RestoreDog(newService newServiceFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		ds := newService(t)
+		ctx := context.Background()
+		is := is.New(t)
+
+		dogID, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+		_, err = ds.RestoreDog(ctx, dogID, time.Time{})
+		is.Equal(err, gotoproduction.ErrDogNotFound) // only deleted dogs can be restored
+
+		is.NoErr(ds.DeleteDog(ctx, dogID, time.Time{})) // ds.DeleteDog error
+		deleted, err := ds.GetDogByID(ctx, dogID, gotoproduction.IncludeDeleted(true))
+		is.NoErr(err)                     // deleted dogs can still be read when asked for
+		is.True(deleted.DeletedAt != nil) // and say when they were deleted
+
+		byType, err := ds.FindDogByType(ctx, "Golden Doodle")
+		is.NoErr(err)            // ds.FindDogByType error
+		is.Equal(len(byType), 0) // hidden by default
+		byType, err = ds.FindDogByType(ctx, "Golden Doodle", gotoproduction.IncludeDeleted(true))
+		is.NoErr(err)            // ds.FindDogByType error
+		is.Equal(len(byType), 1) // visible when asked for
+
+		_, err = ds.UpdateDog(ctx, dogID, &gotoproduction.UpdateDogRequest{Name: "Oscar II", Type: "Golden Doodle"})
+		is.Equal(err, gotoproduction.ErrDogNotFound) // deleted dogs can't be updated
+
+		_, err = ds.RestoreDog(ctx, dogID, time.Unix(1, 0))
+		is.Equal(err, gotoproduction.ErrDogConflict) // stale restore must conflict
+		restored, err := ds.RestoreDog(ctx, dogID, deleted.UpdateTime)
+		is.NoErr(err)                                          // ds.RestoreDog error
+		is.True(restored.DeletedAt == nil)                     // no longer deleted
+		is.True(restored.UpdateTime.After(deleted.UpdateTime)) // restoring is a write
+
+		_, err = ds.GetDogByID(ctx, dogID)
+		is.NoErr(err) // back in plain reads
+	}
+}
+
+func testDogService_TransitionDog(newService newServiceFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		ds := newService(t)
+		ctx := context.Background()
+		is := is.New(t)
+
+		dogID, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+		dog, err := ds.GetDogByID(ctx, dogID)
+		is.NoErr(err)                                           // ds.GetDogByID error
+		is.Equal(dog.Status, gotoproduction.DogStatusAvailable) // new dogs are available
+
+		_, err = ds.TransitionDog(ctx, dogID, &gotoproduction.TransitionDogRequest{Status: gotoproduction.DogStatusAdopted, Reason: "skipping ahead"})
+		is.True(errors.Is(err, gotoproduction.ErrIllegalTransition)) // available dogs can't be adopted straight away
+		_, err = ds.TransitionDog(ctx, dogID, &gotoproduction.TransitionDogRequest{Status: gotoproduction.DogStatusOnHold})
+		var validationErr *gotoproduction.ValidationError
+		is.True(errors.As(err, &validationErr)) // a reason has to be given
+		_, err = ds.TransitionDog(ctx, dogID, &gotoproduction.TransitionDogRequest{Status: gotoproduction.DogStatusOnHold, Reason: "meet and greet", LastUpdateTime: time.Unix(1, 0)})
+		is.Equal(err, gotoproduction.ErrDogConflict) // stale transition must conflict
+
+		// two people placing a hold at once, only one of them gets it
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = ds.TransitionDog(ctx, dogID, &gotoproduction.TransitionDogRequest{Status: gotoproduction.DogStatusOnHold, Reason: "meet and greet"})
+			}(i)
+		}
+		wg.Wait()
+		held, illegal := 0, 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				held++
+			case errors.Is(err, gotoproduction.ErrIllegalTransition):
+				illegal++
+			}
+		}
+		is.Equal(held, 1)    // one hold was placed
+		is.Equal(illegal, 1) // the other found the dog already on hold
+
+		pending, err := ds.TransitionDog(ctx, dogID, &gotoproduction.TransitionDogRequest{Status: gotoproduction.DogStatusPendingAdoption, Reason: "application approved"})
+		is.NoErr(err) // ds.TransitionDog error
+		is.Equal(pending.Status, gotoproduction.DogStatusPendingAdoption)
+
+		history, err := ds.ListDogHistory(ctx, dogID, &gotoproduction.ListDogHistoryRequest{})
+		is.NoErr(err)                     // ds.ListDogHistory error
+		is.Equal(len(history.Entries), 3) // created, held and pending
+		last := history.Entries[2]
+		is.Equal(last.Action, gotoproduction.AuditActionTransition)
+		is.Equal(last.Reason, "application approved") // why is kept with who
+		is.Equal(last.Changes, []gotoproduction.AuditChange{{Field: "status", Before: "on-hold", After: "pending-adoption"}})
+
+		is.NoErr(ds.DeleteDog(ctx, dogID, time.Time{})) // ds.DeleteDog error
+		_, err = ds.TransitionDog(ctx, dogID, &gotoproduction.TransitionDogRequest{Status: gotoproduction.DogStatusAdopted, Reason: "adopted"})
+		is.Equal(err, gotoproduction.ErrDogNotFound) // deleted dogs stay where they are
+	}
+}
+
+// every successful change leaves one audit entry attributed to the principal that made it, failed ones leave none
+func testDogService_ListDogHistory(newService newServiceFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		ds := newService(t)
+		ctx := authx.NewContext(context.Background(), authx.Principal{Subject: "user-1", Method: "api_key"})
+		is := is.New(t)
+
+		dogID, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+		age := 2
+		_, err = ds.PatchDog(ctx, dogID, &gotoproduction.PatchDogRequest{Age: &age})
+		is.NoErr(err) // ds.PatchDog error
+		_, err = ds.PatchDog(ctx, dogID, &gotoproduction.PatchDogRequest{Age: &age, LastUpdateTime: time.Unix(1, 0)})
+		is.Equal(err, gotoproduction.ErrDogConflict)    // stale patch must conflict
+		is.NoErr(ds.DeleteDog(ctx, dogID, time.Time{})) // ds.DeleteDog error
+
+		first, err := ds.ListDogHistory(ctx, dogID, &gotoproduction.ListDogHistoryRequest{PageSize: 2})
+		is.NoErr(err)                      // ds.ListDogHistory error
+		is.Equal(len(first.Entries), 2)    // first page is full
+		is.True(first.NextPageToken != "") // and there is another one
+		created, patched := first.Entries[0], first.Entries[1]
+		is.Equal(created.Action, gotoproduction.AuditActionCreate)     // oldest first
+		is.Equal(created.DogID, dogID)                                 // entry points at the dog
+		is.Equal(created.Actor, "user-1")                              // attributed to the principal
+		is.Equal(created.ActorMethod, "api_key")                       // and how it authenticated
+		is.Equal(len(created.Changes), 4)                              // name, age, type and status went from nothing to something
+		is.Equal(patched.Action, gotoproduction.AuditActionUpdate)     // then the patch
+		is.Equal(len(patched.Changes), 1)                              // only the age changed
+		is.Equal(patched.Changes[0].Field, "age")                      // and it is named
+		is.True(patched.Changes[0].Before != patched.Changes[0].After) // with both values
+
+		_, err = ds.ListDogHistory(ctx, "another-dog", &gotoproduction.ListDogHistoryRequest{PageToken: first.NextPageToken})
+		is.Equal(err, gotoproduction.ErrInvalidPageToken) // tokens are pinned to their dog
+
+		second, err := ds.ListDogHistory(ctx, dogID, &gotoproduction.ListDogHistoryRequest{PageSize: 2, PageToken: first.NextPageToken})
+		is.NoErr(err)                                                        // ds.ListDogHistory error
+		is.Equal(len(second.Entries), 1)                                     // the conflicting patch left nothing behind
+		is.Equal(second.Entries[0].Action, gotoproduction.AuditActionDelete) // just the delete
+		is.Equal(second.NextPageToken, "")                                   // last page
+
+		none, err := ds.ListDogHistory(ctx, "999", &gotoproduction.ListDogHistoryRequest{})
+		is.NoErr(err)                  // unknown dogs are not an error
+		is.Equal(len(none.Entries), 0) // they just have no history
+	}
+}
+
+// page through dogs by name, inserting a dog in the middle of paging must not shift the pages we have not seen yet
+func testDogService_ListDogs(newService newServiceFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		ds := newService(t)
+		ctx := context.Background()
+		is := is.New(t)
+
+		for _, name := range []string{"Charlie", "Archie", "Oscar", "Bella", "Daisy"} {
+			_, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: name, Age: 1, Type: "Golden Doodle"})
+			is.NoErr(err) // ds.CreateDog error
+		}
+		_, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Rex", Age: 3, Type: "Boxer"})
+		is.NoErr(err) // ds.CreateDog error
+
+		request := &gotoproduction.ListDogsRequest{Type: "Golden Doodle", OrderBy: gotoproduction.DogOrderName, PageSize: 2}
+		first, err := ds.ListDogs(ctx, request)
+		is.NoErr(err)                          // ds.ListDogs error
+		is.Equal(len(first.Dogs), 2)           // first page is full
+		is.Equal(first.Dogs[0].Name, "Archie") // ordered by name
+		is.Equal(first.Dogs[1].Name, "Bella")  // ordered by name
+		is.True(first.NextPageToken != "")     // more pages to come
+
+		// sorts before the cursor, so it must not show up on the later pages
+		_, err = ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Abby", Age: 1, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+
+		var names []string
+		request.PageToken = first.NextPageToken
+		for request.PageToken != "" {
+			page, err := ds.ListDogs(ctx, request)
+			is.NoErr(err) // ds.ListDogs error
+			for _, dog := range page.Dogs {
+				names = append(names, dog.Name)
+			}
+			request.PageToken = page.NextPageToken
+		}
+		is.Equal(names, []string{"Charlie", "Daisy", "Oscar"}) // remaining pages
+
+		_, err = ds.ListDogs(ctx, &gotoproduction.ListDogsRequest{Type: "Boxer", OrderBy: gotoproduction.DogOrderName, PageToken: first.NextPageToken})
+		is.Equal(err, gotoproduction.ErrInvalidPageToken) // token belongs to another query
+
+		_, err = ds.ListDogs(ctx, &gotoproduction.ListDogsRequest{Type: "Golden Doodle", OrderBy: gotoproduction.DogOrderName, PageToken: first.NextPageToken + "x"})
+		is.Equal(err, gotoproduction.ErrInvalidPageToken) // tampered token
+
+		_, err = ds.ListDogs(ctx, &gotoproduction.ListDogsRequest{OrderBy: "age"})
+		is.Equal(err, gotoproduction.ErrInvalidOrderBy) // unsupported order
+	}
+}
+
+func testDogService_SearchDogs(newService newServiceFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		ds := newService(t)
+		ctx := context.Background()
+		is := is.New(t)
+
+		dogs := []struct {
+			name    string
+			age     int
+			dogType string
+		}{
+			{"Bella", 2, "Golden Doodle"},
+			{"Bailey", 7, "Beagle"},
+			{"Buster", 4, "Boxer"},
+			{"Daisy", 5, "Groodle"},
+			{"Max", 9, "Beagle"},
+		}
+		for _, dog := range dogs {
+			_, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: dog.name, Age: dog.age, Type: dog.dogType})
+			is.NoErr(err) // ds.CreateDog error
+		}
+		names := func(request *gotoproduction.SearchDogsRequest) []string {
+			var names []string
+			for {
+				page, err := ds.SearchDogs(ctx, request)
+				is.NoErr(err) // ds.SearchDogs error
+				for _, dog := range page.Dogs {
+					names = append(names, dog.Name)
+				}
+				if page.NextPageToken == "" {
+					return names
+				}
+				request.PageToken = page.NextPageToken
+			}
+		}
+
+		is.Equal(names(&gotoproduction.SearchDogsRequest{NamePrefix: "B"}), []string{"Bailey", "Bella", "Buster"}) // name prefix sorts by name
+
+		minAge, maxAge := 4, 7
+		is.Equal(names(&gotoproduction.SearchDogsRequest{MinAge: &minAge, MaxAge: &maxAge, Descending: true, PageSize: 1}), []string{"Bailey", "Daisy", "Buster"}) // age range, oldest first across pages
+
+		is.Equal(names(&gotoproduction.SearchDogsRequest{Types: []string{"golden-doodle", "Beagle"}, OrderBy: gotoproduction.DogOrderAge}), []string{"Bella", "Daisy", "Bailey", "Max"}) // types by any of their names
+
+		is.Equal(names(&gotoproduction.SearchDogsRequest{Types: []string{"Beagle"}, MinAge: &maxAge}), []string{"Bailey", "Max"}) // type and age together
+
+		is.Equal(len(names(&gotoproduction.SearchDogsRequest{CreatedBefore: time.Now().Add(time.Hour), PageSize: 2})), 5) // created range
+
+		_, err := ds.SearchDogs(ctx, &gotoproduction.SearchDogsRequest{NamePrefix: "B", MinAge: &minAge})
+		is.True(errors.Is(err, gotoproduction.ErrUnsupportedSearch)) // two range filters
+
+		_, err = ds.SearchDogs(ctx, &gotoproduction.SearchDogsRequest{NamePrefix: "B", OrderBy: gotoproduction.DogOrderAge})
+		is.True(errors.Is(err, gotoproduction.ErrUnsupportedSearch)) // sorted by another field than the range
+
+		_, err = ds.SearchDogs(ctx, &gotoproduction.SearchDogsRequest{MinAge: &maxAge, MaxAge: &minAge})
+		var validationErr *gotoproduction.ValidationError
+		is.True(errors.As(err, &validationErr)) // min_age above max_age
+
+		first, err := ds.SearchDogs(ctx, &gotoproduction.SearchDogsRequest{NamePrefix: "B", PageSize: 1})
+		is.NoErr(err) // ds.SearchDogs error
+		_, err = ds.SearchDogs(ctx, &gotoproduction.SearchDogsRequest{NamePrefix: "Ba", PageSize: 1, PageToken: first.NextPageToken})
+		is.Equal(err, gotoproduction.ErrInvalidPageToken) // token belongs to another search
+		_, err = ds.ListDogs(ctx, &gotoproduction.ListDogsRequest{OrderBy: gotoproduction.DogOrderName, PageToken: first.NextPageToken})
+		is.Equal(err, gotoproduction.ErrInvalidPageToken) // search tokens don't page lists
+	}
+}
+
+func testDogService_FuzzySearchDogs(newService newServiceFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		ds := newService(t)
+		ctx := context.Background()
+		is := is.New(t)
+
+		ids := map[string]string{}
+		for _, name := range []string{"Charlie", "Charlotte", "Biscuit"} {
+			id, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: name, Age: 1, Type: "Beagle"})
+			is.NoErr(err) // ds.CreateDog error
+			ids[name] = id
+		}
+
+		matches, err := ds.FuzzySearchDogs(ctx, "Chralie", 10)
+		is.NoErr(err)                                         // ds.FuzzySearchDogs error
+		is.Equal(len(matches), 1)                             // misspelled name
+		is.Equal(matches[0].Dog.ID, ids["Charlie"])           // the right dog
+		is.True(matches[0].Score > 0 && matches[0].Score < 1) // not an exact match
+
+		name := "Buster"
+		_, err = ds.PatchDog(ctx, ids["Charlie"], &gotoproduction.PatchDogRequest{Name: &name})
+		is.NoErr(err)                                              // ds.PatchDog error
+		is.NoErr(ds.DeleteDog(ctx, ids["Charlotte"], time.Time{})) // ds.DeleteDog error
+
+		matches, err = ds.FuzzySearchDogs(ctx, "char", 10)
+		is.NoErr(err)             // ds.FuzzySearchDogs error
+		is.Equal(len(matches), 0) // renamed and deleted dogs are gone
+
+		matches, err = ds.FuzzySearchDogs(ctx, "bust", 10)
+		is.NoErr(err)                               // ds.FuzzySearchDogs error
+		is.Equal(len(matches), 1)                   // found by the new name
+		is.Equal(matches[0].Dog.ID, ids["Charlie"]) // the renamed dog
+
+		_, err = ds.RestoreDog(ctx, ids["Charlotte"], time.Time{})
+		is.NoErr(err) // ds.RestoreDog error
+		matches, err = ds.FuzzySearchDogs(ctx, "charlote", 10)
+		is.NoErr(err)             // ds.FuzzySearchDogs error
+		is.Equal(len(matches), 1) // back after a restore
+
+		_, err = ds.FuzzySearchDogs(ctx, "", 10)
+		var validationErr *gotoproduction.ValidationError
+		is.True(errors.As(err, &validationErr)) // q is required
+	}
+}
+
+// watches golden doodles live, then resumes from before the changes happened
+func testDogService_WatchDogs(newService newServiceFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		ds := newService(t)
+		ctx := context.Background()
+		is := is.New(t)
+
+		bellaID, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Bella", Age: 1, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+		bella, err := ds.GetDogByID(ctx, bellaID)
+		is.NoErr(err) // ds.GetDogByID error
+
+		// watch runs a watch until its ready and returns the changes it sees and a func that stops it
+		watch := func(since time.Time) (<-chan gotoproduction.DogChange, func()) {
+			watchCtx, cancel := context.WithCancel(ctx)
+			ready := make(chan struct{})
+			changes := make(chan gotoproduction.DogChange, 10)
+			done := make(chan error, 1)
+			request := &gotoproduction.WatchDogsRequest{Type: "Golden Doodle", Since: since, Ready: func() { close(ready) }}
+			go func() {
+				done <- ds.WatchDogs(watchCtx, request, func(change gotoproduction.DogChange) error {
+					changes <- change
+					return nil
+				})
+			}()
+			select {
+			case <-ready:
+			case err := <-done:
+				t.Fatalf("ds.WatchDogs() err = %v; want it to keep watching", err)
+			}
+			return changes, func() {
+				cancel()
+				is.NoErr(<-done) // a cancelled watch ends without an error
+			}
+		}
+		next := func(changes <-chan gotoproduction.DogChange) gotoproduction.DogChange {
+			select {
+			case change := <-changes:
+				return change
+			case <-time.After(5 * time.Second):
+				t.Fatal("no change within 5s")
+				return gotoproduction.DogChange{}
+			}
+		}
+
+		changes, stop := watch(time.Time{})
+		oscarID, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 2, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+		_, err = ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Rex", Age: 3, Type: "Boxer"})
+		is.NoErr(err) // ds.CreateDog error
+		age := 3
+		_, err = ds.PatchDog(ctx, oscarID, &gotoproduction.PatchDogRequest{Age: &age})
+		is.NoErr(err)                                     // ds.PatchDog error
+		is.NoErr(ds.DeleteDog(ctx, oscarID, time.Time{})) // ds.DeleteDog error
+
+		created := next(changes)
+		is.Equal(created.Type, gotoproduction.EventDogCreated) // bella was there before the watch, rex is a boxer
+		is.Equal(created.Dog.ID, oscarID)
+		updated := next(changes)
+		is.Equal(updated.Type, gotoproduction.EventDogUpdated)
+		is.Equal(updated.Dog.Age, 3)
+		deleted := next(changes)
+		is.Equal(deleted.Type, gotoproduction.EventDogDeleted) // soft deletes are sent as deletes
+		is.True(deleted.Dog.DeletedAt != nil)
+		stop()
+
+		// a dog last written before the watch started is sent as soon as it changes
+		changes, stop = watch(time.Time{})
+		is.NoErr(ds.DeleteDog(ctx, bellaID, time.Time{})) // ds.DeleteDog error
+		bellaDeleted := next(changes)
+		is.Equal(bellaDeleted.Type, gotoproduction.EventDogDeleted)
+		is.Equal(bellaDeleted.Dog.ID, bellaID)
+		stop()
+
+		// resuming from bella's creation sends oscar and bella as they are now, only once
+		changes, stop = watch(bella.UpdateTime)
+		resumed := next(changes)
+		is.Equal(resumed.Type, gotoproduction.EventDogDeleted)
+		is.Equal(resumed.Dog.ID, oscarID)
+		is.Equal(resumed.Time, deleted.Time) // the resume point of the last live change
+		resumed = next(changes)
+		is.Equal(resumed.Dog.ID, bellaID)
+		is.Equal(resumed.Time, bellaDeleted.Time)
+		select {
+		case change := <-changes:
+			t.Fatalf("got change %s for %s after resuming; want none", change.Type, change.Dog.Name)
+		case <-time.After(100 * time.Millisecond):
+		}
+		stop()
+	}
+}
@@ -0,0 +1,97 @@
+package gotoproduction
+
+import (
+	"context"
+	"time"
+)
+
+// Domain events announced to downstream systems
+const (
+	EventDogCreated = "DogCreated"
+	// EventDogUpdated is also announced when a deleted dog is restored
+	EventDogUpdated = "DogUpdated"
+	EventDogDeleted = "DogDeleted"
+	// EventDogTransferred is announced when a dog gets another owner
+	EventDogTransferred = "DogTransferred"
+	// EventDogStatusChanged is announced when a dog moves to another adoption status
+	EventDogStatusChanged = "DogStatusChanged"
+)
+
+// DogEvent says something happened to a dog, ID stays the same across redeliveries so consumers can drop duplicates
+type DogEvent struct {
+	ID    string `json:"id" firestore:"id"`
+	Type  string `json:"type" firestore:"type"`
+	DogID string `json:"dog_id" firestore:"dog_id"`
+	// Dog is the dog as it was written
+	Dog        *Dog      `json:"dog" firestore:"dog"`
+	Actor      string    `json:"actor" firestore:"actor"`
+	TraceID    string    `json:"trace_id,omitempty" firestore:"trace_id"`
+	OccurredAt time.Time `json:"occurred_at" firestore:"occurred_at"`
+}
+
+// OutboxEvent is a DogEvent waiting in the outbox to be published
+type OutboxEvent struct {
+	Event    *DogEvent `firestore:"event"`
+	Attempts int       `firestore:"attempts"`
+	// NextAttemptAt is when the event is due, relays push it forward while they hold the event and after a failure
+	NextAttemptAt time.Time `firestore:"next_attempt_at"`
+	LastError     string    `firestore:"last_error"`
+}
+
+// EventPublisher sends events somewhere downstream systems can pick them up, Publish returning nil means the event is
+// safely handed over and won't be published again
+type EventPublisher interface {
+	Publish(ctx context.Context, event *DogEvent) error
+}
+
+// OutboxStore is where the relay finds the events stores write next to every dog mutation
+type OutboxStore interface {
+	// ClaimEvents returns up to limit events due at now, oldest first, and pushes them to now+lease so other relays skip
+	// them while they are being published
+	ClaimEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*OutboxEvent, error)
+	// AckEvent removes a published event from the outbox
+	AckEvent(ctx context.Context, id string) error
+	// RetryEvent records a failed attempt, the event is due again at its NextAttemptAt
+	RetryEvent(ctx context.Context, event *OutboxEvent) error
+}
+
+// outboxEventFor is the event announcing the change audited by audit, purges have none since the delete was already
+// announced
+func outboxEventFor(audit *AuditEntry, dog *Dog) *OutboxEvent {
+	var eventType string
+	switch audit.Action {
+	case AuditActionCreate:
+		eventType = EventDogCreated
+	case AuditActionUpdate, AuditActionRestore:
+		eventType = EventDogUpdated
+	case AuditActionDelete:
+		eventType = EventDogDeleted
+	case AuditActionTransfer:
+		eventType = EventDogTransferred
+	case AuditActionTransition:
+		eventType = EventDogStatusChanged
+	default:
+		return nil
+	}
+	return &OutboxEvent{
+		Event: &DogEvent{
+			// one event per audit entry, so the entry id makes a stable dedupe id
+			ID:         audit.ID,
+			Type:       eventType,
+			DogID:      audit.DogID,
+			Dog:        copyDog(dog),
+			Actor:      audit.Actor,
+			TraceID:    audit.TraceID,
+			OccurredAt: audit.Timestamp,
+		},
+		NextAttemptAt: audit.Timestamp,
+	}
+}
+
+func copyOutboxEvent(event *OutboxEvent) *OutboxEvent {
+	c := *event
+	e := *event.Event
+	e.Dog = copyDog(event.Event.Dog)
+	c.Event = &e
+	return &c
+}
@@ -0,0 +1,911 @@
+package gotoproduction
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"time"
+)
+
+const (
+	dogCollectionName         = "dogs"
+	auditCollectionName       = "dog_audit"
+	outboxCollectionName      = "dog_outbox"
+	idempotencyCollectionName = "idempotency_keys"
+	webhookCollectionName     = "webhooks"
+	deliveryCollectionName    = "webhook_deliveries"
+	ownerCollectionName       = "owners"
+)
+
+// FirestoreStore is a DogStore backed by a firestore database
+type FirestoreStore struct {
+	db *firestore.Client
+}
+
+// NewFirestoreStore creates a store that reads and writes to the given firestore client
+func NewFirestoreStore(db *firestore.Client) *FirestoreStore {
+	return &FirestoreStore{db: db}
+}
+
+// GetDog retrieves 1 dog document by its id
+func (fs *FirestoreStore) GetDog(ctx context.Context, id string) (*Dog, error) {
+	dogPath := fmt.Sprintf("%s/%s", dogCollectionName, id)
+	docRefSnap, err := fs.db.Doc(dogPath).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrDogNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fs.db.Doc(%q): %w", dogPath, err)
+	}
+	return snapshotToDog(docRefSnap)
+}
+
+// Ping reads at most one dog id without any fields, which is about as cheap as a firestore read gets
+func (fs *FirestoreStore) Ping(ctx context.Context) error {
+	if _, err := fs.db.Collection(dogCollectionName).Select().Limit(1).Documents(ctx).GetAll(); err != nil {
+		return fmt.Errorf("fs.db.Collection(%q).Documents(): %w", dogCollectionName, err)
+	}
+	return nil
+}
+
+// ListDogs runs a cursor based query, ordering by the requested field and then the document id so pages are stable across
+// inserts. Filtering by type while ordering needs a composite index on (type, order field)
+func (fs *FirestoreStore) ListDogs(ctx context.Context, query DogQuery) ([]*Dog, error) {
+	q := fs.db.Collection(dogCollectionName).Query
+	if query.Type != "" {
+		q = q.Where("type", "==", query.Type)
+	}
+	if query.OwnerID != "" {
+		q = q.Where("owner_id", "==", query.OwnerID)
+	}
+	if !query.IncludeDeleted {
+		// live dogs carry an explicit null deleted_at, documents written before soft delete existed need BackfillDogs
+		q = q.Where("deleted_at", "==", nil)
+	}
+	orderField := string(DogOrderCreated)
+	if query.OrderBy == DogOrderName {
+		orderField = string(DogOrderName)
+	}
+	q = q.OrderBy(orderField, firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc)
+	if cursor := query.StartAfter; cursor != nil {
+		var value interface{} = cursor.CreatedTimestamp
+		if query.OrderBy == DogOrderName {
+			value = cursor.Name
+		}
+		q = q.StartAfter(value, cursor.ID)
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+	all, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("q.Documents(): %w", err)
+	}
+	var dogs []*Dog
+	for _, snapshot := range all {
+		dog, err := snapshotToDog(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		dogs = append(dogs, dog)
+	}
+	return dogs, nil
+}
+
+// SearchDogs translates the query into one firestore query, it relies on SearchDogs having checked firestore can run it
+// with the indexes in firestore.indexes.json
+func (fs *FirestoreStore) SearchDogs(ctx context.Context, query DogSearchQuery) ([]*Dog, error) {
+	q := fs.db.Collection(dogCollectionName).Where("deleted_at", "==", nil)
+	switch len(query.Types) {
+	case 0:
+	case 1:
+		q = q.Where("type", "==", query.Types[0])
+	default:
+		q = q.Where("type", "in", query.Types)
+	}
+	if query.NamePrefix != "" {
+		// every name starting with the prefix sorts between it and the prefix followed by the highest code point
+		q = q.Where("name", ">=", query.NamePrefix).Where("name", "<", query.NamePrefix+"\uf8ff")
+	}
+	if query.MinAge != nil {
+		q = q.Where("age", ">=", *query.MinAge)
+	}
+	if query.MaxAge != nil {
+		q = q.Where("age", "<=", *query.MaxAge)
+	}
+	if !query.CreatedAfter.IsZero() {
+		q = q.Where("created_timestamp", ">", query.CreatedAfter)
+	}
+	if !query.CreatedBefore.IsZero() {
+		q = q.Where("created_timestamp", "<", query.CreatedBefore)
+	}
+	direction := firestore.Asc
+	if query.Descending {
+		direction = firestore.Desc
+	}
+	q = q.OrderBy(string(query.OrderBy), direction).OrderBy(firestore.DocumentID, direction)
+	if cursor := query.StartAfter; cursor != nil {
+		var value interface{} = cursor.CreatedTimestamp
+		switch query.OrderBy {
+		case DogOrderName:
+			value = cursor.Name
+		case DogOrderAge:
+			value = cursor.Age
+		}
+		q = q.StartAfter(value, cursor.ID)
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+	all, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("q.Documents(): %w", err)
+	}
+	var dogs []*Dog
+	for _, snapshot := range all {
+		dog, err := snapshotToDog(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		dogs = append(dogs, dog)
+	}
+	return dogs, nil
+}
+
+// CreateDog creates a new document with a generated id in the same batch as its audit entry, the created timestamp is
+// set by the server. Counting the dog towards its owner is an update, which fails the batch when the owner is missing
+func (fs *FirestoreStore) CreateDog(ctx context.Context, dog *Dog, audit *AuditEntry) (string, error) {
+	doc := fs.db.Collection(dogCollectionName).NewDoc()
+	dog.ID = doc.ID
+	audit.DogID = doc.ID
+	batch := fs.db.Batch().Create(doc, &storedDog{Dog: *dog})
+	if dog.OwnerID != "" {
+		batch.Update(fs.db.Collection(ownerCollectionName).Doc(dog.OwnerID), []firestore.Update{{Path: "dog_count", Value: firestore.Increment(1)}})
+	}
+	fs.recordChange(batch, audit, dog)
+	results, err := batch.Commit(ctx)
+	if status.Code(err) == codes.NotFound {
+		return "", ErrOwnerNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("batch.Commit(): %w", err)
+	}
+	// the server timestamp is the time of the commit
+	dog.CreatedTimestamp = results[0].UpdateTime
+	dog.UpdateTime = results[0].UpdateTime
+	return doc.ID, nil
+}
+
+// UpdateDog updates the mutable fields and deleted_at of the dog document, using an update time precondition for
+// optimistic concurrency
+func (fs *FirestoreStore) UpdateDog(ctx context.Context, dog *Dog, lastUpdateTime time.Time, audit *AuditEntry) (*Dog, error) {
+	updates := []firestore.Update{
+		{Path: "name", Value: dog.Name},
+		{Path: "age", Value: dog.Age},
+		{Path: "type", Value: dog.Type},
+		{Path: "deleted_at", Value: dog.DeletedAt},
+		dogWritten,
+	}
+	var preconditions []firestore.Precondition
+	if !lastUpdateTime.IsZero() {
+		preconditions = append(preconditions, firestore.LastUpdateTime(lastUpdateTime))
+	}
+	// a batch commits atomically, a failed precondition drops the audit entry along with the update
+	batch := fs.db.Batch().Update(fs.db.Collection(dogCollectionName).Doc(dog.ID), updates, preconditions...)
+	fs.recordChange(batch, audit, dog)
+	results, err := batch.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("batch.Commit(): %w", mapDogWriteErr(err))
+	}
+	updated := copyDog(dog)
+	updated.UpdateTime = results[0].UpdateTime
+	return updated, nil
+}
+
+// TransferDog moves the dog and the counts of both owners in one batch, conditional on the update time of the dog so a
+// transfer that raced us can't be counted twice
+func (fs *FirestoreStore) TransferDog(ctx context.Context, dog *Dog, previousOwnerID string, lastUpdateTime time.Time, audit *AuditEntry) (*Dog, error) {
+	owners := fs.db.Collection(ownerCollectionName)
+	batch := fs.db.Batch().Update(fs.db.Collection(dogCollectionName).Doc(dog.ID),
+		[]firestore.Update{{Path: "owner_id", Value: dog.OwnerID}, dogWritten}, firestore.LastUpdateTime(lastUpdateTime))
+	batch.Update(owners.Doc(dog.OwnerID), []firestore.Update{{Path: "dog_count", Value: firestore.Increment(1)}})
+	if previousOwnerID != "" {
+		batch.Update(owners.Doc(previousOwnerID), []firestore.Update{{Path: "dog_count", Value: firestore.Increment(-1)}})
+	}
+	fs.recordChange(batch, audit, dog)
+	results, err := batch.Commit(ctx)
+	if status.Code(err) == codes.NotFound {
+		// either the dog or the new owner is gone, the previous owner can't be while the dog belongs to it
+		if _, err := fs.GetOwner(ctx, dog.OwnerID); errors.Is(err, ErrOwnerNotFound) {
+			return nil, ErrOwnerNotFound
+		}
+		return nil, ErrDogNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("batch.Commit(): %w", mapDogWriteErr(err))
+	}
+	updated := copyDog(dog)
+	updated.UpdateTime = results[0].UpdateTime
+	return updated, nil
+}
+
+// TransitionDog writes the new status in a batch conditional on the update time of the dog, the status we moved from
+// can't have changed without the update time changing too
+func (fs *FirestoreStore) TransitionDog(ctx context.Context, dog *Dog, previous DogStatus, lastUpdateTime time.Time, audit *AuditEntry) (*Dog, error) {
+	batch := fs.db.Batch().Update(fs.db.Collection(dogCollectionName).Doc(dog.ID),
+		[]firestore.Update{{Path: "status", Value: dog.Status}, dogWritten}, firestore.LastUpdateTime(lastUpdateTime))
+	fs.recordChange(batch, audit, dog)
+	results, err := batch.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("batch.Commit(): %w", mapDogWriteErr(err))
+	}
+	updated := copyDog(dog)
+	updated.UpdateTime = results[0].UpdateTime
+	return updated, nil
+}
+
+// PurgeDogs deletes one batch of the longest deleted dogs, every delete is conditional on the update time we read so a
+// dog restored in the meantime fails the batch instead of being purged
+func (fs *FirestoreStore) PurgeDogs(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	q := fs.db.Collection(dogCollectionName).Where("deleted_at", "<", deletedBefore).OrderBy("deleted_at", firestore.Asc)
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	all, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("q.Documents(): %w", err)
+	}
+	if len(all) == 0 {
+		return 0, nil
+	}
+	batch := fs.db.Batch()
+	now := time.Now().UTC()
+	purgedPerOwner := map[string]int{}
+	for _, snapshot := range all {
+		dog, err := snapshotToDog(snapshot)
+		if err != nil {
+			return 0, err
+		}
+		batch.Delete(snapshot.Ref, firestore.LastUpdateTime(snapshot.UpdateTime))
+		fs.recordChange(batch, newAuditEntry(ctx, AuditActionPurge, dog, nil, now), dog)
+		if dog.OwnerID != "" {
+			purgedPerOwner[dog.OwnerID]++
+		}
+	}
+	// one update per owner, a batch can't write the same document twice. With an owner per dog that is a third write
+	// per dog, which is what caps purge_batch_size
+	for ownerID, purged := range purgedPerOwner {
+		batch.Update(fs.db.Collection(ownerCollectionName).Doc(ownerID), []firestore.Update{{Path: "dog_count", Value: firestore.Increment(-purged)}})
+	}
+	if _, err := batch.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("batch.Commit(): %w", mapDogWriteErr(err))
+	}
+	return len(all), nil
+}
+
+// ListAuditEntries queries one dog's history, filtering on dog_id while ordering by timestamp needs a composite index on
+// (dog_id, timestamp)
+func (fs *FirestoreStore) ListAuditEntries(ctx context.Context, query AuditQuery) ([]*AuditEntry, error) {
+	q := fs.db.Collection(auditCollectionName).Where("dog_id", "==", query.DogID).
+		OrderBy("timestamp", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc)
+	if cursor := query.StartAfter; cursor != nil {
+		q = q.StartAfter(cursor.Timestamp, cursor.ID)
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+	all, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("q.Documents(): %w", err)
+	}
+	var entries []*AuditEntry
+	for _, snapshot := range all {
+		entry := &AuditEntry{}
+		if err := snapshot.DataTo(entry); err != nil {
+			return nil, fmt.Errorf("snapshot.DataTo(): %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// watchClockSkew is how far our clock may be ahead of firestore's, a watch from now listens from this long before it so
+// no write committed after the watch is ready can fall before the filter
+const watchClockSkew = time.Minute
+
+// WatchDogs listens to a query snapshot of the dogs written after the resume point, or shortly before now without one.
+// Filtering on updated_at keeps the listener to the dogs that actually change while it runs instead of the whole
+// collection. The first snapshot is the state at the start, which is only used to resume, every later one carries the
+// changes since the one before
+func (fs *FirestoreStore) WatchDogs(ctx context.Context, request WatchDogsRequest, fn func(change DogChange) error) error {
+	from := request.Since
+	if from.IsZero() {
+		from = time.Now().Add(-watchClockSkew)
+	}
+	q := fs.db.Collection(dogCollectionName).Where("updated_at", ">", from)
+	if request.Type != "" {
+		q = q.Where("type", "==", request.Type)
+	}
+	it := q.Snapshots(ctx)
+	defer it.Stop()
+
+	snapshot, err := it.Next()
+	if err != nil {
+		return fmt.Errorf("it.Next(): %w", err)
+	}
+	if request.Ready != nil {
+		request.Ready()
+	}
+	if !request.Since.IsZero() {
+		var pending []DogChange
+		for _, change := range snapshot.Changes {
+			dog, err := snapshotToDog(change.Doc)
+			if err != nil {
+				return err
+			}
+			if change, ok := resumeChange(dog, request.Since); ok {
+				pending = append(pending, change)
+			}
+		}
+		sort.Slice(pending, func(i, j int) bool {
+			return pending[i].Time.Before(pending[j].Time)
+		})
+		for _, change := range pending {
+			if err := fn(change); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		snapshot, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("it.Next(): %w", err)
+		}
+		for _, change := range snapshot.Changes {
+			dog, err := snapshotToDog(change.Doc)
+			if err != nil {
+				return err
+			}
+			dogChange := DogChange{Type: EventDogUpdated, Dog: dog, Time: dog.UpdateTime}
+			switch change.Kind {
+			case firestore.DocumentAdded, firestore.DocumentModified:
+				// every dog written since the watch started is added by its first write, whatever that write was
+				switch {
+				case dog.DeletedAt != nil:
+					dogChange.Type = EventDogDeleted
+				case change.Doc.CreateTime.Equal(change.Doc.UpdateTime):
+					dogChange.Type = EventDogCreated
+				}
+			case firestore.DocumentRemoved:
+				// removed is a purge, which was already sent as a delete, or a type change away from the watched one
+				if dog.DeletedAt != nil {
+					continue
+				}
+				dogChange.Type = EventDogDeleted
+				dogChange.Time = snapshot.ReadTime
+			}
+			if err := fn(dogChange); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// recordChange adds the audit entry under a generated id and the event announcing it to batch, Create rather than Set
+// so an entry can never be overwritten
+func (fs *FirestoreStore) recordChange(batch *firestore.WriteBatch, audit *AuditEntry, dog *Dog) {
+	doc := fs.db.Collection(auditCollectionName).NewDoc()
+	audit.ID = doc.ID
+	batch.Create(doc, audit)
+	if event := outboxEventFor(audit, dog); event != nil {
+		batch.Create(fs.db.Collection(outboxCollectionName).Doc(event.Event.ID), event)
+	}
+}
+
+// ClaimEvents queries the due events and pushes them back by lease in one transaction, so two relays never claim the
+// same event at the same time
+func (fs *FirestoreStore) ClaimEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*OutboxEvent, error) {
+	q := fs.db.Collection(outboxCollectionName).Where("next_attempt_at", "<=", now).OrderBy("next_attempt_at", firestore.Asc)
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	var claimed []*OutboxEvent
+	err := fs.db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = nil
+		all, err := tx.Documents(q).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, snapshot := range all {
+			event := &OutboxEvent{}
+			if err := snapshot.DataTo(event); err != nil {
+				return fmt.Errorf("snapshot.DataTo(): %w", err)
+			}
+			event.NextAttemptAt = now.Add(lease)
+			if err := tx.Update(snapshot.Ref, []firestore.Update{{Path: "next_attempt_at", Value: event.NextAttemptAt}}); err != nil {
+				return err
+			}
+			claimed = append(claimed, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fs.db.RunTransaction(): %w", err)
+	}
+	return claimed, nil
+}
+
+// AckEvent deletes the event document
+func (fs *FirestoreStore) AckEvent(ctx context.Context, id string) error {
+	if _, err := fs.db.Collection(outboxCollectionName).Doc(id).Delete(ctx); err != nil {
+		return fmt.Errorf("doc.Delete(): %w", err)
+	}
+	return nil
+}
+
+// RetryEvent records the failed attempt, an event that was acked in the meantime is left alone
+func (fs *FirestoreStore) RetryEvent(ctx context.Context, event *OutboxEvent) error {
+	_, err := fs.db.Collection(outboxCollectionName).Doc(event.Event.ID).Update(ctx, []firestore.Update{
+		{Path: "attempts", Value: event.Attempts},
+		{Path: "next_attempt_at", Value: event.NextAttemptAt},
+		{Path: "last_error", Value: event.LastError},
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("doc.Update(): %w", err)
+	}
+	return nil
+}
+
+// ReserveIdempotencyKey creates the record in a transaction, so of two concurrent requests with the same key only one
+// gets to reserve it
+func (fs *FirestoreStore) ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	ref := fs.db.Collection(idempotencyCollectionName).Doc(record.Key)
+	var existing *IdempotencyRecord
+	err := fs.db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		existing = nil
+		snapshot, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			stored := &IdempotencyRecord{}
+			if err := snapshot.DataTo(stored); err != nil {
+				return fmt.Errorf("snapshot.DataTo(): %w", err)
+			}
+			if stored.ExpiresAt.After(time.Now()) {
+				existing = stored
+				return nil
+			}
+		}
+		return tx.Set(ref, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fs.db.RunTransaction(): %w", err)
+	}
+	return existing, nil
+}
+
+// CompleteIdempotencyKey overwrites the reserved record
+func (fs *FirestoreStore) CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	if _, err := fs.db.Collection(idempotencyCollectionName).Doc(record.Key).Set(ctx, record); err != nil {
+		return fmt.Errorf("doc.Set(): %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes the record, deleting a missing record is not an error
+func (fs *FirestoreStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if _, err := fs.db.Collection(idempotencyCollectionName).Doc(key).Delete(ctx); err != nil {
+		return fmt.Errorf("doc.Delete(): %w", err)
+	}
+	return nil
+}
+
+// CreateWebhookSubscription creates the subscription under a generated id
+func (fs *FirestoreStore) CreateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) (string, error) {
+	doc := fs.db.Collection(webhookCollectionName).NewDoc()
+	subscription.ID = doc.ID
+	if _, err := doc.Create(ctx, subscription); err != nil {
+		return "", fmt.Errorf("doc.Create(): %w", err)
+	}
+	return doc.ID, nil
+}
+
+// GetWebhookSubscription retrieves 1 subscription document by its id
+func (fs *FirestoreStore) GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	snapshot, err := fs.db.Collection(webhookCollectionName).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("doc.Get(%q): %w", id, err)
+	}
+	subscription := &WebhookSubscription{}
+	if err := snapshot.DataTo(subscription); err != nil {
+		return nil, fmt.Errorf("snapshot.DataTo(): %w", err)
+	}
+	return subscription, nil
+}
+
+// ListWebhookSubscriptions reads the whole collection, oldest first
+func (fs *FirestoreStore) ListWebhookSubscriptions(ctx context.Context) ([]*WebhookSubscription, error) {
+	all, err := fs.db.Collection(webhookCollectionName).OrderBy("created_at", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("q.Documents(): %w", err)
+	}
+	subscriptions := make([]*WebhookSubscription, 0, len(all))
+	for _, snapshot := range all {
+		subscription := &WebhookSubscription{}
+		if err := snapshot.DataTo(subscription); err != nil {
+			return nil, fmt.Errorf("snapshot.DataTo(): %w", err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+// UpdateWebhookSubscription overwrites the document, which has to exist
+func (fs *FirestoreStore) UpdateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	ref := fs.db.Collection(webhookCollectionName).Doc(subscription.ID)
+	err := fs.db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(ref); err != nil {
+			return err
+		}
+		return tx.Set(ref, subscription)
+	})
+	if status.Code(err) == codes.NotFound {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("fs.db.RunTransaction(): %w", err)
+	}
+	return nil
+}
+
+// DeleteWebhookSubscription deletes the document, its deliveries are kept
+func (fs *FirestoreStore) DeleteWebhookSubscription(ctx context.Context, id string) error {
+	_, err := fs.db.Collection(webhookCollectionName).Doc(id).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("doc.Delete(): %w", err)
+	}
+	return nil
+}
+
+// RecordWebhookResult reads and writes the subscription in a transaction, so concurrent deliverers don't lose counts
+func (fs *FirestoreStore) RecordWebhookResult(ctx context.Context, id string, success bool, disableAfter int) (*WebhookSubscription, error) {
+	ref := fs.db.Collection(webhookCollectionName).Doc(id)
+	var subscription *WebhookSubscription
+	err := fs.db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		subscription = &WebhookSubscription{}
+		if err := snapshot.DataTo(subscription); err != nil {
+			return fmt.Errorf("snapshot.DataTo(): %w", err)
+		}
+		recordWebhookResult(subscription, success, disableAfter)
+		return tx.Set(ref, subscription)
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fs.db.RunTransaction(): %w", err)
+	}
+	return subscription, nil
+}
+
+// EnqueueWebhookDeliveries creates the deliveries that don't exist yet in one transaction
+func (fs *FirestoreStore) EnqueueWebhookDeliveries(ctx context.Context, deliveries []*WebhookDelivery) error {
+	refs := make([]*firestore.DocumentRef, len(deliveries))
+	for i, delivery := range deliveries {
+		refs[i] = fs.db.Collection(deliveryCollectionName).Doc(delivery.ID)
+	}
+	err := fs.db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshots, err := tx.GetAll(refs)
+		if err != nil {
+			return err
+		}
+		for i, snapshot := range snapshots {
+			if snapshot.Exists() {
+				continue
+			}
+			if err := tx.Create(refs[i], deliveries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("fs.db.RunTransaction(): %w", err)
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries queries the due deliveries and pushes them back by lease in one transaction, the query needs a
+// composite index on (status, next_attempt_at)
+func (fs *FirestoreStore) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error) {
+	q := fs.db.Collection(deliveryCollectionName).
+		Where("status", "==", WebhookDeliveryPending).
+		Where("next_attempt_at", "<=", now).
+		OrderBy("next_attempt_at", firestore.Asc)
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	var claimed []*WebhookDelivery
+	err := fs.db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = nil
+		all, err := tx.Documents(q).GetAll()
+		if err != nil {
+			return err
+		}
+		for _, snapshot := range all {
+			delivery := &WebhookDelivery{}
+			if err := snapshot.DataTo(delivery); err != nil {
+				return fmt.Errorf("snapshot.DataTo(): %w", err)
+			}
+			delivery.NextAttemptAt = now.Add(lease)
+			if err := tx.Update(snapshot.Ref, []firestore.Update{{Path: "next_attempt_at", Value: delivery.NextAttemptAt}}); err != nil {
+				return err
+			}
+			claimed = append(claimed, delivery)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fs.db.RunTransaction(): %w", err)
+	}
+	return claimed, nil
+}
+
+// SaveWebhookDelivery overwrites the delivery document
+func (fs *FirestoreStore) SaveWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	if _, err := fs.db.Collection(deliveryCollectionName).Doc(delivery.ID).Set(ctx, delivery); err != nil {
+		return fmt.Errorf("doc.Set(): %w", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries needs a composite index on (subscription_id, created_at descending)
+func (fs *FirestoreStore) ListWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]*WebhookDelivery, error) {
+	q := fs.db.Collection(deliveryCollectionName).
+		Where("subscription_id", "==", subscriptionID).
+		OrderBy("created_at", firestore.Desc).
+		OrderBy(firestore.DocumentID, firestore.Desc)
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	all, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("q.Documents(): %w", err)
+	}
+	var deliveries []*WebhookDelivery
+	for _, snapshot := range all {
+		delivery := &WebhookDelivery{}
+		if err := snapshot.DataTo(delivery); err != nil {
+			return nil, fmt.Errorf("snapshot.DataTo(): %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// CreateOwner creates a new owner document with a generated id and no dogs
+func (fs *FirestoreStore) CreateOwner(ctx context.Context, owner *Owner) (string, error) {
+	doc := fs.db.Collection(ownerCollectionName).NewDoc()
+	owner.ID = doc.ID
+	owner.DogCount = 0
+	result, err := doc.Create(ctx, owner)
+	if err != nil {
+		return "", fmt.Errorf("doc.Create(): %w", err)
+	}
+	// the server timestamp is the time of the create
+	owner.CreatedTimestamp = result.UpdateTime
+	return doc.ID, nil
+}
+
+// GetOwner retrieves 1 owner document by its id
+func (fs *FirestoreStore) GetOwner(ctx context.Context, id string) (*Owner, error) {
+	snapshot, err := fs.db.Collection(ownerCollectionName).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrOwnerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fs.db.Collection(%q).Doc(%q).Get(): %w", ownerCollectionName, id, err)
+	}
+	owner := &Owner{}
+	if err := snapshot.DataTo(owner); err != nil {
+		return nil, fmt.Errorf("snapshot.DataTo(): %w", err)
+	}
+	return owner, nil
+}
+
+// ListOwners pages through the owners by document id
+func (fs *FirestoreStore) ListOwners(ctx context.Context, query OwnerQuery) ([]*Owner, error) {
+	q := fs.db.Collection(ownerCollectionName).OrderBy(firestore.DocumentID, firestore.Asc)
+	if query.StartAfter != "" {
+		q = q.StartAfter(query.StartAfter)
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+	all, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("q.Documents(): %w", err)
+	}
+	var owners []*Owner
+	for _, snapshot := range all {
+		owner := &Owner{}
+		if err := snapshot.DataTo(owner); err != nil {
+			return nil, fmt.Errorf("snapshot.DataTo(): %w", err)
+		}
+		owners = append(owners, owner)
+	}
+	return owners, nil
+}
+
+// UpdateOwner only writes the name and email, so the dog count is never overwritten with a stale one
+func (fs *FirestoreStore) UpdateOwner(ctx context.Context, owner *Owner) (*Owner, error) {
+	_, err := fs.db.Collection(ownerCollectionName).Doc(owner.ID).Update(ctx, []firestore.Update{
+		{Path: "name", Value: owner.Name},
+		{Path: "email", Value: owner.Email},
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrOwnerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fs.db.Collection(%q).Doc(%q).Update(): %w", ownerCollectionName, owner.ID, err)
+	}
+	return fs.GetOwner(ctx, owner.ID)
+}
+
+// DeleteOwner deletes the owner conditional on the update time we read it at, every dog given to or taken from the
+// owner changes its count and so its update time. A cascade updates the dogs in the same batch, each conditional on
+// its own update time
+func (fs *FirestoreStore) DeleteOwner(ctx context.Context, id string, cascade bool) error {
+	ownerRef := fs.db.Collection(ownerCollectionName).Doc(id)
+	snapshot, err := ownerRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return ErrOwnerNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("ownerRef.Get(): %w", err)
+	}
+	owner := &Owner{}
+	if err := snapshot.DataTo(owner); err != nil {
+		return fmt.Errorf("snapshot.DataTo(): %w", err)
+	}
+	if owner.DogCount > 0 && !cascade {
+		return ErrOwnerHasDogs
+	}
+
+	batch := fs.db.Batch()
+	if owner.DogCount > 0 {
+		dogs, err := fs.db.Collection(dogCollectionName).Where("owner_id", "==", id).Documents(ctx).GetAll()
+		if err != nil {
+			return fmt.Errorf("q.Documents(): %w", err)
+		}
+		if len(dogs) > maxCascadeDogs {
+			return fmt.Errorf("%d dogs are more than a delete can cascade to: %w", len(dogs), ErrOwnerHasDogs)
+		}
+		now := time.Now().UTC()
+		for _, dogSnapshot := range dogs {
+			dog, err := snapshotToDog(dogSnapshot)
+			if err != nil {
+				return err
+			}
+			updated, audit := cascadeDelete(ctx, dog, now)
+			batch.Update(dogSnapshot.Ref, []firestore.Update{
+				{Path: "deleted_at", Value: updated.DeletedAt},
+				{Path: "owner_id", Value: updated.OwnerID},
+				dogWritten,
+			}, firestore.LastUpdateTime(dogSnapshot.UpdateTime))
+			fs.recordChange(batch, audit, updated)
+		}
+	}
+	batch.Delete(ownerRef, firestore.LastUpdateTime(snapshot.UpdateTime))
+	_, err = batch.Commit(ctx)
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.NotFound, codes.FailedPrecondition:
+		return ErrOwnerConflict
+	}
+	return fmt.Errorf("batch.Commit(): %w", err)
+}
+
+// storedDog is how a dog document is written. UpdatedAt mirrors the update time of the document so watches can filter
+// on it, every write to a dog sets it with dogWritten
+type storedDog struct {
+	Dog
+	UpdatedAt time.Time `firestore:"updated_at,serverTimestamp"`
+}
+
+// dogWritten is added to every update of a dog document
+var dogWritten = firestore.Update{Path: "updated_at", Value: firestore.ServerTimestamp}
+
+// DogBackfillResult says what BackfillDogs did
+type DogBackfillResult struct {
+	Scanned    int
+	Backfilled int
+	// Conflicts are dogs that changed while they were being backfilled, running the backfill again picks them up
+	Conflicts int
+}
+
+// BackfillDogs gives every dog document written before soft delete existed an explicit null deleted_at, and every one
+// written before watches filtered on it an updated_at. Live dogs are found by filtering on deleted_at, and firestore
+// can't match a field that isn't there, so until then those dogs are missing from every list and search. It pages
+// through the collection by document id batchSize at a time. Only the missing fields are written, conditional on the
+// document not changing in between, and nothing is audited or announced since the dog itself doesn't change. updated_at
+// gets the update time from before the backfill so watches don't see the backfill as a change. Running it again only
+// touches what it missed
+func (fs *FirestoreStore) BackfillDogs(ctx context.Context, batchSize int) (*DogBackfillResult, error) {
+	result := &DogBackfillResult{}
+	q := fs.db.Collection(dogCollectionName).OrderBy(firestore.DocumentID, firestore.Asc).Limit(batchSize)
+	for {
+		snapshots, err := q.Documents(ctx).GetAll()
+		if err != nil {
+			return result, fmt.Errorf("q.Documents(): %w", err)
+		}
+		for _, snapshot := range snapshots {
+			result.Scanned++
+			var updates []firestore.Update
+			if _, err := snapshot.DataAt("deleted_at"); err != nil {
+				updates = append(updates, firestore.Update{Path: "deleted_at", Value: nil})
+			}
+			if _, err := snapshot.DataAt("updated_at"); err != nil {
+				updates = append(updates, firestore.Update{Path: "updated_at", Value: snapshot.UpdateTime})
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			_, err := snapshot.Ref.Update(ctx, updates, firestore.LastUpdateTime(snapshot.UpdateTime))
+			switch status.Code(err) {
+			case codes.OK:
+				result.Backfilled++
+			case codes.FailedPrecondition, codes.NotFound:
+				result.Conflicts++
+			default:
+				return result, fmt.Errorf("snapshot.Ref.Update(%q): %w", snapshot.Ref.ID, err)
+			}
+		}
+		if len(snapshots) < batchSize {
+			return result, nil
+		}
+		q = q.StartAfter(snapshots[len(snapshots)-1].Ref.ID)
+	}
+}
+
+func snapshotToDog(snapshot *firestore.DocumentSnapshot) (*Dog, error) {
+	dog := &Dog{}
+	err := snapshot.DataTo(dog)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot.DataTo(): %w", err)
+	}
+	dog.UpdateTime = snapshot.UpdateTime
+	dog.Status = statusOf(dog)
+	return dog, nil
+}
+
+// mapDogWriteErr translates the grpc status codes firestore uses for failed preconditions into our sentinel errors
+func mapDogWriteErr(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return ErrDogNotFound
+	case codes.FailedPrecondition:
+		return ErrDogConflict
+	}
+	return err
+}
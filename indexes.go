@@ -0,0 +1,138 @@
+package gotoproduction
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// queryShape is what decides which composite index a firestore query needs: the fields it filters on with equality or
+// in, and the field it sorts by. Range filters have to be on the sort field so they don't change the index
+type queryShape struct {
+	collection string
+	equality   []string
+	orderBy    string
+	descending bool
+}
+
+// firestoreIndex is one composite index in the format of firestore.indexes.json
+type firestoreIndex struct {
+	CollectionGroup string                `json:"collectionGroup"`
+	QueryScope      string                `json:"queryScope"`
+	Fields          []firestoreIndexField `json:"fields"`
+}
+
+type firestoreIndexField struct {
+	FieldPath string `json:"fieldPath"`
+	Order     string `json:"order"`
+}
+
+// index is the composite index serving the shape, false when the automatic single field indexes do
+func (s queryShape) index() (firestoreIndex, bool) {
+	if len(s.equality) == 0 || s.orderBy == "" {
+		return firestoreIndex{}, false
+	}
+	index := firestoreIndex{CollectionGroup: s.collection, QueryScope: "COLLECTION"}
+	// firestore doesn't care about the order of equality fields, sorting them keeps one index per shape
+	equality := append([]string(nil), s.equality...)
+	sort.Strings(equality)
+	for _, field := range equality {
+		index.Fields = append(index.Fields, firestoreIndexField{FieldPath: field, Order: "ASCENDING"})
+	}
+	order := "ASCENDING"
+	if s.descending {
+		order = "DESCENDING"
+	}
+	index.Fields = append(index.Fields, firestoreIndexField{FieldPath: s.orderBy, Order: order})
+	return index, true
+}
+
+// shape is the shape of the firestore query FirestoreStore.SearchDogs runs for q
+func (q *DogSearchQuery) shape() queryShape {
+	shape := queryShape{collection: dogCollectionName, equality: []string{"deleted_at"}, orderBy: string(q.OrderBy), descending: q.Descending}
+	if len(q.Types) > 0 {
+		shape.equality = append(shape.equality, "type")
+	}
+	return shape
+}
+
+// queryShapes lists the shape of every query the firestore store runs, firestore.indexes.json is generated from it
+func queryShapes() []queryShape {
+	var shapes []queryShape
+	// ListDogs, every combination of its filters
+	for _, includeDeleted := range []bool{false, true} {
+		for _, dogType := range []string{"", "beagle"} {
+			for _, ownerID := range []string{"", "owner"} {
+				for _, orderBy := range []DogOrder{DogOrderCreated, DogOrderName} {
+					shape := queryShape{collection: dogCollectionName, orderBy: string(orderBy)}
+					if !includeDeleted {
+						shape.equality = append(shape.equality, "deleted_at")
+					}
+					if dogType != "" {
+						shape.equality = append(shape.equality, "type")
+					}
+					if ownerID != "" {
+						shape.equality = append(shape.equality, "owner_id")
+					}
+					shapes = append(shapes, shape)
+				}
+			}
+		}
+	}
+	// SearchDogs, every sort it accepts with and without a type filter
+	for _, types := range [][]string{nil, {"beagle"}} {
+		for _, orderBy := range []DogOrder{DogOrderCreated, DogOrderName, DogOrderAge} {
+			for _, descending := range []bool{false, true} {
+				query := &DogSearchQuery{Types: types, OrderBy: orderBy, Descending: descending}
+				shapes = append(shapes, query.shape())
+			}
+		}
+	}
+	return append(shapes,
+		// WatchDogs of a type, the range filter on updated_at sorts by it
+		queryShape{collection: dogCollectionName, equality: []string{"type"}, orderBy: "updated_at"},
+		// ListAuditEntries
+		queryShape{collection: auditCollectionName, equality: []string{"dog_id"}, orderBy: "timestamp"},
+		// ClaimWebhookDeliveries
+		queryShape{collection: deliveryCollectionName, equality: []string{"status"}, orderBy: "next_attempt_at"},
+		// ListWebhookDeliveries
+		queryShape{collection: deliveryCollectionName, equality: []string{"subscription_id"}, orderBy: "created_at", descending: true},
+	)
+}
+
+// firestoreIndexes renders firestore.indexes.json, with every composite index the queries of the firestore store need
+// once and in a stable order
+func firestoreIndexes() ([]byte, error) {
+	file := struct {
+		Indexes        []firestoreIndex `json:"indexes"`
+		FieldOverrides []interface{}    `json:"fieldOverrides"`
+	}{Indexes: []firestoreIndex{}, FieldOverrides: []interface{}{}}
+	seen := map[string]bool{}
+	for _, shape := range queryShapes() {
+		index, ok := shape.index()
+		if !ok {
+			continue
+		}
+		if seen[indexKey(index)] {
+			continue
+		}
+		seen[indexKey(index)] = true
+		file.Indexes = append(file.Indexes, index)
+	}
+	sort.SliceStable(file.Indexes, func(i, j int) bool {
+		return indexKey(file.Indexes[i]) < indexKey(file.Indexes[j])
+	})
+	out, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func indexKey(index firestoreIndex) string {
+	parts := []string{index.CollectionGroup}
+	for _, field := range index.Fields {
+		parts = append(parts, field.FieldPath, field.Order)
+	}
+	return strings.Join(parts, " ")
+}
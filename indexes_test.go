@@ -0,0 +1,63 @@
+package gotoproduction
+
+import (
+	"flag"
+	"github.com/matryer/is"
+	"os"
+	"testing"
+	"time"
+)
+
+var updateIndexes = flag.Bool("update-indexes", false, "rewrite firestore.indexes.json from the query shapes")
+
+// firestore.indexes.json is generated, run go test -run Test_firestoreIndexes -update-indexes . after changing a query
+func Test_firestoreIndexes(t *testing.T) {
+	is := is.New(t)
+	generated, err := firestoreIndexes()
+	is.NoErr(err) // firestoreIndexes error
+	if *updateIndexes {
+		is.NoErr(os.WriteFile("firestore.indexes.json", generated, 0644)) // os.WriteFile error
+	}
+	shipped, err := os.ReadFile("firestore.indexes.json")
+	is.NoErr(err)                                // os.ReadFile error
+	is.Equal(string(shipped), string(generated)) // firestore.indexes.json is stale, regenerate it with -update-indexes
+}
+
+// every search SearchDogs lets through has to have its index shipped, whatever combination of filters it has
+func Test_searchShapesAreIndexed(t *testing.T) {
+	is := is.New(t)
+	indexed := map[string]bool{}
+	for _, shape := range queryShapes() {
+		if index, ok := shape.index(); ok {
+			indexed[indexKey(index)] = true
+		}
+	}
+	age, after := 3, time.Unix(1, 0)
+	var requests []*SearchDogsRequest
+	for _, prefix := range []string{"", "Os"} {
+		for _, minAge := range []*int{nil, &age} {
+			for _, createdAfter := range []time.Time{{}, after} {
+				for _, types := range [][]string{nil, {"Beagle"}, {"Beagle", "Pug"}} {
+					for _, orderBy := range []DogOrder{"", DogOrderCreated, DogOrderName, DogOrderAge} {
+						for _, descending := range []bool{false, true} {
+							requests = append(requests, &SearchDogsRequest{NamePrefix: prefix, MinAge: minAge, CreatedAfter: createdAfter, Types: types, OrderBy: orderBy, Descending: descending})
+						}
+					}
+				}
+			}
+		}
+	}
+	supported := 0
+	for _, request := range requests {
+		orderBy, err := request.orderBy()
+		if err != nil {
+			continue
+		}
+		supported++
+		query := &DogSearchQuery{Types: request.Types, OrderBy: orderBy, Descending: request.Descending}
+		index, ok := query.shape().index()
+		is.True(ok)                       // searches always filter on deleted_at
+		is.True(indexed[indexKey(index)]) // and firestore.indexes.json has their index
+	}
+	is.True(supported > 0) // some searches are supported
+}
@@ -0,0 +1,241 @@
+// Package authx verifies the credentials our apis accept and carries the authenticated principal through the context
+package authx
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/semconv"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Ways a principal can authenticate
+const (
+	MethodJWT    = "jwt"
+	MethodAPIKey = "api_key"
+)
+
+// clockSkew is how far off the token issuer's clock may be from ours
+const clockSkew = time.Minute
+
+// ErrUnauthenticated is returned for missing, malformed, expired or unknown credentials, the wrapped message says which
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Principal is who a request is made on behalf of
+type Principal struct {
+	// Subject is the jwt sub claim or the api key name
+	Subject string
+	// Method is MethodJWT or MethodAPIKey
+	Method string
+	// Roles come from the jwt roles claim or the api key definition
+	Roles []string
+	// Scopes come from the jwt scope claim and limit the token to those permissions whatever its roles grant, nil when
+	// the token has no scope claim or for api keys
+	Scopes []string
+}
+
+// Attributes describes the principal for spans
+func (p Principal) Attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.EnduserIDKey.String(p.Subject),
+		semconv.EnduserRoleKey.String(strings.Join(p.Roles, ",")),
+		attribute.String("enduser.auth_method", p.Method),
+	}
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal the request was authenticated as, ok is false for unauthenticated contexts
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Options says which credentials an Authenticator accepts, at least one of JWKSFile, JWTSecret or APIKeysFile is
+// required
+type Options struct {
+	// JWKSFile is a json web key set with the public keys bearer tokens may be signed with
+	JWKSFile string
+	// JWTSecret is a static HS256 key bearer tokens may be signed with
+	JWTSecret string
+	// Issuer and Audience are checked against the iss and aud claims when set
+	Issuer   string
+	Audience string
+	// APIKeysFile is a json list of APIKey
+	APIKeysFile string
+}
+
+// APIKey is one entry of the api keys file, only the sha256 of the key is stored so the file is not a secret itself
+type APIKey struct {
+	Name   string   `json:"name"`
+	SHA256 string   `json:"sha256"`
+	Roles  []string `json:"roles"`
+}
+
+type apiKey struct {
+	name  string
+	hash  []byte
+	roles []string
+}
+
+// Authenticator verifies bearer tokens and api keys
+type Authenticator struct {
+	keys     []jose.JSONWebKey
+	issuer   string
+	audience string
+	apiKeys  []apiKey
+}
+
+// New loads the keys opts points at
+func New(opts Options) (*Authenticator, error) {
+	a := &Authenticator{issuer: opts.Issuer, audience: opts.Audience}
+	if opts.JWKSFile != "" {
+		raw, err := os.ReadFile(opts.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadFile(%q): %w", opts.JWKSFile, err)
+		}
+		var jwks jose.JSONWebKeySet
+		if err := json.Unmarshal(raw, &jwks); err != nil {
+			return nil, fmt.Errorf("parsing jwks %q: %w", opts.JWKSFile, err)
+		}
+		for _, key := range jwks.Keys {
+			if !key.IsPublic() {
+				return nil, fmt.Errorf("jwks %q: key %q must be a public key", opts.JWKSFile, key.KeyID)
+			}
+		}
+		a.keys = append(a.keys, jwks.Keys...)
+	}
+	if opts.JWTSecret != "" {
+		a.keys = append(a.keys, jose.JSONWebKey{Key: []byte(opts.JWTSecret), Algorithm: string(jose.HS256)})
+	}
+	if opts.APIKeysFile != "" {
+		raw, err := os.ReadFile(opts.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadFile(%q): %w", opts.APIKeysFile, err)
+		}
+		var keys []APIKey
+		if err := json.Unmarshal(raw, &keys); err != nil {
+			return nil, fmt.Errorf("parsing api keys %q: %w", opts.APIKeysFile, err)
+		}
+		for _, key := range keys {
+			hash, err := hex.DecodeString(key.SHA256)
+			if err != nil || len(hash) != sha256.Size || key.Name == "" {
+				return nil, fmt.Errorf("api keys %q: %q needs a name and a hex sha256", opts.APIKeysFile, key.Name)
+			}
+			a.apiKeys = append(a.apiKeys, apiKey{name: key.Name, hash: hash, roles: key.Roles})
+		}
+	}
+	if len(a.keys) == 0 && len(a.apiKeys) == 0 {
+		return nil, errors.New("no jwt keys or api keys configured")
+	}
+	return a, nil
+}
+
+// HashAPIKey is how api keys are stored in the api keys file
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate checks whichever credential was sent, authorization is the full Authorization header value and a bearer
+// token wins over an api key when both are sent
+func (a *Authenticator) Authenticate(authorization, apiKey string) (Principal, error) {
+	if authorization != "" {
+		const prefix = "bearer "
+		if len(authorization) < len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+			return Principal{}, fmt.Errorf("%w: only bearer authorization is supported", ErrUnauthenticated)
+		}
+		return a.VerifyToken(strings.TrimSpace(authorization[len(prefix):]))
+	}
+	if apiKey != "" {
+		return a.VerifyAPIKey(apiKey)
+	}
+	return Principal{}, fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+}
+
+// claims are the jwt claims we read on top of the registered ones
+type claims struct {
+	Roles []string `json:"roles"`
+	// Scope is space separated like oauth2 scopes, a pointer so a missing claim and an empty one can be told apart
+	Scope *string `json:"scope"`
+}
+
+// VerifyToken checks a signed jwt against our keys, tokens must carry a subject and an expiry
+func (a *Authenticator) VerifyToken(raw string) (Principal, error) {
+	token, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	if len(token.Headers) != 1 {
+		return Principal{}, fmt.Errorf("%w: token must have exactly one signature", ErrUnauthenticated)
+	}
+	header := token.Headers[0]
+
+	var registered jwt.Claims
+	var custom claims
+	verified := false
+	for _, key := range a.keys {
+		if header.KeyID != "" && key.KeyID != "" && header.KeyID != key.KeyID {
+			continue
+		}
+		// pinning the algorithm to the key stops a token from picking a weaker one
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			continue
+		}
+		if err := token.Claims(key.Key, &registered, &custom); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return Principal{}, fmt.Errorf("%w: token signature does not match any key", ErrUnauthenticated)
+	}
+
+	if registered.Expiry == nil {
+		return Principal{}, fmt.Errorf("%w: token has no expiry", ErrUnauthenticated)
+	}
+	if registered.Subject == "" {
+		return Principal{}, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	}
+	expected := jwt.Expected{Issuer: a.issuer, Time: time.Now()}
+	if a.audience != "" {
+		expected.Audience = jwt.Audience{a.audience}
+	}
+	if err := registered.ValidateWithLeeway(expected, clockSkew); err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	principal := Principal{Subject: registered.Subject, Method: MethodJWT, Roles: custom.Roles}
+	if custom.Scope != nil {
+		principal.Scopes = append([]string{}, strings.Fields(*custom.Scope)...)
+	}
+	return principal, nil
+}
+
+// VerifyAPIKey looks the key up by its hash, every configured key is compared so the timing doesn't give away a match
+func (a *Authenticator) VerifyAPIKey(key string) (Principal, error) {
+	sum := sha256.Sum256([]byte(key))
+	var found *apiKey
+	for i := range a.apiKeys {
+		if subtle.ConstantTimeCompare(sum[:], a.apiKeys[i].hash) == 1 {
+			found = &a.apiKeys[i]
+		}
+	}
+	if found == nil {
+		return Principal{}, fmt.Errorf("%w: unknown api key", ErrUnauthenticated)
+	}
+	return Principal{Subject: found.name, Method: MethodAPIKey, Roles: found.roles}, nil
+}
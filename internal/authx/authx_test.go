@@ -0,0 +1,177 @@
+package authx
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"github.com/matryer/is"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// testClaims are the claims a well behaved issuer would put on a token
+type testClaims struct {
+	jwt.Claims
+	Roles []string `json:"roles,omitempty"`
+}
+
+func signToken(t *testing.T, key jose.SigningKey, claims testClaims) string {
+	t.Helper()
+	signer, err := jose.NewSigner(key, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatalf("jose.NewSigner() err = %v; want nil", err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("CompactSerialize() err = %v; want nil", err)
+	}
+	return token
+}
+
+func writeJSON(t *testing.T, name string, v interface{}) string {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal() err = %v; want nil", err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatalf("os.WriteFile() err = %v; want nil", err)
+	}
+	return path
+}
+
+func validClaims() testClaims {
+	return testClaims{
+		Claims: jwt.Claims{
+			Subject:  "user-1",
+			Issuer:   "https://issuer.test",
+			Audience: jwt.Audience{"gotoproduction"},
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Roles: []string{"editor"},
+	}
+}
+
+func TestAuthenticator_VerifyToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() err = %v; want nil", err)
+	}
+	jwksFile := writeJSON(t, "jwks.json", jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: rsaKey.Public(), KeyID: "key-1", Algorithm: string(jose.RS256), Use: "sig"},
+	}})
+	a, err := New(Options{JWKSFile: jwksFile, JWTSecret: testSecret, Issuer: "https://issuer.test", Audience: "gotoproduction"})
+	if err != nil {
+		t.Fatalf("New() err = %v; want nil", err)
+	}
+	rsaSigner := jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: rsaKey, KeyID: "key-1"}}
+	hmacSigner := jose.SigningKey{Algorithm: jose.HS256, Key: []byte(testSecret)}
+
+	expired := validClaims()
+	expired.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	noExpiry := validClaims()
+	noExpiry.Expiry = nil
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.Audience{"somebody-else"}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() err = %v; want nil", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{name: "jwks key", token: signToken(t, rsaSigner, validClaims()), ok: true},
+		{name: "static secret", token: signToken(t, hmacSigner, validClaims()), ok: true},
+		{name: "unknown key", token: signToken(t, jose.SigningKey{Algorithm: jose.RS256, Key: otherKey}, validClaims())},
+		{name: "wrong secret", token: signToken(t, jose.SigningKey{Algorithm: jose.HS256, Key: []byte("not-the-secret-not-the-secret!!!")}, validClaims())},
+		{name: "expired", token: signToken(t, rsaSigner, expired)},
+		{name: "no expiry", token: signToken(t, rsaSigner, noExpiry)},
+		{name: "wrong audience", token: signToken(t, rsaSigner, wrongAudience)},
+		{name: "malformed", token: "not.a.token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			principal, err := a.VerifyToken(tt.token)
+			if !tt.ok {
+				is.True(errors.Is(err, ErrUnauthenticated)) // token must be rejected
+				return
+			}
+			is.NoErr(err)                                 // VerifyToken error
+			is.Equal(principal.Subject, "user-1")         // subject from the sub claim
+			is.Equal(principal.Method, MethodJWT)         // authenticated by jwt
+			is.Equal(principal.Roles, []string{"editor"}) // roles from the roles claim
+		})
+	}
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	a, err := New(Options{
+		JWTSecret:   testSecret,
+		APIKeysFile: writeJSON(t, "keys.json", []APIKey{{Name: "ci", SHA256: HashAPIKey("s3cret"), Roles: []string{"admin"}}}),
+	})
+	if err != nil {
+		t.Fatalf("New() err = %v; want nil", err)
+	}
+	token := signToken(t, jose.SigningKey{Algorithm: jose.HS256, Key: []byte(testSecret)}, validClaims())
+
+	t.Run("api key", func(t *testing.T) {
+		is := is.New(t)
+		principal, err := a.Authenticate("", "s3cret")
+		is.NoErr(err) // Authenticate error
+		is.Equal(principal, Principal{Subject: "ci", Method: MethodAPIKey, Roles: []string{"admin"}})
+	})
+
+	t.Run("bearer wins over api key", func(t *testing.T) {
+		is := is.New(t)
+		principal, err := a.Authenticate("Bearer "+token, "s3cret")
+		is.NoErr(err)                         // Authenticate error
+		is.Equal(principal.Method, MethodJWT) // the token was used
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		for name, creds := range map[string][2]string{
+			"nothing":         {"", ""},
+			"unknown api key": {"", "guess"},
+			"basic auth":      {"Basic dXNlcjpwYXNz", ""},
+		} {
+			t.Run(name, func(t *testing.T) {
+				is := is.New(t)
+				_, err := a.Authenticate(creds[0], creds[1])
+				is.True(errors.Is(err, ErrUnauthenticated)) // credentials must be rejected
+			})
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	is := is.New(t)
+	_, err := New(Options{})
+	is.True(err != nil) // refuses to build an authenticator nobody can pass
+
+	_, err = New(Options{APIKeysFile: writeJSON(t, "keys.json", []APIKey{{Name: "ci", SHA256: "s3cret"}})})
+	is.True(err != nil) // plain text keys are rejected
+}
+
+func TestFromContext(t *testing.T) {
+	is := is.New(t)
+	_, ok := FromContext(context.Background())
+	is.True(!ok) // no principal on a fresh context
+
+	ctx := NewContext(context.Background(), Principal{Subject: "user-1"})
+	principal, ok := FromContext(ctx)
+	is.True(ok)                           // principal found
+	is.Equal(principal.Subject, "user-1") // same principal back
+}
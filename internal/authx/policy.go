@@ -0,0 +1,117 @@
+package authx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AnyPermission granted to a role grants it every permission
+const AnyPermission = "*"
+
+// ErrPermissionDenied is returned when the principal is known but not allowed to do what it asked, the wrapped message
+// says why
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Policy maps roles onto the permissions they grant, a principal is allowed anything one of its roles grants
+type Policy struct {
+	roles map[string]map[string]bool
+}
+
+// policyFile is the yaml or json layout LoadPolicy reads
+type policyFile struct {
+	Roles map[string][]string `json:"roles" yaml:"roles"`
+}
+
+// NewPolicy builds a policy from role names to the permissions they grant
+func NewPolicy(roles map[string][]string) *Policy {
+	p := &Policy{roles: make(map[string]map[string]bool, len(roles))}
+	for role, permissions := range roles {
+		granted := make(map[string]bool, len(permissions))
+		for _, permission := range permissions {
+			granted[permission] = true
+		}
+		p.roles[role] = granted
+	}
+	return p
+}
+
+// LoadPolicy reads a policy from a .json, .yaml or .yml file, the file replaces the built in roles rather than adding
+// to them
+func LoadPolicy(path string) (*Policy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile(%q): %w", path, err)
+	}
+	var file policyFile
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(raw, &file)
+	default:
+		return nil, fmt.Errorf("policy file %q must be .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing policy %q: %w", path, err)
+	}
+	if len(file.Roles) == 0 {
+		return nil, fmt.Errorf("policy %q does not define any roles", path)
+	}
+	return NewPolicy(file.Roles), nil
+}
+
+// Decision is the outcome of checking one permission, Reason explains a denial for the audit log
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// Decide checks whether any of the principal's roles grants permission
+func (p *Policy) Decide(principal Principal, permission string) Decision {
+	for _, role := range principal.Roles {
+		granted := p.roles[role]
+		if granted[permission] || granted[AnyPermission] {
+			return Decision{Allowed: true}
+		}
+	}
+	if len(principal.Roles) == 0 {
+		return Decision{Reason: "principal has no roles"}
+	}
+	return Decision{Reason: fmt.Sprintf("none of the roles %s grant %s", strings.Join(principal.Roles, ","), permission)}
+}
+
+// Authorize is Decide for the principal on ctx, it fails with ErrUnauthenticated when there is none and with
+// ErrPermissionDenied carrying the reason when the policy says no
+func (p *Policy) Authorize(ctx context.Context, permission string) error {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("%w: no principal on the request", ErrUnauthenticated)
+	}
+	if decision := p.Decide(principal, permission); !decision.Allowed {
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, decision.Reason)
+	}
+	return nil
+}
+
+// HasScope reports whether the principal's token allows permission, principals without a scope claim are only limited
+// by their roles
+func (p Principal) HasScope(permission string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	for _, scope := range p.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package authx
+
+import (
+	"context"
+	"errors"
+	"github.com/matryer/is"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicy_Decide(t *testing.T) {
+	policy := NewPolicy(map[string][]string{
+		"viewer": {"dogs.read"},
+		"admin":  {AnyPermission},
+	})
+	tests := []struct {
+		name       string
+		roles      []string
+		permission string
+		allowed    bool
+	}{
+		{name: "granted", roles: []string{"viewer"}, permission: "dogs.read", allowed: true},
+		{name: "not granted", roles: []string{"viewer"}, permission: "dogs.delete"},
+		{name: "wildcard", roles: []string{"admin"}, permission: "dogs.delete", allowed: true},
+		{name: "any role", roles: []string{"unknown", "viewer"}, permission: "dogs.read", allowed: true},
+		{name: "unknown role", roles: []string{"unknown"}, permission: "dogs.read"},
+		{name: "no roles", permission: "dogs.read"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			decision := policy.Decide(Principal{Subject: "user-1", Roles: tt.roles}, tt.permission)
+			is.Equal(decision.Allowed, tt.allowed)            // decision
+			is.Equal(decision.Reason == "", decision.Allowed) // denials explain themselves
+		})
+	}
+}
+
+func TestPolicy_Authorize(t *testing.T) {
+	is := is.New(t)
+	policy := NewPolicy(map[string][]string{"viewer": {"dogs.read"}})
+
+	err := policy.Authorize(context.Background(), "dogs.read")
+	is.True(errors.Is(err, ErrUnauthenticated)) // no principal at all
+
+	ctx := NewContext(context.Background(), Principal{Subject: "user-1", Roles: []string{"viewer"}})
+	is.NoErr(policy.Authorize(ctx, "dogs.read"))                                  // viewers can read
+	is.True(errors.Is(policy.Authorize(ctx, "dogs.create"), ErrPermissionDenied)) // but not create
+}
+
+func TestLoadPolicy(t *testing.T) {
+	write := func(t *testing.T, name, content string) string {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("os.WriteFile() err = %v; want nil", err)
+		}
+		return path
+	}
+
+	t.Run("yaml", func(t *testing.T) {
+		is := is.New(t)
+		policy, err := LoadPolicy(write(t, "policy.yaml", "roles:\n  groomer: [dogs.read, dogs.update]\n"))
+		is.NoErr(err)                                                                        // LoadPolicy error
+		is.True(policy.Decide(Principal{Roles: []string{"groomer"}}, "dogs.update").Allowed) // role from the file
+	})
+
+	t.Run("json", func(t *testing.T) {
+		is := is.New(t)
+		policy, err := LoadPolicy(write(t, "policy.json", `{"roles":{"groomer":["dogs.read"]}}`))
+		is.NoErr(err)                                                                      // LoadPolicy error
+		is.True(policy.Decide(Principal{Roles: []string{"groomer"}}, "dogs.read").Allowed) // role from the file
+	})
+
+	for name, content := range map[string]string{
+		"unknown field": "roles:\n  viewer: [dogs.read]\nusers: {}\n",
+		"no roles":      "roles: {}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			_, err := LoadPolicy(write(t, "policy.yaml", content))
+			is.True(err != nil) // policy rejected
+		})
+	}
+}
+
+func TestPrincipal_HasScope(t *testing.T) {
+	is := is.New(t)
+	is.True(Principal{}.HasScope("dogs.delete"))                               // no scope claim, only roles apply
+	is.True(Principal{Scopes: []string{"dogs.read"}}.HasScope("dogs.read"))    // scope granted
+	is.True(!Principal{Scopes: []string{"dogs.read"}}.HasScope("dogs.delete")) // scope missing
+	is.True(!Principal{Scopes: []string{}}.HasScope("dogs.read"))              // empty scope claim allows nothing
+}
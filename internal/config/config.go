@@ -0,0 +1,834 @@
+// Package config loads the settings our binaries run with from defaults, an optional yaml or json file, environment
+// variables and flags, in that order of precedence
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources a value can come from, reported next to each value by Print
+const (
+	SourceDefault  = "default"
+	SourceFile     = "file"
+	SourceEnv      = "env"
+	SourceFlag     = "flag"
+	SourcePlatform = "platform"
+)
+
+// Log formats, auto picks console locally and json (cloud logging) on gce
+const (
+	LogFormatAuto    = "auto"
+	LogFormatConsole = "console"
+	LogFormatJSON    = "json"
+)
+
+// Trace exporters, auto picks cloud trace on gce and none locally so local runs don't fail or spam cloud trace
+const (
+	TraceExporterAuto       = "auto"
+	TraceExporterCloudTrace = "cloudtrace"
+	TraceExporterOTLPGRPC   = "otlp-grpc"
+	TraceExporterOTLPHTTP   = "otlp-http"
+	TraceExporterStdout     = "stdout"
+	TraceExporterMemory     = "memory"
+	TraceExporterNone       = "none"
+)
+
+// Event publishers the outbox relay can deliver to, none leaves events in the outbox
+const (
+	EventPublisherLog     = "log"
+	EventPublisherPubSub  = "pubsub"
+	EventPublisherWebhook = "webhook"
+	EventPublisherNone    = "none"
+)
+
+// DefaultLocalProjectID is the project we talk to when we are not running on gce and nothing else was configured
+const DefaultLocalProjectID = "a-mammay-website"
+
+const (
+	configFileEnv  = "CONFIG_FILE"
+	configFileFlag = "config"
+	printFlag      = "print-config"
+	redacted       = "[REDACTED]"
+)
+
+// Config is everything a binary needs to start, zero values for ProjectID, Host and InstanceID are filled in by
+// ResolvePlatform
+type Config struct {
+	ProjectID       string
+	ServiceName     string
+	ServiceVersion  string
+	InstanceID      string
+	Host            string
+	Port            string
+	GRPCEnabled     bool
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	ShutdownGrace   time.Duration
+	DrainDelay      time.Duration
+	PageTokenSecret string
+	LogFormat       string
+	LogLevel        string
+
+	TraceExporter    string
+	TraceEndpoint    string
+	TraceSampleRatio float64
+
+	// AuthDisabled serves the dog api without authentication, only meant for local development
+	AuthDisabled    bool
+	AuthJWKSFile    string
+	AuthJWTSecret   string
+	AuthJWTIssuer   string
+	AuthJWTAudience string
+	AuthAPIKeysFile string
+	AuthPolicyFile  string
+
+	// RateLimitRPS is how many requests per second each client may make on average, 0 turns rate limiting off
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// TrustedProxies are the proxies whose X-Forwarded-For we believe when working out the client ip
+	TrustedProxies []*net.IPNet
+	// MaxInFlight is how many dog api requests we serve at once before shedding load, 0 turns shedding off
+	MaxInFlight int
+
+	// IdempotencyTTL is how long the response to a request with an Idempotency-Key is kept for replays, 0 turns it off
+	IdempotencyTTL time.Duration
+
+	// PurgeRetention is how long soft deleted dogs can be restored before the purge job deletes them for good
+	PurgeRetention time.Duration
+	// PurgeBatchSize is how many dogs the purge job deletes per batch
+	PurgeBatchSize int
+	// PurgeInterval runs the purge job inside the server this often, 0 leaves it to cmd/purge
+	PurgeInterval time.Duration
+
+	// EventPublisher is where the relay sends dog events from the outbox
+	EventPublisher  string
+	PubSubTopic     string
+	EventWebhookURL string
+	// RelayInterval is how often the relay looks for due events, 0 turns the in process relay off
+	RelayInterval  time.Duration
+	RelayBatchSize int
+
+	// WebhookInterval is how often queued webhook deliveries are sent, 0 turns webhooks off altogether
+	WebhookInterval time.Duration
+	// WebhookMaxAttempts is how often a delivery is tried before it is given up on
+	WebhookMaxAttempts int
+	// WebhookDisableAfter is how many failed attempts in a row disable a subscription
+	WebhookDisableAfter int
+	WebhookTimeout      time.Duration
+	// WebhookAllowPrivateURLs lets webhooks be sent to loopback and private addresses, only for local development
+	WebhookAllowPrivateURLs bool
+
+	// WatchHeartbeat is how often an idle /dogs/watch stream gets a comment to keep proxies from closing it
+	WatchHeartbeat time.Duration
+	// WatchMaxDuration ends a /dogs/watch stream before write_timeout does, clients reconnect and resume from there
+	WatchMaxDuration time.Duration
+
+	// PrintConfig is set by --print-config, the binary should Print and exit instead of serving
+	PrintConfig bool
+
+	sources map[string]string
+}
+
+// Default is the config a binary starts from before anything is loaded on top of it
+func Default() *Config {
+	return &Config{
+		Port:          "8080",
+		ReadTimeout:   30 * time.Second,
+		WriteTimeout:  30 * time.Second,
+		ShutdownGrace: 9 * time.Second,
+		DrainDelay:    3 * time.Second,
+		LogFormat:     LogFormatAuto,
+		LogLevel:      "debug",
+
+		ServiceName:      "gotoproduction",
+		ServiceVersion:   "dev",
+		TraceExporter:    TraceExporterAuto,
+		TraceSampleRatio: 1,
+
+		RateLimitRPS:   10,
+		RateLimitBurst: 20,
+		MaxInFlight:    100,
+
+		IdempotencyTTL: 24 * time.Hour,
+
+		PurgeRetention: 30 * 24 * time.Hour,
+		PurgeBatchSize: 100,
+
+		EventPublisher: EventPublisherLog,
+		PubSubTopic:    "dog-events",
+		RelayInterval:  time.Second,
+		RelayBatchSize: 100,
+
+		WebhookMaxAttempts:  10,
+		WebhookDisableAfter: 20,
+		WebhookTimeout:      10 * time.Second,
+
+		WatchHeartbeat:   15 * time.Second,
+		WatchMaxDuration: 25 * time.Second,
+	}
+}
+
+// field ties a config key to its env variable and flag, every source funnels through set so they all parse the same way
+type field struct {
+	key    string
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	isBool bool
+	get    func(c *Config) string
+	set    func(c *Config, value string) error
+}
+
+var fields = []field{
+	{
+		key: "project_id", env: "PROJECT_ID", flag: "project-id",
+		usage: "google cloud project, defaults to the metadata server on gce",
+		get:   func(c *Config) string { return c.ProjectID },
+		set:   func(c *Config, v string) error { c.ProjectID = v; return nil },
+	},
+	{
+		key: "service_name", env: "SERVICE_NAME", flag: "service-name",
+		usage: "service.name on our traces",
+		get:   func(c *Config) string { return c.ServiceName },
+		set:   func(c *Config, v string) error { c.ServiceName = v; return nil },
+	},
+	{
+		key: "service_version", env: "SERVICE_VERSION", flag: "service-version",
+		usage: "service.version on our traces, set it to the git sha when deploying",
+		get:   func(c *Config) string { return c.ServiceVersion },
+		set:   func(c *Config, v string) error { c.ServiceVersion = v; return nil },
+	},
+	{
+		key: "instance_id", env: "INSTANCE_ID", flag: "instance-id",
+		usage: "service.instance.id on our traces, defaults to the gce instance id or the hostname",
+		get:   func(c *Config) string { return c.InstanceID },
+		set:   func(c *Config, v string) error { c.InstanceID = v; return nil },
+	},
+	{
+		key: "host", env: "LISTEN_HOST", flag: "host",
+		usage: "interface to listen on, defaults to every interface on gce and loopback locally",
+		get:   func(c *Config) string { return c.Host },
+		set:   func(c *Config, v string) error { c.Host = v; return nil },
+	},
+	{
+		key: "port", env: "PORT", flag: "port",
+		usage: "port to listen on",
+		get:   func(c *Config) string { return c.Port },
+		set:   func(c *Config, v string) error { c.Port = v; return nil },
+	},
+	{
+		key: "grpc_enabled", env: "GRPC_ENABLED", flag: "grpc-enabled", isBool: true,
+		usage: "serve the grpc api next to the rest api",
+		get:   func(c *Config) string { return strconv.FormatBool(c.GRPCEnabled) },
+		set:   func(c *Config, v string) error { return parseBool(v, &c.GRPCEnabled) },
+	},
+	{
+		key: "read_timeout", env: "READ_TIMEOUT", flag: "read-timeout",
+		usage: "http server read timeout",
+		get:   func(c *Config) string { return c.ReadTimeout.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.ReadTimeout) },
+	},
+	{
+		key: "write_timeout", env: "WRITE_TIMEOUT", flag: "write-timeout",
+		usage: "http server write timeout",
+		get:   func(c *Config) string { return c.WriteTimeout.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.WriteTimeout) },
+	},
+	{
+		key: "shutdown_grace", env: "SHUTDOWN_GRACE", flag: "shutdown-grace",
+		usage: "how long in flight requests get to finish after a shutdown signal",
+		get:   func(c *Config) string { return c.ShutdownGrace.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.ShutdownGrace) },
+	},
+	{
+		key: "drain_delay", env: "DRAIN_DELAY", flag: "drain-delay",
+		usage: "how long readiness fails before we stop accepting connections, counts towards the shutdown grace",
+		get:   func(c *Config) string { return c.DrainDelay.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.DrainDelay) },
+	},
+	{
+		key: "page_token_secret", env: "PAGE_TOKEN_SECRET", flag: "page-token-secret", secret: true,
+		usage: "key page tokens are signed with, page tokens only work on the instance that issued them when empty",
+		get:   func(c *Config) string { return c.PageTokenSecret },
+		set:   func(c *Config, v string) error { c.PageTokenSecret = v; return nil },
+	},
+	{
+		key: "log_format", env: "LOG_FORMAT", flag: "log-format",
+		usage: "auto, console or json",
+		get:   func(c *Config) string { return c.LogFormat },
+		set:   func(c *Config, v string) error { c.LogFormat = v; return nil },
+	},
+	{
+		key: "log_level", env: "LOG_LEVEL", flag: "log-level",
+		usage: "minimum level to log, debug, info, warn or error",
+		get:   func(c *Config) string { return c.LogLevel },
+		set:   func(c *Config, v string) error { c.LogLevel = v; return nil },
+	},
+	{
+		key: "trace_exporter", env: "TRACE_EXPORTER", flag: "trace-exporter",
+		usage: "auto, cloudtrace, otlp-grpc, otlp-http, stdout, memory or none",
+		get:   func(c *Config) string { return c.TraceExporter },
+		set:   func(c *Config, v string) error { c.TraceExporter = v; return nil },
+	},
+	{
+		key: "trace_endpoint", env: "TRACE_ENDPOINT", flag: "trace-endpoint",
+		usage: "otlp collector host:port, defaults to localhost:4317 for grpc and localhost:4318 for http",
+		get:   func(c *Config) string { return c.TraceEndpoint },
+		set:   func(c *Config, v string) error { c.TraceEndpoint = v; return nil },
+	},
+	{
+		key: "trace_sample_ratio", env: "TRACE_SAMPLE_RATIO", flag: "trace-sample-ratio",
+		usage: "fraction of new traces to record between 0 and 1, requests with a sampled parent are always recorded",
+		get:   func(c *Config) string { return strconv.FormatFloat(c.TraceSampleRatio, 'g', -1, 64) },
+		set:   func(c *Config, v string) error { return parseFloat(v, &c.TraceSampleRatio) },
+	},
+	{
+		key: "auth_disabled", env: "AUTH_DISABLED", flag: "auth-disabled", isBool: true,
+		usage: "serve the dog api without authentication, only for local development",
+		get:   func(c *Config) string { return strconv.FormatBool(c.AuthDisabled) },
+		set:   func(c *Config, v string) error { return parseBool(v, &c.AuthDisabled) },
+	},
+	{
+		key: "auth_jwks_file", env: "AUTH_JWKS_FILE", flag: "auth-jwks-file",
+		usage: "json web key set with the public keys bearer tokens are signed with",
+		get:   func(c *Config) string { return c.AuthJWKSFile },
+		set:   func(c *Config, v string) error { c.AuthJWKSFile = v; return nil },
+	},
+	{
+		key: "auth_jwt_secret", env: "AUTH_JWT_SECRET", flag: "auth-jwt-secret", secret: true,
+		usage: "static HS256 key bearer tokens are signed with",
+		get:   func(c *Config) string { return c.AuthJWTSecret },
+		set:   func(c *Config, v string) error { c.AuthJWTSecret = v; return nil },
+	},
+	{
+		key: "auth_jwt_issuer", env: "AUTH_JWT_ISSUER", flag: "auth-jwt-issuer",
+		usage: "required iss claim of bearer tokens, empty accepts any issuer",
+		get:   func(c *Config) string { return c.AuthJWTIssuer },
+		set:   func(c *Config, v string) error { c.AuthJWTIssuer = v; return nil },
+	},
+	{
+		key: "auth_jwt_audience", env: "AUTH_JWT_AUDIENCE", flag: "auth-jwt-audience",
+		usage: "required aud claim of bearer tokens, empty accepts any audience",
+		get:   func(c *Config) string { return c.AuthJWTAudience },
+		set:   func(c *Config, v string) error { c.AuthJWTAudience = v; return nil },
+	},
+	{
+		key: "auth_api_keys_file", env: "AUTH_API_KEYS_FILE", flag: "auth-api-keys-file",
+		usage: "json list of api keys as name, sha256 and roles",
+		get:   func(c *Config) string { return c.AuthAPIKeysFile },
+		set:   func(c *Config, v string) error { c.AuthAPIKeysFile = v; return nil },
+	},
+	{
+		key: "auth_policy_file", env: "AUTH_POLICY_FILE", flag: "auth-policy-file",
+		usage: "yaml or json file mapping roles onto permissions, replaces the built in viewer, editor and admin roles",
+		get:   func(c *Config) string { return c.AuthPolicyFile },
+		set:   func(c *Config, v string) error { c.AuthPolicyFile = v; return nil },
+	},
+	{
+		key: "rate_limit_rps", env: "RATE_LIMIT_RPS", flag: "rate-limit-rps",
+		usage: "requests per second each client may make on average, 0 turns rate limiting off",
+		get:   func(c *Config) string { return strconv.FormatFloat(c.RateLimitRPS, 'g', -1, 64) },
+		set:   func(c *Config, v string) error { return parseFloat(v, &c.RateLimitRPS) },
+	},
+	{
+		key: "rate_limit_burst", env: "RATE_LIMIT_BURST", flag: "rate-limit-burst",
+		usage: "requests a client may make in a burst on top of the average rate",
+		get:   func(c *Config) string { return strconv.Itoa(c.RateLimitBurst) },
+		set:   func(c *Config, v string) error { return parseInt(v, &c.RateLimitBurst) },
+	},
+	{
+		key: "trusted_proxies", env: "TRUSTED_PROXIES", flag: "trusted-proxies",
+		usage: "comma separated ips or cidrs of proxies whose X-Forwarded-For is trusted",
+		get: func(c *Config) string {
+			cidrs := make([]string, len(c.TrustedProxies))
+			for i, n := range c.TrustedProxies {
+				cidrs[i] = n.String()
+			}
+			return strings.Join(cidrs, ",")
+		},
+		set: func(c *Config, v string) error { return parseCIDRs(v, &c.TrustedProxies) },
+	},
+	{
+		key: "max_in_flight", env: "MAX_IN_FLIGHT", flag: "max-in-flight",
+		usage: "dog api requests served at once before shedding load with 503, 0 turns shedding off",
+		get:   func(c *Config) string { return strconv.Itoa(c.MaxInFlight) },
+		set:   func(c *Config, v string) error { return parseInt(v, &c.MaxInFlight) },
+	},
+	{
+		key: "idempotency_ttl", env: "IDEMPOTENCY_TTL", flag: "idempotency-ttl",
+		usage: "how long responses to requests with an Idempotency-Key are kept for replays, 0 turns it off",
+		get:   func(c *Config) string { return c.IdempotencyTTL.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.IdempotencyTTL) },
+	},
+	{
+		key: "purge_retention", env: "PURGE_RETENTION", flag: "purge-retention",
+		usage: "how long soft deleted dogs can be restored before they are purged",
+		get:   func(c *Config) string { return c.PurgeRetention.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.PurgeRetention) },
+	},
+	{
+		key: "purge_batch_size", env: "PURGE_BATCH_SIZE", flag: "purge-batch-size",
+		usage: "dogs deleted per purge batch, at most 166",
+		get:   func(c *Config) string { return strconv.Itoa(c.PurgeBatchSize) },
+		set:   func(c *Config, v string) error { return parseInt(v, &c.PurgeBatchSize) },
+	},
+	{
+		key: "purge_interval", env: "PURGE_INTERVAL", flag: "purge-interval",
+		usage: "run the purge job in process this often, 0 leaves it to cmd/purge",
+		get:   func(c *Config) string { return c.PurgeInterval.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.PurgeInterval) },
+	},
+	{
+		key: "event_publisher", env: "EVENT_PUBLISHER", flag: "event-publisher",
+		usage: "where dog events go: log, pubsub, webhook or none",
+		get:   func(c *Config) string { return c.EventPublisher },
+		set:   func(c *Config, v string) error { c.EventPublisher = v; return nil },
+	},
+	{
+		key: "pubsub_topic", env: "PUBSUB_TOPIC", flag: "pubsub-topic",
+		usage: "pubsub topic dog events are published to",
+		get:   func(c *Config) string { return c.PubSubTopic },
+		set:   func(c *Config, v string) error { c.PubSubTopic = v; return nil },
+	},
+	{
+		key: "event_webhook_url", env: "EVENT_WEBHOOK_URL", flag: "event-webhook-url",
+		usage: "url dog events are POSTed to by the webhook publisher",
+		get:   func(c *Config) string { return c.EventWebhookURL },
+		set:   func(c *Config, v string) error { c.EventWebhookURL = v; return nil },
+	},
+	{
+		key: "relay_interval", env: "RELAY_INTERVAL", flag: "relay-interval",
+		usage: "how often the outbox relay looks for events to publish, 0 turns it off",
+		get:   func(c *Config) string { return c.RelayInterval.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.RelayInterval) },
+	},
+	{
+		key: "relay_batch_size", env: "RELAY_BATCH_SIZE", flag: "relay-batch-size",
+		usage: "events the outbox relay claims at a time, at most 500",
+		get:   func(c *Config) string { return strconv.Itoa(c.RelayBatchSize) },
+		set:   func(c *Config, v string) error { return parseInt(v, &c.RelayBatchSize) },
+	},
+	{
+		key: "webhook_interval", env: "WEBHOOK_INTERVAL", flag: "webhook-interval",
+		usage: "how often queued webhook deliveries are sent, 0 turns webhooks off",
+		get:   func(c *Config) string { return c.WebhookInterval.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.WebhookInterval) },
+	},
+	{
+		key: "webhook_max_attempts", env: "WEBHOOK_MAX_ATTEMPTS", flag: "webhook-max-attempts",
+		usage: "attempts per webhook delivery before it is given up on",
+		get:   func(c *Config) string { return strconv.Itoa(c.WebhookMaxAttempts) },
+		set:   func(c *Config, v string) error { return parseInt(v, &c.WebhookMaxAttempts) },
+	},
+	{
+		key: "webhook_disable_after", env: "WEBHOOK_DISABLE_AFTER", flag: "webhook-disable-after",
+		usage: "failed attempts in a row that disable a webhook subscription",
+		get:   func(c *Config) string { return strconv.Itoa(c.WebhookDisableAfter) },
+		set:   func(c *Config, v string) error { return parseInt(v, &c.WebhookDisableAfter) },
+	},
+	{
+		key: "webhook_timeout", env: "WEBHOOK_TIMEOUT", flag: "webhook-timeout",
+		usage: "how long a single webhook POST may take",
+		get:   func(c *Config) string { return c.WebhookTimeout.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.WebhookTimeout) },
+	},
+	{
+		key: "webhook_allow_private_urls", env: "WEBHOOK_ALLOW_PRIVATE_URLS", flag: "webhook-allow-private-urls", isBool: true,
+		usage: "let webhooks be sent to loopback and private addresses, only for local development",
+		get:   func(c *Config) string { return strconv.FormatBool(c.WebhookAllowPrivateURLs) },
+		set:   func(c *Config, v string) error { return parseBool(v, &c.WebhookAllowPrivateURLs) },
+	},
+	{
+		key: "watch_heartbeat", env: "WATCH_HEARTBEAT", flag: "watch-heartbeat",
+		usage: "how often an idle /dogs/watch stream gets a heartbeat comment",
+		get:   func(c *Config) string { return c.WatchHeartbeat.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.WatchHeartbeat) },
+	},
+	{
+		key: "watch_max_duration", env: "WATCH_MAX_DURATION", flag: "watch-max-duration",
+		usage: "how long a /dogs/watch stream lasts before the client has to reconnect, less than write_timeout",
+		get:   func(c *Config) string { return c.WatchMaxDuration.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.WatchMaxDuration) },
+	},
+}
+
+func parseBool(v string, dst *bool) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%q is not a bool", v)
+	}
+	*dst = b
+	return nil
+}
+
+func parseInt(v string, dst *int) error {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number", v)
+	}
+	*dst = i
+	return nil
+}
+
+// parseCIDRs accepts plain ips as well, they become a single address network
+func parseCIDRs(v string, dst *[]*net.IPNet) error {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if ip := net.ParseIP(part); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(part)
+		if err != nil {
+			return fmt.Errorf("%q is not an ip or cidr", part)
+		}
+		nets = append(nets, n)
+	}
+	*dst = nets
+	return nil
+}
+
+func parseFloat(v string, dst *float64) error {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", v)
+	}
+	*dst = f
+	return nil
+}
+
+func parseDuration(v string, dst *time.Duration) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration like 30s", v)
+	}
+	*dst = d
+	return nil
+}
+
+// rawFlag holds the flag value until the file and env are applied, so flags win no matter where they sit in the args
+type rawFlag struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (f *rawFlag) String() string   { return f.value }
+func (f *rawFlag) IsBoolFlag() bool { return f.isBool }
+func (f *rawFlag) Set(value string) error {
+	f.value = value
+	f.set = true
+	return nil
+}
+
+// Load applies the config file, environment and flags on top of c, args should not include the program name. A config
+// file is picked up from --config or CONFIG_FILE, and returns flag.ErrHelp when -h was asked for
+func (c *Config) Load(args []string, getenv func(string) string) error {
+	if c.sources == nil {
+		c.sources = map[string]string{}
+	}
+	fs := flag.NewFlagSet("gotoproduction", flag.ContinueOnError)
+	configFile := fs.String(configFileFlag, getenv(configFileEnv), "yaml or json config file, also read from "+configFileEnv)
+	fs.BoolVar(&c.PrintConfig, printFlag, false, "print the effective config with secrets redacted and exit")
+	flags := make([]*rawFlag, len(fields))
+	for i, f := range fields {
+		flags[i] = &rawFlag{isBool: f.isBool}
+		fs.Var(flags[i], f.flag, fmt.Sprintf("%s (env %s)", f.usage, f.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return err
+		}
+	}
+	for _, f := range fields {
+		if v, ok := lookupEnv(getenv, f.env); ok {
+			if err := c.set(f, v, SourceEnv); err != nil {
+				return err
+			}
+		}
+	}
+	for i, f := range fields {
+		if flags[i].set {
+			if err := c.set(f, flags[i].value, SourceFlag); err != nil {
+				return err
+			}
+		}
+	}
+	return c.Validate()
+}
+
+// lookupEnv treats an empty variable as unset, so PORT= doesn't wipe out the file or default value
+func lookupEnv(getenv func(string) string, key string) (string, bool) {
+	v := getenv(key)
+	return v, v != ""
+}
+
+func (c *Config) set(f field, value, source string) error {
+	if err := f.set(c, value); err != nil {
+		return fmt.Errorf("%s from %s: %w", f.key, source, err)
+	}
+	c.sources[f.key] = source
+	return nil
+}
+
+// loadFile reads a flat yaml or json document keyed by the same names Print uses, unknown keys are an error so typos
+// don't silently fall back to the default
+func (c *Config) loadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("os.ReadFile(): %w", err)
+	}
+	values := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(raw, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &values)
+	default:
+		return fmt.Errorf("config file %q must end in .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %q: %w", path, err)
+	}
+	for _, f := range fields {
+		v, ok := values[f.key]
+		if !ok {
+			continue
+		}
+		delete(values, f.key)
+		switch v.(type) {
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}, nil:
+			return fmt.Errorf("%s from %s: must be a single value", f.key, SourceFile)
+		}
+		if err := c.set(f, fmt.Sprint(v), SourceFile); err != nil {
+			return err
+		}
+	}
+	for key := range values {
+		return fmt.Errorf("config file %q has unknown key %q", path, key)
+	}
+	return nil
+}
+
+// Platform is what ResolvePlatform needs to know about where we are running, backed by the gce metadata server in our
+// binaries
+type Platform struct {
+	OnGCE      bool
+	ProjectID  func() (string, error)
+	InstanceID func() (string, error)
+}
+
+// ResolvePlatform fills in what depends on where we run, on gce we listen on every interface, export to cloud trace and
+// ask the metadata server who we are, locally we stick to loopback, the dev project and no trace exporter
+func (c *Config) ResolvePlatform(p Platform) error {
+	if c.sources == nil {
+		c.sources = map[string]string{}
+	}
+	if c.ProjectID == "" {
+		c.ProjectID = DefaultLocalProjectID
+		if p.OnGCE {
+			id, err := p.ProjectID()
+			if err != nil {
+				return fmt.Errorf("metadata.ProjectID(): %w", err)
+			}
+			c.ProjectID = id
+		}
+		c.sources["project_id"] = SourcePlatform
+	}
+	if c.InstanceID == "" {
+		lookup := os.Hostname
+		if p.OnGCE {
+			lookup = p.InstanceID
+		}
+		id, err := lookup()
+		if err != nil {
+			return fmt.Errorf("looking up instance id: %w", err)
+		}
+		c.InstanceID = id
+		c.sources["instance_id"] = SourcePlatform
+	}
+	if c.Host == "" && !p.OnGCE {
+		c.Host = "127.0.0.1"
+		c.sources["host"] = SourcePlatform
+	}
+	if c.LogFormat == LogFormatAuto {
+		c.LogFormat = LogFormatConsole
+		if p.OnGCE {
+			c.LogFormat = LogFormatJSON
+		}
+		c.sources["log_format"] = SourcePlatform
+	}
+	if c.TraceExporter == TraceExporterAuto {
+		c.TraceExporter = TraceExporterNone
+		if p.OnGCE {
+			c.TraceExporter = TraceExporterCloudTrace
+		}
+		c.sources["trace_exporter"] = SourcePlatform
+	}
+	return nil
+}
+
+// Validate reports every bad value at once rather than making somebody fix them one deploy at a time
+func (c *Config) Validate() error {
+	var problems []string
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 0 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("port %q must be a number between 0 and 65535", c.Port))
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"shutdown_grace", c.ShutdownGrace},
+	} {
+		if d.value <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be positive, got %s", d.key, d.value))
+		}
+	}
+	if c.DrainDelay < 0 || c.DrainDelay >= c.ShutdownGrace {
+		problems = append(problems, fmt.Sprintf("drain_delay %s must be at least 0 and less than shutdown_grace %s", c.DrainDelay, c.ShutdownGrace))
+	}
+	switch c.LogFormat {
+	case LogFormatAuto, LogFormatConsole, LogFormatJSON:
+	default:
+		problems = append(problems, fmt.Sprintf("log_format %q must be auto, console or json", c.LogFormat))
+	}
+	switch c.TraceExporter {
+	case TraceExporterAuto, TraceExporterCloudTrace, TraceExporterOTLPGRPC, TraceExporterOTLPHTTP, TraceExporterStdout, TraceExporterMemory, TraceExporterNone:
+	default:
+		problems = append(problems, fmt.Sprintf("trace_exporter %q must be auto, cloudtrace, otlp-grpc, otlp-http, stdout, memory or none", c.TraceExporter))
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		problems = append(problems, fmt.Sprintf("trace_sample_ratio %g must be between 0 and 1", c.TraceSampleRatio))
+	}
+	if c.RateLimitRPS < 0 {
+		problems = append(problems, fmt.Sprintf("rate_limit_rps %g must be at least 0", c.RateLimitRPS))
+	}
+	if c.RateLimitRPS > 0 && c.RateLimitBurst < 1 {
+		problems = append(problems, fmt.Sprintf("rate_limit_burst %d must be at least 1 when rate limiting is on", c.RateLimitBurst))
+	}
+	if c.MaxInFlight < 0 {
+		problems = append(problems, fmt.Sprintf("max_in_flight %d must be at least 0", c.MaxInFlight))
+	}
+	if c.IdempotencyTTL < 0 {
+		problems = append(problems, fmt.Sprintf("idempotency_ttl %s must be at least 0", c.IdempotencyTTL))
+	}
+	if c.PurgeRetention <= 0 {
+		problems = append(problems, fmt.Sprintf("purge_retention %s must be positive", c.PurgeRetention))
+	}
+	// every purged dog is a delete and an audit entry, plus a dog_count update for its owner when no other dog in the
+	// batch has the same one. Up to three writes per dog, and a firestore batch holds at most 500 writes
+	if c.PurgeBatchSize < 1 || c.PurgeBatchSize > 166 {
+		problems = append(problems, fmt.Sprintf("purge_batch_size %d must be between 1 and 166", c.PurgeBatchSize))
+	}
+	if c.PurgeInterval < 0 {
+		problems = append(problems, fmt.Sprintf("purge_interval %s must be at least 0", c.PurgeInterval))
+	}
+	switch c.EventPublisher {
+	case EventPublisherLog, EventPublisherNone:
+	case EventPublisherPubSub:
+		if c.PubSubTopic == "" {
+			problems = append(problems, "pubsub_topic is required for the pubsub event publisher")
+		}
+	case EventPublisherWebhook:
+		if u, err := url.Parse(c.EventWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("event_webhook_url %q must be an http or https url for the webhook event publisher", c.EventWebhookURL))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("event_publisher %q must be log, pubsub, webhook or none", c.EventPublisher))
+	}
+	if c.RelayInterval < 0 {
+		problems = append(problems, fmt.Sprintf("relay_interval %s must be at least 0", c.RelayInterval))
+	}
+	// claiming a batch updates every event in one transaction, which firestore caps at 500 writes
+	if c.RelayBatchSize < 1 || c.RelayBatchSize > 500 {
+		problems = append(problems, fmt.Sprintf("relay_batch_size %d must be between 1 and 500", c.RelayBatchSize))
+	}
+	if c.WebhookInterval < 0 {
+		problems = append(problems, fmt.Sprintf("webhook_interval %s must be at least 0", c.WebhookInterval))
+	}
+	// deliveries are queued by the relay, without it nothing would ever be sent
+	if c.WebhookInterval > 0 && c.RelayInterval == 0 {
+		problems = append(problems, "webhook_interval needs relay_interval to be set")
+	}
+	if c.WebhookMaxAttempts < 1 {
+		problems = append(problems, fmt.Sprintf("webhook_max_attempts %d must be at least 1", c.WebhookMaxAttempts))
+	}
+	if c.WebhookDisableAfter < 1 {
+		problems = append(problems, fmt.Sprintf("webhook_disable_after %d must be at least 1", c.WebhookDisableAfter))
+	}
+	// a batch only has half of the deliverer's 5m lease to send in, a single POST has to fit in there comfortably
+	if c.WebhookTimeout <= 0 || c.WebhookTimeout > time.Minute {
+		problems = append(problems, fmt.Sprintf("webhook_timeout %s must be positive and at most 1m", c.WebhookTimeout))
+	}
+	if c.WatchHeartbeat <= 0 {
+		problems = append(problems, fmt.Sprintf("watch_heartbeat %s must be positive", c.WatchHeartbeat))
+	}
+	// the server cuts off any response that takes longer than write_timeout, streams have to end cleanly before that
+	if c.WatchMaxDuration <= 0 || c.WatchMaxDuration >= c.WriteTimeout {
+		problems = append(problems, fmt.Sprintf("watch_max_duration %s must be positive and less than write_timeout %s", c.WatchMaxDuration, c.WriteTimeout))
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, fmt.Sprintf("log_level %q must be debug, info, warn or error", c.LogLevel))
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Addr is the address to listen on
+func (c *Config) Addr() string {
+	return c.Host + ":" + c.Port
+}
+
+// Print writes the effective config as yaml with where every value came from, secrets are redacted so the output is
+// safe to paste into an issue
+func (c *Config) Print(w io.Writer) error {
+	for _, f := range fields {
+		value, prefix := f.get(c), ""
+		if f.secret && value != "" {
+			// commented out so feeding the output back in as a config file doesn't use the placeholder as the secret
+			value, prefix = redacted, "# "
+		}
+		source := c.sources[f.key]
+		if source == "" {
+			source = SourceDefault
+		}
+		if _, err := fmt.Fprintf(w, "%s%s: %s # %s\n", prefix, f.key, strconv.Quote(value), source); err != nil {
+			return fmt.Errorf("fmt.Fprintf(): %w", err)
+		}
+	}
+	return nil
+}
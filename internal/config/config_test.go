@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"github.com/matryer/is"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envMap(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() err = %v; want nil", err)
+	}
+	return path
+}
+
+func TestConfig_Load(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		is := is.New(t)
+		cfg := Default()
+		is.NoErr(cfg.Load(nil, envMap(nil)))          // cfg.Load error
+		is.Equal(cfg.Port, "8080")                    // default port
+		is.Equal(cfg.ReadTimeout, 30*time.Second)     // default read timeout
+		is.Equal(cfg.ShutdownGrace, 9*time.Second)    // default shutdown grace
+		is.Equal(cfg.LogFormat, LogFormatAuto)        // default log format
+		is.True(!cfg.GRPCEnabled && !cfg.PrintConfig) // flags off by default
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		is := is.New(t)
+		file := writeFile(t, "config.yaml", "port: 9000\nlog_level: info\nread_timeout: 5s\ngrpc_enabled: true\n")
+		cfg := Default()
+		err := cfg.Load([]string{"--port", "9002"}, envMap(map[string]string{
+			"CONFIG_FILE": file,
+			"PORT":        "9001",
+			"LOG_LEVEL":   "warn",
+		}))
+		is.NoErr(err)                            // cfg.Load error
+		is.Equal(cfg.Port, "9002")               // flag beats env and file
+		is.Equal(cfg.LogLevel, "warn")           // env beats file
+		is.Equal(cfg.ReadTimeout, 5*time.Second) // file beats default
+		is.True(cfg.GRPCEnabled)                 // bools come through from the file
+		is.Equal(cfg.sources["port"], SourceFlag)
+		is.Equal(cfg.sources["log_level"], SourceEnv)
+		is.Equal(cfg.sources["read_timeout"], SourceFile)
+	})
+
+	t.Run("json file from flag", func(t *testing.T) {
+		is := is.New(t)
+		file := writeFile(t, "config.json", `{"project_id": "from-json", "port": 7000, "shutdown_grace": "1m"}`)
+		cfg := Default()
+		is.NoErr(cfg.Load([]string{"-config", file, "-grpc-enabled"}, envMap(nil))) // cfg.Load error
+		is.Equal(cfg.ProjectID, "from-json")                                        // string from json
+		is.Equal(cfg.Port, "7000")                                                  // number from json
+		is.Equal(cfg.ShutdownGrace, time.Minute)                                    // duration from json
+		is.True(cfg.GRPCEnabled)                                                    // bool flag without a value
+	})
+
+	t.Run("unknown file key", func(t *testing.T) {
+		is := is.New(t)
+		file := writeFile(t, "config.yaml", "prot: 9000\n")
+		err := Default().Load([]string{"-config", file}, envMap(nil))
+		is.True(err != nil)                              // typo in the file must fail
+		is.True(strings.Contains(err.Error(), `"prot"`)) // names the bad key
+	})
+
+	t.Run("bad env value", func(t *testing.T) {
+		is := is.New(t)
+		err := Default().Load(nil, envMap(map[string]string{"READ_TIMEOUT": "30"}))
+		is.True(err != nil)                                             // a duration without a unit must fail
+		is.True(strings.Contains(err.Error(), "read_timeout from env")) // says where the value came from
+	})
+
+	t.Run("validation reports every problem", func(t *testing.T) {
+		is := is.New(t)
+		err := Default().Load([]string{"-port", "http", "-log-level", "loud", "-shutdown-grace", "0s", "-trace-exporter", "zipkin", "-trace-sample-ratio", "2"}, envMap(nil))
+		is.True(err != nil) // invalid config
+		for _, key := range []string{"port", "log_level", "shutdown_grace", "trace_exporter", "trace_sample_ratio"} {
+			is.True(strings.Contains(err.Error(), key)) // every bad key is reported
+		}
+	})
+
+	t.Run("trusted proxies", func(t *testing.T) {
+		is := is.New(t)
+		cfg := Default()
+		is.NoErr(cfg.Load(nil, envMap(map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8, 192.0.2.1"})))   // cfg.Load error
+		is.Equal(len(cfg.TrustedProxies), 2)                                                             // one network per entry
+		is.Equal(cfg.TrustedProxies[1].String(), "192.0.2.1/32")                                         // plain ips become a single address
+		is.True(Default().Load(nil, envMap(map[string]string{"TRUSTED_PROXIES": "10.0.0.0/33"})) != nil) // bad cidr
+	})
+
+	t.Run("event publisher", func(t *testing.T) {
+		is := is.New(t)
+		err := Default().Load([]string{"-event-publisher", "webhook"}, envMap(nil))
+		is.True(err != nil)                                         // webhook publisher without a url
+		is.True(strings.Contains(err.Error(), "event_webhook_url")) // names the missing url
+		cfg := Default()
+		is.NoErr(cfg.Load(nil, envMap(map[string]string{"EVENT_PUBLISHER": "webhook", "EVENT_WEBHOOK_URL": "https://example.com/hook"}))) // cfg.Load error
+		is.Equal(cfg.EventPublisher, EventPublisherWebhook)
+		is.True(Default().Load([]string{"-event-publisher", "kafka"}, envMap(nil)) != nil) // unknown publisher
+		is.True(Default().Load([]string{"-relay-batch-size", "501"}, envMap(nil)) != nil)  // more than a transaction holds
+	})
+
+	t.Run("purge batch size", func(t *testing.T) {
+		is := is.New(t)
+		cfg := Default()
+		is.NoErr(cfg.Load([]string{"-purge-batch-size", "166"}, envMap(nil))) // cfg.Load error
+		is.Equal(cfg.PurgeBatchSize, 166)
+		err := Default().Load([]string{"-purge-batch-size", "167"}, envMap(nil))
+		is.True(err != nil)                                                             // with an owner update per dog, more than a batch holds
+		is.True(strings.Contains(err.Error(), "purge_batch_size"))                      // names the key
+		is.True(Default().Load([]string{"-purge-batch-size", "0"}, envMap(nil)) != nil) // purges nothing
+	})
+
+	t.Run("webhooks", func(t *testing.T) {
+		is := is.New(t)
+		cfg := Default()
+		is.NoErr(cfg.Load([]string{"-webhook-interval", "5s"}, envMap(nil))) // cfg.Load error
+		is.Equal(cfg.WebhookInterval, 5*time.Second)
+		err := Default().Load([]string{"-webhook-interval", "5s", "-relay-interval", "0"}, envMap(nil))
+		is.True(err != nil)                                                                 // webhooks without a relay
+		is.True(strings.Contains(err.Error(), "relay_interval"))                            // says what is missing
+		is.True(Default().Load([]string{"-webhook-max-attempts", "0"}, envMap(nil)) != nil) // never tried
+		is.True(Default().Load([]string{"-webhook-timeout", "5m"}, envMap(nil)) != nil)     // outlasts the batch deadline
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		is := is.New(t)
+		cfg := Default()
+		is.NoErr(cfg.Load([]string{"-write-timeout", "2m", "-watch-max-duration", "90s"}, envMap(nil))) // cfg.Load error
+		is.Equal(cfg.WatchMaxDuration, 90*time.Second)
+		err := Default().Load([]string{"-watch-max-duration", "30s"}, envMap(nil))
+		is.True(err != nil)                                     // the write timeout would cut the stream off
+		is.True(strings.Contains(err.Error(), "write_timeout")) // says what it conflicts with
+	})
+
+	t.Run("help", func(t *testing.T) {
+		is := is.New(t)
+		cfg := Default()
+		err := cfg.Load([]string{"-h"}, envMap(nil))
+		is.True(errors.Is(err, flag.ErrHelp)) // callers can exit cleanly on -h
+	})
+}
+
+func TestConfig_ResolvePlatform(t *testing.T) {
+	t.Run("local", func(t *testing.T) {
+		is := is.New(t)
+		cfg := Default()
+		is.NoErr(cfg.ResolvePlatform(Platform{})) // cfg.ResolvePlatform error
+		is.Equal(cfg.ProjectID, DefaultLocalProjectID)
+		is.Equal(cfg.Addr(), "127.0.0.1:8080") // loopback only locally
+		is.Equal(cfg.LogFormat, LogFormatConsole)
+		is.Equal(cfg.TraceExporter, TraceExporterNone) // local runs don't spam cloud trace
+		hostname, _ := os.Hostname()
+		is.Equal(cfg.InstanceID, hostname) // instance is the hostname locally
+	})
+
+	t.Run("gce", func(t *testing.T) {
+		is := is.New(t)
+		cfg := Default()
+		is.NoErr(cfg.ResolvePlatform(Platform{
+			OnGCE:      true,
+			ProjectID:  func() (string, error) { return "from-metadata", nil },
+			InstanceID: func() (string, error) { return "instance-1", nil },
+		})) // cfg.ResolvePlatform error
+		is.Equal(cfg.ProjectID, "from-metadata") // project from the metadata server
+		is.Equal(cfg.InstanceID, "instance-1")   // instance from the metadata server
+		is.Equal(cfg.Addr(), ":8080")            // every interface on gce
+		is.Equal(cfg.LogFormat, LogFormatJSON)
+		is.Equal(cfg.TraceExporter, TraceExporterCloudTrace)
+	})
+
+	t.Run("configured values win", func(t *testing.T) {
+		is := is.New(t)
+		cfg := Default()
+		is.NoErr(cfg.Load([]string{"-project-id", "mine", "-instance-id", "me", "-log-format", "console", "-trace-exporter", "stdout"}, envMap(nil))) // cfg.Load error
+		askedMetadata := func() (string, error) {
+			t.Fatal("metadata must not be asked when the value is configured")
+			return "", nil
+		}
+		is.NoErr(cfg.ResolvePlatform(Platform{OnGCE: true, ProjectID: askedMetadata, InstanceID: askedMetadata}))
+		is.Equal(cfg.ProjectID, "mine")
+		is.Equal(cfg.InstanceID, "me")
+		is.Equal(cfg.LogFormat, LogFormatConsole)
+		is.Equal(cfg.TraceExporter, TraceExporterStdout)
+	})
+}
+
+func TestConfig_Print(t *testing.T) {
+	is := is.New(t)
+	cfg := Default()
+	is.NoErr(cfg.Load([]string{"-print-config"}, envMap(map[string]string{"PAGE_TOKEN_SECRET": "hunter2"}))) // cfg.Load error
+	is.True(cfg.PrintConfig)                                                                                 // print mode requested
+
+	var out bytes.Buffer
+	is.NoErr(cfg.Print(&out))                                                          // cfg.Print error
+	is.True(!strings.Contains(out.String(), "hunter2"))                                // secrets never get printed
+	is.True(strings.Contains(out.String(), `# page_token_secret: "[REDACTED]" # env`)) // but we can see one is set
+	is.True(strings.Contains(out.String(), `port: "8080" # default`))
+
+	// what we print can be fed straight back in as a config file
+	file := writeFile(t, "printed.yaml", out.String())
+	reloaded := Default()
+	is.NoErr(reloaded.Load([]string{"-config", file}, envMap(nil))) // printed config loads
+	is.Equal(reloaded.Port, cfg.Port)
+	is.Equal(reloaded.PageTokenSecret, "") // the redacted placeholder is not loaded as the secret
+}
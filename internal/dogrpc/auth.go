@@ -0,0 +1,67 @@
+package dogrpc
+
+import (
+	"context"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/dogpb"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// dogServicePrefix is the prefix of every method auth applies to, health and reflection stay open for probes and tooling
+var dogServicePrefix = "/" + dogpb.DogService_ServiceDesc.ServiceName + "/"
+
+// methodScopes is the jwt scope every DogService rpc requires, what the principal's roles allow is up to the DogService
+// policy
+var methodScopes = map[string]string{
+	dogServicePrefix + "GetDog":    gotoproduction.PermissionReadDogs,
+	dogServicePrefix + "FindDogs":  gotoproduction.PermissionReadDogs,
+	dogServicePrefix + "ListDogs":  gotoproduction.PermissionReadDogs,
+	dogServicePrefix + "CreateDog": gotoproduction.PermissionCreateDogs,
+}
+
+// WithAuthenticator requires every DogService rpc to send an authorization or x-api-key metadata entry, pass the
+// options to NewServer
+func WithAuthenticator(a *authx.Authenticator) []grpc.ServerOption {
+	return WithGuards(Guards{Authenticator: a})
+}
+
+func authenticate(ctx context.Context, a *authx.Authenticator, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	principal, err := a.Authenticate(first(md.Get("authorization")), first(md.Get("x-api-key")))
+	if err != nil {
+		// the client only learns it has to authenticate, not why its credential was rejected
+		return nil, status.Error(codes.Unauthenticated, "a valid bearer token or api key is required")
+	}
+	trace.SpanFromContext(ctx).SetAttributes(principal.Attributes()...)
+	// unknown methods need every scope, a new rpc without an entry fails closed
+	scope, ok := methodScopes[method]
+	if !ok {
+		scope = authx.AnyPermission
+	}
+	if !principal.HasScope(scope) {
+		return nil, status.Errorf(codes.PermissionDenied, "token scope does not include %s", scope)
+	}
+	return authx.NewContext(ctx, principal), nil
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// authenticatedStream hands the stream handler the context carrying the principal
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,57 @@
+package dogrpc
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/amammay/gotoproduction/dogpb"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/matryer/is"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestAuthenticator accepts the api key s3cret
+func newTestAuthenticator(t *testing.T) *authx.Authenticator {
+	is := is.New(t)
+	raw, err := json.Marshal([]authx.APIKey{{Name: "ci", SHA256: authx.HashAPIKey("s3cret")}})
+	is.NoErr(err) // json.Marshal error
+	path := filepath.Join(t.TempDir(), "api-keys.json")
+	is.NoErr(os.WriteFile(path, raw, 0o600)) // os.WriteFile error
+	authenticator, err := authx.New(authx.Options{APIKeysFile: path})
+	is.NoErr(err) // authx.New error
+	return authenticator
+}
+
+func TestWithAuthenticator(t *testing.T) {
+	is := is.New(t)
+	conn := newTestClient(t, WithAuthenticator(newTestAuthenticator(t))...)
+	client := dogpb.NewDogServiceClient(conn)
+	ctx := context.Background()
+
+	_, err := client.CreateDog(ctx, &dogpb.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+	is.Equal(status.Code(err), codes.Unauthenticated) // unary rpcs require credentials
+	stream, err := client.ListDogs(ctx, &dogpb.ListDogsRequest{})
+	is.NoErr(err) // client.ListDogs error
+	_, err = stream.Recv()
+	is.Equal(status.Code(err), codes.Unauthenticated) // streaming rpcs require credentials
+
+	authed := metadata.AppendToOutgoingContext(ctx, "x-api-key", "s3cret")
+	_, err = client.CreateDog(authed, &dogpb.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+	is.NoErr(err) // api key accepted
+	stream, err = client.ListDogs(authed, &dogpb.ListDogsRequest{})
+	is.NoErr(err) // client.ListDogs error
+	_, err = stream.Recv()
+	is.NoErr(err) // streamed the dog
+	_, err = stream.Recv()
+	is.Equal(err, io.EOF) // and nothing else
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	is.NoErr(err)                                                    // health checks stay open
+	is.Equal(resp.GetStatus(), healthpb.HealthCheckResponse_SERVING) // still serving
+}
@@ -0,0 +1,132 @@
+package dogrpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/limitx"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryAfterKey is the trailer telling a throttled or shed client how many seconds to wait, like Retry-After over http
+const retryAfterKey = "retry-after"
+
+// Guards is what stands between a client and the DogService rpcs, every field is optional. The rest api has the same
+// protections, pass the same limiters to both so a client can't double its budget by switching transports
+type Guards struct {
+	// Authenticator requires an authorization or x-api-key metadata entry
+	Authenticator *authx.Authenticator
+	// InFlight answers UNAVAILABLE once it is full, rather than queueing work the store can't keep up with
+	InFlight *limitx.Gate
+	// AuthFailures counts failed authentications by peer ip, an ip that failed too often is turned away before its
+	// credentials are looked at
+	AuthFailures *limitx.Limiter
+	// Requests is every client's token bucket, keyed by principal or else by peer ip
+	Requests *limitx.Limiter
+	// TrustedProxies are the proxies whose x-forwarded-for metadata is believed when working out the peer ip
+	TrustedProxies []*net.IPNet
+}
+
+// WithGuards puts the DogService rpcs behind load shedding, authentication and rate limiting in the same order as the
+// rest api: load is shed before doing any work, then we authenticate so the rate limit can tell clients apart by
+// principal. Health and reflection stay open for probes and tooling, pass the options to NewServer
+func WithGuards(g Guards) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if !strings.HasPrefix(info.FullMethod, dogServicePrefix) {
+				return handler(ctx, req)
+			}
+			ctx, release, err := g.admit(ctx, info.FullMethod, func(md metadata.MD) { _ = grpc.SetTrailer(ctx, md) })
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if !strings.HasPrefix(info.FullMethod, dogServicePrefix) {
+				return handler(srv, ss)
+			}
+			ctx, release, err := g.admit(ss.Context(), info.FullMethod, ss.SetTrailer)
+			if err != nil {
+				return err
+			}
+			// the slot is held for the whole stream, a long scan is as much work as many small calls
+			defer release()
+			return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+		}),
+	}
+}
+
+// admit runs every guard for one rpc and returns the context to call it with and a func freeing its in flight slot,
+// or the status it is turned away with. setTrailer sends the retry-after trailer
+func (g Guards) admit(ctx context.Context, method string, setTrailer func(md metadata.MD)) (context.Context, func(), error) {
+	release := func() {}
+	if g.InFlight != nil {
+		if !g.InFlight.Enter() {
+			return nil, nil, throttled(setTrailer, codes.Unavailable, "the server is handling too many requests, retry shortly", time.Second)
+		}
+		release = g.InFlight.Leave
+	}
+	ip := peerIP(ctx, g.TrustedProxies)
+	if g.AuthFailures != nil {
+		if result := g.AuthFailures.Peek(ip); !result.Allowed {
+			release()
+			return nil, nil, throttled(setTrailer, codes.ResourceExhausted, "too many failed authentications", result.RetryAfter)
+		}
+	}
+	if g.Authenticator != nil {
+		authed, err := authenticate(ctx, g.Authenticator, method)
+		if err != nil {
+			if g.AuthFailures != nil && status.Code(err) == codes.Unauthenticated {
+				g.AuthFailures.Take(ip)
+			}
+			release()
+			return nil, nil, err
+		}
+		ctx = authed
+	}
+	if g.Requests != nil {
+		key := "ip:" + ip
+		// an unverified credential would let a client pick a fresh bucket per call, only a principal counts
+		if principal, ok := authx.FromContext(ctx); ok {
+			key = fmt.Sprintf("principal:%s:%s", principal.Method, principal.Subject)
+		}
+		if result := g.Requests.Take(key); !result.Allowed {
+			release()
+			return nil, nil, throttled(setTrailer, codes.ResourceExhausted, "slow down and retry after the retry-after trailer", result.RetryAfter)
+		}
+	}
+	return ctx, release, nil
+}
+
+// peerIP is the ip of the client on the other end of ctx, x-forwarded-for is only believed from trustedProxies
+func peerIP(ctx context.Context, trustedProxies []*net.IPNet) string {
+	var remote string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remote = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return limitx.ClientIP(remote, md.Get("x-forwarded-for"), trustedProxies)
+}
+
+// throttled is a status telling the client when to come back, both as a retry-after trailer and as RetryInfo for
+// clients that know about error details
+func throttled(setTrailer func(md metadata.MD), code codes.Code, message string, retryAfter time.Duration) error {
+	setTrailer(metadata.Pairs(retryAfterKey, strconv.Itoa(limitx.CeilSeconds(retryAfter))))
+	st, err := status.New(code, message).WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return status.Error(code, message)
+	}
+	return st.Err()
+}
@@ -0,0 +1,94 @@
+package dogrpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/amammay/gotoproduction/dogpb"
+	"github.com/amammay/gotoproduction/internal/limitx"
+	"github.com/matryer/is"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestWithGuards_rateLimit(t *testing.T) {
+	is := is.New(t)
+	client := dogpb.NewDogServiceClient(newTestClient(t, WithGuards(Guards{Requests: limitx.NewLimiter(0.001, 2)})...))
+	ctx := context.Background()
+
+	_, err := client.FindDogs(ctx, &dogpb.FindDogsRequest{Type: "beagle"})
+	is.NoErr(err) // within the burst
+	stream, err := client.ListDogs(ctx, &dogpb.ListDogsRequest{})
+	is.NoErr(err) // client.ListDogs error
+	_, err = stream.Recv()
+	is.True(status.Code(err) != codes.ResourceExhausted) // streams draw from the same bucket, still within the burst
+
+	var trailer metadata.MD
+	_, err = client.FindDogs(ctx, &dogpb.FindDogsRequest{Type: "beagle"}, grpc.Trailer(&trailer))
+	is.Equal(status.Code(err), codes.ResourceExhausted) // over the limit
+	is.True(len(trailer.Get(retryAfterKey)) == 1)       // tells the client when to come back
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+	is.True(retryInfo != nil) // and as an error detail
+
+	stream, err = client.ListDogs(ctx, &dogpb.ListDogsRequest{})
+	is.NoErr(err) // client.ListDogs error
+	_, err = stream.Recv()
+	is.Equal(status.Code(err), codes.ResourceExhausted) // streaming scans are limited too
+}
+
+func TestWithGuards_loadShedding(t *testing.T) {
+	is := is.New(t)
+	gate := limitx.NewGate(1)
+	conn := newTestClient(t, WithGuards(Guards{InFlight: gate})...)
+	client := dogpb.NewDogServiceClient(conn)
+	ctx := context.Background()
+
+	// the rest api holds the only slot
+	is.True(gate.Enter())
+	var trailer metadata.MD
+	_, err := client.FindDogs(ctx, &dogpb.FindDogsRequest{Type: "beagle"}, grpc.Trailer(&trailer))
+	is.Equal(status.Code(err), codes.Unavailable)       // shed
+	is.Equal(trailer.Get(retryAfterKey), []string{"1"}) // come back shortly
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	is.NoErr(err)                                                    // health checks are never shed
+	is.Equal(resp.GetStatus(), healthpb.HealthCheckResponse_SERVING) // still serving
+
+	gate.Leave()
+	_, err = client.FindDogs(ctx, &dogpb.FindDogsRequest{Type: "beagle"})
+	is.NoErr(err)         // capacity is back
+	is.True(gate.Enter()) // and the rpc gave its slot back
+}
+
+func TestWithGuards_authFailures(t *testing.T) {
+	is := is.New(t)
+	client := dogpb.NewDogServiceClient(newTestClient(t, WithGuards(Guards{
+		Authenticator: newTestAuthenticator(t),
+		AuthFailures:  limitx.NewAuthFailureLimiter(),
+	})...))
+	ctx := context.Background()
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "x-api-key", key)
+	}
+
+	for i := 0; i < limitx.AuthFailureBurst; i++ {
+		_, err := client.FindDogs(withKey(fmt.Sprintf("guess-%d", i)), &dogpb.FindDogsRequest{Type: "beagle"})
+		is.Equal(status.Code(err), codes.Unauthenticated) // wrong key
+	}
+	_, err := client.FindDogs(withKey("guess-next"), &dogpb.FindDogsRequest{Type: "beagle"})
+	is.Equal(status.Code(err), codes.ResourceExhausted) // too many failures from this peer
+	_, err = client.FindDogs(withKey("s3cret"), &dogpb.FindDogsRequest{Type: "beagle"})
+	is.Equal(status.Code(err), codes.ResourceExhausted) // even with a good key, the peer is turned away first
+	stream, err := client.ListDogs(withKey("s3cret"), &dogpb.ListDogsRequest{})
+	is.NoErr(err) // client.ListDogs error
+	_, err = stream.Recv()
+	is.Equal(status.Code(err), codes.ResourceExhausted) // streams count the same failures
+}
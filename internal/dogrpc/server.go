@@ -0,0 +1,216 @@
+// Package dogrpc serves the gotoproduction.DogService over grpc, so every binary that wants the typed api can mount it
+package dogrpc
+
+import (
+	"context"
+	"errors"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/dogpb"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+)
+
+// Server is a grpc server with the dog api, health checking and reflection registered
+type Server struct {
+	*grpc.Server
+	health *health.Server
+}
+
+// NewServer builds a grpc server backed by the given DogService, extra server options are applied after our interceptors
+func NewServer(dogService *gotoproduction.DogService, logger *logx.AppLogger, opts ...grpc.ServerOption) *Server {
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), unaryLogger(logger)),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(), streamLogger(logger)),
+	}, opts...)
+	gs := grpc.NewServer(opts...)
+	dogpb.RegisterDogServiceServer(gs, &dogServer{dogService: dogService, appLogger: logger})
+
+	hs := health.NewServer()
+	hs.SetServingStatus(dogpb.DogService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(gs, hs)
+	reflection.Register(gs)
+	return &Server{Server: gs, health: hs}
+}
+
+// Drain reports NOT_SERVING to health checks while still serving rpcs, so clients move away before we stop
+func (s *Server) Drain() {
+	s.health.Shutdown()
+}
+
+// GracefulStop reports NOT_SERVING to health checks so traffic drains, then waits for in flight rpcs to finish
+func (s *Server) GracefulStop() {
+	s.health.Shutdown()
+	s.Server.GracefulStop()
+}
+
+type dogServer struct {
+	dogpb.UnimplementedDogServiceServer
+	dogService *gotoproduction.DogService
+	appLogger  *logx.AppLogger
+}
+
+func (s *dogServer) GetDog(ctx context.Context, request *dogpb.GetDogRequest) (*dogpb.Dog, error) {
+	if request.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	dog, err := s.dogService.GetDogByID(ctx, request.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProtoDog(dog), nil
+}
+
+func (s *dogServer) FindDogs(ctx context.Context, request *dogpb.FindDogsRequest) (*dogpb.FindDogsResponse, error) {
+	if request.GetType() == "" {
+		return nil, status.Error(codes.InvalidArgument, "type is required")
+	}
+	page, err := s.dogService.ListDogs(ctx, &gotoproduction.ListDogsRequest{
+		Type:      request.GetType(),
+		PageSize:  int(request.GetPageSize()),
+		PageToken: request.GetPageToken(),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	response := &dogpb.FindDogsResponse{NextPageToken: page.NextPageToken}
+	for _, dog := range page.Dogs {
+		response.Dogs = append(response.Dogs, toProtoDog(dog))
+	}
+	return response, nil
+}
+
+func (s *dogServer) CreateDog(ctx context.Context, request *dogpb.CreateDogRequest) (*dogpb.CreateDogResponse, error) {
+	dogID, err := s.dogService.CreateDog(ctx, &gotoproduction.CreateDogRequest{
+		Name: request.GetName(),
+		Age:  int(request.GetAge()),
+		Type: request.GetType(),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &dogpb.CreateDogResponse{Id: dogID}, nil
+}
+
+func (s *dogServer) ListDogs(request *dogpb.ListDogsRequest, stream dogpb.DogService_ListDogsServer) error {
+	ctx := stream.Context()
+	listRequest := &gotoproduction.ListDogsRequest{
+		Type:     request.GetType(),
+		OrderBy:  gotoproduction.DogOrder(request.GetOrderBy()),
+		PageSize: gotoproduction.MaxPageSize,
+	}
+	for {
+		page, err := s.dogService.ListDogs(ctx, listRequest)
+		if err != nil {
+			return toStatus(err)
+		}
+		for _, dog := range page.Dogs {
+			if err := stream.Send(toProtoDog(dog)); err != nil {
+				return err
+			}
+		}
+		if page.NextPageToken == "" {
+			return nil
+		}
+		listRequest.PageToken = page.NextPageToken
+	}
+}
+
+func toProtoDog(dog *gotoproduction.Dog) *dogpb.Dog {
+	return &dogpb.Dog{
+		Id:         dog.ID,
+		Name:       dog.Name,
+		Age:        int32(dog.Age),
+		Type:       dog.Type,
+		CreateTime: timestamppb.New(dog.CreatedTimestamp),
+		UpdateTime: timestamppb.New(dog.UpdateTime),
+	}
+}
+
+// toStatus maps service errors onto grpc status codes, storage errors keep their code but not their message
+func toStatus(err error) error {
+	var validationErr *gotoproduction.ValidationError
+	if errors.As(err, &validationErr) {
+		badRequest := &errdetails.BadRequest{}
+		for _, f := range validationErr.Fields {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       f.Field,
+				Description: f.Message,
+			})
+		}
+		st, detailErr := status.New(codes.InvalidArgument, "one or more fields are invalid").WithDetails(badRequest)
+		if detailErr != nil {
+			return status.Error(codes.InvalidArgument, validationErr.Error())
+		}
+		return st.Err()
+	}
+	switch {
+	case errors.Is(err, gotoproduction.ErrDogNotFound):
+		return status.Error(codes.NotFound, "dog not found")
+	case errors.Is(err, gotoproduction.ErrDogConflict):
+		return status.Error(codes.Aborted, "dog was modified concurrently")
+	case errors.Is(err, gotoproduction.ErrInvalidPageToken):
+		return status.Error(codes.InvalidArgument, "invalid page token")
+	case errors.Is(err, authx.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, "a valid bearer token or api key is required")
+	case errors.Is(err, authx.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, "permission denied")
+	case errors.Is(err, gotoproduction.ErrInvalidOrderBy):
+		return status.Error(codes.InvalidArgument, "invalid order by")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "request canceled")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	}
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		switch code := grpcErr.GRPCStatus().Code(); code {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+			return status.Error(code, "dog database unavailable")
+		}
+	}
+	return status.Error(codes.Internal, "internal error")
+}
+
+// unaryLogger logs every unary rpc with the trace context the otel interceptor put on the context
+func unaryLogger(appLogger *logx.AppLogger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logRPC(ctx, appLogger, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// streamLogger logs every streaming rpc once it completes
+func streamLogger(appLogger *logx.AppLogger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logRPC(ss.Context(), appLogger, info.FullMethod, start, err)
+		return err
+	}
+}
+
+func logRPC(ctx context.Context, appLogger *logx.AppLogger, method string, start time.Time, err error) {
+	logger := appLogger.WrapTraceContext(ctx)
+	code := status.Code(err)
+	fields := []interface{}{"method", method, "code", code.String(), "duration", time.Since(start)}
+	switch code {
+	case codes.OK:
+		logger.Infow("rpc finished", fields...)
+	case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss:
+		logger.Errorw("rpc failed", fields...)
+	default:
+		logger.Infow("rpc rejected", fields...)
+	}
+}
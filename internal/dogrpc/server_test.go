@@ -0,0 +1,103 @@
+package dogrpc
+
+import (
+	"context"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/dogpb"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+// newTestClient serves the grpc api over an in process bufconn listener backed by the in memory store
+func newTestClient(t *testing.T, opts ...grpc.ServerOption) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	dogService := gotoproduction.NewDogService(gotoproduction.NewMemoryStore(), logx.NewTesterLogger(t))
+	server := NewServer(dogService, logx.NewTesterLogger(t), opts...)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("grpc.DialContext() err = %v; want nil", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		server.GracefulStop()
+	})
+	return conn
+}
+
+func TestServer_dogs(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	client := dogpb.NewDogServiceClient(newTestClient(t))
+
+	created, err := client.CreateDog(ctx, &dogpb.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"})
+	is.NoErr(err)                 // client.CreateDog error
+	is.Equal(len(created.Id), 20) // must have a document id
+	_, err = client.CreateDog(ctx, &dogpb.CreateDogRequest{Name: "Bella", Age: 2, Type: "Golden Doodle"})
+	is.NoErr(err) // client.CreateDog error
+
+	dog, err := client.GetDog(ctx, &dogpb.GetDogRequest{Id: created.Id})
+	is.NoErr(err)                          // client.GetDog error
+	is.Equal(dog.GetName(), "Oscar")       // got back our dog
+	is.True(dog.GetCreateTime().IsValid()) // timestamps are set
+
+	_, err = client.GetDog(ctx, &dogpb.GetDogRequest{Id: "999"})
+	is.Equal(status.Code(err), codes.NotFound) // ErrDogNotFound maps to NOT_FOUND
+
+	found, err := client.FindDogs(ctx, &dogpb.FindDogsRequest{Type: "Golden Doodle", PageSize: 1})
+	is.NoErr(err)                           // client.FindDogs error
+	is.Equal(len(found.GetDogs()), 1)       // page size honoured
+	is.True(found.GetNextPageToken() != "") // second page exists
+
+	stream, err := client.ListDogs(ctx, &dogpb.ListDogsRequest{OrderBy: "name"})
+	is.NoErr(err) // client.ListDogs error
+	var names []string
+	for {
+		dog, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		is.NoErr(err) // stream.Recv error
+		names = append(names, dog.GetName())
+	}
+	is.Equal(names, []string{"Bella", "Oscar"}) // streamed every dog by name
+}
+
+func TestServer_CreateDog_invalid(t *testing.T) {
+	is := is.New(t)
+	client := dogpb.NewDogServiceClient(newTestClient(t))
+
+	_, err := client.CreateDog(context.Background(), &dogpb.CreateDogRequest{Age: -1, Type: "Dragon"})
+	st := status.Convert(err)
+	is.Equal(st.Code(), codes.InvalidArgument) // validation maps to INVALID_ARGUMENT
+	is.Equal(len(st.Details()), 1)             // carries a bad request detail
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	is.True(ok)                                       // detail is a BadRequest
+	is.Equal(len(badRequest.GetFieldViolations()), 3) // name, age and type all fail
+}
+
+func TestServer_health(t *testing.T) {
+	is := is.New(t)
+	client := healthpb.NewHealthClient(newTestClient(t))
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: dogpb.DogService_ServiceDesc.ServiceName})
+	is.NoErr(err)                                                    // client.Check error
+	is.Equal(resp.GetStatus(), healthpb.HealthCheckResponse_SERVING) // dog service is serving
+}
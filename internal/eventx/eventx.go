@@ -0,0 +1,228 @@
+// Package eventx has the EventPublishers the outbox relay can deliver dog events to
+package eventx
+
+import (
+	"bytes"
+	"cloud.google.com/go/pubsub"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Publishers New knows how to build
+const (
+	PublisherPubSub  = "pubsub"
+	PublisherWebhook = "webhook"
+	PublisherLog     = "log"
+	PublisherNone    = "none"
+)
+
+// Event attributes and headers carrying the metadata consumers route and dedupe on
+const (
+	AttributeEventID   = "event_id"
+	AttributeEventType = "event_type"
+	AttributeDogID     = "dog_id"
+	HeaderEventID      = "X-Event-ID"
+	HeaderEventType    = "X-Event-Type"
+)
+
+// webhookTimeout bounds a single webhook delivery, a slow receiver shouldn't hold up the rest of the batch
+const webhookTimeout = 10 * time.Second
+
+// Options picks where events go
+type Options struct {
+	// Publisher is one of the Publisher constants
+	Publisher string
+	// ProjectID and Topic say where pubsub events go, the client talks to the emulator when PUBSUB_EMULATOR_HOST is set
+	ProjectID string
+	Topic     string
+	// WebhookURL receives every event as a json POST
+	WebhookURL string
+}
+
+// New builds the publisher opts asks for, call the returned close func before exit to flush what is still buffered.
+// PublisherNone returns a nil publisher, nothing should relay events then
+func New(ctx context.Context, opts Options, logger *logx.AppLogger) (gotoproduction.EventPublisher, func() error, error) {
+	noop := func() error { return nil }
+	switch opts.Publisher {
+	case PublisherPubSub:
+		client, err := pubsub.NewClient(ctx, opts.ProjectID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pubsub.NewClient(): %w", err)
+		}
+		topic := client.Topic(opts.Topic)
+		// the emulator starts out empty, in a real project the topic is provisioned with everything else
+		if os.Getenv("PUBSUB_EMULATOR_HOST") != "" {
+			if err := ensureTopic(ctx, client, topic); err != nil {
+				client.Close()
+				return nil, nil, err
+			}
+		}
+		return NewPubSubPublisher(topic), func() error {
+			topic.Stop()
+			return client.Close()
+		}, nil
+	case PublisherWebhook:
+		return NewWebhookPublisher(opts.WebhookURL, &http.Client{Timeout: webhookTimeout}), noop, nil
+	case PublisherLog:
+		return NewLogPublisher(logger), noop, nil
+	case PublisherNone:
+		return nil, noop, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown event publisher %q", opts.Publisher)
+	}
+}
+
+func ensureTopic(ctx context.Context, client *pubsub.Client, topic *pubsub.Topic) error {
+	exists, err := topic.Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("topic.Exists(): %w", err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := client.CreateTopic(ctx, topic.ID()); err != nil && status.Code(err) != codes.AlreadyExists {
+		return fmt.Errorf("client.CreateTopic(): %w", err)
+	}
+	return nil
+}
+
+// PubSubPublisher publishes every event as a pubsub message with the event json as data
+type PubSubPublisher struct {
+	topic *pubsub.Topic
+}
+
+// NewPubSubPublisher publishes to topic, the topic has to exist already
+func NewPubSubPublisher(topic *pubsub.Topic) *PubSubPublisher {
+	return &PubSubPublisher{topic: topic}
+}
+
+// Publish waits until pubsub has the message, so a nil error means it is stored
+func (p *PubSubPublisher) Publish(ctx context.Context, event *gotoproduction.DogEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %w", err)
+	}
+	result := p.topic.Publish(ctx, &pubsub.Message{
+		Data: data,
+		Attributes: map[string]string{
+			AttributeEventID:   event.ID,
+			AttributeEventType: event.Type,
+			AttributeDogID:     event.DogID,
+		},
+	})
+	if _, err := result.Get(ctx); err != nil {
+		return fmt.Errorf("result.Get(): %w", err)
+	}
+	return nil
+}
+
+// WebhookPublisher POSTs every event as json to a single url, anything but a 2xx is a failed delivery
+type WebhookPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookPublisher delivers to url with client
+func NewWebhookPublisher(url string, client *http.Client) *WebhookPublisher {
+	return &WebhookPublisher{url: url, client: client}
+}
+
+// Publish sends the event, the event id goes in a header as well so receivers can dedupe before parsing the body
+func (p *WebhookPublisher) Publish(ctx context.Context, event *gotoproduction.DogEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("http.NewRequestWithContext(): %w", err)
+	}
+	request.Header.Set("content-type", "application/json")
+	request.Header.Set(HeaderEventID, event.ID)
+	request.Header.Set(HeaderEventType, event.Type)
+	response, err := p.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("p.client.Do(): %w", err)
+	}
+	defer response.Body.Close()
+	// drain so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %d", response.StatusCode)
+	}
+	return nil
+}
+
+// MultiPublisher hands every event to each of its publishers
+type MultiPublisher []gotoproduction.EventPublisher
+
+// Publish tries every publisher and fails if any of them did, the relay then retries the event with all of them so the
+// ones that already succeeded see it twice and have to dedupe
+func (m MultiPublisher) Publish(ctx context.Context, event *gotoproduction.DogEvent) error {
+	var failed error
+	for _, publisher := range m {
+		if err := publisher.Publish(ctx, event); err != nil && failed == nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// LogPublisher writes every event to the log, handy locally and as a default that keeps the outbox drained
+type LogPublisher struct {
+	appLogger *logx.AppLogger
+}
+
+// NewLogPublisher logs with logger
+func NewLogPublisher(logger *logx.AppLogger) *LogPublisher {
+	return &LogPublisher{appLogger: logger}
+}
+
+// Publish never fails
+func (p *LogPublisher) Publish(ctx context.Context, event *gotoproduction.DogEvent) error {
+	p.appLogger.WrapTraceContext(ctx).Infow("dog event", "event_id", event.ID, "event_type", event.Type, "dog_id", event.DogID, "actor", event.Actor)
+	return nil
+}
+
+// MemoryPublisher keeps every event it is handed, for tests
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []*gotoproduction.DogEvent
+	// Err is returned from Publish instead of keeping the event while it is set
+	Err error
+}
+
+// Publish keeps the event unless Err is set
+func (p *MemoryPublisher) Publish(ctx context.Context, event *gotoproduction.DogEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Err != nil {
+		return p.Err
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+// Events returns what was published so far
+func (p *MemoryPublisher) Events() []*gotoproduction.DogEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*gotoproduction.DogEvent(nil), p.events...)
+}
+
+// SetErr makes Publish fail with err, nil makes it succeed again
+func (p *MemoryPublisher) SetErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Err = err
+}
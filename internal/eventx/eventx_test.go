@@ -0,0 +1,53 @@
+package eventx
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/amammay/gotoproduction"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookPublisher_Publish(t *testing.T) {
+	is := is.New(t)
+	var got gotoproduction.DogEvent
+	var gotID string
+	status := http.StatusNoContent
+	receiver := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		gotID = request.Header.Get(HeaderEventID)
+		if err := json.NewDecoder(request.Body).Decode(&got); err != nil {
+			t.Errorf("json.Decode() err = %v; want nil", err)
+		}
+		writer.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	publisher := NewWebhookPublisher(receiver.URL, receiver.Client())
+	event := &gotoproduction.DogEvent{
+		ID:         "event-1",
+		Type:       gotoproduction.EventDogCreated,
+		DogID:      "dog-1",
+		Dog:        &gotoproduction.Dog{ID: "dog-1", Name: "Oscar", Age: 2, Type: "Golden Doodle"},
+		OccurredAt: time.Now().UTC(),
+	}
+	is.NoErr(publisher.Publish(context.Background(), event)) // Publish error
+	is.Equal(gotID, "event-1")                               // dedupe id in the header
+	is.Equal(got.Type, gotoproduction.EventDogCreated)
+	is.Equal(got.Dog.Name, "Oscar")
+
+	status = http.StatusServiceUnavailable
+	is.True(publisher.Publish(context.Background(), event) != nil) // non 2xx is a failed delivery
+}
+
+func TestNew(t *testing.T) {
+	is := is.New(t)
+	publisher, closePublisher, err := New(context.Background(), Options{Publisher: PublisherNone}, nil)
+	is.NoErr(err)              // New error
+	is.Equal(publisher, nil)   // none has nothing to publish to
+	is.NoErr(closePublisher()) // closePublisher error
+	_, _, err = New(context.Background(), Options{Publisher: "kafka"}, nil)
+	is.True(err != nil) // unknown publisher
+}
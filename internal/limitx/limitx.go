@@ -0,0 +1,191 @@
+// Package limitx has the rate limits and load shedding the rest and grpc apis share, both transports are served from
+// the same port so they have to draw from the same buckets or a client could double its budget by switching
+package limitx
+
+import (
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets are dropped, a bucket that has refilled is no different from a new one
+const sweepInterval = time.Minute
+
+const (
+	// AuthFailureBurst is how many failed authentications an ip gets before it is turned away
+	AuthFailureBurst = 10
+	// AuthFailureRPS refills the failure budget of an ip by one every 6 seconds, so guessing credentials stays slow
+	AuthFailureRPS = 1.0 / 6
+)
+
+// Limiter is a set of token buckets keyed by client, safe for concurrent use
+type Limiter struct {
+	rps   float64
+	burst int
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Result is what a client is told about its bucket
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed, zero when this one was
+	RetryAfter time.Duration
+}
+
+// NewLimiter gives every client a bucket of burst tokens refilling at rps per second
+func NewLimiter(rps float64, burst int) *Limiter {
+	return newLimiter(rps, burst, time.Now)
+}
+
+// NewAuthFailureLimiter counts failed authentications by ip, they never get as far as a principal to be limited by
+func NewAuthFailureLimiter() *Limiter {
+	return NewLimiter(AuthFailureRPS, AuthFailureBurst)
+}
+
+func newLimiter(rps float64, burst int, now func() time.Time) *Limiter {
+	return &Limiter{rps: rps, burst: burst, now: now, buckets: map[string]*bucket{}, lastSweep: now()}
+}
+
+// Burst is how many tokens a full bucket has
+func (l *Limiter) Burst() int {
+	return l.burst
+}
+
+// Take spends one token of key's bucket if there is one
+func (l *Limiter) Take(key string) Result {
+	return l.check(key, true)
+}
+
+// Peek says whether key's bucket has a token left without spending it
+func (l *Limiter) Peek(key string) Result {
+	return l.check(key, false)
+}
+
+func (l *Limiter) check(key string, spend bool) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+
+	result := Result{Allowed: b.tokens >= 1}
+	switch {
+	case !result.Allowed:
+		result.RetryAfter = l.durationFor(1 - b.tokens)
+	case spend:
+		b.tokens--
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = l.durationFor(float64(l.burst) - b.tokens)
+	return result
+}
+
+// sweep drops buckets that have refilled since their last request
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rps >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (l *Limiter) durationFor(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rps * float64(time.Second))
+}
+
+// Gate lets a fixed number of requests in at once and turns the rest away rather than queueing them, safe for
+// concurrent use
+type Gate struct {
+	slots chan struct{}
+}
+
+// NewGate lets up to max requests in at once
+func NewGate(max int) *Gate {
+	return &Gate{slots: make(chan struct{}, max)}
+}
+
+// Enter takes a slot if one is free, every successful Enter has to be followed by a Leave
+func (g *Gate) Enter() bool {
+	select {
+	case g.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Leave frees the slot Enter took
+func (g *Gate) Leave() {
+	<-g.slots
+}
+
+// ClientIP is remoteAddr unless it is a trusted proxy, then forwardedFor, the X-Forwarded-For values, is walked from the
+// right and the first address that isn't a trusted proxy wins, anything left of that could have been made up by the
+// client
+func ClientIP(remoteAddr string, forwardedFor []string, trustedProxies []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		remote = remoteAddr
+	}
+	if !isTrusted(remote, trustedProxies) {
+		return remote
+	}
+	hops := strings.Split(strings.Join(forwardedFor, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if net.ParseIP(hop) == nil {
+			// garbage in the chain, we can't trust anything left of it
+			return remote
+		}
+		if !isTrusted(hop, trustedProxies) {
+			return hop
+		}
+		remote = hop
+	}
+	return remote
+}
+
+func isTrusted(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// CeilSeconds rounds d up to whole seconds, the unit Retry-After is given in
+func CeilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
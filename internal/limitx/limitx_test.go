@@ -0,0 +1,71 @@
+package limitx
+
+import (
+	"github.com/matryer/is"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	is := is.New(t)
+	now := time.Unix(0, 0)
+	rl := newLimiter(1, 2, func() time.Time { return now })
+
+	is.True(rl.Take("a").Allowed) // first of the burst
+	result := rl.Take("a")
+	is.True(result.Allowed)               // second of the burst
+	is.Equal(result.Remaining, 0)         // burst used up
+	is.Equal(result.Reset, 2*time.Second) // two tokens to refill
+
+	result = rl.Take("a")
+	is.True(!result.Allowed)                 // over the limit
+	is.Equal(result.RetryAfter, time.Second) // one token away
+	is.True(rl.Take("b").Allowed)            // other clients have their own bucket
+
+	now = now.Add(time.Second)
+	is.True(rl.Take("a").Allowed)  // refilled one token
+	is.True(!rl.Take("a").Allowed) // and only one
+
+	now = now.Add(time.Hour)
+	rl.Take("c")
+	is.Equal(len(rl.buckets), 1) // idle buckets are swept
+}
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   string
+		trustedProxies []*net.IPNet
+		want           string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "untrusted forwarded for is ignored", remoteAddr: "203.0.113.7:1234", forwardedFor: "198.51.100.1", trustedProxies: trusted, want: "203.0.113.7"},
+		{name: "no trusted proxies configured", remoteAddr: "10.0.0.1:1234", forwardedFor: "198.51.100.1", want: "10.0.0.1"},
+		{name: "through a trusted proxy", remoteAddr: "10.0.0.1:1234", forwardedFor: "198.51.100.1", trustedProxies: trusted, want: "198.51.100.1"},
+		{name: "spoofed hops left of the client", remoteAddr: "10.0.0.1:1234", forwardedFor: "1.2.3.4, 198.51.100.1, 10.0.0.2", trustedProxies: trusted, want: "198.51.100.1"},
+		{name: "garbage hop", remoteAddr: "10.0.0.1:1234", forwardedFor: "not-an-ip", trustedProxies: trusted, want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			var forwardedFor []string
+			if tt.forwardedFor != "" {
+				forwardedFor = []string{tt.forwardedFor}
+			}
+			is.Equal(ClientIP(tt.remoteAddr, forwardedFor, tt.trustedProxies), tt.want)
+		})
+	}
+}
+
+func TestGate(t *testing.T) {
+	is := is.New(t)
+	gate := NewGate(1)
+	is.True(gate.Enter())  // a free slot
+	is.True(!gate.Enter()) // full
+	gate.Leave()
+	is.True(gate.Enter()) // free again
+}
@@ -3,6 +3,7 @@ package logx
 import (
 	"context"
 	"fmt"
+	"github.com/amammay/gotoproduction/internal/authx"
 	"github.com/blendle/zapdriver"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
@@ -16,19 +17,27 @@ type AppLogger struct {
 }
 
 func NewDevLogger(projectID string) (*AppLogger, error) {
-	config := zapdriver.NewDevelopmentConfig()
-	config.Encoding = "console"
-	config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	clientLogger, err := config.Build()
-	if err != nil {
-		return nil, fmt.Errorf("config.Build(): %v", err)
-	}
-	return &AppLogger{zap: clientLogger, projectID: projectID}, nil
+	return NewLogger(projectID, false, "debug")
 }
 
 func NewProdLogger(projectID string) (*AppLogger, error) {
+	return NewLogger(projectID, true, "debug")
+}
+
+// NewLogger builds a cloud logging friendly json logger when production is set and a colored console logger otherwise,
+// level is one of debug, info, warn or error
+func NewLogger(projectID string, production bool, level string) (*AppLogger, error) {
+	var zapLevel zapcore.Level
+	if err := zapLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("zapLevel.UnmarshalText(): %v", err)
+	}
 	config := zapdriver.NewProductionConfig()
-	config.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	if !production {
+		config = zapdriver.NewDevelopmentConfig()
+		config.Encoding = "console"
+		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	config.Level = zap.NewAtomicLevelAt(zapLevel)
 
 	clientLogger, err := config.Build()
 	if err != nil {
@@ -45,9 +54,13 @@ func NewTesterLogger(t *testing.T) *AppLogger {
 	return &AppLogger{zap: development, projectID: "fake"}
 }
 
+// WrapTraceContext tags log lines with the trace and, for authenticated requests, the principal they were made by
 func (i *AppLogger) WrapTraceContext(ctx context.Context) *zap.SugaredLogger {
 	sc := trace.SpanContextFromContext(ctx)
 	fields := zapdriver.TraceContext(sc.TraceID().String(), sc.SpanID().String(), sc.IsSampled(), i.projectID)
+	if principal, ok := authx.FromContext(ctx); ok {
+		fields = append(fields, zap.String("principal", principal.Subject), zap.String("auth_method", principal.Method))
+	}
 	setFields := i.zap.With(fields...)
 	return setFields.Sugar()
 }
@@ -63,3 +76,7 @@ func (i *AppLogger) Infof(template string, args ...interface{}) {
 func (i *AppLogger) Info(s string) {
 	i.zap.Info(s)
 }
+
+func (i *AppLogger) Errorf(template string, args ...interface{}) {
+	i.zap.Error(fmt.Sprintf(template, args...))
+}
@@ -0,0 +1,45 @@
+// Package metricx sets up the open telemetry metrics pipeline shared by our binaries, exported in the prometheus format
+package metricx
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	otelprom "go.opentelemetry.io/otel/exporters/metric/prometheus"
+	"go.opentelemetry.io/otel/metric/global"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+)
+
+// histogramBoundaries are shared by every histogram in the pipeline, they cover milliseconds of latency and bytes of
+// response size well enough since the otel sdk only lets us pick one set
+var histogramBoundaries = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 25000, 50000, 100000}
+
+// NewExporter builds a meter provider backed by its own prometheus registry with the go runtime and process collectors
+// registered, the exporter is the http handler for /metrics
+func NewExporter() (*otelprom.Exporter, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(prometheus.NewGoCollector()); err != nil {
+		return nil, fmt.Errorf("registry.Register(go): %w", err)
+	}
+	if err := registry.Register(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{})); err != nil {
+		return nil, fmt.Errorf("registry.Register(process): %w", err)
+	}
+	// collect on every scrape, scrapes are far apart enough that caching the checkpoint buys us nothing
+	exporter, err := otelprom.NewExportPipeline(otelprom.Config{
+		Registry:                   registry,
+		DefaultHistogramBoundaries: histogramBoundaries,
+	}, controller.WithCollectPeriod(0))
+	if err != nil {
+		return nil, fmt.Errorf("otelprom.NewExportPipeline(): %w", err)
+	}
+	return exporter, nil
+}
+
+// InitMetrics is NewExporter installed as the global meter provider
+func InitMetrics() (*otelprom.Exporter, error) {
+	exporter, err := NewExporter()
+	if err != nil {
+		return nil, err
+	}
+	global.SetMeterProvider(exporter.MeterProvider())
+	return exporter, nil
+}
@@ -0,0 +1,203 @@
+// Package tracex sets up the open telemetry tracing pipeline shared by our binaries
+package tracex
+
+import (
+	"context"
+	"fmt"
+	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
+	"github.com/amammay/propagationgcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/semconv"
+	"sync"
+	"time"
+)
+
+// Exporters InitTracing knows how to build
+const (
+	ExporterCloudTrace = "cloudtrace"
+	ExporterOTLPGRPC   = "otlp-grpc"
+	ExporterOTLPHTTP   = "otlp-http"
+	ExporterStdout     = "stdout"
+	ExporterMemory     = "memory"
+	ExporterNone       = "none"
+)
+
+// unhealthyAfter is how many exports in a row have to fail before Check reports the exporter as unhealthy, one dropped
+// batch shouldn't pull an instance out of rotation
+const unhealthyAfter = 3
+
+// Options picks where spans go and how many of them we keep
+type Options struct {
+	// Exporter is one of the Exporter constants
+	Exporter string
+	// ProjectID is the project cloud trace writes to
+	ProjectID string
+	// Endpoint is the otlp collector address, empty uses the exporter default of localhost:4317 for grpc and
+	// localhost:4318 for http
+	Endpoint string
+	// SampleRatio is the fraction of new traces we record, requests that arrive with a sampled parent are always recorded
+	SampleRatio float64
+
+	ServiceName    string
+	ServiceVersion string
+	InstanceID     string
+}
+
+// Tracing is the tracing pipeline InitTracing installed globally
+type Tracing struct {
+	provider *sdktrace.TracerProvider
+	exporter *healthExporter
+	memory   *tracetest.InMemoryExporter
+}
+
+// InitTracing registers a global tracer provider exporting wherever opts says and the propagators we accept, call
+// Shutdown before exit to flush pending spans
+func InitTracing(ctx context.Context, opts Options) (*Tracing, error) {
+	res, err := resource.New(ctx, resource.WithAttributes(
+		semconv.ServiceNameKey.String(opts.ServiceName),
+		semconv.ServiceVersionKey.String(opts.ServiceVersion),
+		semconv.ServiceInstanceIDKey.String(opts.InstanceID),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("resource.New(): %w", err)
+	}
+	providerOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	}
+
+	t := &Tracing{}
+	switch opts.Exporter {
+	case ExporterNone:
+		// still install a real provider so trace ids end up in our logs and problem responses, they just go nowhere
+	case ExporterMemory:
+		// spans are exported as soon as they end so tests don't have to wait on a batch
+		t.memory = tracetest.NewInMemoryExporter()
+		providerOpts = append(providerOpts, sdktrace.WithSyncer(t.memory))
+	default:
+		exporter, err := newExporter(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		t.exporter = &healthExporter{SpanExporter: exporter}
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(t.exporter))
+	}
+	t.provider = sdktrace.NewTracerProvider(providerOpts...)
+	otel.SetTracerProvider(t.provider)
+
+	propagator := propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+		propagationgcp.HTTPFormat{},
+	)
+	otel.SetTextMapPropagator(propagator)
+	return t, nil
+}
+
+func newExporter(ctx context.Context, opts Options) (sdktrace.SpanExporter, error) {
+	switch opts.Exporter {
+	case ExporterCloudTrace:
+		exporter, err := texporter.NewExporter(texporter.WithProjectID(opts.ProjectID))
+		if err != nil {
+			return nil, fmt.Errorf("texporter.NewExporter(): %w", err)
+		}
+		return exporter, nil
+	case ExporterOTLPGRPC:
+		driverOpts := []otlpgrpc.Option{otlpgrpc.WithInsecure()}
+		if opts.Endpoint != "" {
+			driverOpts = append(driverOpts, otlpgrpc.WithEndpoint(opts.Endpoint))
+		}
+		exporter, err := otlp.NewExporter(ctx, otlpgrpc.NewDriver(driverOpts...))
+		if err != nil {
+			return nil, fmt.Errorf("otlp.NewExporter(grpc): %w", err)
+		}
+		return exporter, nil
+	case ExporterOTLPHTTP:
+		driverOpts := []otlphttp.Option{otlphttp.WithInsecure()}
+		if opts.Endpoint != "" {
+			driverOpts = append(driverOpts, otlphttp.WithEndpoint(opts.Endpoint))
+		}
+		exporter, err := otlp.NewExporter(ctx, otlphttp.NewDriver(driverOpts...))
+		if err != nil {
+			return nil, fmt.Errorf("otlp.NewExporter(http): %w", err)
+		}
+		return exporter, nil
+	case ExporterStdout:
+		exporter, err := stdout.NewExporter(stdout.WithPrettyPrint(), stdout.WithoutMetricExport())
+		if err != nil {
+			return nil, fmt.Errorf("stdout.NewExporter(): %w", err)
+		}
+		return exporter, nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+}
+
+// Shutdown flushes pending spans and closes the exporter, spans that haven't made it out by the ctx deadline are dropped
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	if t.exporter == nil && t.memory == nil {
+		// the sdk reports a provider without span processors as an error rather than a no op
+		return nil
+	}
+	if err := t.provider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("t.provider.Shutdown(): %w", err)
+	}
+	return nil
+}
+
+// Spans returns what the memory exporter has recorded so far, nil for every other exporter
+func (t *Tracing) Spans() []*sdktrace.SpanSnapshot {
+	if t.memory == nil {
+		return nil
+	}
+	return t.memory.GetSpans()
+}
+
+// Check fails once the last few exports in a row have failed, it never calls the backend itself so it is cheap enough
+// for every readiness probe
+func (t *Tracing) Check(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.check()
+}
+
+// healthExporter remembers how the last exports went so readiness can report a broken trace backend
+type healthExporter struct {
+	sdktrace.SpanExporter
+
+	mu        sync.Mutex
+	failures  int
+	lastErr   error
+	lastError time.Time
+}
+
+func (e *healthExporter) ExportSpans(ctx context.Context, ss []*sdktrace.SpanSnapshot) error {
+	err := e.SpanExporter.ExportSpans(ctx, ss)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.failures++
+		e.lastErr = err
+		e.lastError = time.Now()
+		return err
+	}
+	e.failures = 0
+	return nil
+}
+
+func (e *healthExporter) check() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failures >= unhealthyAfter {
+		return fmt.Errorf("last %d span exports failed, most recently at %s: %w", e.failures, e.lastError.Format(time.RFC3339), e.lastErr)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package tracex
+
+import (
+	"context"
+	"errors"
+	"github.com/matryer/is"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+type fakeExporter struct {
+	err error
+}
+
+func (f *fakeExporter) ExportSpans(ctx context.Context, ss []*sdktrace.SpanSnapshot) error {
+	return f.err
+}
+
+func (f *fakeExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func Test_healthExporter(t *testing.T) {
+	is := is.New(t)
+	fake := &fakeExporter{}
+	he := &healthExporter{SpanExporter: fake}
+	ctx := context.Background()
+
+	is.NoErr(he.check()) // healthy before anything is exported
+
+	fake.err = errors.New("permission denied")
+	for i := 0; i < unhealthyAfter-1; i++ {
+		is.True(he.ExportSpans(ctx, nil) != nil) // export errors are passed through
+	}
+	is.NoErr(he.check()) // a couple of failures are tolerated
+
+	_ = he.ExportSpans(ctx, nil)
+	is.True(errors.Is(he.check(), fake.err)) // unhealthy after enough failures in a row
+
+	fake.err = nil
+	is.NoErr(he.ExportSpans(ctx, nil)) // export error
+	is.NoErr(he.check())               // one success makes it healthy again
+}
+
+func TestInitTracing(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{
+		Exporter:       ExporterMemory,
+		ServiceName:    "gotoproduction-test",
+		ServiceVersion: "v1.2.3",
+		InstanceID:     "instance-1",
+	}
+
+	t.Run("memory exporter", func(t *testing.T) {
+		is := is.New(t)
+		opts := opts
+		opts.SampleRatio = 1
+		tracing, err := InitTracing(ctx, opts)
+		is.NoErr(err) // InitTracing error
+		defer tracing.Shutdown(ctx)
+
+		_, span := otel.Tracer("test").Start(ctx, "op")
+		span.End()
+		spans := tracing.Spans()
+		is.Equal(len(spans), 1)       // span exported as soon as it ended
+		is.Equal(spans[0].Name, "op") // span name
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range spans[0].Resource.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		is.Equal(attrs[semconv.ServiceNameKey].AsString(), "gotoproduction-test") // service name on the resource
+		is.Equal(attrs[semconv.ServiceVersionKey].AsString(), "v1.2.3")           // service version on the resource
+		is.Equal(attrs[semconv.ServiceInstanceIDKey].AsString(), "instance-1")    // instance id on the resource
+		is.NoErr(tracing.Check(ctx))                                              // nothing to check for in memory
+	})
+
+	t.Run("parent based sampling", func(t *testing.T) {
+		is := is.New(t)
+		opts := opts
+		opts.SampleRatio = 0
+		tracing, err := InitTracing(ctx, opts)
+		is.NoErr(err) // InitTracing error
+		defer tracing.Shutdown(ctx)
+
+		_, span := otel.Tracer("test").Start(ctx, "root")
+		span.End()
+		is.Equal(len(tracing.Spans()), 0) // new traces are dropped at ratio 0
+
+		parent := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1},
+			SpanID:     trace.SpanID{1},
+			TraceFlags: trace.FlagsSampled,
+			Remote:     true,
+		})
+		_, span = otel.Tracer("test").Start(trace.ContextWithRemoteSpanContext(ctx, parent), "child")
+		span.End()
+		spans := tracing.Spans()
+		is.Equal(len(spans), 1)                               // a sampled parent is always followed
+		is.Equal(spans[0].Parent.TraceID(), parent.TraceID()) // joined the caller's trace
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		is := is.New(t)
+		tracing, err := InitTracing(ctx, Options{Exporter: ExporterNone, SampleRatio: 1})
+		is.NoErr(err)                   // InitTracing error
+		is.True(tracing.Spans() == nil) // only the memory exporter keeps spans
+		is.NoErr(tracing.Shutdown(ctx)) // shutdown error
+	})
+}
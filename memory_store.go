@@ -0,0 +1,745 @@
+package gotoproduction
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a DogStore that keeps everything in process memory, handy for tests and local development
+type MemoryStore struct {
+	mu                 sync.RWMutex
+	dogs               map[string]*Dog
+	idempotencyRecords map[string]*IdempotencyRecord
+	audit              []*AuditEntry
+	outbox             map[string]*OutboxEvent
+	webhooks           map[string]*WebhookSubscription
+	webhookDeliveries  map[string]*WebhookDelivery
+	owners             map[string]*Owner
+	now                func() time.Time
+
+	// dogLog is every dog write in order for watchers to follow, changed is closed and replaced after each one
+	dogLog  []dogLogEntry
+	changed chan struct{}
+}
+
+// NewMemoryStore creates an empty in memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		dogs:               map[string]*Dog{},
+		idempotencyRecords: map[string]*IdempotencyRecord{},
+		outbox:             map[string]*OutboxEvent{},
+		webhooks:           map[string]*WebhookSubscription{},
+		webhookDeliveries:  map[string]*WebhookDelivery{},
+		owners:             map[string]*Owner{},
+		changed:            make(chan struct{}),
+		now:                func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Ping always succeeds unless the context is already done, there is nothing to reach
+func (ms *MemoryStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// GetDog retrieves 1 dog by its id
+func (ms *MemoryStore) GetDog(ctx context.Context, id string) (*Dog, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	dog, ok := ms.dogs[id]
+	if !ok {
+		return nil, ErrDogNotFound
+	}
+	return copyDog(dog), nil
+}
+
+// ListDogs filters and sorts every dog in memory, then cuts out the requested page
+func (ms *MemoryStore) ListDogs(ctx context.Context, query DogQuery) ([]*Dog, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var dogs []*Dog
+	for _, dog := range ms.dogs {
+		if query.Type != "" && dog.Type != query.Type {
+			continue
+		}
+		if query.OwnerID != "" && dog.OwnerID != query.OwnerID {
+			continue
+		}
+		if dog.DeletedAt != nil && !query.IncludeDeleted {
+			continue
+		}
+		dogs = append(dogs, copyDog(dog))
+	}
+	sort.Slice(dogs, func(i, j int) bool {
+		return cursorLess(query.OrderBy, cursorOf(dogs[i]), cursorOf(dogs[j]))
+	})
+	if cursor := query.StartAfter; cursor != nil {
+		start := sort.Search(len(dogs), func(i int) bool {
+			return cursorLess(query.OrderBy, cursor, cursorOf(dogs[i]))
+		})
+		dogs = dogs[start:]
+	}
+	if query.Limit > 0 && len(dogs) > query.Limit {
+		dogs = dogs[:query.Limit]
+	}
+	return dogs, nil
+}
+
+// SearchDogs filters every dog in memory, sorting descending searches by the reversed order so cursors work both ways
+func (ms *MemoryStore) SearchDogs(ctx context.Context, query DogSearchQuery) ([]*Dog, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	less := func(a, b *DogCursor) bool {
+		if query.Descending {
+			return cursorLess(query.OrderBy, b, a)
+		}
+		return cursorLess(query.OrderBy, a, b)
+	}
+	var dogs []*Dog
+	for _, dog := range ms.dogs {
+		if !query.matches(dog) {
+			continue
+		}
+		if query.StartAfter != nil && !less(query.StartAfter, cursorOf(dog)) {
+			continue
+		}
+		dogs = append(dogs, copyDog(dog))
+	}
+	sort.Slice(dogs, func(i, j int) bool {
+		return less(cursorOf(dogs[i]), cursorOf(dogs[j]))
+	})
+	if query.Limit > 0 && len(dogs) > query.Limit {
+		dogs = dogs[:query.Limit]
+	}
+	return dogs, nil
+}
+
+// CreateDog stores a copy of the dog under a newly generated id
+func (ms *MemoryStore) CreateDog(ctx context.Context, dog *Dog, audit *AuditEntry) (string, error) {
+	id, err := newDocID()
+	if err != nil {
+		return "", fmt.Errorf("newDocID(): %w", err)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.owners[dog.OwnerID]; dog.OwnerID != "" && !ok {
+		return "", ErrOwnerNotFound
+	}
+	dog.ID = id
+	dog.CreatedTimestamp = ms.now()
+	dog.UpdateTime = dog.CreatedTimestamp
+	audit.DogID = id
+	if err := ms.recordChange(audit, dog); err != nil {
+		return "", err
+	}
+	ms.dogs[id] = copyDog(dog)
+	ms.logChange(nil, dog, dog.UpdateTime)
+	return id, nil
+}
+
+// UpdateDog overwrites the name, age, type and deleted at of a stored dog, honouring the lastUpdateTime precondition
+func (ms *MemoryStore) UpdateDog(ctx context.Context, dog *Dog, lastUpdateTime time.Time, audit *AuditEntry) (*Dog, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	stored, ok := ms.dogs[dog.ID]
+	if !ok {
+		return nil, ErrDogNotFound
+	}
+	if !lastUpdateTime.IsZero() && !stored.UpdateTime.Equal(lastUpdateTime) {
+		return nil, ErrDogConflict
+	}
+	updated := copyDog(stored)
+	updated.Name = dog.Name
+	updated.Age = dog.Age
+	updated.Type = dog.Type
+	updated.DeletedAt = dog.DeletedAt
+	updated.UpdateTime = ms.nextUpdateTime(stored.UpdateTime)
+	if err := ms.recordChange(audit, updated); err != nil {
+		return nil, err
+	}
+	ms.dogs[dog.ID] = updated
+	ms.logChange(stored, updated, updated.UpdateTime)
+	return copyDog(updated), nil
+}
+
+// TransferDog gives a stored dog to dog.OwnerID, honouring the lastUpdateTime precondition. Dog counts are worked out
+// from the dogs, so there is nothing to keep in step
+func (ms *MemoryStore) TransferDog(ctx context.Context, dog *Dog, previousOwnerID string, lastUpdateTime time.Time, audit *AuditEntry) (*Dog, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	stored, ok := ms.dogs[dog.ID]
+	if !ok {
+		return nil, ErrDogNotFound
+	}
+	if !stored.UpdateTime.Equal(lastUpdateTime) || stored.OwnerID != previousOwnerID {
+		return nil, ErrDogConflict
+	}
+	if _, ok := ms.owners[dog.OwnerID]; !ok {
+		return nil, ErrOwnerNotFound
+	}
+	updated := copyDog(stored)
+	updated.OwnerID = dog.OwnerID
+	updated.UpdateTime = ms.nextUpdateTime(stored.UpdateTime)
+	if err := ms.recordChange(audit, updated); err != nil {
+		return nil, err
+	}
+	ms.dogs[dog.ID] = updated
+	ms.logChange(stored, updated, updated.UpdateTime)
+	return copyDog(updated), nil
+}
+
+// TransitionDog moves a stored dog to dog.Status, honouring the lastUpdateTime precondition
+func (ms *MemoryStore) TransitionDog(ctx context.Context, dog *Dog, previous DogStatus, lastUpdateTime time.Time, audit *AuditEntry) (*Dog, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	stored, ok := ms.dogs[dog.ID]
+	if !ok {
+		return nil, ErrDogNotFound
+	}
+	if !stored.UpdateTime.Equal(lastUpdateTime) || statusOf(stored) != previous {
+		return nil, ErrDogConflict
+	}
+	updated := copyDog(stored)
+	updated.Status = dog.Status
+	updated.UpdateTime = ms.nextUpdateTime(stored.UpdateTime)
+	if err := ms.recordChange(audit, updated); err != nil {
+		return nil, err
+	}
+	ms.dogs[dog.ID] = updated
+	ms.logChange(stored, updated, updated.UpdateTime)
+	return copyDog(updated), nil
+}
+
+// PurgeDogs removes the longest deleted dogs that were deleted before deletedBefore
+func (ms *MemoryStore) PurgeDogs(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var purgeable []*Dog
+	for _, dog := range ms.dogs {
+		if dog.DeletedAt != nil && dog.DeletedAt.Before(deletedBefore) {
+			purgeable = append(purgeable, dog)
+		}
+	}
+	sort.Slice(purgeable, func(i, j int) bool {
+		return purgeable[i].DeletedAt.Before(*purgeable[j].DeletedAt)
+	})
+	if limit > 0 && len(purgeable) > limit {
+		purgeable = purgeable[:limit]
+	}
+	for _, dog := range purgeable {
+		if err := ms.recordChange(newAuditEntry(ctx, AuditActionPurge, dog, nil, ms.now()), dog); err != nil {
+			return 0, err
+		}
+		delete(ms.dogs, dog.ID)
+		ms.logChange(dog, nil, ms.now())
+	}
+	return len(purgeable), nil
+}
+
+// CreateOwner stores a copy of the owner under a newly generated id
+func (ms *MemoryStore) CreateOwner(ctx context.Context, owner *Owner) (string, error) {
+	id, err := newDocID()
+	if err != nil {
+		return "", fmt.Errorf("newDocID(): %w", err)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	owner.ID = id
+	owner.CreatedTimestamp = ms.now()
+	ms.owners[id] = copyOwner(owner)
+	return id, nil
+}
+
+// GetOwner retrieves 1 owner by its id, counting its dogs
+func (ms *MemoryStore) GetOwner(ctx context.Context, id string) (*Owner, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	owner, ok := ms.owners[id]
+	if !ok {
+		return nil, ErrOwnerNotFound
+	}
+	return ms.withDogCount(owner), nil
+}
+
+// ListOwners sorts every owner by id, then cuts out the requested page
+func (ms *MemoryStore) ListOwners(ctx context.Context, query OwnerQuery) ([]*Owner, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var owners []*Owner
+	for _, owner := range ms.owners {
+		if owner.ID > query.StartAfter {
+			owners = append(owners, ms.withDogCount(owner))
+		}
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		return owners[i].ID < owners[j].ID
+	})
+	if query.Limit > 0 && len(owners) > query.Limit {
+		owners = owners[:query.Limit]
+	}
+	return owners, nil
+}
+
+// UpdateOwner overwrites the name and email of a stored owner
+func (ms *MemoryStore) UpdateOwner(ctx context.Context, owner *Owner) (*Owner, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	stored, ok := ms.owners[owner.ID]
+	if !ok {
+		return nil, ErrOwnerNotFound
+	}
+	stored.Name = owner.Name
+	stored.Email = owner.Email
+	return ms.withDogCount(stored), nil
+}
+
+// DeleteOwner removes an owner without dogs, or with cascade soft deletes its dogs and takes the owner off them first
+func (ms *MemoryStore) DeleteOwner(ctx context.Context, id string, cascade bool) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.owners[id]; !ok {
+		return ErrOwnerNotFound
+	}
+	var dogs []*Dog
+	for _, dog := range ms.dogs {
+		if dog.OwnerID == id {
+			dogs = append(dogs, dog)
+		}
+	}
+	if len(dogs) > 0 && !cascade {
+		return ErrOwnerHasDogs
+	}
+	if len(dogs) > maxCascadeDogs {
+		return fmt.Errorf("%d dogs are more than a delete can cascade to: %w", len(dogs), ErrOwnerHasDogs)
+	}
+	now := ms.now()
+	for _, dog := range dogs {
+		updated, audit := cascadeDelete(ctx, dog, now)
+		updated.UpdateTime = ms.nextUpdateTime(dog.UpdateTime)
+		if err := ms.recordChange(audit, updated); err != nil {
+			return err
+		}
+		ms.dogs[dog.ID] = updated
+		ms.logChange(dog, updated, updated.UpdateTime)
+	}
+	delete(ms.owners, id)
+	return nil
+}
+
+// withDogCount copies the owner with its dogs counted, callers hold the lock
+func (ms *MemoryStore) withDogCount(owner *Owner) *Owner {
+	c := copyOwner(owner)
+	c.DogCount = 0
+	for _, dog := range ms.dogs {
+		if dog.OwnerID == owner.ID {
+			c.DogCount++
+		}
+	}
+	return c
+}
+
+// WatchDogs sends the dogs written since the resume point, then follows the log of writes until ctx is done
+func (ms *MemoryStore) WatchDogs(ctx context.Context, request WatchDogsRequest, fn func(change DogChange) error) error {
+	ms.mu.RLock()
+	var pending []DogChange
+	if !request.Since.IsZero() {
+		for _, dog := range ms.dogs {
+			if request.Type != "" && dog.Type != request.Type {
+				continue
+			}
+			if change, ok := resumeChange(copyDog(dog), request.Since); ok {
+				pending = append(pending, change)
+			}
+		}
+		sort.Slice(pending, func(i, j int) bool {
+			return pending[i].Time.Before(pending[j].Time)
+		})
+	}
+	next, changed := len(ms.dogLog), ms.changed
+	ms.mu.RUnlock()
+	if request.Ready != nil {
+		request.Ready()
+	}
+
+	for {
+		for _, change := range pending {
+			if err := fn(change); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+		ms.mu.RLock()
+		pending = pending[:0]
+		for _, entry := range ms.dogLog[next:] {
+			if change, ok := entry.changeFor(request.Type); ok {
+				pending = append(pending, change)
+			}
+		}
+		next, changed = len(ms.dogLog), ms.changed
+		ms.mu.RUnlock()
+	}
+}
+
+// dogLogEntry is one dog write, before is nil for a create and after is nil for a purge
+type dogLogEntry struct {
+	before, after *Dog
+	at            time.Time
+}
+
+// logChange appends the write to the log and wakes up the watchers, callers hold the write lock. The log is never
+// trimmed, which is fine for tests and local development
+func (ms *MemoryStore) logChange(before, after *Dog, at time.Time) {
+	entry := dogLogEntry{at: at}
+	if before != nil {
+		entry.before = copyDog(before)
+	}
+	if after != nil {
+		entry.after = copyDog(after)
+	}
+	ms.dogLog = append(ms.dogLog, entry)
+	close(ms.changed)
+	ms.changed = make(chan struct{})
+}
+
+// changeFor is how a watch of dogType sees the write, the same way a firestore query listener would
+func (e dogLogEntry) changeFor(dogType string) (DogChange, bool) {
+	matches := func(dog *Dog) bool {
+		return dog != nil && (dogType == "" || dog.Type == dogType)
+	}
+	was, is := matches(e.before), matches(e.after)
+	switch {
+	case is && e.before == nil:
+		return DogChange{Type: EventDogCreated, Dog: copyDog(e.after), Time: e.at}, true
+	case is && was && e.after.DeletedAt != nil && e.before.DeletedAt == nil:
+		return DogChange{Type: EventDogDeleted, Dog: copyDog(e.after), Time: e.at}, true
+	case is:
+		return DogChange{Type: EventDogUpdated, Dog: copyDog(e.after), Time: e.at}, true
+	case was && e.after != nil:
+		// the type changed away from the one being watched
+		return DogChange{Type: EventDogDeleted, Dog: copyDog(e.after), Time: e.at}, true
+	}
+	// purges aren't sent, the dog was already deleted
+	return DogChange{}, false
+}
+
+// ListAuditEntries pages through the entries of one dog
+func (ms *MemoryStore) ListAuditEntries(ctx context.Context, query AuditQuery) ([]*AuditEntry, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var entries []*AuditEntry
+	for _, entry := range ms.audit {
+		if entry.DogID != query.DogID {
+			continue
+		}
+		if query.StartAfter != nil && !auditCursorLess(query.StartAfter, auditCursorOf(entry)) {
+			continue
+		}
+		entries = append(entries, copyAuditEntry(entry))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return auditCursorLess(auditCursorOf(entries[i]), auditCursorOf(entries[j]))
+	})
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+	return entries, nil
+}
+
+// recordChange stores a copy of the audit entry under a new id and queues the event announcing it, callers hold the
+// write lock
+func (ms *MemoryStore) recordChange(audit *AuditEntry, dog *Dog) error {
+	id, err := newDocID()
+	if err != nil {
+		return fmt.Errorf("newDocID(): %w", err)
+	}
+	audit.ID = id
+	ms.audit = append(ms.audit, copyAuditEntry(audit))
+	if event := outboxEventFor(audit, dog); event != nil {
+		ms.outbox[event.Event.ID] = event
+	}
+	return nil
+}
+
+// ClaimEvents leases the events that are due, oldest first
+func (ms *MemoryStore) ClaimEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*OutboxEvent, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var due []*OutboxEvent
+	for _, event := range ms.outbox {
+		if !event.NextAttemptAt.After(now) {
+			due = append(due, event)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].Event.ID < due[j].Event.ID
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	claimed := make([]*OutboxEvent, len(due))
+	for i, event := range due {
+		event.NextAttemptAt = now.Add(lease)
+		claimed[i] = copyOutboxEvent(event)
+	}
+	return claimed, nil
+}
+
+// AckEvent forgets the event
+func (ms *MemoryStore) AckEvent(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.outbox, id)
+	return nil
+}
+
+// RetryEvent overwrites the stored event, an event that was acked in the meantime stays gone
+func (ms *MemoryStore) RetryEvent(ctx context.Context, event *OutboxEvent) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.outbox[event.Event.ID]; ok {
+		ms.outbox[event.Event.ID] = copyOutboxEvent(event)
+	}
+	return nil
+}
+
+// ReserveIdempotencyKey stores a copy of record unless an unexpired one with the same key exists
+func (ms *MemoryStore) ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if stored, ok := ms.idempotencyRecords[record.Key]; ok && stored.ExpiresAt.After(ms.now()) {
+		return copyIdempotencyRecord(stored), nil
+	}
+	ms.idempotencyRecords[record.Key] = copyIdempotencyRecord(record)
+	return nil, nil
+}
+
+// CompleteIdempotencyKey overwrites the stored record with a copy of record
+func (ms *MemoryStore) CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.idempotencyRecords[record.Key] = copyIdempotencyRecord(record)
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets the record
+func (ms *MemoryStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.idempotencyRecords, key)
+	return nil
+}
+
+// CreateWebhookSubscription stores a copy of the subscription under a newly generated id
+func (ms *MemoryStore) CreateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) (string, error) {
+	id, err := newDocID()
+	if err != nil {
+		return "", fmt.Errorf("newDocID(): %w", err)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	subscription.ID = id
+	ms.webhooks[id] = copyWebhookSubscription(subscription)
+	return id, nil
+}
+
+// GetWebhookSubscription retrieves 1 subscription by its id
+func (ms *MemoryStore) GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	subscription, ok := ms.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	return copyWebhookSubscription(subscription), nil
+}
+
+// ListWebhookSubscriptions returns every subscription, oldest first
+func (ms *MemoryStore) ListWebhookSubscriptions(ctx context.Context) ([]*WebhookSubscription, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	subscriptions := make([]*WebhookSubscription, 0, len(ms.webhooks))
+	for _, subscription := range ms.webhooks {
+		subscriptions = append(subscriptions, copyWebhookSubscription(subscription))
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if !subscriptions[i].CreatedAt.Equal(subscriptions[j].CreatedAt) {
+			return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+		}
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+	return subscriptions, nil
+}
+
+// UpdateWebhookSubscription overwrites the stored subscription with a copy of subscription
+func (ms *MemoryStore) UpdateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.webhooks[subscription.ID]; !ok {
+		return ErrWebhookNotFound
+	}
+	ms.webhooks[subscription.ID] = copyWebhookSubscription(subscription)
+	return nil
+}
+
+// DeleteWebhookSubscription forgets the subscription, its deliveries are kept
+func (ms *MemoryStore) DeleteWebhookSubscription(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(ms.webhooks, id)
+	return nil
+}
+
+// RecordWebhookResult updates the failure count under the write lock
+func (ms *MemoryStore) RecordWebhookResult(ctx context.Context, id string, success bool, disableAfter int) (*WebhookSubscription, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	subscription, ok := ms.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	recordWebhookResult(subscription, success, disableAfter)
+	return copyWebhookSubscription(subscription), nil
+}
+
+// EnqueueWebhookDeliveries stores copies of the deliveries that aren't stored yet
+func (ms *MemoryStore) EnqueueWebhookDeliveries(ctx context.Context, deliveries []*WebhookDelivery) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, delivery := range deliveries {
+		if _, ok := ms.webhookDeliveries[delivery.ID]; !ok {
+			ms.webhookDeliveries[delivery.ID] = copyWebhookDelivery(delivery)
+		}
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries leases the pending deliveries that are due, oldest first
+func (ms *MemoryStore) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var due []*WebhookDelivery
+	for _, delivery := range ms.webhookDeliveries {
+		if delivery.Status == WebhookDeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	claimed := make([]*WebhookDelivery, len(due))
+	for i, delivery := range due {
+		delivery.NextAttemptAt = now.Add(lease)
+		claimed[i] = copyWebhookDelivery(delivery)
+	}
+	return claimed, nil
+}
+
+// SaveWebhookDelivery overwrites the stored delivery with a copy of delivery
+func (ms *MemoryStore) SaveWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.webhookDeliveries[delivery.ID] = copyWebhookDelivery(delivery)
+	return nil
+}
+
+// ListWebhookDeliveries returns the latest deliveries of a subscription
+func (ms *MemoryStore) ListWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]*WebhookDelivery, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var deliveries []*WebhookDelivery
+	for _, delivery := range ms.webhookDeliveries {
+		if delivery.SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, copyWebhookDelivery(delivery))
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// nextUpdateTime makes sure every write moves the update time forward, even when the clock has not ticked since the last one
+func (ms *MemoryStore) nextUpdateTime(previous time.Time) time.Time {
+	now := ms.now()
+	if !now.After(previous) {
+		return previous.Add(time.Microsecond)
+	}
+	return now
+}
+
+func copyDog(dog *Dog) *Dog {
+	c := *dog
+	if dog.DeletedAt != nil {
+		deletedAt := *dog.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
+
+func copyIdempotencyRecord(record *IdempotencyRecord) *IdempotencyRecord {
+	c := *record
+	c.Body = append([]byte(nil), record.Body...)
+	return &c
+}
+
+// cursorLess orders dogs the same way the firestore query does, by the order field and then by id
+func cursorLess(orderBy DogOrder, a, b *DogCursor) bool {
+	switch orderBy {
+	case DogOrderName:
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+	case DogOrderAge:
+		if a.Age != b.Age {
+			return a.Age < b.Age
+		}
+	default:
+		if !a.CreatedTimestamp.Equal(b.CreatedTimestamp) {
+			return a.CreatedTimestamp.Before(b.CreatedTimestamp)
+		}
+	}
+	return a.ID < b.ID
+}
+
+func cursorOf(dog *Dog) *DogCursor {
+	return &DogCursor{ID: dog.ID, Name: dog.Name, Age: dog.Age, CreatedTimestamp: dog.CreatedTimestamp}
+}
+
+const docIDAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// newDocID generates a 20 character id in the same shape firestore uses for auto generated ids
+func newDocID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("rand.Read(): %w", err)
+	}
+	for i := range b {
+		b[i] = docIDAlphabet[int(b[i])%len(docIDAlphabet)]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,81 @@
+package gotoproduction
+
+import (
+	"context"
+	"errors"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/unit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const instrumentationName = "github.com/amammay/gotoproduction"
+
+// dogServiceMetrics records how long every DogService method takes and how often it fails
+type dogServiceMetrics struct {
+	latency metric.Float64ValueRecorder
+	errors  metric.Int64Counter
+}
+
+func newDogServiceMetrics(mp metric.MeterProvider) dogServiceMetrics {
+	meter := metric.Must(mp.Meter(instrumentationName))
+	return dogServiceMetrics{
+		latency: meter.NewFloat64ValueRecorder("dogservice.operation.duration",
+			metric.WithUnit(unit.Milliseconds),
+			metric.WithDescription("latency of DogService operations by method and status code"),
+		),
+		errors: meter.NewInt64Counter("dogservice.operation.errors",
+			metric.WithDescription("failed DogService operations by method and status code"),
+		),
+	}
+}
+
+// observe records one call of method, defer it with a pointer to the method's named error so it sees the final value
+func (m dogServiceMetrics) observe(ctx context.Context, method string, start time.Time, err *error) {
+	labels := []attribute.KeyValue{
+		attribute.String("method", method),
+		attribute.String("code", errorCode(*err).String()),
+	}
+	m.latency.Record(ctx, float64(time.Since(start))/float64(time.Millisecond), labels...)
+	if *err != nil {
+		m.errors.Add(ctx, 1, labels...)
+	}
+}
+
+// errorCode maps an error onto the grpc code it stands for, firestore errors keep the code firestore gave them so a
+// storage outage shows up as UNAVAILABLE rather than a generic failure
+func errorCode(err error) codes.Code {
+	var validationErr *ValidationError
+	switch {
+	case err == nil:
+		return codes.OK
+	case errors.As(err, &validationErr), errors.Is(err, ErrInvalidPageToken), errors.Is(err, ErrInvalidOrderBy),
+		errors.Is(err, ErrUnsupportedSearch):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrDogNotFound), errors.Is(err, ErrWebhookNotFound), errors.Is(err, ErrOwnerNotFound),
+		errors.Is(err, ErrBreedNotFound):
+		return codes.NotFound
+	case errors.Is(err, ErrDogConflict), errors.Is(err, ErrOwnerConflict):
+		return codes.Aborted
+	case errors.Is(err, ErrOwnerHasDogs), errors.Is(err, ErrIllegalTransition):
+		return codes.FailedPrecondition
+	case errors.Is(err, ErrNoDogIndex):
+		return codes.Unimplemented
+	case errors.Is(err, authx.ErrUnauthenticated):
+		return codes.Unauthenticated
+	case errors.Is(err, authx.ErrPermissionDenied):
+		return codes.PermissionDenied
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	}
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		return grpcErr.GRPCStatus().Code()
+	}
+	return codes.Unknown
+}
@@ -0,0 +1,36 @@
+package gotoproduction
+
+import (
+	"context"
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/matryer/is"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func Test_errorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{name: "ok", err: nil, want: codes.OK},
+		{name: "not found", err: ErrDogNotFound, want: codes.NotFound},
+		{name: "conflict", err: ErrDogConflict, want: codes.Aborted},
+		{name: "validation", err: &ValidationError{}, want: codes.InvalidArgument},
+		{name: "page token", err: ErrInvalidPageToken, want: codes.InvalidArgument},
+		{name: "unauthenticated", err: authx.ErrUnauthenticated, want: codes.Unauthenticated},
+		{name: "denied", err: fmt.Errorf("%w: no roles", authx.ErrPermissionDenied), want: codes.PermissionDenied},
+		{name: "deadline", err: fmt.Errorf("ds.store.GetDog(): %w", context.DeadlineExceeded), want: codes.DeadlineExceeded},
+		{name: "firestore", err: fmt.Errorf("ds.store.ListDogs(): %w", status.Error(codes.Unavailable, "down")), want: codes.Unavailable},
+		{name: "unknown", err: fmt.Errorf("boom"), want: codes.Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(errorCode(tt.err), tt.want) // firestore codes survive wrapping
+		})
+	}
+}
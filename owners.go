@@ -0,0 +1,298 @@
+package gotoproduction
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"go.opentelemetry.io/otel/trace"
+	"net/mail"
+	"time"
+)
+
+// ErrOwnerNotFound represents when an owner cannot be found
+var ErrOwnerNotFound = errors.New("owner not found")
+
+// ErrOwnerHasDogs represents deleting an owner that dogs still belong to without cascading the delete to them
+var ErrOwnerHasDogs = errors.New("owner still has dogs")
+
+// ErrOwnerConflict represents when an owner or its dogs changed while the owner was being deleted
+var ErrOwnerConflict = errors.New("owner was modified concurrently")
+
+const (
+	maxEmailLength = 254
+	// maxCascadeDogs is how many dogs a cascading owner delete takes with it, every dog is three writes of one atomic
+	// batch and firestore caps those at 500
+	maxCascadeDogs = 150
+)
+
+// ownerOrder marks page tokens of the owner listing, so they can't be used to list dogs and vice versa
+const ownerOrder DogOrder = "owners"
+
+// Owner is a person dogs belong to, a dog has at most one owner
+type Owner struct {
+	ID    string `json:"id" firestore:"id"`
+	Name  string `json:"name" firestore:"name"`
+	Email string `json:"email" firestore:"email"`
+	// DogCount is how many dogs belong to the owner, soft deleted ones included until they are purged. Stores keep it in
+	// step with the dogs so a delete can tell whether the owner still has any
+	DogCount         int       `json:"dog_count" firestore:"dog_count"`
+	CreatedTimestamp time.Time `json:"created_timestamp" firestore:"created_timestamp,serverTimestamp"`
+}
+
+// OwnerQuery is what the store needs to produce one page of owners, ordered by id
+type OwnerQuery struct {
+	Limit      int
+	StartAfter string
+}
+
+// OwnerStore keeps owners next to their dogs, implementations return ErrOwnerNotFound for an owner that does not exist.
+// Which dogs belong to an owner is written with the dogs, see DogStore
+type OwnerStore interface {
+	// CreateOwner persists a new owner without dogs, assigning its id and created timestamp
+	CreateOwner(ctx context.Context, owner *Owner) (string, error)
+	GetOwner(ctx context.Context, id string) (*Owner, error)
+	// ListOwners returns up to query.Limit owners ordered by id, starting right after query.StartAfter when it is set
+	ListOwners(ctx context.Context, query OwnerQuery) ([]*Owner, error)
+	// UpdateOwner overwrites the name and email of an existing owner and returns it as written
+	UpdateOwner(ctx context.Context, owner *Owner) (*Owner, error)
+	// DeleteOwner removes an owner, failing with ErrOwnerHasDogs while dogs belong to it unless cascade is set. Cascading
+	// soft deletes the owner's live dogs and takes the owner off all of them, auditing every dog as made by the principal
+	// on ctx, in the same atomic write as the owner delete. ErrOwnerConflict means a dog came or went in the meantime
+	DeleteOwner(ctx context.Context, id string, cascade bool) error
+}
+
+// CreateOwnerRequest registers a new owner
+type CreateOwnerRequest struct {
+	Name  string
+	Email string
+}
+
+// UpdateOwnerRequest replaces the name and email of an owner
+type UpdateOwnerRequest struct {
+	Name  string
+	Email string
+}
+
+// ListOwnersRequest asks for one page of owners, PageToken is the NextPageToken of the previous page
+type ListOwnersRequest struct {
+	PageSize  int
+	PageToken string
+}
+
+// ListOwnersResponse is one page of owners, NextPageToken is empty on the last page
+type ListOwnersResponse struct {
+	Owners        []*Owner
+	NextPageToken string
+}
+
+// Validate checks a create request, returning a *ValidationError listing every failing field
+func (r *CreateOwnerRequest) Validate() error {
+	v := &validator{}
+	validateOwnerFields(v, r.Name, r.Email)
+	return v.err()
+}
+
+// Validate checks an update request, the rules are the same as for creating an owner
+func (r *UpdateOwnerRequest) Validate() error {
+	v := &validator{}
+	validateOwnerFields(v, r.Name, r.Email)
+	return v.err()
+}
+
+func validateOwnerFields(v *validator, name, email string) {
+	v.str("name", name, nameRules...)
+	v.str("email", email, required, maxLength(maxEmailLength), emailAddress)
+}
+
+func emailAddress(value string) *FieldError {
+	if address, err := mail.ParseAddress(value); err != nil || address.Address != value {
+		return &FieldError{Code: "invalid_email", Message: "must be a plain email address"}
+	}
+	return nil
+}
+
+// OwnerService manages owners, the dogs that belong to them are managed by the DogService
+type OwnerService struct {
+	store        OwnerStore
+	appLogger    *logx.AppLogger
+	pageTokenKey []byte
+	policy       *authx.Policy
+}
+
+// OwnerServiceOption tweaks how an OwnerService is built
+type OwnerServiceOption func(ows *OwnerService)
+
+// WithOwnerPolicy makes every OwnerService method check the principal on the context against the policy, without it
+// the service trusts its callers
+func WithOwnerPolicy(policy *authx.Policy) OwnerServiceOption {
+	return func(ows *OwnerService) {
+		ows.policy = policy
+	}
+}
+
+// WithOwnerPageTokenKey sets the secret owner page tokens are signed with, see WithPageTokenKey
+func WithOwnerPageTokenKey(key []byte) OwnerServiceOption {
+	return func(ows *OwnerService) {
+		ows.pageTokenKey = key
+	}
+}
+
+// NewOwnerService creates a service managing the owners in store
+func NewOwnerService(store OwnerStore, logger *logx.AppLogger, opts ...OwnerServiceOption) *OwnerService {
+	ows := &OwnerService{store: store, appLogger: logger}
+	for _, opt := range opts {
+		opt(ows)
+	}
+	if len(ows.pageTokenKey) == 0 {
+		ows.pageTokenKey = make([]byte, 32)
+		if _, err := rand.Read(ows.pageTokenKey); err != nil {
+			panic(fmt.Sprintf("rand.Read(): %v", err))
+		}
+	}
+	return ows
+}
+
+// CreateOwner registers a new owner, dogs are given to it with CreateDog or TransferDog
+func (ows *OwnerService) CreateOwner(ctx context.Context, request *CreateOwnerRequest) (*Owner, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "OwnerService.CreateOwner")
+	defer span.End()
+	if err := authorize(ctx, ows.policy, ows.appLogger, PermissionWriteOwners); err != nil {
+		return nil, err
+	}
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	owner := &Owner{Name: request.Name, Email: request.Email}
+	id, err := ows.store.CreateOwner(ctx, owner)
+	if err != nil {
+		return nil, fmt.Errorf("ows.store.CreateOwner(): %w", err)
+	}
+	ows.appLogger.WrapTraceContext(ctx).Debugw("created owner", "id", id)
+	return owner, nil
+}
+
+// GetOwner retrieves 1 owner by its id
+func (ows *OwnerService) GetOwner(ctx context.Context, id string) (*Owner, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "OwnerService.GetOwner")
+	defer span.End()
+	if err := authorize(ctx, ows.policy, ows.appLogger, PermissionReadOwners); err != nil {
+		return nil, err
+	}
+	owner, err := ows.store.GetOwner(ctx, id)
+	if errors.Is(err, ErrOwnerNotFound) {
+		return nil, ErrOwnerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ows.store.GetOwner(%q): %w", id, err)
+	}
+	return owner, nil
+}
+
+// ListOwners returns one page of owners ordered by id
+func (ows *OwnerService) ListOwners(ctx context.Context, request *ListOwnersRequest) (*ListOwnersResponse, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "OwnerService.ListOwners")
+	defer span.End()
+	if err := authorize(ctx, ows.policy, ows.appLogger, PermissionReadOwners); err != nil {
+		return nil, err
+	}
+	pageSize := request.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	query := OwnerQuery{Limit: pageSize + 1}
+	if request.PageToken != "" {
+		token, err := decodePageToken(ows.pageTokenKey, request.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		if token.OrderBy != ownerOrder {
+			return nil, ErrInvalidPageToken
+		}
+		query.StartAfter = token.ID
+	}
+
+	owners, err := ows.store.ListOwners(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("ows.store.ListOwners(): %w", err)
+	}
+	response := &ListOwnersResponse{Owners: owners}
+	if len(owners) > pageSize {
+		response.Owners = owners[:pageSize]
+		next, err := encodePageToken(ows.pageTokenKey, &pageToken{OrderBy: ownerOrder, ID: response.Owners[pageSize-1].ID})
+		if err != nil {
+			return nil, fmt.Errorf("encodePageToken(): %w", err)
+		}
+		response.NextPageToken = next
+	}
+	return response, nil
+}
+
+// UpdateOwner replaces the name and email of an owner
+func (ows *OwnerService) UpdateOwner(ctx context.Context, id string, request *UpdateOwnerRequest) (*Owner, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "OwnerService.UpdateOwner")
+	defer span.End()
+	if err := authorize(ctx, ows.policy, ows.appLogger, PermissionWriteOwners); err != nil {
+		return nil, err
+	}
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	owner, err := ows.store.UpdateOwner(ctx, &Owner{ID: id, Name: request.Name, Email: request.Email})
+	if errors.Is(err, ErrOwnerNotFound) {
+		return nil, ErrOwnerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ows.store.UpdateOwner(%q): %w", id, err)
+	}
+	return owner, nil
+}
+
+// DeleteOwner removes an owner that has no dogs. With cascade the owner's dogs are soft deleted along with it, which
+// also takes the permission to delete dogs
+func (ows *OwnerService) DeleteOwner(ctx context.Context, id string, cascade bool) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "OwnerService.DeleteOwner")
+	defer span.End()
+	if err := authorize(ctx, ows.policy, ows.appLogger, PermissionDeleteOwners); err != nil {
+		return err
+	}
+	if cascade {
+		if err := authorize(ctx, ows.policy, ows.appLogger, PermissionDeleteDogs); err != nil {
+			return err
+		}
+	}
+	err := ows.store.DeleteOwner(ctx, id, cascade)
+	switch {
+	case errors.Is(err, ErrOwnerNotFound):
+		return ErrOwnerNotFound
+	case errors.Is(err, ErrOwnerHasDogs), errors.Is(err, ErrOwnerConflict):
+		return err
+	case err != nil:
+		return fmt.Errorf("ows.store.DeleteOwner(%q): %w", id, err)
+	}
+	ows.appLogger.WrapTraceContext(ctx).Debugw("deleted owner", "id", id, "cascade", cascade)
+	return nil
+}
+
+// cascadeDelete is what deleting its owner does to a dog, live dogs are soft deleted at now and every dog loses its
+// owner so restoring one doesn't bring back a reference to an owner that is gone
+func cascadeDelete(ctx context.Context, dog *Dog, now time.Time) (*Dog, *AuditEntry) {
+	updated := copyDog(dog)
+	updated.OwnerID = ""
+	action := AuditActionUpdate
+	if updated.DeletedAt == nil {
+		updated.DeletedAt = &now
+		action = AuditActionDelete
+	}
+	return updated, newAuditEntry(ctx, action, dog, updated, now)
+}
+
+func copyOwner(owner *Owner) *Owner {
+	c := *owner
+	return &c
+}
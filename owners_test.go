@@ -0,0 +1,72 @@
+package gotoproduction_test
+
+import (
+	"context"
+	"errors"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"testing"
+	"time"
+)
+
+func TestOwnerService(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+	logger := logx.NewTesterLogger(t)
+	ds := gotoproduction.NewDogService(store, logger)
+	ows := gotoproduction.NewOwnerService(store, logger)
+
+	alice, err := ows.CreateOwner(ctx, &gotoproduction.CreateOwnerRequest{Name: "Alice", Email: "alice@example.com"})
+	is.NoErr(err) // ows.CreateOwner error
+	bob, err := ows.CreateOwner(ctx, &gotoproduction.CreateOwnerRequest{Name: "Bob", Email: "bob@example.com"})
+	is.NoErr(err) // ows.CreateOwner error
+	_, err = ows.CreateOwner(ctx, &gotoproduction.CreateOwnerRequest{Name: "Eve", Email: "Eve <eve@example.com>"})
+	var validationErr *gotoproduction.ValidationError
+	is.True(errors.As(err, &validationErr)) // only plain addresses
+
+	oscarID, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 2, Type: "Golden Doodle", OwnerID: alice.ID})
+	is.NoErr(err) // ds.CreateDog error
+	_, err = ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Rex", Age: 3, Type: "Boxer", OwnerID: "nobody"})
+	is.True(errors.As(err, &validationErr)) // the owner has to exist
+	is.Equal(validationErr.Fields[0].Field, "owner_id")
+
+	owner, err := ows.GetOwner(ctx, alice.ID)
+	is.NoErr(err) // ows.GetOwner error
+	is.Equal(owner.DogCount, 1)
+
+	oscar, err := ds.TransferDog(ctx, oscarID, &gotoproduction.TransferDogRequest{OwnerID: bob.ID})
+	is.NoErr(err) // ds.TransferDog error
+	is.Equal(oscar.OwnerID, bob.ID)
+	_, err = ds.TransferDog(ctx, oscarID, &gotoproduction.TransferDogRequest{OwnerID: alice.ID, LastUpdateTime: time.Unix(1, 0)})
+	is.Equal(err, gotoproduction.ErrDogConflict) // stale read
+	_, err = ds.TransferDog(ctx, oscarID, &gotoproduction.TransferDogRequest{OwnerID: "nobody"})
+	is.True(errors.As(err, &validationErr)) // the new owner has to exist
+	owner, err = ows.GetOwner(ctx, alice.ID)
+	is.NoErr(err)               // ows.GetOwner error
+	is.Equal(owner.DogCount, 0) // oscar moved out
+
+	page, err := ds.ListDogs(ctx, &gotoproduction.ListDogsRequest{OwnerID: bob.ID})
+	is.NoErr(err) // ds.ListDogs error
+	is.Equal(len(page.Dogs), 1)
+	is.Equal(page.Dogs[0].ID, oscarID)
+
+	history, err := ds.ListDogHistory(ctx, oscarID, &gotoproduction.ListDogHistoryRequest{})
+	is.NoErr(err) // ds.ListDogHistory error
+	transfer := history.Entries[len(history.Entries)-1]
+	is.Equal(transfer.Action, gotoproduction.AuditActionTransfer)
+	is.Equal(transfer.Changes, []gotoproduction.AuditChange{{Field: "owner_id", Before: alice.ID, After: bob.ID}})
+
+	is.Equal(ows.DeleteOwner(ctx, bob.ID, false), gotoproduction.ErrOwnerHasDogs) // oscar still belongs to bob
+	is.NoErr(ows.DeleteOwner(ctx, bob.ID, true))                                  // cascading takes oscar along
+	_, err = ows.GetOwner(ctx, bob.ID)
+	is.Equal(err, gotoproduction.ErrOwnerNotFound)
+	_, err = ds.GetDogByID(ctx, oscarID)
+	is.Equal(err, gotoproduction.ErrDogNotFound) // soft deleted
+	restored, err := ds.RestoreDog(ctx, oscarID, time.Time{})
+	is.NoErr(err)                  // ds.RestoreDog error
+	is.Equal(restored.OwnerID, "") // restored without the owner that is gone
+
+	is.NoErr(ows.DeleteOwner(ctx, alice.ID, false)) // no dogs left
+}
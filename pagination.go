@@ -0,0 +1,157 @@
+package gotoproduction
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPageSize is used when a list request does not ask for a page size
+	DefaultPageSize = 20
+	// MaxPageSize caps how many dogs a single page can hold
+	MaxPageSize = 100
+)
+
+// ErrInvalidPageToken represents a page token that was tampered with, or was issued for a different query
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// ErrInvalidOrderBy represents an ordering we do not support
+var ErrInvalidOrderBy = errors.New("invalid order by")
+
+// DogOrder is a field dogs can be listed by, ties are always broken by the dog id so pages stay stable
+type DogOrder string
+
+const (
+	DogOrderCreated DogOrder = "created_timestamp"
+	DogOrderName    DogOrder = "name"
+	// historyOrder marks page tokens of a dog's audit trail, so they can't be used to list dogs and vice versa
+	historyOrder DogOrder = "history"
+)
+
+// DogCursor marks the last dog of a page, the next page starts right after it
+type DogCursor struct {
+	ID               string
+	Name             string
+	Age              int
+	CreatedTimestamp time.Time
+}
+
+// DogQuery is what the store needs to produce one page of dogs
+type DogQuery struct {
+	// Type filters on an exact dog type when set
+	Type string
+	// OwnerID filters on the dogs of one owner when set
+	OwnerID string
+	OrderBy DogOrder
+	// Limit is the max amount of dogs to return
+	Limit      int
+	StartAfter *DogCursor
+	// IncludeDeleted returns soft deleted dogs alongside the rest
+	IncludeDeleted bool
+}
+
+// ListDogsRequest asks for one page of dogs, PageToken is the NextPageToken of the previous page
+type ListDogsRequest struct {
+	Type      string
+	OwnerID   string
+	OrderBy   DogOrder
+	PageSize  int
+	PageToken string
+	// IncludeDeleted lists soft deleted dogs too
+	IncludeDeleted bool
+}
+
+// ListDogsResponse is one page of dogs, NextPageToken is empty on the last page
+type ListDogsResponse struct {
+	Dogs          []*Dog
+	NextPageToken string
+}
+
+// pageToken is the payload we sign and hand out, it pins the query it was issued for so it cannot be replayed against another one
+type pageToken struct {
+	OrderBy DogOrder `json:"o"`
+	Type    string   `json:"t,omitempty"`
+	ID      string   `json:"i"`
+	Name    string   `json:"n,omitempty"`
+	Created int64    `json:"c,omitempty"`
+	// IncludeDeleted is part of the query, a token from a listing with deleted dogs can't page through one without them
+	IncludeDeleted bool `json:"d,omitempty"`
+	// DogID pins history tokens to the dog they were issued for
+	DogID string `json:"g,omitempty"`
+	// OwnerID is part of the query like Type
+	OwnerID string `json:"w,omitempty"`
+	// Age is the cursor of searches sorted by age
+	Age int `json:"a,omitempty"`
+	// Search fingerprints the filters of a search, see SearchDogsRequest.fingerprint. Listing tokens don't have one
+	Search string `json:"s,omitempty"`
+}
+
+func encodePageToken(key []byte, token *pageToken) (string, error) {
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal(): %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+func decodePageToken(key []byte, raw string) (*pageToken, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidPageToken
+	}
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	signature, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidPageToken
+	}
+	token := &pageToken{}
+	if err := json.Unmarshal(payload, token); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	return token, nil
+}
+
+func (t *pageToken) cursor() *DogCursor {
+	cursor := &DogCursor{ID: t.ID, Name: t.Name, Age: t.Age}
+	if t.Created != 0 {
+		cursor.CreatedTimestamp = time.Unix(0, t.Created).UTC()
+	}
+	return cursor
+}
+
+func newPageToken(orderBy DogOrder, request *ListDogsRequest, last *Dog) *pageToken {
+	token := &pageToken{OrderBy: orderBy, Type: request.Type, OwnerID: request.OwnerID, IncludeDeleted: request.IncludeDeleted, ID: last.ID}
+	switch orderBy {
+	case DogOrderName:
+		token.Name = last.Name
+	default:
+		token.Created = last.CreatedTimestamp.UnixNano()
+	}
+	return token
+}
+
+func (t *pageToken) auditCursor() *AuditCursor {
+	return &AuditCursor{ID: t.ID, Timestamp: time.Unix(0, t.Created).UTC()}
+}
+
+func newHistoryPageToken(dogID string, last *AuditEntry) *pageToken {
+	return &pageToken{OrderBy: historyOrder, DogID: dogID, ID: last.ID, Created: last.Timestamp.UnixNano()}
+}
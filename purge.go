@@ -0,0 +1,76 @@
+package gotoproduction
+
+import (
+	"context"
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// PurgePrincipal is the actor of the purge audit entries
+var PurgePrincipal = authx.Principal{Subject: "purge", Method: "system"}
+
+// Purger hard deletes dogs that have been soft deleted for longer than the retention window, it works on the store
+// directly since nobody is on the other end of it to authorize
+type Purger struct {
+	store     DogStore
+	appLogger *logx.AppLogger
+	retention time.Duration
+	batchSize int
+	now       func() time.Time
+}
+
+// NewPurger creates a purger that deletes batchSize dogs at a time once they have been deleted for retention
+func NewPurger(store DogStore, logger *logx.AppLogger, retention time.Duration, batchSize int) *Purger {
+	return &Purger{store: store, appLogger: logger, retention: retention, batchSize: batchSize, now: time.Now}
+}
+
+// Purge deletes batches until there is nothing old enough left and returns how many dogs it deleted
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	ctx, span := otel.Tracer("gotoproduction").Start(ctx, "Purger.Purge")
+	defer span.End()
+	// purges are audited like any other change, this is who they are attributed to
+	ctx = authx.NewContext(ctx, PurgePrincipal)
+	logger := p.appLogger.WrapTraceContext(ctx)
+
+	// fix the cutoff up front so dogs deleted while we run wait for the next run
+	deletedBefore := p.now().Add(-p.retention)
+	purged := 0
+	for {
+		n, err := p.store.PurgeDogs(ctx, deletedBefore, p.batchSize)
+		purged += n
+		if err != nil {
+			return purged, fmt.Errorf("p.store.PurgeDogs(): %w", err)
+		}
+		if n > 0 {
+			logger.Debugw("purged batch", "dogs", n)
+		}
+		if n < p.batchSize {
+			span.SetAttributes(attribute.Int("purged", purged))
+			return purged, nil
+		}
+	}
+}
+
+// Run purges every interval until ctx is done, a failed run is logged and retried on the next tick
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	logger := p.appLogger.WrapTraceContext(ctx)
+	for {
+		purged, err := p.Purge(ctx)
+		if err != nil {
+			logger.Errorw("purging deleted dogs", "purged", purged, "err", err)
+		} else if purged > 0 {
+			logger.Infow("purged deleted dogs", "purged", purged, "retention", p.retention.String())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package gotoproduction_test
+
+import (
+	"context"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"testing"
+	"time"
+)
+
+func TestPurger_Purge(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+	ds := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+
+	var deleted []string
+	for _, name := range []string{"Oscar", "Bella", "Archie", "Daisy"} {
+		id, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: name, Age: 1, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+		if name != "Daisy" {
+			is.NoErr(ds.DeleteDog(ctx, id, time.Time{})) // ds.DeleteDog error
+			deleted = append(deleted, id)
+		}
+	}
+
+	purged, err := gotoproduction.NewPurger(store, logx.NewTesterLogger(t), time.Hour, 2).Purge(ctx)
+	is.NoErr(err)       // Purge error
+	is.Equal(purged, 0) // nothing is past the retention window yet
+
+	// a negative retention puts the cutoff in the future, so everything deleted so far is old enough
+	purged, err = gotoproduction.NewPurger(store, logx.NewTesterLogger(t), -time.Hour, 2).Purge(ctx)
+	is.NoErr(err)       // Purge error
+	is.Equal(purged, 3) // every deleted dog, over two batches
+
+	for _, id := range deleted {
+		_, err := ds.GetDogByID(ctx, id, gotoproduction.IncludeDeleted(true))
+		is.Equal(err, gotoproduction.ErrDogNotFound) // gone for good
+		history, err := ds.ListDogHistory(ctx, id, &gotoproduction.ListDogHistoryRequest{})
+		is.NoErr(err) // ds.ListDogHistory error
+		purge := history.Entries[len(history.Entries)-1]
+		is.Equal(purge.Action, gotoproduction.AuditActionPurge)      // the purge is audited
+		is.Equal(purge.Actor, gotoproduction.PurgePrincipal.Subject) // as the purge job
+	}
+	dogs, err := ds.FindDogByType(ctx, "Golden Doodle")
+	is.NoErr(err)          // ds.FindDogByType error
+	is.Equal(len(dogs), 1) // live dogs are never purged
+}
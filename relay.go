@@ -0,0 +1,126 @@
+package gotoproduction
+
+import (
+	"context"
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// Relay publishes the events waiting in the outbox. Delivery is at least once: an event is only removed after
+// Publish succeeded, so a relay dying in between publishes it again and consumers dedupe on the event id
+type Relay struct {
+	store     OutboxStore
+	publisher EventPublisher
+	appLogger *logx.AppLogger
+	batchSize int
+	// lease is how long a claimed event is hidden from other relays, it has to outlast publishing a batch
+	lease      time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	now        func() time.Time
+}
+
+// RelayOption tweaks how a Relay is built
+type RelayOption func(r *Relay)
+
+// WithRelayBatchSize sets how many events are claimed at a time
+func WithRelayBatchSize(n int) RelayOption {
+	return func(r *Relay) {
+		r.batchSize = n
+	}
+}
+
+// WithRelayBackoff sets the delay after the first failed attempt, it doubles with every further failure up to max
+func WithRelayBackoff(min, max time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.minBackoff = min
+		r.maxBackoff = max
+	}
+}
+
+// NewRelay creates a relay moving events from store to publisher
+func NewRelay(store OutboxStore, publisher EventPublisher, logger *logx.AppLogger, opts ...RelayOption) *Relay {
+	r := &Relay{
+		store:      store,
+		publisher:  publisher,
+		appLogger:  logger,
+		batchSize:  100,
+		lease:      time.Minute,
+		minBackoff: time.Second,
+		maxBackoff: 10 * time.Minute,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// RelayOnce publishes one batch of due events and says how many made it, failed events are rescheduled with backoff
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	ctx, span := otel.Tracer("gotoproduction").Start(ctx, "Relay.RelayOnce")
+	defer span.End()
+	logger := r.appLogger.WrapTraceContext(ctx)
+
+	events, err := r.store.ClaimEvents(ctx, r.now(), r.lease, r.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("r.store.ClaimEvents(): %w", err)
+	}
+	published := 0
+	for _, event := range events {
+		if err := r.publisher.Publish(ctx, event.Event); err != nil {
+			event.Attempts++
+			event.LastError = err.Error()
+			event.NextAttemptAt = r.now().Add(backoff(r.minBackoff, r.maxBackoff, event.Attempts))
+			logger.Warnw("publishing event failed", "event_id", event.Event.ID, "event_type", event.Event.Type, "attempts", event.Attempts, "next_attempt_at", event.NextAttemptAt, "err", err)
+			if err := r.store.RetryEvent(ctx, event); err != nil {
+				// the lease runs out eventually and the event is retried anyway, just sooner than the backoff wanted
+				logger.Errorw("rescheduling event", "event_id", event.Event.ID, "err", err)
+			}
+			continue
+		}
+		published++
+		if err := r.store.AckEvent(ctx, event.Event.ID); err != nil {
+			// the event goes out again once the lease runs out, that is what the dedupe id is for
+			logger.Errorw("acking published event", "event_id", event.Event.ID, "err", err)
+		}
+	}
+	span.SetAttributes(attribute.Int("claimed", len(events)), attribute.Int("published", published))
+	return published, nil
+}
+
+// Run relays every interval until ctx is done, a full batch is followed straight away by the next one
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	logger := r.appLogger.WrapTraceContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		published, err := r.RelayOnce(ctx)
+		if err != nil {
+			logger.Errorw("relaying events", "err", err)
+		}
+		if err == nil && published == r.batchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// backoff doubles min for every failed attempt after the first, capped at max
+func backoff(min, max time.Duration, attempts int) time.Duration {
+	d := min
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
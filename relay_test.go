@@ -0,0 +1,88 @@
+package gotoproduction_test
+
+import (
+	"context"
+	"errors"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/eventx"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"testing"
+	"time"
+)
+
+func TestRelay_RelayOnce(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+	ds := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+
+	id, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 2, Type: "Golden Doodle"})
+	is.NoErr(err) // ds.CreateDog error
+	name := "Oscar II"
+	_, err = ds.PatchDog(ctx, id, &gotoproduction.PatchDogRequest{Name: &name})
+	is.NoErr(err)                                // ds.PatchDog error
+	is.NoErr(ds.DeleteDog(ctx, id, time.Time{})) // ds.DeleteDog error
+
+	publisher := &eventx.MemoryPublisher{}
+	publisher.SetErr(errors.New("pubsub is down"))
+	// no backoff, so a failed event is due again on the very next run
+	relay := gotoproduction.NewRelay(store, publisher, logx.NewTesterLogger(t), gotoproduction.WithRelayBackoff(0, 0))
+
+	published, err := relay.RelayOnce(ctx)
+	is.NoErr(err)          // RelayOnce error
+	is.Equal(published, 0) // publisher is failing
+
+	publisher.SetErr(nil)
+	published, err = relay.RelayOnce(ctx)
+	is.NoErr(err)          // RelayOnce error
+	is.Equal(published, 3) // failed events are retried
+
+	events := publisher.Events()
+	is.Equal(len(events), 3)
+	is.Equal(events[0].Type, gotoproduction.EventDogCreated)
+	is.Equal(events[0].DogID, id)
+	is.Equal(events[0].Dog.Name, "Oscar") // the dog as it was created
+	var types []string
+	for _, event := range events {
+		is.True(event.ID != "") // every event has a dedupe id
+		types = append(types, event.Type)
+	}
+	is.True(containsString(types, gotoproduction.EventDogUpdated)) // the patch
+	is.True(containsString(types, gotoproduction.EventDogDeleted)) // the soft delete
+
+	published, err = relay.RelayOnce(ctx)
+	is.NoErr(err)          // RelayOnce error
+	is.Equal(published, 0) // published events are gone from the outbox
+}
+
+func TestRelay_RelayOnce_backoff(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+	ds := gotoproduction.NewDogService(store, logx.NewTesterLogger(t))
+	_, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Bella", Age: 1, Type: "Poodle"})
+	is.NoErr(err) // ds.CreateDog error
+
+	publisher := &eventx.MemoryPublisher{}
+	publisher.SetErr(errors.New("pubsub is down"))
+	relay := gotoproduction.NewRelay(store, publisher, logx.NewTesterLogger(t), gotoproduction.WithRelayBackoff(time.Hour, time.Hour))
+	published, err := relay.RelayOnce(ctx)
+	is.NoErr(err)          // RelayOnce error
+	is.Equal(published, 0) // publisher is failing
+
+	publisher.SetErr(nil)
+	published, err = relay.RelayOnce(ctx)
+	is.NoErr(err)          // RelayOnce error
+	is.Equal(published, 0) // the failed event waits out its backoff
+	is.Equal(len(publisher.Events()), 0)
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
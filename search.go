@@ -0,0 +1,246 @@
+package gotoproduction
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedSearch represents a combination of filters and sorting firestore can't serve with the indexes we ship
+var ErrUnsupportedSearch = errors.New("unsupported search")
+
+// DogOrderAge sorts dogs by age, only searches can be sorted by it
+const DogOrderAge DogOrder = "age"
+
+// maxSearchTypes is how many types a search can ask for at once, firestore caps in filters at 10 values
+const maxSearchTypes = 10
+
+// SearchDogsRequest asks for one page of live dogs matching every filter that is set. Firestore allows range filters
+// on one field only and then has to sort by that field first, so NamePrefix, the age range and the created range can't
+// be combined and OrderBy defaults to whichever of them is set. PageToken is the NextPageToken of the previous page
+type SearchDogsRequest struct {
+	// NamePrefix matches names that start with it, case sensitively
+	NamePrefix string
+	MinAge     *int
+	MaxAge     *int
+	// Types matches dogs of any of these breeds, by any of their names
+	Types         []string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	OrderBy       DogOrder
+	Descending    bool
+	PageSize      int
+	PageToken     string
+}
+
+// DogSearchQuery is what the store needs to produce one page of a search, Types are canonical breed ids
+type DogSearchQuery struct {
+	NamePrefix    string
+	MinAge        *int
+	MaxAge        *int
+	Types         []string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	OrderBy       DogOrder
+	Descending    bool
+	// Limit is the max amount of dogs to return
+	Limit      int
+	StartAfter *DogCursor
+}
+
+// Validate checks the values of a search request, returning a *ValidationError listing every failing field. Whether
+// the filters can be combined is up to SearchDogs
+func (r *SearchDogsRequest) Validate() error {
+	v := &validator{}
+	v.str("name_prefix", r.NamePrefix, maxLength(maxNameLength))
+	if r.MinAge != nil {
+		v.int("min_age", *r.MinAge, ageRules...)
+	}
+	if r.MaxAge != nil {
+		v.int("max_age", *r.MaxAge, ageRules...)
+	}
+	if r.MinAge != nil && r.MaxAge != nil && *r.MinAge > *r.MaxAge {
+		v.fields = append(v.fields, FieldError{Field: "max_age", Code: "out_of_range", Message: "must not be less than min_age"})
+	}
+	if len(r.Types) > maxSearchTypes {
+		v.fields = append(v.fields, FieldError{Field: "type", Code: "too_many", Message: fmt.Sprintf("must list at most %d types", maxSearchTypes)})
+	}
+	for _, dogType := range r.Types {
+		v.str("type", dogType, typeRules...)
+	}
+	if !r.CreatedAfter.IsZero() && !r.CreatedBefore.IsZero() && !r.CreatedAfter.Before(r.CreatedBefore) {
+		v.fields = append(v.fields, FieldError{Field: "created_before", Code: "out_of_range", Message: "must be after created_after"})
+	}
+	return v.err()
+}
+
+// rangeField is the field the request has range filters on, empty without any. More than one is unsupported
+func (r *SearchDogsRequest) rangeField() (DogOrder, error) {
+	var fields []DogOrder
+	if r.NamePrefix != "" {
+		fields = append(fields, DogOrderName)
+	}
+	if r.MinAge != nil || r.MaxAge != nil {
+		fields = append(fields, DogOrderAge)
+	}
+	if !r.CreatedAfter.IsZero() || !r.CreatedBefore.IsZero() {
+		fields = append(fields, DogOrderCreated)
+	}
+	if len(fields) > 1 {
+		return "", fmt.Errorf("%w: name_prefix, the age range and the created range can't be combined, %s and %s were given", ErrUnsupportedSearch, fields[0], fields[1])
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
+}
+
+// orderBy resolves the field the search is sorted by, checking it is one the range filter allows
+func (r *SearchDogsRequest) orderBy() (DogOrder, error) {
+	rangeField, err := r.rangeField()
+	if err != nil {
+		return "", err
+	}
+	orderBy := r.OrderBy
+	switch {
+	case orderBy == "" && rangeField != "":
+		orderBy = rangeField
+	case orderBy == "":
+		orderBy = DogOrderCreated
+	case orderBy != DogOrderCreated && orderBy != DogOrderName && orderBy != DogOrderAge:
+		return "", ErrInvalidOrderBy
+	}
+	if rangeField != "" && orderBy != rangeField {
+		return "", fmt.Errorf("%w: a search filtered on %s has to be sorted by %s", ErrUnsupportedSearch, rangeField, rangeField)
+	}
+	return orderBy, nil
+}
+
+// fingerprint identifies the filters and sorting of a search, page tokens carry it so they only page through the search
+// they were issued for
+func (r *SearchDogsRequest) fingerprint(orderBy DogOrder, types []string) string {
+	payload, _ := json.Marshal([]interface{}{r.NamePrefix, r.MinAge, r.MaxAge, types, r.CreatedAfter.UnixNano(), r.CreatedBefore.UnixNano(), orderBy, r.Descending})
+	sum := sha256.Sum256(payload)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// SearchDogs returns one page of live dogs matching every filter set on request. Filters and sorting firestore would
+// need an index for that we don't ship fail with ErrUnsupportedSearch, see firestore.indexes.json
+func (ds *DogService) SearchDogs(ctx context.Context, request *SearchDogsRequest) (_ *ListDogsResponse, err error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.SearchDogs")
+	defer span.End()
+	defer ds.metrics.observe(ctx, "SearchDogs", time.Now(), &err)
+	if err := ds.authorize(ctx, PermissionReadDogs); err != nil {
+		return nil, err
+	}
+	logger := ds.appLogger.WrapTraceContext(ctx)
+
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	orderBy, err := request.orderBy()
+	if err != nil {
+		return nil, err
+	}
+	pageSize := request.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	// different spellings of the same set of breeds are the same search
+	var types []string
+	seen := map[string]bool{}
+	for _, dogType := range request.Types {
+		if id := defaultBreedCatalog.Canonical(dogType); !seen[id] {
+			seen[id] = true
+			types = append(types, id)
+		}
+	}
+	sort.Strings(types)
+	fingerprint := request.fingerprint(orderBy, types)
+
+	query := DogSearchQuery{
+		NamePrefix:    request.NamePrefix,
+		MinAge:        request.MinAge,
+		MaxAge:        request.MaxAge,
+		Types:         types,
+		CreatedAfter:  request.CreatedAfter,
+		CreatedBefore: request.CreatedBefore,
+		OrderBy:       orderBy,
+		Descending:    request.Descending,
+		Limit:         pageSize + 1,
+	}
+	if request.PageToken != "" {
+		token, err := decodePageToken(ds.pageTokenKey, request.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		if token.Search != fingerprint {
+			return nil, ErrInvalidPageToken
+		}
+		query.StartAfter = token.cursor()
+	}
+	logger.Debugw("searching store", "types", types, "order_by", orderBy, "descending", request.Descending, "page_size", pageSize)
+
+	dogs, err := ds.store.SearchDogs(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("ds.store.SearchDogs(): %w", err)
+	}
+	response := &ListDogsResponse{Dogs: dogs}
+	if len(dogs) > pageSize {
+		response.Dogs = dogs[:pageSize]
+		last := response.Dogs[pageSize-1]
+		token := &pageToken{OrderBy: orderBy, Search: fingerprint, ID: last.ID}
+		switch orderBy {
+		case DogOrderName:
+			token.Name = last.Name
+		case DogOrderAge:
+			token.Age = last.Age
+		default:
+			token.Created = last.CreatedTimestamp.UnixNano()
+		}
+		next, err := encodePageToken(ds.pageTokenKey, token)
+		if err != nil {
+			return nil, fmt.Errorf("encodePageToken(): %w", err)
+		}
+		response.NextPageToken = next
+	}
+	return response, nil
+}
+
+// matches says whether a dog passes every filter of the query, for stores that filter in memory
+func (q *DogSearchQuery) matches(dog *Dog) bool {
+	if dog.DeletedAt != nil {
+		return false
+	}
+	if !strings.HasPrefix(dog.Name, q.NamePrefix) {
+		return false
+	}
+	if (q.MinAge != nil && dog.Age < *q.MinAge) || (q.MaxAge != nil && dog.Age > *q.MaxAge) {
+		return false
+	}
+	if !q.CreatedAfter.IsZero() && !dog.CreatedTimestamp.After(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !dog.CreatedTimestamp.Before(q.CreatedBefore) {
+		return false
+	}
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, dogType := range q.Types {
+		if dog.Type == dogType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package gotoproduction
+
+import (
+	"context"
+	"time"
+)
+
+// DogStore is the persistence layer behind the DogService, implementations must return ErrDogNotFound when a dog does not exist
+// and ErrDogConflict when a write precondition does not hold. Every write stores its AuditEntry and the DogEvent
+// announcing it atomically with the change, either all of them happen or none does. Writes that give a dog an owner or
+// take it away keep Owner.DogCount in step in that same atomic write
+type DogStore interface {
+	// GetDog retrieves 1 dog by its id, soft deleted or not
+	GetDog(ctx context.Context, id string) (*Dog, error)
+	// ListDogs returns up to query.Limit dogs in the requested order, starting right after the cursor when one is given.
+	// Soft deleted dogs are left out unless query.IncludeDeleted is set
+	ListDogs(ctx context.Context, query DogQuery) ([]*Dog, error)
+	// SearchDogs returns up to query.Limit live dogs matching every filter of the query, sorted by query.OrderBy and then
+	// id in the requested direction, starting right after the cursor when one is given
+	SearchDogs(ctx context.Context, query DogSearchQuery) ([]*Dog, error)
+	// CreateDog persists a new dog and its audit entry, assigning the dog id to both and the created timestamp to the dog.
+	// A dog with an owner fails with ErrOwnerNotFound when the owner does not exist
+	CreateDog(ctx context.Context, dog *Dog, audit *AuditEntry) (string, error)
+	// UpdateDog overwrites the mutable fields of an existing dog, deleted_at included, when lastUpdateTime is set the write
+	// only succeeds if the stored dog has not been modified since then. The owner and status are left as they are
+	UpdateDog(ctx context.Context, dog *Dog, lastUpdateTime time.Time, audit *AuditEntry) (*Dog, error)
+	// TransferDog moves a dog from previousOwnerID, which is empty for a dog without an owner, to dog.OwnerID. It only
+	// succeeds if the stored dog has not been modified since lastUpdateTime, and fails with ErrOwnerNotFound when the new
+	// owner does not exist
+	TransferDog(ctx context.Context, dog *Dog, previousOwnerID string, lastUpdateTime time.Time, audit *AuditEntry) (*Dog, error)
+	// TransitionDog moves a dog from status previous to dog.Status. It only succeeds if the stored dog has not been
+	// modified since lastUpdateTime, so two transitions from the same status can't both happen
+	TransitionDog(ctx context.Context, dog *Dog, previous DogStatus, lastUpdateTime time.Time, audit *AuditEntry) (*Dog, error)
+	// PurgeDogs hard deletes up to limit dogs that were soft deleted before deletedBefore, oldest first, and says how many
+	// it deleted. Each purge is audited with a purge entry made by the principal on ctx, and no longer counts towards
+	// its owner
+	PurgeDogs(ctx context.Context, deletedBefore time.Time, limit int) (int, error)
+	// ListAuditEntries returns up to query.Limit entries of a dog's history ordered by timestamp and then id, starting
+	// right after the cursor when one is given. History outlives the dog, purged dogs still have theirs
+	ListAuditEntries(ctx context.Context, query AuditQuery) ([]*AuditEntry, error)
+	// Ping does the cheapest possible round trip to the backing database so readiness checks know it is reachable
+	Ping(ctx context.Context) error
+}
+
+// IdempotencyRecord remembers the outcome of a request made with an idempotency key, so a retry gets the same response
+// instead of doing the work twice
+type IdempotencyRecord struct {
+	// Key identifies the record, callers scope it to the client so two clients can't collide
+	Key string `firestore:"key"`
+	// Fingerprint is a hash of the request, a retry has to match it
+	Fingerprint string `firestore:"fingerprint"`
+	// Completed is false while the first request is still being served
+	Completed   bool      `firestore:"completed"`
+	Status      int       `firestore:"status"`
+	ContentType string    `firestore:"content_type"`
+	Body        []byte    `firestore:"body"`
+	ExpiresAt   time.Time `firestore:"expires_at"`
+}
+
+// IdempotencyStore keeps IdempotencyRecords next to the dogs, expired records must be treated as if they did not exist
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey atomically stores record unless an unexpired record with the same key exists, the existing
+	// record is returned in that case and nil when record was stored
+	ReserveIdempotencyKey(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error)
+	// CompleteIdempotencyKey overwrites a reserved record with the response that was sent
+	CompleteIdempotencyKey(ctx context.Context, record *IdempotencyRecord) error
+	// ReleaseIdempotencyKey deletes a record so the key can be used again, for requests that failed without side effects
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
@@ -0,0 +1,147 @@
+package gotoproduction_test
+
+import (
+	"context"
+	"errors"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/testx"
+	"github.com/matryer/is"
+	"github.com/testcontainers/testcontainers-go"
+	"testing"
+	"time"
+)
+
+// newStoreFunc hands each sub test a fresh, empty store
+type newStoreFunc func(t *testing.T) gotoproduction.DogStore
+
+// the DogStore contract, against firestore in a container
+func TestDogStore(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	fsContainer, err := testx.CreateFirestoreContainer(ctx)
+	if err != nil {
+		t.Fatalf("createFirestoreContainer() err = %v; want nil", err)
+	}
+	t.Cleanup(func() {
+		if err := fsContainer.Terminate(ctx); err != nil {
+			t.Fatalf("fsContainer.Terminate() err = %v; want nil", err)
+		}
+	})
+	endpoint, err := fsContainer.Endpoint(ctx, "")
+	if err != nil {
+		t.Fatalf("fsContainer.Endpoint() err = %v; want nil", err)
+	}
+	fsClient := testx.NewFirestoreTestingClient(ctx, t, endpoint)
+
+	testDogStore(t, func(t *testing.T) gotoproduction.DogStore {
+		fsClient.ClearData(t)
+		return gotoproduction.NewFirestoreStore(fsClient.Client)
+	})
+	t.Run("BackfillDogs", func(t *testing.T) {
+		is := is.New(t)
+		fsClient.ClearData(t)
+		store := gotoproduction.NewFirestoreStore(fsClient.Client)
+
+		// written before soft delete and watches existed, without a deleted_at or updated_at field
+		legacy, err := fsClient.Client.Collection("dogs").Doc("legacy").Set(ctx, map[string]interface{}{
+			"id": "legacy", "name": "Oscar", "age": 2, "type": "beagle", "created_timestamp": time.Now(),
+		})
+		is.NoErr(err) // Set error
+		_, err = store.CreateDog(ctx, &gotoproduction.Dog{Name: "Rex", Age: 1, Type: "boxer"}, &gotoproduction.AuditEntry{Action: gotoproduction.AuditActionCreate})
+		is.NoErr(err) // store.CreateDog error
+
+		dogs, err := store.ListDogs(ctx, gotoproduction.DogQuery{Limit: 10})
+		is.NoErr(err)          // store.ListDogs error
+		is.Equal(len(dogs), 1) // the legacy dog is missing
+
+		result, err := store.BackfillDogs(ctx, 1)
+		is.NoErr(err)                  // store.BackfillDogs error
+		is.Equal(result.Scanned, 2)    // every dog, one per batch
+		is.Equal(result.Backfilled, 1) // only the legacy dog
+
+		dogs, err = store.ListDogs(ctx, gotoproduction.DogQuery{Limit: 10})
+		is.NoErr(err)          // store.ListDogs error
+		is.Equal(len(dogs), 2) // the legacy dog is back
+		snapshot, err := fsClient.Client.Collection("dogs").Doc("legacy").Get(ctx)
+		is.NoErr(err) // Get error
+		updatedAt, err := snapshot.DataAt("updated_at")
+		is.NoErr(err)                                                  // updated_at is set
+		is.True(sameInstant(updatedAt.(time.Time), legacy.UpdateTime)) // to when the dog was last changed, not the backfill
+
+		result, err = store.BackfillDogs(ctx, 10)
+		is.NoErr(err)                  // store.BackfillDogs error
+		is.Equal(result.Backfilled, 0) // nothing left to do
+	})
+}
+
+// the same contract against the in memory store, so the two can't drift apart unnoticed where docker is missing
+func TestDogStore_memoryStore(t *testing.T) {
+	testDogStore(t, func(t *testing.T) gotoproduction.DogStore {
+		return gotoproduction.NewMemoryStore()
+	})
+}
+
+func testDogStore(t *testing.T, newStore newStoreFunc) {
+	t.Run("CreateDog", testDogStore_CreateDog(newStore))
+	t.Run("UpdateDog", testDogStore_UpdateDog(newStore))
+}
+
+// sameInstant compares times the way the stores keep them, firestore only has microseconds
+func sameInstant(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -time.Microsecond && d < time.Microsecond
+}
+
+func testDogStore_CreateDog(newStore newStoreFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		store := newStore(t)
+
+		before := time.Now().Add(-time.Minute)
+		dog := &gotoproduction.Dog{Name: "Oscar", Age: 2, Type: "beagle", Status: gotoproduction.DogStatusAvailable}
+		id, err := store.CreateDog(ctx, dog, &gotoproduction.AuditEntry{Action: gotoproduction.AuditActionCreate})
+		is.NoErr(err)                                         // store.CreateDog error
+		is.True(id != "")                                     // id assigned
+		is.Equal(dog.ID, id)                                  // to the dog too
+		is.True(dog.CreatedTimestamp.After(before))           // created timestamp assigned
+		is.True(!dog.UpdateTime.Before(dog.CreatedTimestamp)) // update time assigned
+
+		stored, err := store.GetDog(ctx, id)
+		is.NoErr(err)                                                       // store.GetDog error
+		is.Equal(stored.Name, "Oscar")                                      // stored as given
+		is.True(sameInstant(stored.CreatedTimestamp, dog.CreatedTimestamp)) // what CreateDog said it is
+		is.True(sameInstant(stored.UpdateTime, dog.UpdateTime))             // what CreateDog said it is
+
+		_, err = store.GetDog(ctx, "missing")
+		is.True(errors.Is(err, gotoproduction.ErrDogNotFound)) // unknown id
+	}
+}
+
+func testDogStore_UpdateDog(newStore newStoreFunc) func(t *testing.T) {
+	return func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		store := newStore(t)
+
+		dog := &gotoproduction.Dog{Name: "Oscar", Age: 2, Type: "beagle", Status: gotoproduction.DogStatusAvailable}
+		id, err := store.CreateDog(ctx, dog, &gotoproduction.AuditEntry{Action: gotoproduction.AuditActionCreate})
+		is.NoErr(err) // store.CreateDog error
+
+		renamed := *dog
+		renamed.Name = "Rex"
+		updated, err := store.UpdateDog(ctx, &renamed, dog.UpdateTime, &gotoproduction.AuditEntry{Action: gotoproduction.AuditActionUpdate})
+		is.NoErr(err)                                     // store.UpdateDog error
+		is.Equal(updated.Name, "Rex")                     // written
+		is.True(updated.UpdateTime.After(dog.UpdateTime)) // update time moved on
+
+		_, err = store.UpdateDog(ctx, &renamed, dog.UpdateTime, &gotoproduction.AuditEntry{Action: gotoproduction.AuditActionUpdate})
+		is.True(errors.Is(err, gotoproduction.ErrDogConflict)) // stale update time
+
+		stored, err := store.GetDog(ctx, id)
+		is.NoErr(err)                                                       // store.GetDog error
+		is.Equal(stored.Name, "Rex")                                        // only the first update landed
+		is.True(sameInstant(stored.CreatedTimestamp, dog.CreatedTimestamp)) // created timestamp never changes
+	}
+}
@@ -0,0 +1,164 @@
+package gotoproduction
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxNameLength = 64
+	maxTypeLength = 64
+	maxDogAge     = 30
+)
+
+// FieldError describes why a single field failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field of a request that failed validation, not just the first one
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", f.Field, f.Message))
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// stringRule checks a single string value, returning nil when the value is fine
+type stringRule func(value string) *FieldError
+
+// intRule checks a single int value, returning nil when the value is fine
+type intRule func(value int) *FieldError
+
+// validator collects field errors, rules for a field stop at the first failure so each field reports at most once
+type validator struct {
+	fields []FieldError
+}
+
+func (v *validator) str(field, value string, rules ...stringRule) {
+	for _, rule := range rules {
+		if fe := rule(value); fe != nil {
+			fe.Field = field
+			v.fields = append(v.fields, *fe)
+			return
+		}
+	}
+}
+
+func (v *validator) int(field string, value int, rules ...intRule) {
+	for _, rule := range rules {
+		if fe := rule(value); fe != nil {
+			fe.Field = field
+			v.fields = append(v.fields, *fe)
+			return
+		}
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+func required(value string) *FieldError {
+	if strings.TrimSpace(value) == "" {
+		return &FieldError{Code: "required", Message: "must not be blank"}
+	}
+	return nil
+}
+
+func maxLength(n int) stringRule {
+	return func(value string) *FieldError {
+		if utf8.RuneCountInString(value) > n {
+			return &FieldError{Code: "too_long", Message: fmt.Sprintf("must be at most %d characters", n)}
+		}
+		return nil
+	}
+}
+
+// nameCharacters allows letters, digits, spaces and the punctuation that shows up in real dog names
+func nameCharacters(value string) *FieldError {
+	for _, r := range value {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ' || strings.ContainsRune("'-.", r) {
+			continue
+		}
+		return &FieldError{Code: "invalid_characters", Message: fmt.Sprintf("must not contain %q", r)}
+	}
+	return nil
+}
+
+// knownBreed accepts the id, name or any alias of a breed in the catalog
+func knownBreed(value string) *FieldError {
+	if _, ok := defaultBreedCatalog.Lookup(value); ok {
+		return nil
+	}
+	return &FieldError{Code: "unknown_breed", Message: fmt.Sprintf("%q is not a known breed", value)}
+}
+
+func between(min, max int) intRule {
+	return func(value int) *FieldError {
+		if value < min || value > max {
+			return &FieldError{Code: "out_of_range", Message: fmt.Sprintf("must be between %d and %d", min, max)}
+		}
+		return nil
+	}
+}
+
+// Validate checks a create request, returning a *ValidationError listing every failing field
+func (r *CreateDogRequest) Validate() error {
+	v := &validator{}
+	validateDogFields(v, r.Name, r.Age, r.Type)
+	return v.err()
+}
+
+// Validate checks a transfer request, the owner has to be named
+func (r *TransferDogRequest) Validate() error {
+	v := &validator{}
+	v.str("owner_id", r.OwnerID, required)
+	return v.err()
+}
+
+// Validate checks an update request, the rules are the same as for creating a dog
+func (r *UpdateDogRequest) Validate() error {
+	v := &validator{}
+	validateDogFields(v, r.Name, r.Age, r.Type)
+	return v.err()
+}
+
+// Validate checks only the fields the patch sets
+func (r *PatchDogRequest) Validate() error {
+	v := &validator{}
+	if r.Name != nil {
+		v.str("name", *r.Name, nameRules...)
+	}
+	if r.Age != nil {
+		v.int("age", *r.Age, ageRules...)
+	}
+	if r.Type != nil {
+		v.str("type", *r.Type, typeRules...)
+	}
+	return v.err()
+}
+
+var (
+	nameRules = []stringRule{required, maxLength(maxNameLength), nameCharacters}
+	ageRules  = []intRule{between(0, maxDogAge)}
+	typeRules = []stringRule{required, maxLength(maxTypeLength), knownBreed}
+)
+
+func validateDogFields(v *validator, name string, age int, dogType string) {
+	v.str("name", name, nameRules...)
+	v.int("age", age, ageRules...)
+	v.str("type", dogType, typeRules...)
+}
@@ -0,0 +1,59 @@
+package gotoproduction_test
+
+import (
+	"errors"
+	"github.com/amammay/gotoproduction"
+	"github.com/matryer/is"
+	"strings"
+	"testing"
+)
+
+func TestCreateDogRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    gotoproduction.CreateDogRequest
+		wantFields []string
+	}{
+		{name: "valid", request: gotoproduction.CreateDogRequest{Name: "Oscar", Age: 1, Type: "Golden Doodle"}},
+		{name: "breed is case insensitive", request: gotoproduction.CreateDogRequest{Name: "Oscar", Age: 1, Type: "golden doodle"}},
+		{name: "punctuation in names", request: gotoproduction.CreateDogRequest{Name: "Mr. O'Malley-Smith", Age: 1, Type: "Pug"}},
+		{name: "blank", request: gotoproduction.CreateDogRequest{Name: " "}, wantFields: []string{"name", "type"}},
+		{name: "negative age", request: gotoproduction.CreateDogRequest{Name: "Oscar", Age: -1, Type: "Pug"}, wantFields: []string{"age"}},
+		{name: "ancient", request: gotoproduction.CreateDogRequest{Name: "Oscar", Age: 31, Type: "Pug"}, wantFields: []string{"age"}},
+		{name: "huge name", request: gotoproduction.CreateDogRequest{Name: strings.Repeat("a", 10*1024), Type: "Pug"}, wantFields: []string{"name"}},
+		{name: "bad characters", request: gotoproduction.CreateDogRequest{Name: "Oscar; DROP", Type: "Pug"}, wantFields: []string{"name"}},
+		{name: "unknown breed", request: gotoproduction.CreateDogRequest{Name: "Oscar", Type: "Dragon"}, wantFields: []string{"type"}},
+		{name: "everything", request: gotoproduction.CreateDogRequest{Name: "", Age: 99, Type: "Dragon"}, wantFields: []string{"name", "age", "type"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			err := tt.request.Validate()
+			if len(tt.wantFields) == 0 {
+				is.NoErr(err) // request should be valid
+				return
+			}
+			var validationErr *gotoproduction.ValidationError
+			is.True(errors.As(err, &validationErr)) // must be a validation error
+			var got []string
+			for _, f := range validationErr.Fields {
+				got = append(got, f.Field)
+			}
+			is.Equal(got, tt.wantFields) // failing fields
+		})
+	}
+}
+
+func TestPatchDogRequest_Validate(t *testing.T) {
+	is := is.New(t)
+	age := 40
+	blank := ""
+
+	err := (&gotoproduction.PatchDogRequest{}).Validate()
+	is.NoErr(err) // unset fields are not validated
+
+	err = (&gotoproduction.PatchDogRequest{Age: &age, Type: &blank}).Validate()
+	var validationErr *gotoproduction.ValidationError
+	is.True(errors.As(err, &validationErr)) // must be a validation error
+	is.Equal(len(validationErr.Fields), 2)  // age and type both fail
+}
@@ -0,0 +1,83 @@
+package gotoproduction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// ErrWatchNotSupported is returned by WatchDogs when the store can't push changes
+var ErrWatchNotSupported = errors.New("watching dogs is not supported by this store")
+
+// DogChange is one change a watch saw, Type is EventDogCreated, EventDogUpdated or EventDogDeleted
+type DogChange struct {
+	Type string
+	Dog  *Dog
+	// Time is when the change was written, passing it back as WatchDogsRequest.Since resumes right after it
+	Time time.Time
+}
+
+// WatchDogsRequest asks for changes to dogs of Type, or every dog when it is empty. Since resumes a watch: dogs
+// written after it are sent first, as they are now, and then changes as they happen. Without Since only changes from
+// now on are sent
+type WatchDogsRequest struct {
+	Type  string
+	Since time.Time
+	// Ready is called once the watch is listening, before the first change is sent, when it is not nil. Writes after it
+	// returns are guaranteed to be seen
+	Ready func()
+}
+
+// DogWatcher is implemented by stores that can push dog changes as they happen. Soft deletes are sent as deletes and
+// restores as updates, purges are not sent since the delete already was. A dog whose type changes away from the
+// watched type is sent as deleted
+type DogWatcher interface {
+	// WatchDogs calls fn with every change until ctx is done or fn returns an error, which WatchDogs then returns. fn is
+	// called from a single goroutine, in the order the changes were written
+	WatchDogs(ctx context.Context, request WatchDogsRequest, fn func(change DogChange) error) error
+}
+
+// WatchDogs streams changes to dogs to fn until ctx is done or fn fails, fn must not block for long since the store's
+// listener waits for it
+func (ds *DogService) WatchDogs(ctx context.Context, request *WatchDogsRequest, fn func(change DogChange) error) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "DogService.WatchDogs")
+	defer span.End()
+	if err := ds.authorize(ctx, PermissionReadDogs); err != nil {
+		return err
+	}
+	watcher, ok := ds.store.(DogWatcher)
+	if !ok {
+		return ErrWatchNotSupported
+	}
+	logger := ds.appLogger.WrapTraceContext(ctx)
+	logger.Debugw("watching dogs", "type", request.Type, "since", request.Since)
+	watch := *request
+	watch.Type = defaultBreedCatalog.Canonical(request.Type)
+	err := watcher.WatchDogs(ctx, watch, fn)
+	if ctx.Err() != nil {
+		// the caller went away, that is how every watch ends
+		logger.Debugw("stopped watching dogs", "type", request.Type)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("watcher.WatchDogs(): %w", err)
+	}
+	return nil
+}
+
+// resumeChange is how a dog written after a resume point is sent, as the state it is in now
+func resumeChange(dog *Dog, since time.Time) (DogChange, bool) {
+	if !dog.UpdateTime.After(since) {
+		return DogChange{}, false
+	}
+	change := DogChange{Type: EventDogUpdated, Dog: dog, Time: dog.UpdateTime}
+	switch {
+	case dog.DeletedAt != nil:
+		change.Type = EventDogDeleted
+	case dog.CreatedTimestamp.After(since):
+		change.Type = EventDogCreated
+	}
+	return change, true
+}
@@ -0,0 +1,393 @@
+package gotoproduction
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Headers on every webhook POST, receivers dedupe on the delivery id and check the signature before trusting the body
+const (
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// ErrInvalidWebhookSignature is returned by VerifyWebhookSignature for payloads that were not signed with the secret
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+// SignWebhookPayload renders the signature header for body sent at timestamp, t=<unix seconds>,v1=<hex hmac-sha256>
+// where the hmac covers the timestamp, a dot and the body so a captured payload can't be replayed with a fresh timestamp
+func SignWebhookPayload(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(webhookMAC(secret, ts, body)))
+}
+
+// VerifyWebhookSignature checks a signature header made by SignWebhookPayload, signatures older than tolerance are
+// rejected too
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			sig = kv[1]
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: bad timestamp", ErrInvalidWebhookSignature)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside of tolerance", ErrInvalidWebhookSignature)
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, webhookMAC(secret, ts, body)) {
+		return ErrInvalidWebhookSignature
+	}
+	return nil
+}
+
+func webhookMAC(secret, ts string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// NewWebhookClient creates the client the deliverer should send with, it refuses to connect to any address in
+// blockedNetworks. The check runs on the address actually dialed, so names resolving to our own networks and redirects
+// to them are caught too. Proxies from the environment are ignored since the check would only see the proxy
+func NewWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return fmt.Errorf("net.SplitHostPort(%q): %w", address, err)
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateWebhookAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// WebhookFanout is an EventPublisher that queues a delivery of every event for each enabled subscription that wants it,
+// the WebhookDeliverer takes it from there
+type WebhookFanout struct {
+	store WebhookStore
+	now   func() time.Time
+}
+
+// NewWebhookFanout queues deliveries in store
+func NewWebhookFanout(store WebhookStore) *WebhookFanout {
+	return &WebhookFanout{store: store, now: time.Now}
+}
+
+// Publish queues the deliveries, an event published again after a crash maps onto the same delivery ids and is not
+// queued twice
+func (f *WebhookFanout) Publish(ctx context.Context, event *DogEvent) error {
+	subscriptions, err := f.store.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("f.store.ListWebhookSubscriptions(): %w", err)
+	}
+	now := f.now().UTC()
+	var deliveries []*WebhookDelivery
+	for _, subscription := range subscriptions {
+		if subscription.Disabled || !subscription.subscribedTo(event.Type) {
+			continue
+		}
+		deliveries = append(deliveries, &WebhookDelivery{
+			ID:             event.ID + "_" + subscription.ID,
+			SubscriptionID: subscription.ID,
+			Event:          event,
+			Status:         WebhookDeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := f.store.EnqueueWebhookDeliveries(ctx, deliveries); err != nil {
+		return fmt.Errorf("f.store.EnqueueWebhookDeliveries(): %w", err)
+	}
+	return nil
+}
+
+// WebhookDeliverer POSTs queued deliveries to their subscribers, retrying failures with backoff until a delivery runs
+// out of attempts. Subscriptions that fail too often in a row are disabled
+type WebhookDeliverer struct {
+	store     WebhookStore
+	client    *http.Client
+	appLogger *logx.AppLogger
+	batchSize int
+	// concurrency is how many deliveries of a batch are sent at once
+	concurrency int
+	// lease is how long a claimed delivery is hidden from other deliverers, a batch stops starting deliveries half way
+	// through it so every attempt is saved before another deliverer can claim the same deliveries
+	lease        time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	maxAttempts  int
+	disableAfter int
+	now          func() time.Time
+}
+
+// WebhookDelivererOption tweaks how a WebhookDeliverer is built
+type WebhookDelivererOption func(d *WebhookDeliverer)
+
+// WithWebhookBatchSize sets how many deliveries are claimed at a time
+func WithWebhookBatchSize(n int) WebhookDelivererOption {
+	return func(d *WebhookDeliverer) {
+		d.batchSize = n
+	}
+}
+
+// WithWebhookConcurrency sets how many deliveries of a batch are sent at once, so a slow receiver only holds up its own
+func WithWebhookConcurrency(n int) WebhookDelivererOption {
+	return func(d *WebhookDeliverer) {
+		d.concurrency = n
+	}
+}
+
+// WithWebhookLease sets how long claimed deliveries are hidden from other deliverers, a batch gets half of it to send in
+func WithWebhookLease(lease time.Duration) WebhookDelivererOption {
+	return func(d *WebhookDeliverer) {
+		d.lease = lease
+	}
+}
+
+// WithWebhookBackoff sets the delay after the first failed attempt, it doubles with every further failure up to max
+func WithWebhookBackoff(min, max time.Duration) WebhookDelivererOption {
+	return func(d *WebhookDeliverer) {
+		d.minBackoff = min
+		d.maxBackoff = max
+	}
+}
+
+// WithWebhookMaxAttempts sets how often a delivery is tried before it is given up on
+func WithWebhookMaxAttempts(n int) WebhookDelivererOption {
+	return func(d *WebhookDeliverer) {
+		d.maxAttempts = n
+	}
+}
+
+// WithWebhookDisableAfter sets how many failed attempts in a row disable a subscription
+func WithWebhookDisableAfter(n int) WebhookDelivererOption {
+	return func(d *WebhookDeliverer) {
+		d.disableAfter = n
+	}
+}
+
+// NewWebhookDeliverer creates a deliverer sending the deliveries in store with client, the client's timeout bounds
+// every attempt
+func NewWebhookDeliverer(store WebhookStore, client *http.Client, logger *logx.AppLogger, opts ...WebhookDelivererOption) *WebhookDeliverer {
+	d := &WebhookDeliverer{
+		store:        store,
+		client:       client,
+		appLogger:    logger,
+		batchSize:    50,
+		concurrency:  10,
+		lease:        5 * time.Minute,
+		minBackoff:   5 * time.Second,
+		maxBackoff:   time.Hour,
+		maxAttempts:  10,
+		disableAfter: 20,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// DeliverOnce sends one batch of due deliveries and says how many of them were attempted
+func (d *WebhookDeliverer) DeliverOnce(ctx context.Context) (int, error) {
+	ctx, span := otel.Tracer("gotoproduction").Start(ctx, "WebhookDeliverer.DeliverOnce")
+	defer span.End()
+	logger := d.appLogger.WrapTraceContext(ctx)
+
+	deliveries, err := d.store.ClaimWebhookDeliveries(ctx, d.now().UTC(), d.lease, d.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("d.store.ClaimWebhookDeliveries(): %w", err)
+	}
+	// once another deliverer could claim these deliveries again, sending them from here too would be a duplicate.
+	// Nothing starts after half the lease, and requests still running then are cut off, leaving the other half for saving
+	sendCtx, cancel := context.WithTimeout(ctx, d.lease/2)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	delivered, skipped := 0, 0
+	slots := make(chan struct{}, d.concurrency)
+	for _, delivery := range deliveries {
+		select {
+		case slots <- struct{}{}:
+		case <-sendCtx.Done():
+		}
+		if sendCtx.Err() != nil {
+			// left claimed, it is tried again once the lease runs out
+			skipped++
+			continue
+		}
+		wg.Add(1)
+		go func(delivery *WebhookDelivery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			d.deliver(ctx, sendCtx, delivery)
+			if err := d.store.SaveWebhookDelivery(ctx, delivery); err != nil {
+				// the lease runs out eventually and the delivery is tried again, receivers dedupe on the delivery id
+				logger.Errorw("saving webhook delivery", "delivery_id", delivery.ID, "err", err)
+			}
+			if delivery.Status == WebhookDeliveryDelivered {
+				mu.Lock()
+				delivered++
+				mu.Unlock()
+			}
+		}(delivery)
+	}
+	wg.Wait()
+	if skipped > 0 {
+		logger.Warnw("webhook batch ran out of time", "skipped", skipped)
+	}
+	span.SetAttributes(attribute.Int("claimed", len(deliveries)), attribute.Int("delivered", delivered), attribute.Int("skipped", skipped))
+	return len(deliveries), nil
+}
+
+// Run delivers every interval until ctx is done, a full batch is followed straight away by the next one
+func (d *WebhookDeliverer) Run(ctx context.Context, interval time.Duration) {
+	logger := d.appLogger.WrapTraceContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		attempted, err := d.DeliverOnce(ctx)
+		if err != nil {
+			logger.Errorw("delivering webhooks", "err", err)
+		}
+		if err == nil && attempted == d.batchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver makes one attempt and moves the delivery on to its next state, the POST itself is bound by sendCtx
+func (d *WebhookDeliverer) deliver(ctx, sendCtx context.Context, delivery *WebhookDelivery) {
+	logger := d.appLogger.WrapTraceContext(ctx)
+	subscription, err := d.store.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+	switch {
+	case errors.Is(err, ErrWebhookNotFound):
+		d.giveUp(delivery, "subscription was deleted")
+		return
+	case err != nil:
+		// nothing was sent, try again once the lease runs out
+		logger.Errorw("loading webhook subscription", "subscription_id", delivery.SubscriptionID, "err", err)
+		return
+	case subscription.Disabled:
+		d.giveUp(delivery, "subscription is disabled")
+		return
+	}
+
+	attempt := d.post(sendCtx, subscription, delivery)
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	success := attempt.Error == ""
+	switch {
+	case success:
+		delivery.Status = WebhookDeliveryDelivered
+	case len(delivery.Attempts) >= d.maxAttempts:
+		delivery.Status = WebhookDeliveryFailed
+		logger.Warnw("webhook delivery failed for good", "delivery_id", delivery.ID, "subscription_id", subscription.ID, "attempts", len(delivery.Attempts), "err", attempt.Error)
+	default:
+		delivery.NextAttemptAt = d.now().UTC().Add(backoff(d.minBackoff, d.maxBackoff, len(delivery.Attempts)))
+		logger.Infow("webhook delivery failed", "delivery_id", delivery.ID, "subscription_id", subscription.ID, "attempts", len(delivery.Attempts), "next_attempt_at", delivery.NextAttemptAt, "err", attempt.Error)
+	}
+
+	recorded, err := d.store.RecordWebhookResult(ctx, subscription.ID, success, d.disableAfter)
+	if err != nil {
+		logger.Errorw("recording webhook result", "subscription_id", subscription.ID, "err", err)
+		return
+	}
+	if recorded.Disabled && !subscription.Disabled {
+		logger.Warnw("disabled webhook subscription", "subscription_id", subscription.ID, "url", subscription.URL, "consecutive_failures", recorded.ConsecutiveFailures)
+	}
+}
+
+// post sends the event signed with the subscription's secret, anything but a 2xx is a failed attempt
+func (d *WebhookDeliverer) post(ctx context.Context, subscription *WebhookSubscription, delivery *WebhookDelivery) WebhookAttempt {
+	start := d.now().UTC()
+	attempt := WebhookAttempt{At: start}
+	fail := func(err error) WebhookAttempt {
+		attempt.Error = err.Error()
+		attempt.DurationMillis = d.now().Sub(start).Milliseconds()
+		return attempt
+	}
+
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return fail(fmt.Errorf("json.Marshal(): %w", err))
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return fail(fmt.Errorf("http.NewRequestWithContext(): %w", err))
+	}
+	request.Header.Set("content-type", "application/json")
+	request.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	request.Header.Set(WebhookEventHeader, delivery.Event.Type)
+	request.Header.Set(WebhookSignatureHeader, SignWebhookPayload(subscription.Secret, start, body))
+	response, err := d.client.Do(request)
+	if err != nil {
+		return fail(fmt.Errorf("d.client.Do(): %w", err))
+	}
+	defer response.Body.Close()
+	// drain so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+	attempt.StatusCode = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fail(fmt.Errorf("subscriber answered %d", response.StatusCode))
+	}
+	attempt.DurationMillis = d.now().Sub(start).Milliseconds()
+	return attempt
+}
+
+func (d *WebhookDeliverer) giveUp(delivery *WebhookDelivery, reason string) {
+	delivery.Status = WebhookDeliveryFailed
+	delivery.Attempts = append(delivery.Attempts, WebhookAttempt{At: d.now().UTC(), Error: reason})
+}
@@ -0,0 +1,271 @@
+package gotoproduction_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/amammay/gotoproduction"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"github.com/matryer/is"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a partner endpoint that checks signatures and answers with status
+type receiver struct {
+	t      *testing.T
+	secret string
+	mu     sync.Mutex
+	status int
+	events []*gotoproduction.DogEvent
+	ids    []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("io.ReadAll() err = %v; want nil", err)
+	}
+	if err := gotoproduction.VerifyWebhookSignature(rc.secret, r.Header.Get(gotoproduction.WebhookSignatureHeader), body, time.Minute, time.Now()); err != nil {
+		rc.t.Errorf("VerifyWebhookSignature() err = %v; want nil", err)
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.status != http.StatusOK {
+		w.WriteHeader(rc.status)
+		return
+	}
+	event := &gotoproduction.DogEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		rc.t.Errorf("json.Unmarshal() err = %v; want nil", err)
+	}
+	rc.events = append(rc.events, event)
+	rc.ids = append(rc.ids, r.Header.Get(gotoproduction.WebhookDeliveryHeader))
+}
+
+func TestWebhookDeliverer_endToEnd(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+	logger := logx.NewTesterLogger(t)
+	ds := gotoproduction.NewDogService(store, logger)
+	ws := gotoproduction.NewWebhookService(store, logger, gotoproduction.WithPrivateWebhookURLs())
+
+	rc := &receiver{t: t, secret: "a-very-secret-secret", status: http.StatusOK}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	subscription, err := ws.CreateWebhook(ctx, &gotoproduction.CreateWebhookRequest{
+		URL:        server.URL,
+		EventTypes: []string{gotoproduction.EventDogCreated},
+		Secret:     rc.secret,
+	})
+	is.NoErr(err) // ws.CreateWebhook error
+
+	id, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: "Oscar", Age: 2, Type: "Golden Doodle"})
+	is.NoErr(err)                                // ds.CreateDog error
+	is.NoErr(ds.DeleteDog(ctx, id, time.Time{})) // ds.DeleteDog error
+
+	relay := gotoproduction.NewRelay(store, gotoproduction.NewWebhookFanout(store), logger)
+	published, err := relay.RelayOnce(ctx)
+	is.NoErr(err)          // RelayOnce error
+	is.Equal(published, 2) // both events went through the fanout
+
+	deliverer := gotoproduction.NewWebhookDeliverer(store, server.Client(), logger)
+	attempted, err := deliverer.DeliverOnce(ctx)
+	is.NoErr(err)          // DeliverOnce error
+	is.Equal(attempted, 1) // only the create, the subscription doesn't want deletes
+	is.Equal(len(rc.events), 1)
+	is.Equal(rc.events[0].Type, gotoproduction.EventDogCreated)
+	is.Equal(rc.events[0].DogID, id)
+
+	deliveries, err := ws.ListWebhookDeliveries(ctx, subscription.ID, 0)
+	is.NoErr(err) // ws.ListWebhookDeliveries error
+	is.Equal(len(deliveries), 1)
+	is.Equal(deliveries[0].ID, rc.ids[0]) // the delivery id goes in a header
+	is.Equal(deliveries[0].Status, gotoproduction.WebhookDeliveryDelivered)
+	is.Equal(len(deliveries[0].Attempts), 1)
+	is.Equal(deliveries[0].Attempts[0].StatusCode, http.StatusOK)
+
+	attempted, err = deliverer.DeliverOnce(ctx)
+	is.NoErr(err)          // DeliverOnce error
+	is.Equal(attempted, 0) // nothing is delivered twice
+}
+
+func TestWebhookDeliverer_failures(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+	logger := logx.NewTesterLogger(t)
+	ds := gotoproduction.NewDogService(store, logger)
+	ws := gotoproduction.NewWebhookService(store, logger, gotoproduction.WithPrivateWebhookURLs())
+
+	rc := &receiver{t: t, secret: "a-very-secret-secret", status: http.StatusInternalServerError}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	subscription, err := ws.CreateWebhook(ctx, &gotoproduction.CreateWebhookRequest{URL: server.URL, Secret: rc.secret})
+	is.NoErr(err) // ws.CreateWebhook error
+
+	for _, name := range []string{"Oscar", "Bella"} {
+		_, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: name, Age: 2, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+	}
+	_, err = gotoproduction.NewRelay(store, gotoproduction.NewWebhookFanout(store), logger).RelayOnce(ctx)
+	is.NoErr(err) // RelayOnce error
+
+	// no backoff so every run retries, 3 failures in a row disable the subscription. One at a time so the second
+	// delivery of a run sees what the first did to the subscription
+	deliverer := gotoproduction.NewWebhookDeliverer(store, server.Client(), logger,
+		gotoproduction.WithWebhookConcurrency(1),
+		gotoproduction.WithWebhookBackoff(0, 0),
+		gotoproduction.WithWebhookMaxAttempts(2),
+		gotoproduction.WithWebhookDisableAfter(3),
+	)
+	attempted, err := deliverer.DeliverOnce(ctx)
+	is.NoErr(err)          // DeliverOnce error
+	is.Equal(attempted, 2) // both dogs, both failing
+
+	// the first retry is the third failure in a row, the second delivery finds the subscription disabled
+	_, err = deliverer.DeliverOnce(ctx)
+	is.NoErr(err) // DeliverOnce error
+	disabled, err := ws.GetWebhook(ctx, subscription.ID)
+	is.NoErr(err)              // ws.GetWebhook error
+	is.True(disabled.Disabled) // too many failures in a row
+	is.Equal(disabled.ConsecutiveFailures, 3)
+
+	deliveries, err := ws.ListWebhookDeliveries(ctx, subscription.ID, 0)
+	is.NoErr(err) // ws.ListWebhookDeliveries error
+	for _, delivery := range deliveries {
+		is.Equal(delivery.Status, gotoproduction.WebhookDeliveryFailed) // out of attempts or disabled
+		is.Equal(len(delivery.Attempts), 2)                             // every attempt is recorded
+	}
+
+	attempted, err = deliverer.DeliverOnce(ctx)
+	is.NoErr(err)          // DeliverOnce error
+	is.Equal(attempted, 0) // failed deliveries are not retried
+
+	enabled, err := ws.EnableWebhook(ctx, subscription.ID)
+	is.NoErr(err) // ws.EnableWebhook error
+	is.True(!enabled.Disabled)
+	is.Equal(enabled.ConsecutiveFailures, 0)
+}
+
+// queueDeliveries subscribes url to every event and queues one delivery per dog in names
+func queueDeliveries(t *testing.T, store *gotoproduction.MemoryStore, url string, names ...string) *gotoproduction.WebhookSubscription {
+	is := is.New(t)
+	ctx := context.Background()
+	logger := logx.NewTesterLogger(t)
+	ds := gotoproduction.NewDogService(store, logger)
+	ws := gotoproduction.NewWebhookService(store, logger, gotoproduction.WithPrivateWebhookURLs())
+	subscription, err := ws.CreateWebhook(ctx, &gotoproduction.CreateWebhookRequest{URL: url, Secret: "a-very-secret-secret"})
+	is.NoErr(err) // ws.CreateWebhook error
+	for _, name := range names {
+		_, err := ds.CreateDog(ctx, &gotoproduction.CreateDogRequest{Name: name, Age: 2, Type: "Golden Doodle"})
+		is.NoErr(err) // ds.CreateDog error
+	}
+	_, err = gotoproduction.NewRelay(store, gotoproduction.NewWebhookFanout(store), logger).RelayOnce(ctx)
+	is.NoErr(err) // RelayOnce error
+	return subscription
+}
+
+func TestWebhookDeliverer_concurrent(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+
+	// every request waits until all three arrived, that only happens when they are sent at the same time
+	var arrived sync.WaitGroup
+	arrived.Add(3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		arrived.Wait()
+	}))
+	defer server.Close()
+	queueDeliveries(t, store, server.URL, "Oscar", "Bella", "Luna")
+
+	deliverer := gotoproduction.NewWebhookDeliverer(store, server.Client(), logx.NewTesterLogger(t), gotoproduction.WithWebhookConcurrency(3))
+	attempted, err := deliverer.DeliverOnce(ctx)
+	is.NoErr(err) // DeliverOnce error
+	is.Equal(attempted, 3)
+}
+
+func TestWebhookDeliverer_leaseDeadline(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	store := gotoproduction.NewMemoryStore()
+
+	// the receiver hangs until the deliverer gives up on it, the server only notices that once the body is read
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	subscription := queueDeliveries(t, store, server.URL, "Oscar", "Bella")
+
+	deliverer := gotoproduction.NewWebhookDeliverer(store, server.Client(), logx.NewTesterLogger(t),
+		gotoproduction.WithWebhookConcurrency(1),
+		gotoproduction.WithWebhookLease(200*time.Millisecond),
+	)
+	start := time.Now()
+	_, err := deliverer.DeliverOnce(ctx)
+	is.NoErr(err)                                     // DeliverOnce error
+	is.True(time.Since(start) < 200*time.Millisecond) // done before the lease runs out
+
+	deliveries, err := gotoproduction.NewWebhookService(store, logx.NewTesterLogger(t)).ListWebhookDeliveries(ctx, subscription.ID, 0)
+	is.NoErr(err) // ListWebhookDeliveries error
+	attempts := 0
+	for _, delivery := range deliveries {
+		attempts += len(delivery.Attempts)
+	}
+	is.Equal(attempts, 1) // the first was cut off, the second never started and waits for its lease to run out
+}
+
+func TestNewWebhookClient(t *testing.T) {
+	is := is.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a private address was dialed")
+	}))
+	defer server.Close()
+
+	_, err := gotoproduction.NewWebhookClient(time.Second).Post(server.URL, "application/json", nil)
+	is.True(errors.Is(err, gotoproduction.ErrPrivateWebhookAddress)) // loopback is refused when dialing
+}
+
+func TestWebhookService_CreateWebhook_privateURLs(t *testing.T) {
+	ctx := context.Background()
+	ws := gotoproduction.NewWebhookService(gotoproduction.NewMemoryStore(), logx.NewTesterLogger(t))
+	for _, url := range []string{
+		"http://169.254.169.254/computeMetadata/v1/",
+		"http://localhost:8080/hooks",
+		"http://127.0.0.1/hooks",
+		"https://10.1.2.3/hooks",
+		"http://[::1]/hooks",
+		"http://[fd00::1]/hooks",
+	} {
+		t.Run(url, func(t *testing.T) {
+			is := is.New(t)
+			_, err := ws.CreateWebhook(ctx, &gotoproduction.CreateWebhookRequest{URL: url})
+			var verr *gotoproduction.ValidationError
+			is.True(errors.As(err, &verr))               // rejected as invalid
+			is.Equal(verr.Fields[0].Code, "private_url") // says why
+		})
+	}
+	_, err := ws.CreateWebhook(ctx, &gotoproduction.CreateWebhookRequest{URL: "https://partner.example.com/hooks"})
+	is.New(t).NoErr(err) // public hosts are fine
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	is := is.New(t)
+	now := time.Now()
+	body := []byte(`{"id":"1"}`)
+	header := gotoproduction.SignWebhookPayload("secret", now, body)
+	is.NoErr(gotoproduction.VerifyWebhookSignature("secret", header, body, time.Minute, now))                       // signed with the secret
+	is.True(gotoproduction.VerifyWebhookSignature("other", header, body, time.Minute, now) != nil)                  // wrong secret
+	is.True(gotoproduction.VerifyWebhookSignature("secret", header, []byte(`{"id":"2"}`), time.Minute, now) != nil) // tampered body
+	is.True(gotoproduction.VerifyWebhookSignature("secret", header, body, time.Minute, now.Add(time.Hour)) != nil)  // replayed later
+	is.True(gotoproduction.VerifyWebhookSignature("secret", "garbage", body, time.Minute, now) != nil)              // not a signature
+}
@@ -0,0 +1,413 @@
+package gotoproduction
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/amammay/gotoproduction/internal/authx"
+	"github.com/amammay/gotoproduction/internal/logx"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrWebhookNotFound represents when a webhook subscription cannot be found
+var ErrWebhookNotFound = errors.New("webhook subscription not found")
+
+// ErrPrivateWebhookAddress is returned when dialing a webhook endpoint that resolves to one of our own networks
+var ErrPrivateWebhookAddress = errors.New("webhook address is not public")
+
+// blockedNetworks reach our own infrastructure rather than a partner, webhooks are never sent there: loopback, private
+// and shared address space, link local with the metadata server on it, multicast and the reserved ranges
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24",
+	"192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b::/96", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("net.ParseCIDR(%q): %v", cidr, err))
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// publicIP says whether webhooks may be sent to ip, ipv4 addresses mapped into ipv6 are checked as ipv4
+func publicIP(ip net.IP) bool {
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Webhook delivery states, a delivery is pending until it succeeded or ran out of attempts
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+const (
+	// minWebhookSecretLength keeps caller chosen secrets from being guessable
+	minWebhookSecretLength = 16
+	maxWebhookURLLength    = 2048
+)
+
+// WebhookSubscription is a partner endpoint that gets dog events POSTed to it
+type WebhookSubscription struct {
+	ID  string `json:"id" firestore:"id"`
+	URL string `json:"url" firestore:"url"`
+	// EventTypes are the event types delivered to the endpoint, empty means every type
+	EventTypes []string `json:"event_types" firestore:"event_types"`
+	// Secret signs every payload, it is only ever handed out when the subscription is created
+	Secret string `json:"-" firestore:"secret"`
+	// Disabled subscriptions get no new deliveries, they are disabled after too many failures in a row
+	Disabled       bool   `json:"disabled" firestore:"disabled"`
+	DisabledReason string `json:"disabled_reason,omitempty" firestore:"disabled_reason"`
+	// ConsecutiveFailures counts failed attempts since the last successful one
+	ConsecutiveFailures int       `json:"consecutive_failures" firestore:"consecutive_failures"`
+	CreatedBy           string    `json:"created_by" firestore:"created_by"`
+	CreatedAt           time.Time `json:"created_at" firestore:"created_at"`
+}
+
+// WebhookDelivery is one event on its way to one subscription
+type WebhookDelivery struct {
+	// ID is made of the event and subscription ids, so an event published twice is only delivered once per subscription
+	ID             string    `json:"id" firestore:"id"`
+	SubscriptionID string    `json:"subscription_id" firestore:"subscription_id"`
+	Event          *DogEvent `json:"event" firestore:"event"`
+	// Status is one of the WebhookDelivery constants
+	Status   string           `json:"status" firestore:"status"`
+	Attempts []WebhookAttempt `json:"attempts" firestore:"attempts"`
+	// NextAttemptAt is when a pending delivery is due, deliverers push it forward while they hold it and after a failure
+	NextAttemptAt time.Time `json:"next_attempt_at" firestore:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at" firestore:"created_at"`
+}
+
+// WebhookAttempt records one POST to a subscriber, StatusCode is 0 when no response came back
+type WebhookAttempt struct {
+	At             time.Time `json:"at" firestore:"at"`
+	StatusCode     int       `json:"status_code,omitempty" firestore:"status_code"`
+	Error          string    `json:"error,omitempty" firestore:"error"`
+	DurationMillis int64     `json:"duration_ms" firestore:"duration_ms"`
+}
+
+// WebhookStore keeps webhook subscriptions and their deliveries, implementations return ErrWebhookNotFound for a
+// subscription that does not exist
+type WebhookStore interface {
+	// CreateWebhookSubscription persists a new subscription, assigning its id
+	CreateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) (string, error)
+	GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscription, error)
+	// ListWebhookSubscriptions returns every subscription, oldest first
+	ListWebhookSubscriptions(ctx context.Context) ([]*WebhookSubscription, error)
+	// UpdateWebhookSubscription overwrites an existing subscription
+	UpdateWebhookSubscription(ctx context.Context, subscription *WebhookSubscription) error
+	DeleteWebhookSubscription(ctx context.Context, id string) error
+	// RecordWebhookResult atomically resets the failure count of a subscription after a success, or counts a failure
+	// and disables the subscription once disableAfter failures happened in a row. It returns the subscription as written
+	RecordWebhookResult(ctx context.Context, id string, success bool, disableAfter int) (*WebhookSubscription, error)
+	// EnqueueWebhookDeliveries stores the deliveries, deliveries whose id is already stored are left as they are
+	EnqueueWebhookDeliveries(ctx context.Context, deliveries []*WebhookDelivery) error
+	// ClaimWebhookDeliveries returns up to limit pending deliveries due at now, oldest first, and pushes them to
+	// now+lease so other deliverers skip them while they are being sent
+	ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error)
+	// SaveWebhookDelivery overwrites a delivery after an attempt
+	SaveWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	// ListWebhookDeliveries returns up to limit deliveries of a subscription, newest first
+	ListWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]*WebhookDelivery, error)
+}
+
+// CreateWebhookRequest registers a new endpoint, Secret is generated when it is left empty
+type CreateWebhookRequest struct {
+	URL        string
+	EventTypes []string
+	Secret     string
+}
+
+// Validate checks a create request, returning a *ValidationError listing every failing field
+func (r *CreateWebhookRequest) Validate() error {
+	v := &validator{}
+	v.str("url", r.URL, required, maxLength(maxWebhookURLLength), httpURL)
+	for i, eventType := range r.EventTypes {
+		v.str(fmt.Sprintf("event_types[%d]", i), eventType, knownEventType)
+	}
+	if r.Secret != "" {
+		v.str("secret", r.Secret, minLength(minWebhookSecretLength))
+	}
+	return v.err()
+}
+
+func httpURL(value string) *FieldError {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &FieldError{Code: "invalid_url", Message: "must be an absolute http or https url"}
+	}
+	return nil
+}
+
+// publicHost rejects urls whose host is obviously ours, an ip in blockedNetworks or localhost. Names resolving to such
+// an address are caught when the deliverer dials them, since what a name resolves to can change after registration
+func publicHost(value string) *FieldError {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if ip := net.ParseIP(host); (ip != nil && !publicIP(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return &FieldError{Code: "private_url", Message: "must not point at a loopback, private or link local address"}
+	}
+	return nil
+}
+
+func knownEventType(value string) *FieldError {
+	switch value {
+	case EventDogCreated, EventDogUpdated, EventDogDeleted, EventDogTransferred, EventDogStatusChanged:
+		return nil
+	}
+	return &FieldError{Code: "unknown_event_type", Message: fmt.Sprintf("%q is not a known event type", value)}
+}
+
+func minLength(n int) stringRule {
+	return func(value string) *FieldError {
+		if len(value) < n {
+			return &FieldError{Code: "too_short", Message: fmt.Sprintf("must be at least %d characters", n)}
+		}
+		return nil
+	}
+}
+
+// WebhookService manages webhook subscriptions, delivering to them is up to the WebhookDeliverer
+type WebhookService struct {
+	store     WebhookStore
+	appLogger *logx.AppLogger
+	policy    *authx.Policy
+	// allowPrivateURLs lets subscriptions point at our own networks, for local development only
+	allowPrivateURLs bool
+}
+
+// WebhookServiceOption tweaks how a WebhookService is built
+type WebhookServiceOption func(ws *WebhookService)
+
+// WithWebhookPolicy makes every WebhookService method check the principal on the context against the policy, without it
+// the service trusts its callers
+func WithWebhookPolicy(policy *authx.Policy) WebhookServiceOption {
+	return func(ws *WebhookService) {
+		ws.policy = policy
+	}
+}
+
+// WithPrivateWebhookURLs lets subscriptions point at loopback, private and link local addresses. It is meant for
+// receivers running next to the server during development, in production it would let anyone with the
+// webhooks.manage permission make the deliverer POST to internal services
+func WithPrivateWebhookURLs() WebhookServiceOption {
+	return func(ws *WebhookService) {
+		ws.allowPrivateURLs = true
+	}
+}
+
+// NewWebhookService creates a service managing the subscriptions in store
+func NewWebhookService(store WebhookStore, logger *logx.AppLogger, opts ...WebhookServiceOption) *WebhookService {
+	ws := &WebhookService{store: store, appLogger: logger}
+	for _, opt := range opts {
+		opt(ws)
+	}
+	return ws
+}
+
+// CreateWebhook registers an endpoint, the returned subscription is the only place its secret shows up
+func (ws *WebhookService) CreateWebhook(ctx context.Context, request *CreateWebhookRequest) (*WebhookSubscription, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "WebhookService.CreateWebhook")
+	defer span.End()
+	if err := authorize(ctx, ws.policy, ws.appLogger, PermissionManageWebhooks); err != nil {
+		return nil, err
+	}
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	if !ws.allowPrivateURLs {
+		v := &validator{}
+		v.str("url", request.URL, publicHost)
+		if err := v.err(); err != nil {
+			return nil, err
+		}
+	}
+	secret := request.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("rand.Read(): %w", err)
+		}
+		secret = hex.EncodeToString(b)
+	}
+	subscription := &WebhookSubscription{
+		URL:        request.URL,
+		EventTypes: append([]string{}, request.EventTypes...),
+		Secret:     secret,
+		CreatedBy:  "anonymous",
+		CreatedAt:  time.Now().UTC(),
+	}
+	if principal, ok := authx.FromContext(ctx); ok {
+		subscription.CreatedBy = principal.Subject
+	}
+	id, err := ws.store.CreateWebhookSubscription(ctx, subscription)
+	if err != nil {
+		return nil, fmt.Errorf("ws.store.CreateWebhookSubscription(): %w", err)
+	}
+	subscription.ID = id
+	ws.appLogger.WrapTraceContext(ctx).Infow("created webhook subscription", "id", id, "url", subscription.URL, "event_types", subscription.EventTypes)
+	return subscription, nil
+}
+
+// GetWebhook retrieves 1 subscription by its id
+func (ws *WebhookService) GetWebhook(ctx context.Context, id string) (*WebhookSubscription, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "WebhookService.GetWebhook")
+	defer span.End()
+	if err := authorize(ctx, ws.policy, ws.appLogger, PermissionManageWebhooks); err != nil {
+		return nil, err
+	}
+	subscription, err := ws.store.GetWebhookSubscription(ctx, id)
+	if errors.Is(err, ErrWebhookNotFound) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ws.store.GetWebhookSubscription(%q): %w", id, err)
+	}
+	return subscription, nil
+}
+
+// ListWebhooks returns every subscription, there are few enough of them that they aren't paged
+func (ws *WebhookService) ListWebhooks(ctx context.Context) ([]*WebhookSubscription, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "WebhookService.ListWebhooks")
+	defer span.End()
+	if err := authorize(ctx, ws.policy, ws.appLogger, PermissionManageWebhooks); err != nil {
+		return nil, err
+	}
+	subscriptions, err := ws.store.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ws.store.ListWebhookSubscriptions(): %w", err)
+	}
+	return subscriptions, nil
+}
+
+// DeleteWebhook removes a subscription, its pending deliveries fail on their next attempt
+func (ws *WebhookService) DeleteWebhook(ctx context.Context, id string) error {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "WebhookService.DeleteWebhook")
+	defer span.End()
+	if err := authorize(ctx, ws.policy, ws.appLogger, PermissionManageWebhooks); err != nil {
+		return err
+	}
+	err := ws.store.DeleteWebhookSubscription(ctx, id)
+	if errors.Is(err, ErrWebhookNotFound) {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("ws.store.DeleteWebhookSubscription(%q): %w", id, err)
+	}
+	return nil
+}
+
+// EnableWebhook turns a disabled subscription back on with a clean failure count, events that happened while it was
+// disabled are not delivered
+func (ws *WebhookService) EnableWebhook(ctx context.Context, id string) (*WebhookSubscription, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "WebhookService.EnableWebhook")
+	defer span.End()
+	if err := authorize(ctx, ws.policy, ws.appLogger, PermissionManageWebhooks); err != nil {
+		return nil, err
+	}
+	subscription, err := ws.store.GetWebhookSubscription(ctx, id)
+	if errors.Is(err, ErrWebhookNotFound) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ws.store.GetWebhookSubscription(%q): %w", id, err)
+	}
+	subscription.Disabled = false
+	subscription.DisabledReason = ""
+	subscription.ConsecutiveFailures = 0
+	if err := ws.store.UpdateWebhookSubscription(ctx, subscription); err != nil {
+		return nil, fmt.Errorf("ws.store.UpdateWebhookSubscription(%q): %w", id, err)
+	}
+	return subscription, nil
+}
+
+// ListWebhookDeliveries returns up to limit of the latest deliveries to a subscription with every attempt made
+func (ws *WebhookService) ListWebhookDeliveries(ctx context.Context, id string, limit int) ([]*WebhookDelivery, error) {
+	ctx, span := trace.SpanFromContext(ctx).Tracer().Start(ctx, "WebhookService.ListWebhookDeliveries")
+	defer span.End()
+	if err := authorize(ctx, ws.policy, ws.appLogger, PermissionManageWebhooks); err != nil {
+		return nil, err
+	}
+	if _, err := ws.store.GetWebhookSubscription(ctx, id); errors.Is(err, ErrWebhookNotFound) {
+		return nil, ErrWebhookNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("ws.store.GetWebhookSubscription(%q): %w", id, err)
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	deliveries, err := ws.store.ListWebhookDeliveries(ctx, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ws.store.ListWebhookDeliveries(%q): %w", id, err)
+	}
+	return deliveries, nil
+}
+
+// subscribedTo says whether the subscription wants events of eventType
+func (s *WebhookSubscription) subscribedTo(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// recordWebhookResult applies the outcome of an attempt to the subscription, stores call it inside whatever keeps the
+// update atomic
+func recordWebhookResult(subscription *WebhookSubscription, success bool, disableAfter int) {
+	if success {
+		subscription.ConsecutiveFailures = 0
+		return
+	}
+	subscription.ConsecutiveFailures++
+	if disableAfter > 0 && subscription.ConsecutiveFailures >= disableAfter && !subscription.Disabled {
+		subscription.Disabled = true
+		subscription.DisabledReason = fmt.Sprintf("%d delivery attempts failed in a row", subscription.ConsecutiveFailures)
+	}
+}
+
+func copyWebhookSubscription(subscription *WebhookSubscription) *WebhookSubscription {
+	c := *subscription
+	c.EventTypes = append([]string{}, subscription.EventTypes...)
+	return &c
+}
+
+func copyWebhookDelivery(delivery *WebhookDelivery) *WebhookDelivery {
+	c := *delivery
+	if delivery.Event != nil {
+		e := *delivery.Event
+		if e.Dog != nil {
+			e.Dog = copyDog(e.Dog)
+		}
+		c.Event = &e
+	}
+	c.Attempts = append([]WebhookAttempt(nil), delivery.Attempts...)
+	return &c
+}